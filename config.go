@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/duego/cryriver/elasticsearch"
+	"github.com/duego/cryriver/mongodb"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteSize defines a flag of a size such as 512MB, read by elasticsearch.ParseByteSize, like flag.Int
+// does for ints.
+func byteSize(name string, value elasticsearch.ByteSize, usage string) *elasticsearch.ByteSize {
+	size := new(elasticsearch.ByteSize)
+	flag.TextVar(size, name, value, usage)
+	return size
+}
+
+// namespaceFilter returns the namespaces to tail on as given by -ns and -exclude.
+func namespaceFilter() (mongodb.NamespaceFilter, error) {
+	filter := mongodb.NamespaceFilter{Include: strings.Split(*ns, ",")}
+	if *nsExclude != "" {
+		filter.Exclude = strings.Split(*nsExclude, ",")
+	}
+	return filter, filter.Validate()
+}
+
+// coalesceFilter returns the namespaces to coalesce as given by -coalesce.
+func coalesceFilter() (mongodb.NamespaceFilter, error) {
+	var filter mongodb.NamespaceFilter
+	if *nsCoalesce != "" {
+		filter.Include = strings.Split(*nsCoalesce, ",")
+	}
+	return filter, filter.Validate()
+}
+
+// lookupFilter returns the namespaces to look up whole documents for as given by -fulldocument.
+func lookupFilter() (mongodb.NamespaceFilter, error) {
+	var filter mongodb.NamespaceFilter
+	if *fullDocument != "" {
+		filter.Include = strings.Split(*fullDocument, ",")
+	}
+	return filter, filter.Validate()
+}
+
+// parseTargets parses the namespace=index or namespace=index/type overrides of -target.
+func parseTargets(s string) (map[string]mongodb.Target, error) {
+	targets := make(map[string]mongodb.Target)
+	if s == "" {
+		return targets, nil
+	}
+	for _, override := range strings.Split(s, ",") {
+		parts := strings.SplitN(override, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("Expected target as namespace=index or namespace=index/type, got: %s", override)
+		}
+		target := mongodb.Target{Index: parts[1]}
+		if i := strings.Index(parts[1], "/"); i >= 0 {
+			target = mongodb.Target{Index: parts[1][:i], Type: parts[1][i+1:]}
+		}
+		targets[parts[0]] = target
+	}
+	return targets, nil
+}
+
+// parseStatic parses the name=value fields of -static.
+func parseStatic(s string) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if s == "" {
+		return fields, nil
+	}
+	for _, field := range strings.Split(s, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Expected static field as name=value, got: %s", field)
+		}
+		fields[parts[0]] = parts[1]
+	}
+	return fields, nil
+}
+
+// parseRenames parses the path=name renames of -rename.
+func parseRenames(s string) (map[string]string, error) {
+	renames := make(map[string]string)
+	if s == "" {
+		return renames, nil
+	}
+	for _, rename := range strings.Split(s, ",") {
+		parts := strings.SplitN(rename, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Expected field to rename as path=name, got: %s", rename)
+		}
+		renames[parts[0]] = parts[1]
+	}
+	return renames, nil
+}
+
+// parseFlatten parses the namespace=path sub-documents of -flatten into the paths of each namespace.
+func parseFlatten(s string) (map[string][]string, error) {
+	paths := make(map[string][]string)
+	if s == "" {
+		return paths, nil
+	}
+	for _, field := range strings.Split(s, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Expected sub-document to flatten as namespace=path, got: %s", field)
+		}
+		namespaces := mongodb.NamespaceFilter{Include: []string{parts[0]}}
+		if err := namespaces.Validate(); err != nil {
+			return nil, err
+		}
+		paths[parts[0]] = append(paths[parts[0]], parts[1])
+	}
+	return paths, nil
+}
+
+// parseFlattenDepth parses the namespace=depth of -flattendepth into the depth of each namespace.
+func parseFlattenDepth(s string) (map[string]int, error) {
+	depths := make(map[string]int)
+	if s == "" {
+		return depths, nil
+	}
+	for _, field := range strings.Split(s, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Expected depth to flatten beyond as namespace=depth, got: %s", field)
+		}
+		depth, err := strconv.Atoi(parts[1])
+		if err != nil || depth < 1 {
+			return nil, fmt.Errorf("Expected depth to flatten beyond as a positive number, got: %s", field)
+		}
+		namespaces := mongodb.NamespaceFilter{Include: []string{parts[0]}}
+		if err := namespaces.Validate(); err != nil {
+			return nil, err
+		}
+		depths[parts[0]] = depth
+	}
+	return depths, nil
+}
+
+// parsePredicates parses the namespace=path:value or namespace=path predicates of -where.
+func parsePredicates(s string) (map[string]mongodb.Predicate, error) {
+	predicates := make(map[string]mongodb.Predicate)
+	if s == "" {
+		return predicates, nil
+	}
+	for _, where := range strings.Split(s, ",") {
+		parts := strings.SplitN(where, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Expected predicate as namespace=path:value or namespace=path, got: %s", where)
+		}
+		predicate := mongodb.Predicate{Path: parts[1]}
+		if i := strings.Index(parts[1], ":"); i >= 0 {
+			predicate = mongodb.Predicate{Path: parts[1][:i], Value: parts[1][i+1:]}
+		}
+		predicates[parts[0]] = predicate
+	}
+	return predicates, nil
+}
+
+// loadSchemas reads the namespace=file JSON Schemas of -schema into the schema of each namespace.
+func loadSchemas(s string) (map[string]*mongodb.Schema, error) {
+	schemas := make(map[string]*mongodb.Schema)
+	if s == "" {
+		return schemas, nil
+	}
+	for _, field := range strings.Split(s, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Expected schema as namespace=file, got: %s", field)
+		}
+		namespaces := mongodb.NamespaceFilter{Include: []string{parts[0]}}
+		if err := namespaces.Validate(); err != nil {
+			return nil, err
+		}
+		schema, err := mongodb.LoadSchema(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		schemas[parts[0]] = schema
+	}
+	return schemas, nil
+}
+
+// loadScripts reads the id=file painless scripts of -storescript into the source of each id.
+func loadScripts(s string) (map[string]string, error) {
+	scripts := make(map[string]string)
+	if s == "" {
+		return scripts, nil
+	}
+	for _, field := range strings.Split(s, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Expected script to store as id=file, got: %s", field)
+		}
+		source, err := ioutil.ReadFile(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		scripts[parts[0]] = string(source)
+	}
+	return scripts, nil
+}
+
+// loadMappings reads the file of -mapping as the mapping of the index, it has to be a JSON object.
+func loadMappings() (map[string]json.RawMessage, error) {
+	mappings := make(map[string]json.RawMessage)
+	if *esMapping == "" {
+		return mappings, nil
+	}
+	mapping, err := ioutil.ReadFile(*esMapping)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(mapping, &object); err != nil {
+		return nil, fmt.Errorf("Mapping in %s is not a JSON object: %s", *esMapping, err)
+	}
+	mappings[*esIndex] = json.RawMessage(mapping)
+	return mappings, nil
+}
+
+// mirrorAcks returns the clusters that have to accept operations as given by -mirroracks.
+func mirrorAcks(s string) (elasticsearch.FanOutAcks, error) {
+	switch s {
+	case "all":
+		return elasticsearch.AllTargets, nil
+	case "quorum":
+		return elasticsearch.QuorumTargets, nil
+	}
+	return 0, fmt.Errorf("Unknown clusters to acknowledge by: %s", s)
+}
+
+// dialConfig returns how to connect to MongoDB as given by flags and the environment.
+func dialConfig() mongodb.DialConfig {
+	config := mongodb.DialConfig{
+		URL:            *mongoServer,
+		Username:       *mongoUser,
+		Password:       *mongoPassword,
+		AuthSource:     *mongoAuthDb,
+		TLS:            *mongoTLS,
+		CAFile:         *mongoCA,
+		ReadPreference: *mongoReadPref,
+		Timeout:        time.Duration(*mongoTimeout) * time.Minute,
+	}
+	if config.Password == "" {
+		config.Password = os.Getenv("CRYRIVER_MONGO_PASSWORD")
+	}
+	return config
+}
+
+// clientOptions returns the options of the ES client as given by flags, except for the spool.
+func clientOptions() ([]elasticsearch.ClientOption, error) {
+	var options []elasticsearch.ClientOption
+	if *esVerbose {
+		options = append(options, elasticsearch.WithLogger(log.New(os.Stderr, "", log.LstdFlags), nil))
+	}
+	if *esDebugBulk > 0 {
+		options = append(options, elasticsearch.WithBulkDebug(func(body []byte, err error) {
+			log.Printf("Bulk request failed with %s, sent:\n%s", err, body)
+		}, *esDebugBulk))
+	}
+	if *esTimeout > 0 {
+		options = append(options, elasticsearch.WithRequestTimeout(*esTimeout))
+	}
+	if *esGzip {
+		if err := elasticsearch.ValidGzipLevel(*esGzipLevel); err != nil {
+			return nil, err
+		}
+		options = append(options, elasticsearch.WithGzip(*esGzipLevel))
+	}
+	if *esNewline {
+		options = append(options, elasticsearch.WithExactTerminator())
+	}
+	if *esCA != "" || *esCert != "" || *esKey != "" {
+		config, err := elasticsearch.TLSConfig(*esCA, *esCert, *esKey)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load TLS configuration for ES: %s", err)
+		}
+		options = append(options, elasticsearch.WithTLS(config))
+	}
+	if *esOpenSearch {
+		if *esCompatible > 0 {
+			return nil, errors.New("OpenSearch has no compatible-with media types, -compatible can't be used with -opensearch")
+		}
+		options = append(options, elasticsearch.WithOpenSearch())
+	}
+	if *esCompatible > 0 {
+		options = append(options, elasticsearch.WithCompatibility(*esCompatible))
+	}
+	if *esVersion != "" {
+		version, err := elasticsearch.ParseClusterVersion(*esVersion)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, elasticsearch.WithClusterVersion(version))
+	}
+	if *esBreaker > 0 {
+		options = append(options, elasticsearch.WithCircuitBreaker(elasticsearch.NewCircuitBreaker(*esBreaker, *esCooldown)))
+	}
+	return options, nil
+}
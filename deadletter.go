@@ -0,0 +1,24 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/duego/cryriver/mongodb"
+	"log"
+	"os"
+)
+
+// saveDeadLetters appends each dead letter received as a json line to the file at path.
+func saveDeadLetters(path string, letters <-chan mongodb.DeadLetter) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	for letter := range letters {
+		if err := encoder.Encode(letter); err != nil {
+			log.Println("Error saving dead letter:", err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+)
+
+// ErrSequenceOrder is returned when tracking a sequence lower than one already tracked.
+var ErrSequenceOrder = errors.New("Sequences have to be tracked in increasing order")
+
+// Sequencer is optionally implemented by entries to tell where they are in the stream they are read
+// from, such as by the oplog timestamp of their operation. Sequences increase monotonically in the
+// order entries are read, while entries of the same operation share the sequence of it.
+type Sequencer interface {
+	Sequence() uint64
+}
+
+// AckTracker keeps track of which sequences have been acknowledged to find the safe checkpoint when
+// entries are completed out of order, such as by several slurpers sending bulk requests at the same
+// time. The checkpoint is the highest sequence where it and every sequence tracked before it have
+// been acknowledged, resuming from it never skips what hasn't been indexed.
+type AckTracker struct {
+	mu         sync.Mutex
+	pending    []ackRange
+	checkpoint uint64
+}
+
+// ackRange is a tracked sequence and the number of its entries not yet acknowledged.
+type ackRange struct {
+	sequence    uint64
+	outstanding int
+}
+
+// NewAckTracker returns an AckTracker with checkpoint as the checkpoint until higher sequences have
+// been acknowledged, such as the one resumed from.
+func NewAckTracker(checkpoint uint64) *AckTracker {
+	return &AckTracker{checkpoint: checkpoint}
+}
+
+// Track registers an entry of sequence as in flight, it has to be done before the entry is handed
+// off to be sent. Entries of the same sequence are tracked once each and the sequence is completed
+// once all of them have been acknowledged.
+func (t *AckTracker) Track(sequence uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n := len(t.pending); n > 0 {
+		last := &t.pending[n-1]
+		if sequence < last.sequence {
+			return ErrSequenceOrder
+		}
+		if sequence == last.sequence {
+			last.outstanding++
+			return nil
+		}
+	} else if sequence <= t.checkpoint {
+		return ErrSequenceOrder
+	}
+	t.pending = append(t.pending, ackRange{sequence, 1})
+	return nil
+}
+
+// Ack acknowledges an entry of sequence as completed, returning the checkpoint after it. Sequences
+// that aren't tracked are ignored.
+func (t *AckTracker) Ack(sequence uint64) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := sort.Search(len(t.pending), func(i int) bool { return t.pending[i].sequence >= sequence })
+	if i < len(t.pending) && t.pending[i].sequence == sequence && t.pending[i].outstanding > 0 {
+		t.pending[i].outstanding--
+	}
+	for len(t.pending) > 0 && t.pending[0].outstanding == 0 {
+		t.checkpoint = t.pending[0].sequence
+		t.pending = t.pending[1:]
+	}
+	return t.checkpoint
+}
+
+// Checkpoint returns the highest sequence that has been acknowledged along with every sequence
+// tracked before it.
+func (t *AckTracker) Checkpoint() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.checkpoint
+}
+
+// Pending returns the number of tracked sequences not yet completed.
+func (t *AckTracker) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+// AckTo makes the BulkBody acknowledge the sequences of its Sequencer entries to tracker once ES
+// has accepted them, item by item, so that the checkpoint moves up to the last entry before any
+// rejected by ES rather than waiting for the whole body. Entries left out, such as ones that
+// wouldn't change anything, are acknowledged when added, while entries replaced by a later entry
+// of the same document are acknowledged along with it. Entries that would fail the same way again,
+// such as items rejected for their mapping or entries failing to be added, are acknowledged as
+// well once logged or dead-lettered: holding the checkpoint for them would hold it forever. Only
+// items ES was too busy or unavailable to take hold it back, resuming from it sends them again.
+// Bodies written to a Spool are acknowledged as a whole, they are replayed from it.
+func AckTo(tracker *AckTracker) BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.acks = tracker
+	}
+}
+
+// sequenceOf returns the sequence of v, 0 which is never tracked if it isn't a Sequencer.
+func sequenceOf(v BulkEntry) uint64 {
+	var sequencer Sequencer
+	if entryAs(v, &sequencer) {
+		return sequencer.Sequence()
+	}
+	return 0
+}
+
+// acknowledge acks the sequences of the items ES is done with in respBody, the response to the body.
+func (bulk *BulkBody) acknowledge(respBody []byte) {
+	if bulk.acks == nil {
+		return
+	}
+	defer func() { bulk.sequences = nil }()
+	settled, err := settledItems(respBody)
+	if err != nil {
+		log.Println("Unable to read what ES accepted of the bulk request, nothing is acknowledged:", err)
+		return
+	}
+	for i, ok := range settled {
+		if !ok || i >= len(bulk.sequences) {
+			continue
+		}
+		for _, sequence := range bulk.sequences[i] {
+			bulk.acks.Ack(sequence)
+		}
+	}
+}
+
+// settledItems tells which of the items in respBody, the response to a bulk request, ES is done
+// with: accepted, or rejected for good such that sending them again would fail the same way.
+func settledItems(respBody []byte) ([]bool, error) {
+	var resp struct {
+		Items []map[string]bulkItem `json:"items"`
+	}
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return nil, err
+	}
+	settled := make([]bool, len(resp.Items))
+	for i, item := range resp.Items {
+		for _, outcome := range item {
+			settled[i] = settledItem(outcome)
+		}
+	}
+	return settled, nil
+}
+
+// settledItem tells if ES is done with an item, see settledItems.
+func settledItem(outcome bulkItem) bool {
+	failure := ItemFailure{Err: &ESError{Status: outcome.Status}, PipelineFailure: pipelineFailure(outcome.Error)}
+	return len(outcome.Error) == 0 || !failure.Retryable()
+}
+
+// acknowledgeEntry acks v on its own, such as once an UnroutedDelete of it has been carried out.
+func (bulk *BulkBody) acknowledgeEntry(v BulkEntry) {
+	if bulk.acks != nil {
+		bulk.acks.Ack(sequenceOf(v))
+	}
+}
+
+// acknowledgeAll acks the sequences of every item in the body, such as once it has been spooled.
+func (bulk *BulkBody) acknowledgeAll() {
+	if bulk.acks == nil {
+		return
+	}
+	for _, sequences := range bulk.sequences {
+		for _, sequence := range sequences {
+			bulk.acks.Ack(sequence)
+		}
+	}
+	bulk.sequences = nil
+}
@@ -0,0 +1,203 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// sequencedEntry is read at sequence in the stream of entries.
+type sequencedEntry struct {
+	rawEntry
+	sequence uint64
+	replaces bool
+}
+
+func (e *sequencedEntry) Sequence() uint64 {
+	return e.sequence
+}
+
+func (e *sequencedEntry) Replaces() bool {
+	return e.replaces
+}
+
+func TestAckTrackerOutOfOrder(t *testing.T) {
+	tracker := NewAckTracker(10)
+	for _, sequence := range []uint64{20, 30, 30, 40, 50} {
+		if err := tracker.Track(sequence); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if checkpoint := tracker.Ack(40); checkpoint != 10 {
+		t.Error("Expected the checkpoint to wait for 20 and 30, got", checkpoint)
+	}
+	if checkpoint := tracker.Ack(30); checkpoint != 10 {
+		t.Error("Expected the checkpoint to wait for 20, got", checkpoint)
+	}
+	if checkpoint := tracker.Ack(20); checkpoint != 20 {
+		t.Error("Expected the checkpoint to stop before the second entry of 30, got", checkpoint)
+	}
+	if checkpoint := tracker.Ack(30); checkpoint != 40 {
+		t.Error("Expected the checkpoint to move past the acknowledged 40, got", checkpoint)
+	}
+	if pending := tracker.Pending(); pending != 1 {
+		t.Error("Expected only 50 to be pending, got", pending)
+	}
+	if checkpoint := tracker.Ack(50); checkpoint != 50 || tracker.Checkpoint() != 50 {
+		t.Error("Expected every sequence to be acknowledged, got", checkpoint)
+	}
+}
+
+func TestAckTrackerIgnoresUntracked(t *testing.T) {
+	tracker := NewAckTracker(0)
+	if err := tracker.Track(5); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint := tracker.Ack(3); checkpoint != 0 {
+		t.Error("Expected untracked sequences to be ignored, got", checkpoint)
+	}
+	if checkpoint := tracker.Ack(5); checkpoint != 5 {
+		t.Error("Unexpected checkpoint", checkpoint)
+	}
+	if checkpoint := tracker.Ack(5); checkpoint != 5 {
+		t.Error("Expected acknowledging twice to do nothing, got", checkpoint)
+	}
+}
+
+func TestAckTrackerOrder(t *testing.T) {
+	tracker := NewAckTracker(10)
+	if err := tracker.Track(10); err != ErrSequenceOrder {
+		t.Error("Expected the checkpoint itself to be refused, got", err)
+	}
+	if err := tracker.Track(20); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Track(15); err != ErrSequenceOrder {
+		t.Error("Expected a lower sequence to be refused, got", err)
+	}
+}
+
+func TestAckToPartialBatch(t *testing.T) {
+	response := `{"took":1,"errors":true,"items":[
+		{"index":{"_index":"testing","_id":"b","status":429,"error":{"type":"es_rejected_execution_exception","reason":"rejected execution"}}},
+		{"index":{"_index":"testing","_id":"a","status":200}},
+		{"index":{"_index":"testing","_id":"d","status":201}}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, 1)
+
+	tracker := NewAckTracker(0)
+	entries := []*sequencedEntry{
+		{rawEntry{"index", "testing", "", "a", map[string]interface{}{"v": "1"}}, 1, true},
+		{rawEntry{"index", "testing", "", "b", map[string]interface{}{"v": "2"}}, 2, true},
+		// Nothing to change, acknowledged when added
+		{rawEntry{"index", "testing", "", "c", nil}, 3, true},
+		// Replaces the first entry, acknowledging both once accepted
+		{rawEntry{"index", "testing", "", "a", map[string]interface{}{"v": "4"}}, 4, true},
+		{rawEntry{"index", "testing", "", "d", map[string]interface{}{"v": "5"}}, 5, true},
+	}
+	bulk := NewBulkBody(MB, AckTo(tracker))
+	for _, entry := range entries {
+		if err := tracker.Track(entry.sequence); err != nil {
+			t.Fatal(err)
+		}
+		if err := bulk.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectIds(t, bulk, "index b 2", "index a 4", "index d 5")
+	if checkpoint := tracker.Checkpoint(); checkpoint != 0 {
+		t.Fatal("Expected nothing to be acknowledged before sending, got", checkpoint)
+	}
+
+	if err := client.BulkSend(bulk); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint := tracker.Checkpoint(); checkpoint != 1 {
+		t.Error("Expected the checkpoint to stop before the entry ES was too busy for, got", checkpoint)
+	}
+	if pending := tracker.Pending(); pending != 4 {
+		t.Error("Expected the entries after the rejected one to be held back, got", pending)
+	}
+
+	// Once the entry is accepted, everything after it has been already
+	response = `{"took":1,"errors":false,"items":[{"index":{"_index":"testing","_id":"b","status":200}}]}`
+	bulk.Add(entries[1])
+	if err := client.BulkSend(bulk); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint := tracker.Checkpoint(); checkpoint != 5 {
+		t.Error("Expected every entry to be acknowledged, got", checkpoint)
+	}
+}
+
+func TestAckToRejectedItem(t *testing.T) {
+	response := `{"took":1,"errors":true,"items":[
+		{"index":{"_index":"testing","_id":"a","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}},
+		{"index":{"_index":"testing","_id":"b","status":201}}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, 1)
+
+	tracker := NewAckTracker(0)
+	bulk := NewBulkBody(MB, AckTo(tracker))
+	for _, entry := range []*sequencedEntry{
+		{rawEntry{"index", "testing", "", "a", map[string]interface{}{"v": "1"}}, 1, true},
+		{rawEntry{"index", "testing", "", "b", map[string]interface{}{"v": "2"}}, 2, true},
+	} {
+		if err := tracker.Track(entry.sequence); err != nil {
+			t.Fatal(err)
+		}
+		if err := bulk.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := client.BulkSend(bulk); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint := tracker.Checkpoint(); checkpoint != 2 {
+		t.Error("Expected the checkpoint to move past the rejected entry, got", checkpoint)
+	}
+
+	// Entries failing to be added are done with as well
+	tracker.Track(3)
+	if err := bulk.Add(&sequencedEntry{rawEntry{"update", "testing", "", "", map[string]interface{}{"v": "3"}}, 3, false}); err == nil {
+		t.Fatal("Expected an update without an id to fail")
+	}
+	response = `{"took":1,"errors":false,"items":[{"index":{"_index":"testing","_id":"d","status":201}}]}`
+	tracker.Track(4)
+	bulk.Add(&sequencedEntry{rawEntry{"index", "testing", "", "d", map[string]interface{}{"v": "4"}}, 4, true})
+	if err := client.BulkSend(bulk); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint := tracker.Checkpoint(); checkpoint != 4 {
+		t.Error("Expected the checkpoint to move past the entry failing to be added, got", checkpoint)
+	}
+	if pending := tracker.Pending(); pending != 0 {
+		t.Error("Expected nothing to be pending, got", pending)
+	}
+}
+
+func TestAckToFailedRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, 1)
+
+	tracker := NewAckTracker(0)
+	tracker.Track(1)
+	bulk := NewBulkBody(MB, AckTo(tracker))
+	bulk.Add(&sequencedEntry{rawEntry{"index", "testing", "", "a", map[string]interface{}{"v": "1"}}, 1, false})
+	if err := client.BulkSend(bulk); err == nil {
+		t.Fatal("Expected the request to fail")
+	}
+	if checkpoint := tracker.Checkpoint(); checkpoint != 0 {
+		t.Error("Expected nothing to be acknowledged of a failed request, got", checkpoint)
+	}
+}
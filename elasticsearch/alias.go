@@ -0,0 +1,95 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// IsAlias tells if name is an alias rather than a concrete index, such as to validate configured
+// index names pointing at rollover aliases.
+func (c *Client) IsAlias(ctx context.Context, name string) (bool, error) {
+	resp, _, err := c.do(ctx, "HEAD", "/_alias/"+url.PathEscape(name), "", nil)
+	if err != nil {
+		return false, err
+	}
+	switch resp.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, &ESError{Status: resp.StatusCode, Reason: "Unable to check if alias exists: " + name}
+	}
+}
+
+// WriteIndex is ResolveWriteIndex remembering the result until ForgetWriteIndex is called for the
+// alias, such as after a rollover.
+func (c *Client) WriteIndex(ctx context.Context, alias string) (string, error) {
+	c.aliasLock.Lock()
+	defer c.aliasLock.Unlock()
+	if index, ok := c.writeIndexes[alias]; ok {
+		return index, nil
+	}
+	index, err := c.ResolveWriteIndex(ctx, alias)
+	if err != nil {
+		return "", err
+	}
+	c.writeIndexes[alias] = index
+	return index, nil
+}
+
+// ResolveWriteIndex returns the concrete index that writes to alias goes to. That is the index
+// marked as write index, or the only index of the alias when none is marked. Bulk requests towards
+// an alias having several indexes but no write index fails, which is returned as an error here.
+func (c *Client) ResolveWriteIndex(ctx context.Context, alias string) (string, error) {
+	resp, body, err := c.do(ctx, "GET", "/_alias/"+url.PathEscape(alias), "", nil)
+	if err != nil {
+		return "", err
+	}
+	if code := resp.StatusCode; code != 200 {
+		return "", parseError(code, body)
+	}
+
+	var indexes map[string]struct {
+		Aliases map[string]struct {
+			IsWriteIndex *bool `json:"is_write_index"`
+		} `json:"aliases"`
+	}
+	if err := json.Unmarshal(body, &indexes); err != nil {
+		return "", err
+	}
+	var candidates []string
+	for name, info := range indexes {
+		a, ok := info.Aliases[alias]
+		if !ok {
+			continue
+		}
+		if a.IsWriteIndex != nil && *a.IsWriteIndex {
+			return name, nil
+		}
+		candidates = append(candidates, name)
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("Alias %s doesn't point at any index", alias)
+	case 1:
+		// Without a flag, the only index is written to unless it has been explicitly unmarked
+		if a := indexes[candidates[0]].Aliases[alias]; a.IsWriteIndex == nil {
+			return candidates[0], nil
+		}
+	}
+	sort.Strings(candidates)
+	return "", fmt.Errorf("Alias %s points at %s without a write index, writes to it will fail", alias, strings.Join(candidates, ", "))
+}
+
+// ForgetWriteIndex clears the remembered write index of alias so that the next call to WriteIndex
+// resolves it again.
+func (c *Client) ForgetWriteIndex(alias string) {
+	c.aliasLock.Lock()
+	defer c.aliasLock.Unlock()
+	delete(c.writeIndexes, alias)
+}
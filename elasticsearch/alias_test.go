@@ -0,0 +1,81 @@
+package elasticsearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// aliasServer answers alias requests for logs, pointing at two indexes where the newest is written to,
+// and swapping, pointing at two indexes without a write index.
+func aliasServer(requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if r.URL.Path == "/_alias/swapping" {
+			w.Write([]byte(`{"users-1":{"aliases":{"swapping":{}}},"users-2":{"aliases":{"swapping":{}}}}`))
+			return
+		}
+		if r.URL.Path != "/_alias/logs" {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":"alias [` + r.URL.Path[8:] + `] missing","status":404}`))
+			return
+		}
+		w.Write([]byte(`{"logs-000001":{"aliases":{"logs":{"is_write_index":false}}},"logs-000002":{"aliases":{"logs":{"is_write_index":true}}}}`))
+	}))
+}
+
+func TestIsAlias(t *testing.T) {
+	var requests int
+	server := aliasServer(&requests)
+	defer server.Close()
+
+	client := NewClient(server.URL, 1)
+	if ok, err := client.IsAlias(context.Background(), "logs"); err != nil || !ok {
+		t.Error("Expected logs to be an alias", ok, err)
+	}
+	if ok, err := client.IsAlias(context.Background(), "users"); err != nil || ok {
+		t.Error("Expected users not to be an alias", ok, err)
+	}
+}
+
+func TestWriteIndex(t *testing.T) {
+	var requests int
+	server := aliasServer(&requests)
+	defer server.Close()
+
+	client := NewClient(server.URL, 1)
+	for i := 0; i < 2; i++ {
+		index, err := client.WriteIndex(context.Background(), "logs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != "logs-000002" {
+			t.Error("Unexpected write index", index)
+		}
+	}
+	if requests != 1 {
+		t.Error("Expected write index to be cached, got requests", requests)
+	}
+
+	client.ForgetWriteIndex("logs")
+	client.WriteIndex(context.Background(), "logs")
+	if requests != 2 {
+		t.Error("Expected write index to be resolved again, got requests", requests)
+	}
+
+	if _, err := client.WriteIndex(context.Background(), "users"); err == nil {
+		t.Error("Expected missing alias to fail")
+	}
+}
+
+func TestResolveWriteIndexAmbiguous(t *testing.T) {
+	var requests int
+	server := aliasServer(&requests)
+	defer server.Close()
+
+	_, err := NewClient(server.URL, 1).ResolveWriteIndex(context.Background(), "swapping")
+	if err == nil || err.Error() != "Alias swapping points at users-1, users-2 without a write index, writes to it will fail" {
+		t.Error("Expected an error telling there's no write index, got", err)
+	}
+}
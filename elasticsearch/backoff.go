@@ -0,0 +1,80 @@
+package elasticsearch
+
+import (
+	"math/rand"
+	"time"
+)
+
+// DefaultBackoff is used between retries unless configured by WithBackoff.
+var DefaultBackoff = Backoff{Initial: 100 * time.Millisecond, Max: 10 * time.Second, Jitter: 0.2}
+
+// DefaultPressureBackoff is used between retries of bulk requests rejected by a circuit breaker of
+// ES unless configured by WithPressureBackoff. It starts much longer than DefaultBackoff as ES needs
+// time to collect garbage, retrying right away only adds to its heap.
+var DefaultPressureBackoff = Backoff{Initial: 5 * time.Second, Max: time.Minute, Jitter: 0.2}
+
+// DefaultRetries is how many times a failed request is retried unless configured by WithRetries.
+const DefaultRetries = 3
+
+// Backoff computes exponentially growing delays between retries.
+type Backoff struct {
+	// Delay before the first retry
+	Initial time.Duration
+
+	// No delay will be longer than this
+	Max time.Duration
+
+	// Fraction of the delay to randomly add, to keep clients from retrying in lock step
+	Jitter float64
+}
+
+// Delay returns how long to wait before retry number attempt, starting from 0. It's
+// min(Initial * 2^attempt, Max) plus up to Jitter of that as random jitter, delays that would
+// exceed Max has the jitter subtracted from Max instead.
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.Initial
+	for i := 0; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	jitter := time.Duration(rand.Float64() * b.Jitter * float64(d))
+	if d+jitter > b.Max {
+		return b.Max - jitter
+	}
+	return d + jitter
+}
+
+// WithBackoff configures the delays between retries of failed requests.
+func WithBackoff(initial, max time.Duration, jitter float64) ClientOption {
+	return func(c *Client) {
+		c.backoff = Backoff{initial, max, jitter}
+	}
+}
+
+// WithPressureBackoff configures the delays between retries of bulk requests rejected by a
+// circuit_breaking_exception, as ES does when it's short of heap, instead of the ones of WithBackoff.
+func WithPressureBackoff(initial, max time.Duration, jitter float64) ClientOption {
+	return func(c *Client) {
+		c.pressureBackoff = Backoff{initial, max, jitter}
+	}
+}
+
+// WithRetries configures how many times a failed request is retried before giving up, 0 to never
+// retry.
+func WithRetries(n int) ClientOption {
+	return func(c *Client) {
+		c.retries = n
+	}
+}
+
+// retryable tells if a response status is worth retrying, as in the server being busy or
+// temporarily unavailable.
+func retryable(status int) bool {
+	switch status {
+	case 429, 502, 503, 504:
+		return true
+	}
+	return false
+}
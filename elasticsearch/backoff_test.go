@@ -0,0 +1,186 @@
+package elasticsearch
+
+import (
+	"context"
+	"github.com/duego/cryriver/stats"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: 5 * time.Second, Jitter: 0.5}
+	for attempt := 0; attempt < 100; attempt++ {
+		base := b.Initial << uint(attempt)
+		if attempt > 10 || base > b.Max {
+			base = b.Max
+		}
+		for i := 0; i < 100; i++ {
+			d := b.Delay(attempt)
+			if d > b.Max {
+				t.Fatal("Delay", d, "exceeds max on attempt", attempt)
+			}
+			// Jitter may at most be half of the base delay in either direction
+			if low, high := base-base/2, base+base/2; d < low || d > high {
+				t.Fatal("Delay", d, "outside of jitter bounds", low, high, "on attempt", attempt)
+			}
+		}
+	}
+}
+
+func TestBackoffNoJitter(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: time.Minute}
+	valid := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for attempt, d := range valid {
+		if delay := b.Delay(attempt); delay != d {
+			t.Error("Expected", d, "on attempt", attempt, "got", delay)
+		}
+	}
+	if delay := b.Delay(1000); delay != time.Minute {
+		t.Error("Expected max delay, got", delay)
+	}
+}
+
+func TestBulkSendRetries(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1, WithBackoff(time.Millisecond, 10*time.Millisecond, 0.1))
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+	if err := client.BulkSend(bulk); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Error("Expected 3 attempts, got", requests)
+	}
+	if bulk.Len() != 0 {
+		t.Error("Expected body to be reset after success")
+	}
+}
+
+func TestBulkSendGivesUp(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(429)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1, WithRetries(2), WithBackoff(time.Millisecond, time.Millisecond, 0))
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+	if err := client.BulkSend(bulk); err == nil {
+		t.Fatal("Expected an error after all retries")
+	}
+	if requests != 3 {
+		t.Error("Expected one attempt and 2 retries, got", requests)
+	}
+}
+
+func TestBulkSendCircuitBreaking(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(429)
+			w.Write([]byte(`{"error":{"root_cause":[{"type":"circuit_breaking_exception","reason":"[parent] Data too large, data for [<http_request>] would be [1031524018/983.7mb], which is larger than the limit of [986061209/940.3mb]","bytes_wanted":1031524018,"bytes_limit":986061209,"durability":"TRANSIENT"}],"type":"circuit_breaking_exception","reason":"[parent] Data too large, data for [<http_request>] would be [1031524018/983.7mb], which is larger than the limit of [986061209/940.3mb]","bytes_wanted":1031524018,"bytes_limit":986061209,"durability":"TRANSIENT"},"status":429}`))
+			return
+		}
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	before := stats.MemoryPressure.Value()
+	client := NewClient(server.URL, 1, WithBackoff(time.Millisecond, time.Millisecond, 0), WithPressureBackoff(50*time.Millisecond, 50*time.Millisecond, 0))
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+	start := time.Now()
+	if err := client.BulkSend(bulk); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Error("Expected the longer backoff between retries, took", elapsed)
+	}
+	if requests != 3 {
+		t.Error("Expected 3 attempts, got", requests)
+	}
+	if rejected := stats.MemoryPressure.Value() - before; rejected != 2 {
+		t.Error("Expected both rejections to be counted, got", rejected)
+	}
+}
+
+func TestBulkSendItemsCircuitBreaking(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Accepted as a whole, while the shard of the second entry is short of heap
+		w.Write([]byte(`{"took":1,"errors":true,"items":[{"index":{"status":201}},{"index":{"status":429,"error":{"type":"circuit_breaking_exception","reason":"[parent] Data too large, data for [<transport_request>] would be [1031524018/983.7mb], which is larger than the limit of [986061209/940.3mb]","bytes_wanted":1031524018,"bytes_limit":986061209,"durability":"TRANSIENT"}}}]}`))
+	}))
+	defer server.Close()
+
+	before := stats.MemoryPressure.Value()
+	client := NewClient(server.URL, 1, WithRetries(0))
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}})
+	bulk.Add(&rawEntry{"index", "testing", "user", "2", map[string]interface{}{"foo": "baz"}})
+	if err := client.BulkSend(bulk); err != ErrMemoryPressure {
+		t.Fatal("Expected ErrMemoryPressure, got", err)
+	}
+	if s := bulk.String(); strings.Contains(s, "bar") || !strings.Contains(s, "baz") {
+		t.Error("Expected only the rejected entry to be kept for sending again, got", s)
+	}
+	if rejected := stats.MemoryPressure.Value() - before; rejected != 1 {
+		t.Error("Expected the rejection to be counted, got", rejected)
+	}
+	if d := pause(ErrMemoryPressure, time.Second); d != PressurePause {
+		t.Error("Expected slurpers to give ES time to collect garbage, got", d)
+	}
+}
+
+func TestBulkSendBackoffCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1, WithBackoff(time.Hour, time.Hour, 0))
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := client.BulkSendContext(ctx, bulk); err != context.DeadlineExceeded {
+		t.Error("Expected the error of the context, got", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("Expected to give up waiting between retries once the context is done, took", elapsed)
+	}
+}
+
+func TestBulkSendBusyBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(429)
+		w.Write([]byte(`{"error":{"type":"es_rejected_execution_exception","reason":"rejected execution of coordinating operation"},"status":429}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1, WithRetries(2), WithBackoff(time.Millisecond, time.Millisecond, 0), WithPressureBackoff(10*time.Second, 10*time.Second, 0))
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+	start := time.Now()
+	if err := client.BulkSend(bulk); err == nil {
+		t.Fatal("Expected an error after all retries")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Error("Expected ES being busy to be retried with the usual backoff, took", elapsed)
+	}
+}
@@ -0,0 +1,105 @@
+package elasticsearch
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of sending bulk requests while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("Circuit breaker is open after too many failed bulk requests, not sending")
+
+// CircuitBreaker stops requests towards an elasticsearch that keeps failing. It opens after a
+// number of consecutive failures and rejects every request until a cooldown has passed, then it's
+// half-open and lets one request through at a time to test if ES has recovered. A success closes
+// it again while a failure opens it for another cooldown. It's safe for concurrent use.
+type CircuitBreaker struct {
+	sync.Mutex
+	threshold int
+	cooldown  time.Duration
+
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker returns a closed circuit breaker opening after threshold consecutive failures
+// for cooldown.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// WithCircuitBreaker makes the Client check b before every bulk request, there is no breaker by
+// default.
+func WithCircuitBreaker(b *CircuitBreaker) ClientOption {
+	return func(c *Client) {
+		c.breaker = b
+	}
+}
+
+// State is closed, open or half-open.
+func (b *CircuitBreaker) State() string {
+	b.Lock()
+	defer b.Unlock()
+	switch {
+	case b.failures < b.threshold:
+		return "closed"
+	case time.Since(b.openedAt) < b.cooldown:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// Allow returns ErrCircuitOpen unless a request may be sent. Every allowed request must be
+// followed by a call to Done with its outcome.
+func (b *CircuitBreaker) Allow() error {
+	b.Lock()
+	defer b.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if time.Since(b.openedAt) < b.cooldown || b.probing {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// Done records the outcome of an allowed request.
+func (b *CircuitBreaker) Done(success bool) {
+	b.Lock()
+	defer b.Unlock()
+	b.probing = false
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
+
+// Trip opens the breaker for a cooldown right away, without waiting for the threshold of failures,
+// such as when ES tells that it won't take any writes until someone steps in.
+func (b *CircuitBreaker) Trip() {
+	b.Lock()
+	defer b.Unlock()
+	b.probing = false
+	if b.failures < b.threshold {
+		b.failures = b.threshold
+	}
+	b.openedAt = time.Now()
+}
+
+// failed tells if err means that ES is failing, rather than rejecting what was sent.
+func failed(err error) bool {
+	if err == nil || err == ErrRequestTooLarge || err == ErrUnterminatedBody {
+		return false
+	}
+	if esErr, ok := err.(*ESError); ok {
+		return esErr.Status >= 500 || esErr.Status == 429
+	}
+	return true
+}
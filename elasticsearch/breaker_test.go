@@ -0,0 +1,104 @@
+package elasticsearch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker(2, 50*time.Millisecond)
+
+	// Opens after two failures in a row
+	for i := 0; i < 2; i++ {
+		if err := breaker.Allow(); err != nil {
+			t.Fatal("Expected closed breaker to allow requests, got", err)
+		}
+		breaker.Done(false)
+	}
+	if s := breaker.State(); s != "open" {
+		t.Fatal("Expected breaker to be open, got", s)
+	}
+	if err := breaker.Allow(); err != ErrCircuitOpen {
+		t.Fatal("Expected open breaker to reject requests, got", err)
+	}
+
+	// Half-open lets one request through, its failure opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	if s := breaker.State(); s != "half-open" {
+		t.Fatal("Expected breaker to be half-open, got", s)
+	}
+	if err := breaker.Allow(); err != nil {
+		t.Fatal("Expected half-open breaker to allow a request, got", err)
+	}
+	if err := breaker.Allow(); err != ErrCircuitOpen {
+		t.Error("Expected only one request at a time while half-open, got", err)
+	}
+	breaker.Done(false)
+	if s := breaker.State(); s != "open" {
+		t.Fatal("Expected failed test to open the breaker, got", s)
+	}
+
+	// A successful test closes it
+	time.Sleep(60 * time.Millisecond)
+	if err := breaker.Allow(); err != nil {
+		t.Fatal(err)
+	}
+	breaker.Done(true)
+	if s := breaker.State(); s != "closed" {
+		t.Error("Expected breaker to be closed, got", s)
+	}
+}
+
+func TestBulkSendCircuitBreaker(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(503)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1, WithRetries(0), WithCircuitBreaker(NewCircuitBreaker(2, time.Hour)))
+	for i := 0; i < 3; i++ {
+		bulk := NewBulkBody(MB)
+		bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+		err := client.BulkSend(bulk)
+		if i < 2 && err == ErrCircuitOpen {
+			t.Error("Expected request to be sent before the breaker opens")
+		}
+		if i == 2 && err != ErrCircuitOpen {
+			t.Error("Expected ErrCircuitOpen, got", err)
+		}
+	}
+	if requests != 2 {
+		t.Error("Expected no requests while open, got", requests)
+	}
+}
+
+func TestBulkSendClusterReadOnlyTrips(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(403)
+		w.Write([]byte(`{"error":{"type":"cluster_block_exception","reason":"blocked by: [FORBIDDEN/6/cluster read-only (api)];"},"status":403}`))
+	}))
+	defer server.Close()
+
+	breaker := NewCircuitBreaker(5, time.Hour)
+	client := NewClient(server.URL, 1, WithRetries(0), WithCircuitBreaker(breaker))
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+	if err := client.BulkSend(bulk); err != ErrClusterReadOnly {
+		t.Fatal("Expected ErrClusterReadOnly, got", err)
+	}
+	if state := breaker.State(); state != "open" {
+		t.Error("Expected the breaker to open on the first block, got", state)
+	}
+	if err := client.BulkSend(bulk); err != ErrCircuitOpen {
+		t.Error("Expected ErrCircuitOpen, got", err)
+	}
+	if requests != 1 {
+		t.Error("Expected no requests while open, got", requests)
+	}
+}
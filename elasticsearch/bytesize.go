@@ -0,0 +1,92 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// byteUnits are the units of ByteSize from the largest, as written by String and read by
+// ParseByteSize.
+var byteUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{"GB", GB},
+	{"MB", MB},
+	{"KB", KB},
+	{"B", 1},
+}
+
+// ParseByteSize reads a size such as 5MB, 512KB or 1.5GB, in units of 1024 like KB, MB and GB.
+// Units are case insensitive and a number without one is in bytes.
+func ParseByteSize(s string) (ByteSize, error) {
+	number := strings.TrimSpace(s)
+	unit := ByteSize(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(number), u.suffix) {
+			number = strings.TrimSpace(number[:len(number)-len(u.suffix)])
+			unit = u.size
+			break
+		}
+	}
+	invalid := fmt.Errorf("Invalid size %q, expected a number of B, KB, MB or GB such as 512KB", s)
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, invalid
+	}
+	size := n * float64(unit)
+	if size != math.Trunc(size) {
+		return 0, fmt.Errorf("Invalid size %q, it's not a whole number of bytes", s)
+	}
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("Invalid size %q, it's too large", s)
+	}
+	return ByteSize(size), nil
+}
+
+// String returns the size in the largest unit it's a number of with at most two decimals, such as
+// 1.5GB, reading it with ParseByteSize gives the same size back.
+func (b ByteSize) String() string {
+	for _, u := range byteUnits {
+		if b >= u.size && b%u.size*100%u.size == 0 {
+			return strconv.FormatFloat(float64(b)/float64(u.size), 'f', -1, 64) + u.suffix
+		}
+	}
+	return strconv.FormatInt(int64(b), 10) + "B"
+}
+
+// MarshalText writes the size as String does.
+func (b ByteSize) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText reads the size as ParseByteSize does.
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	size, err := ParseByteSize(string(text))
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// UnmarshalJSON reads either a string as ParseByteSize does or a number of bytes, null leaves the
+// size as it is like for other types.
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		return b.UnmarshalText([]byte(s))
+	}
+	// Whole numbers like 1e6 are read exactly as well
+	return b.UnmarshalText(data)
+}
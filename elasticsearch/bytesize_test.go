@@ -0,0 +1,88 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for s, expected := range map[string]ByteSize{
+		"5MB":     5 * MB,
+		"512KB":   512 * KB,
+		"1.5GB":   GB + 512*MB,
+		"1.5 gb":  GB + 512*MB,
+		"100mb":   100 * MB,
+		"1024":    KB,
+		"10B":     10,
+		" 2 KB ":  2 * KB,
+		"0":       0,
+		"0.5KB":   512,
+		"1048576": MB,
+	} {
+		size, err := ParseByteSize(s)
+		if err != nil {
+			t.Error("Unable to parse", s, err)
+			continue
+		}
+		if size != expected {
+			t.Error("Expected", s, "to be", int64(expected), "bytes, got", int64(size))
+		}
+	}
+
+	for _, s := range []string{"", "MB", "5XB", "-1MB", "1.5B", "0.1KB", "five MB", "NaNMB", "InfGB", "1e30GB"} {
+		if size, err := ParseByteSize(s); err == nil {
+			t.Error("Expected", s, "to be rejected, got", int64(size))
+		}
+	}
+}
+
+func TestByteSizeString(t *testing.T) {
+	for size, expected := range map[ByteSize]string{
+		0:               "0B",
+		10:              "10B",
+		KB:              "1KB",
+		512 * KB:        "512KB",
+		5 * MB:          "5MB",
+		GB + 512*MB:     "1.5GB",
+		GB + 256*MB:     "1.25GB",
+		1234567:         "1234567B",
+		MaxBulkSize:     "100MB",
+		3*GB + 100*MB:   "3172MB",
+		DefaultBulkSize: "1MB",
+	} {
+		s := size.String()
+		if s != expected {
+			t.Error("Expected", int64(size), "bytes to be", expected, "got", s)
+		}
+		if parsed, err := ParseByteSize(s); err != nil || parsed != size {
+			t.Error("Expected", s, "to be parsed back to", int64(size), "got", int64(parsed), err)
+		}
+	}
+}
+
+func TestByteSizeJSON(t *testing.T) {
+	var config struct {
+		Max      ByteSize `json:"max"`
+		Oversize ByteSize `json:"oversize"`
+	}
+	if err := json.Unmarshal([]byte(`{"max":"5MB","oversize":2048}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Max != 5*MB || config.Oversize != 2*KB {
+		t.Error("Unexpected sizes", int64(config.Max), int64(config.Oversize))
+	}
+	if err := json.Unmarshal([]byte(`{"max":null}`), &config); err != nil || config.Max != 5*MB {
+		t.Error("Expected null to leave the size as it is, got", int64(config.Max), err)
+	}
+	if err := json.Unmarshal([]byte(`{"max":"5 megs"}`), &config); err == nil {
+		t.Error("Expected an error for a malformed size")
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"max":"5MB","oversize":"2KB"}` {
+		t.Error("Unexpected JSON", string(b))
+	}
+}
@@ -0,0 +1,25 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// CanonicalJSON rewrites the serialized json b with the keys of every object sorted and no
+// whitespace between values, for hashing or comparing documents by their content. Numbers and
+// strings are kept as they were written, also integers too large for a float64.
+//
+// Documents built by a BulkBody are already stable: the same document serializes to the same bytes
+// every time as encoding/json sorts the keys of maps, and so does mongodb.MarshalJSON. Marshalers
+// keeping an order of their own, such as bson.D in mongodb.MarshalJSON, write the same document
+// the same way but two documents only differing in the order of their fields differently.
+// CanonicalJSON makes those equal too.
+func CanonicalJSON(b []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,53 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	a, err := CanonicalJSON([]byte(`{"name":"Johnny","address":{"street":"Götgatan","city":"Stockholm"},"id":12345678901234567890}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := CanonicalJSON([]byte(`{ "id": 12345678901234567890, "address": {"city":"Stockholm", "street":"Götgatan"}, "name": "Johnny" }`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Errorf("Expected the same canonical json, got %s and %s", a, b)
+	}
+	if s := string(a); s != `{"address":{"city":"Stockholm","street":"Götgatan"},"id":12345678901234567890,"name":"Johnny"}` {
+		t.Error("Expected sorted keys and untouched numbers, got", s)
+	}
+
+	if _, err := CanonicalJSON([]byte(`{"name":`)); err == nil {
+		t.Error("Expected invalid json to fail")
+	}
+}
+
+func TestBulkBodyStableDocuments(t *testing.T) {
+	var bodies [][]byte
+	for i := 0; i < 10; i++ {
+		// Maps built in different orders, iterated in random order
+		doc := map[string]interface{}{}
+		nested := map[string]interface{}{}
+		keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+		for j := range keys {
+			key := keys[(i+j)%len(keys)]
+			doc[key] = key + "!"
+			nested[key] = key
+		}
+		doc["nested"] = nested
+		bulk := NewBulkBody(MB)
+		if err := bulk.Add(&rawEntry{"index", "testing", "user", "123", doc}); err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, bulk.Bytes())
+	}
+	for _, body := range bodies[1:] {
+		if !bytes.Equal(body, bodies[0]) {
+			t.Fatalf("Expected the same document to serialize the same, got %s and %s", body, bodies[0])
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package elasticsearch
+
+import (
+	"github.com/duego/cryriver/stats"
+)
+
+// span is where one entry is found in the buffer of a BulkBody.
+type span struct {
+	start, end int
+	action     string
+
+	// Index of the item in the sequences of the body, when acknowledging
+	item int
+}
+
+// documentKey identifies the document an entry is applied to.
+func documentKey(header *indexHeader) string {
+	return header.Name + "/" + header.Type + "/" + header.Id
+}
+
+// write adds the serialized entry to the body and counts its action. The last index or update of
+// every document is tracked so that it can be dropped if replaced by a later entry, any other action
+// of a document stops what came before it from being dropped.
+func (bulk *BulkBody) write(v BulkEntry, action string, header *indexHeader, entry []byte) error {
+	key := documentKey(header)
+	if bulk.actions == nil {
+		bulk.actions = make(map[string]int)
+	}
+	// Entries without an id are new documents that never replace each other
+	if header.Id == "" {
+		if _, err := bulk.Write(entry); err != nil {
+			return err
+		}
+		bulk.written(v, len(entry))
+		bulk.actions[action]++
+		return nil
+	}
+	if action != "index" && action != "update" {
+		delete(bulk.last, key)
+		if _, err := bulk.Write(entry); err != nil {
+			return err
+		}
+		bulk.written(v, len(entry))
+		bulk.actions[action]++
+		return nil
+	}
+
+	var replacer Replacer
+	if entryAs(v, &replacer) && replacer.Replaces() {
+		bulk.drop(key)
+	}
+	start := bulk.Len()
+	if _, err := bulk.Write(entry); err != nil {
+		return err
+	}
+	bulk.written(v, len(entry))
+	if bulk.last == nil {
+		bulk.last = make(map[string]span)
+	}
+	bulk.last[key] = span{start, bulk.Len(), action, len(bulk.sequences) - 1}
+	bulk.actions[action]++
+	return nil
+}
+
+// written records that an item of size bytes was written for v, along with what it acknowledges.
+func (bulk *BulkBody) written(v BulkEntry, size int) {
+	bulk.writes++
+	bulk.sizes.add(ByteSize(size))
+	if bulk.acks == nil {
+		return
+	}
+	sequences := append(bulk.carried, sequenceOf(v))
+	bulk.carried = nil
+	bulk.sequences = append(bulk.sequences, sequences)
+}
+
+// drop cuts the last tracked entry of key out of the buffer, moving the entries after it. When
+// acknowledging, its sequences are carried over to the entry written next, which replaces it.
+func (bulk *BulkBody) drop(key string) {
+	dropped, ok := bulk.last[key]
+	if !ok {
+		return
+	}
+	delete(bulk.last, key)
+	bulk.actions[dropped.action]--
+	bulk.sizes.remove(ByteSize(dropped.end - dropped.start))
+	b := bulk.Bytes()
+	n := dropped.end - dropped.start
+	copy(b[dropped.start:], b[dropped.end:])
+	bulk.Truncate(len(b) - n)
+	if bulk.acks != nil {
+		bulk.carried = append(bulk.carried, bulk.sequences[dropped.item]...)
+		bulk.sequences = append(bulk.sequences[:dropped.item], bulk.sequences[dropped.item+1:]...)
+	}
+	for k, s := range bulk.last {
+		if s.start > dropped.start {
+			bulk.last[k] = span{s.start - n, s.end - n, s.action, s.item - 1}
+		}
+	}
+	stats.Coalesced.Add(1)
+}
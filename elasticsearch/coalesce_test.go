@@ -0,0 +1,127 @@
+package elasticsearch
+
+import (
+	"strings"
+	"testing"
+)
+
+// replacingEntry carries the whole document.
+type replacingEntry struct {
+	rawEntry
+}
+
+func (e *replacingEntry) Replaces() bool {
+	return true
+}
+
+// bodyIds returns the action and id of every entry in the body, in order.
+func bodyIds(t *testing.T, bulk *BulkBody) []string {
+	ops, err := ParseBulkBody(bulk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, op := range ops {
+		ids = append(ids, op.action+" "+op.header.Id+" "+fmtValue(op.doc["v"]))
+	}
+	return ids
+}
+
+func fmtValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return "-"
+}
+
+func expectIds(t *testing.T, bulk *BulkBody, expected ...string) {
+	ids := bodyIds(t, bulk)
+	if len(ids) != len(expected) {
+		t.Fatal("Expected", expected, "got", ids)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Error("Expected", expected, "got", ids)
+			return
+		}
+	}
+}
+
+func TestCoalesceReplaced(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	entries := []BulkEntry{
+		&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"v": "a"}},
+		&replacingEntry{rawEntry{"update", "testing", "user", "1", map[string]interface{}{"v": "b"}}},
+		&rawEntry{"index", "testing", "user", "2", map[string]interface{}{"v": "c"}},
+		&replacingEntry{rawEntry{"index", "testing", "user", "1", map[string]interface{}{"v": "d"}}},
+		// Same id in another index is another document
+		&replacingEntry{rawEntry{"index", "other", "user", "2", map[string]interface{}{"v": "e"}}},
+	}
+	for _, entry := range entries {
+		if err := bulk.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectIds(t, bulk, "index 2 c", "index 1 d", "index 2 e")
+}
+
+func TestCoalesceKeepsDeletes(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	entries := []BulkEntry{
+		&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"v": "a"}},
+		&rawEntry{"delete", "testing", "user", "1", nil},
+		&replacingEntry{rawEntry{"index", "testing", "user", "1", map[string]interface{}{"v": "b"}}},
+		&rawEntry{"delete", "testing", "user", "1", nil},
+		&replacingEntry{rawEntry{"index", "testing", "user", "1", map[string]interface{}{"v": "c"}}},
+		&replacingEntry{rawEntry{"index", "testing", "user", "1", map[string]interface{}{"v": "d"}}},
+	}
+	for _, entry := range entries {
+		if err := bulk.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectIds(t, bulk, "index 1 a", "delete 1 -", "index 1 b", "delete 1 -", "index 1 d")
+}
+
+func TestCoalescePartialUpdatesKept(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	entries := []BulkEntry{
+		&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"v": "a"}},
+		&rawEntry{"update", "testing", "user", "1", map[string]interface{}{"v": "b"}},
+	}
+	for _, entry := range entries {
+		if err := bulk.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectIds(t, bulk, "index 1 a", "update 1 b")
+}
+
+func TestCoalesceAfterReset(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	if err := bulk.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"v": "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	bulk.Done()
+	bulk.Reset()
+	if err := bulk.Add(&rawEntry{"index", "testing", "user", "2", map[string]interface{}{"v": "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing tracked from before the reset may be cut out of the new body
+	if err := bulk.Add(&replacingEntry{rawEntry{"index", "testing", "user", "1", map[string]interface{}{"v": "c"}}}); err != nil {
+		t.Fatal(err)
+	}
+	expectIds(t, bulk, "index 2 b", "index 1 c")
+}
+
+func TestCoalesceWithoutId(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	for i := 0; i < 2; i++ {
+		if err := bulk.Add(&replacingEntry{rawEntry{"index", "testing", "user", "", map[string]interface{}{"n": i}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := strings.Count(bulk.String(), `"index"`); n != 2 {
+		t.Error("Expected entries without an id to never replace each other, got", bulk.String())
+	}
+}
@@ -0,0 +1,103 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync/atomic"
+)
+
+// Stats are the totals of a Client since it was created. Each counter is read atomically, but as
+// requests are counted while Stats is called they may be off by one request relative to each other.
+type Stats struct {
+	// Operations by action accepted by ES, failed items included
+	Indexed int64
+	Created int64
+	Updated int64
+	Deleted int64
+
+	// Bulk requests and bytes sent, retries included
+	Requests int64
+	Bytes    int64
+
+	// Single operations rejected by ES in accepted bulk requests
+	ItemErrors int64
+
+	// Bulk requests sent again after failing
+	Retries int64
+}
+
+// counters holds the Stats of a Client, updated atomically. It's allocated on its own to keep the
+// alignment atomic requires on 32-bit platforms.
+type counters struct {
+	stats Stats
+}
+
+// Stats returns the totals of the Client since it was created, safe to call concurrently with
+// requests being sent.
+func (c *Client) Stats() Stats {
+	s := &c.counters.stats
+	return Stats{
+		Indexed:    atomic.LoadInt64(&s.Indexed),
+		Created:    atomic.LoadInt64(&s.Created),
+		Updated:    atomic.LoadInt64(&s.Updated),
+		Deleted:    atomic.LoadInt64(&s.Deleted),
+		Requests:   atomic.LoadInt64(&s.Requests),
+		Bytes:      atomic.LoadInt64(&s.Bytes),
+		ItemErrors: atomic.LoadInt64(&s.ItemErrors),
+		Retries:    atomic.LoadInt64(&s.Retries),
+	}
+}
+
+// request counts an attempt at sending a bulk body of n bytes.
+func (c *counters) request(n int, retry bool) {
+	atomic.AddInt64(&c.stats.Requests, 1)
+	atomic.AddInt64(&c.stats.Bytes, int64(n))
+	if retry {
+		atomic.AddInt64(&c.stats.Retries, 1)
+	}
+}
+
+// accepted counts the operations of the body b accepted by ES with the response body resp. Bodies
+// not built by Add, such as those read back from a spool, has their actions counted from sent.
+func (c *counters) accepted(b *BulkBody, sent, resp []byte) {
+	actions := b.actions
+	if actions == nil {
+		actions = make(map[string]int)
+		entries, _ := splitEntries(sent)
+		for _, entry := range entries {
+			actions[entry.Action]++
+		}
+	}
+	for action, n := range actions {
+		switch action {
+		case "index":
+			atomic.AddInt64(&c.stats.Indexed, int64(n))
+		case "create":
+			atomic.AddInt64(&c.stats.Created, int64(n))
+		case "update":
+			atomic.AddInt64(&c.stats.Updated, int64(n))
+		case "delete":
+			atomic.AddInt64(&c.stats.Deleted, int64(n))
+		}
+	}
+
+	// Items only needs to be looked at when ES tells some failed
+	if !bytes.Contains(resp, []byte(`"errors":true`)) {
+		return
+	}
+	var items struct {
+		Items []map[string]bulkItem `json:"items"`
+	}
+	if err := json.Unmarshal(resp, &items); err != nil {
+		return
+	}
+	var failed int64
+	for _, item := range items.Items {
+		for _, result := range item {
+			if len(result.Error) > 0 {
+				failed++
+			}
+		}
+	}
+	atomic.AddInt64(&c.stats.ItemErrors, failed)
+}
@@ -0,0 +1,119 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientStats(t *testing.T) {
+	var lock sync.Mutex
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests++
+		first := requests == 1
+		lock.Unlock()
+		// The first request is retried
+		if first {
+			w.WriteHeader(503)
+			return
+		}
+		w.Write([]byte(`{"took":1,"errors":true,"items":[` +
+			`{"index":{"_id":"1","status":201}},` +
+			`{"update":{"_id":"2","status":400,"error":{"type":"mapper_parsing_exception","reason":"bad"}}},` +
+			`{"delete":{"_id":"3","status":200}}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1, WithBackoff(time.Millisecond, time.Millisecond, 0))
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}})
+	bulk.Add(&rawEntry{"update", "testing", "user", "2", map[string]interface{}{"foo": "bar"}})
+	bulk.Add(&rawEntry{"delete", "testing", "user", "3", nil})
+	bulk.Done()
+	size := int64(bulk.Len())
+	if err := client.BulkSend(bulk); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := client.Stats()
+	if stats.Indexed != 1 || stats.Updated != 1 || stats.Deleted != 1 || stats.Created != 0 {
+		t.Error("Unexpected operations by action", stats)
+	}
+	if stats.Requests != 2 || stats.Retries != 1 {
+		t.Error("Expected one retried request, got", stats)
+	}
+	if stats.Bytes != 2*size {
+		t.Error("Expected bytes of both attempts, got", stats.Bytes)
+	}
+	if stats.ItemErrors != 1 {
+		t.Error("Expected one item error, got", stats.ItemErrors)
+	}
+}
+
+func TestClientStatsRawBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	// Such as a body read back from the spool
+	body := []byte(`{"create":{"_index":"testing","_id":"1"}}` + "\n" + `{"foo":"bar"}` + "\n" + `{"delete":{"_index":"testing","_id":"2"}}` + "\n\n")
+	client := NewClient(server.URL, 1)
+	bulk := &BulkBody{Buffer: bytes.NewBuffer(body), max: MaxBulkSize, done: true}
+	if err := client.BulkSendContext(context.Background(), bulk); err != nil {
+		t.Fatal(err)
+	}
+	if stats := client.Stats(); stats.Created != 1 || stats.Deleted != 1 {
+		t.Error("Expected actions to be counted from the body, got", stats)
+	}
+}
+
+func TestClientStatsCoalesced(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1)
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"update", "testing", "user", "1", map[string]interface{}{"foo": "bar"}})
+	bulk.Add(&replacingEntry{rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "baz"}}})
+	if err := client.BulkSend(bulk); err != nil {
+		t.Fatal(err)
+	}
+	if stats := client.Stats(); stats.Indexed != 1 || stats.Updated != 0 {
+		t.Error("Expected dropped entries not to be counted, got", stats)
+	}
+}
+
+func TestClientStatsConcurrent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 4)
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				bulk := NewBulkBody(KB)
+				bulk.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}})
+				client.BulkSend(bulk)
+				client.Stats()
+			}
+		}()
+	}
+	wg.Wait()
+	if stats := client.Stats(); stats.Indexed != 40 || stats.Requests != 40 {
+		t.Error("Expected every request to be counted, got", stats)
+	}
+}
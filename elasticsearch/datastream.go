@@ -0,0 +1,58 @@
+package elasticsearch
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DataStreamTimestamp is the field data streams requires every document to have.
+const DataStreamTimestamp = "@timestamp"
+
+// ErrDataStreamAction is returned when adding updates or deletes to a BulkBody writing to a data
+// stream, data streams are append-only.
+var ErrDataStreamAction = errors.New("Data streams only accept new documents, updates and deletes are not supported")
+
+// dataStream is the configuration of WithDataStream.
+type dataStream struct {
+	name           string
+	timestampField string
+}
+
+// WithDataStream makes the BulkBody append all documents to the data stream name, regardless of
+// which index the entries reports. Every entry is added as a create and the @timestamp field is
+// copied from timestampField of the document unless it already has one.
+func WithDataStream(name, timestampField string) BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.dataStream = &dataStream{name, timestampField}
+	}
+}
+
+// header targets the data stream with a create action.
+func (ds *dataStream) header(action string, header *indexHeader) (string, error) {
+	switch action {
+	case "index", "create":
+	default:
+		return "", ErrDataStreamAction
+	}
+	header.Name = ds.name
+	// Data streams are typeless
+	header.Type = ""
+	return "create", nil
+}
+
+// document returns doc with the @timestamp field set, doc itself is left untouched.
+func (ds *dataStream) document(doc map[string]interface{}) (map[string]interface{}, error) {
+	if _, ok := doc[DataStreamTimestamp]; ok {
+		return doc, nil
+	}
+	ts, ok := doc[ds.timestampField]
+	if !ok || ts == nil {
+		return nil, fmt.Errorf("Document has neither %s nor %s for the data stream", DataStreamTimestamp, ds.timestampField)
+	}
+	withTs := make(map[string]interface{}, len(doc)+1)
+	for k, v := range doc {
+		withTs[k] = v
+	}
+	withTs[DataStreamTimestamp] = ts
+	return withTs, nil
+}
@@ -0,0 +1,45 @@
+package elasticsearch
+
+import (
+	"testing"
+)
+
+func TestDataStreamCreateOnly(t *testing.T) {
+	bulk := NewBulkBody(MB, WithDataStream("logs-app", "created_at"))
+	if err := bulk.Add(&rawEntry{"index", "testing", "log", "1", map[string]interface{}{
+		"created_at": "2014-02-25T10:46:24Z",
+		"message":    "hello",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	valid := `{"create":{"_index":"logs-app","_id":"1"}}
+{"@timestamp":"2014-02-25T10:46:24Z","created_at":"2014-02-25T10:46:24Z","message":"hello"}
+`
+	if bulk.String() != valid {
+		t.Errorf("\n'%s'\nNot equal to:\n'%s'", bulk.String(), valid)
+	}
+
+	for _, action := range []string{"update", "delete"} {
+		if err := bulk.Add(&rawEntry{action, "testing", "log", "1", map[string]interface{}{"foo": "bar"}}); err != ErrDataStreamAction {
+			t.Error("Expected", action, "to be rejected, got", err)
+		}
+	}
+}
+
+func TestDataStreamTimestamp(t *testing.T) {
+	bulk := NewBulkBody(MB, WithDataStream("logs-app", "created_at"))
+	doc := map[string]interface{}{"@timestamp": "2014-02-25T10:46:24Z", "created_at": "2013-01-01T00:00:00Z"}
+	if err := bulk.Add(&rawEntry{"create", "testing", "log", "", doc}); err != nil {
+		t.Fatal(err)
+	}
+	valid := `{"create":{"_index":"logs-app"}}
+{"@timestamp":"2014-02-25T10:46:24Z","created_at":"2013-01-01T00:00:00Z"}
+`
+	if bulk.String() != valid {
+		t.Errorf("\n'%s'\nNot equal to:\n'%s'", bulk.String(), valid)
+	}
+
+	if err := bulk.Add(&rawEntry{"index", "testing", "log", "2", map[string]interface{}{"foo": "bar"}}); err == nil {
+		t.Error("Expected document without timestamp to be rejected")
+	}
+}
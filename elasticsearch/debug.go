@@ -0,0 +1,31 @@
+package elasticsearch
+
+import (
+	"github.com/duego/cryriver/redact"
+)
+
+// BulkDebugger receives what was sent in a bulk request refused by elasticsearch, along with the
+// error returned for it.
+type BulkDebugger func(body []byte, err error)
+
+// WithBulkDebug hands the body of every bulk request ES responds to with an error status, such as a
+// 400 for a malformed line, to debug. It's off by default. Bodies are passed with the fields in
+// redact.Paths masked like logged bodies are, and truncated to max bytes unless max is 0.
+func WithBulkDebug(debug BulkDebugger, max int) ClientOption {
+	return func(c *Client) {
+		c.bulkDebug = debug
+		c.bulkDebugMax = max
+	}
+}
+
+// debugBulk hands sent to the BulkDebugger, if any, for failing with err.
+func (c *Client) debugBulk(sent []byte, err error) {
+	if c.bulkDebug == nil {
+		return
+	}
+	body := redact.JSON(sent)
+	if c.bulkDebugMax > 0 && len(body) > c.bulkDebugMax {
+		body = body[:c.bulkDebugMax]
+	}
+	c.bulkDebug(body, err)
+}
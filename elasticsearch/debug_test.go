@@ -0,0 +1,58 @@
+package elasticsearch
+
+import (
+	"github.com/duego/cryriver/redact"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBulkDebug(t *testing.T) {
+	previous := redact.Paths
+	redact.Paths = []string{"email"}
+	defer func() { redact.Paths = previous }()
+
+	status := http.StatusBadRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":{"type":"illegal_argument_exception","reason":"Malformed action/metadata line [1]"},"status":400}`))
+	}))
+	defer server.Close()
+
+	var debugged []string
+	var debugErr error
+	client := NewClient(server.URL, 1, WithBulkDebug(func(body []byte, err error) {
+		debugged = append(debugged, string(body))
+		debugErr = err
+	}, 0))
+
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"email": "joe@example.com", "name": "Joe"}})
+	err := client.BulkSend(bulk)
+	if err == nil {
+		t.Fatal("Expected the bulk request to fail")
+	}
+	if len(debugged) != 1 || debugErr != err {
+		t.Fatal("Expected the failed body to be debugged with the error, got", debugged, debugErr)
+	}
+	if strings.Contains(debugged[0], "joe@example.com") || !strings.Contains(debugged[0], `"name":"Joe"`) {
+		t.Error("Expected the debugged body to be redacted:\n", debugged[0])
+	}
+
+	client = NewClient(server.URL, 1, WithBulkDebug(func(body []byte, err error) {
+		debugged = append(debugged, string(body))
+	}, 10))
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"name": "Joe"}})
+	client.BulkSend(bulk)
+	if len(debugged) != 2 || len(debugged[1]) != 10 {
+		t.Error("Expected the debugged body to be truncated, got", debugged)
+	}
+
+	status = http.StatusOK
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"name": "Joe"}})
+	client.BulkSend(bulk)
+	if len(debugged) != 2 {
+		t.Error("Expected accepted bodies to not be debugged, got", debugged)
+	}
+}
@@ -0,0 +1,57 @@
+package elasticsearch
+
+// IndexDefaults are the bulk options given to every entry towards an index, such as to set the
+// conflict policy of a collection in one place rather than in each entry. Entries implementing
+// ConflictRetrier, VersionTyper or Pipeliner overrides the defaults.
+type IndexDefaults struct {
+	// Retries of updates on version conflicts
+	RetryOnConflict int
+
+	// How versions are compared, only sent for entries implementing Versioner as ES requires a
+	// version with it
+	VersionType string
+
+	// Ingest pipeline for new documents
+	Pipeline string
+}
+
+// WithIndexDefaults makes the BulkBody apply defaults to the entries towards each index found in
+// it, keyed by index name.
+func WithIndexDefaults(defaults map[string]IndexDefaults) BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.defaults = defaults
+	}
+}
+
+// applyDefaults sets the options of the header for v, the defaults of the index first and then
+// whatever v has of its own. Each option is only set for the actions ES accepts it for.
+func (bulk *BulkBody) applyDefaults(v BulkEntry, action string, header *indexHeader) {
+	defaults := bulk.defaults[header.Name]
+	retries, versionType, pipeline := defaults.RetryOnConflict, defaults.VersionType, defaults.Pipeline
+	var retrier ConflictRetrier
+	if entryAs(v, &retrier) {
+		retries = retrier.RetryOnConflict()
+	}
+	var typer VersionTyper
+	if entryAs(v, &typer) {
+		versionType = typer.VersionType()
+	}
+	var pipeliner Pipeliner
+	if entryAs(v, &pipeliner) {
+		pipeline = pipeliner.Pipeline()
+	}
+
+	switch action {
+	case "update":
+		header.RetryOnConflict = retries
+	case "index", "create":
+		header.Pipeline = pipeline
+		fallthrough
+	case "delete":
+		var versioner Versioner
+		if entryAs(v, &versioner) {
+			header.Version = versioner.Version()
+			header.VersionType = versionType
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package elasticsearch
+
+import (
+	"testing"
+)
+
+// policyEntry has a version and bulk options of its own.
+type policyEntry struct {
+	rawEntry
+	version     int64
+	versionType string
+	pipeline    string
+}
+
+func (p *policyEntry) Version() int64 {
+	return p.version
+}
+
+func (p *policyEntry) VersionType() string {
+	return p.versionType
+}
+
+func (p *policyEntry) Pipeline() string {
+	return p.pipeline
+}
+
+// versionedEntry only has a version.
+type versionedEntry struct {
+	rawEntry
+	version int64
+}
+
+func (v *versionedEntry) Version() int64 {
+	return v.version
+}
+
+func TestBulkBodyIndexDefaults(t *testing.T) {
+	defaults := WithIndexDefaults(map[string]IndexDefaults{
+		"users": {RetryOnConflict: 5, VersionType: "external", Pipeline: "users"},
+	})
+	doc := map[string]interface{}{"alias": "Johnny"}
+	for _, test := range []struct {
+		entry  BulkEntry
+		header string
+	}{
+		// Defaults of the index
+		{&rawEntry{"update", "users", "user", "1", doc}, `{"update":{"_index":"users","_type":"user","_id":"1","retry_on_conflict":5}}`},
+		{&versionedEntry{rawEntry{"index", "users", "user", "1", doc}, 7}, `{"index":{"_index":"users","_type":"user","_id":"1","version":7,"version_type":"external","pipeline":"users"}}`},
+		// Version type is left out without a version
+		{&rawEntry{"index", "users", "user", "1", doc}, `{"index":{"_index":"users","_type":"user","_id":"1","pipeline":"users"}}`},
+		// Entries overrides the defaults
+		{&retryingEntry{rawEntry{"update", "users", "user", "1", doc}, 1}, `{"update":{"_index":"users","_type":"user","_id":"1","retry_on_conflict":1}}`},
+		{&policyEntry{rawEntry{"index", "users", "user", "1", doc}, 8, "external_gte", "special"}, `{"index":{"_index":"users","_type":"user","_id":"1","version":8,"version_type":"external_gte","pipeline":"special"}}`},
+		// Other indexes has no defaults
+		{&rawEntry{"update", "photos", "photo", "1", doc}, `{"update":{"_index":"photos","_type":"photo","_id":"1"}}`},
+	} {
+		bulk := NewBulkBody(MB, defaults)
+		if err := bulk.Add(test.entry); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := bulk.Entries()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header := string(entries[0].Header); header != test.header {
+			t.Errorf("\n'%s'\nExpected:\n'%s'", header, test.header)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package elasticsearch
+
+// EntrySizes are the sizes of the entries in a BulkBody, such as to spot a collection with huge
+// documents. Each entry is counted with its header and newlines.
+type EntrySizes struct {
+	Count int
+	Total ByteSize
+
+	// Smallest and largest entry added since the body was reset. Entries dropped as replaced by a
+	// later entry of the same document are still covered by them, but not by Count and Total.
+	Min ByteSize
+	Max ByteSize
+}
+
+// Avg returns the average size of the entries, 0 if there are none.
+func (s EntrySizes) Avg() ByteSize {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / ByteSize(s.Count)
+}
+
+// add counts an entry of size bytes.
+func (s *EntrySizes) add(size ByteSize) {
+	if s.Count == 0 || size < s.Min {
+		s.Min = size
+	}
+	if size > s.Max {
+		s.Max = size
+	}
+	s.Count++
+	s.Total += size
+}
+
+// remove uncounts an entry of size bytes that has been dropped.
+func (s *EntrySizes) remove(size ByteSize) {
+	s.Count--
+	s.Total -= size
+}
+
+// EntrySizes returns the sizes of the entries in the body, tracked as they are added. They are
+// reset along with the body.
+func (bulk *BulkBody) EntrySizes() EntrySizes {
+	if bulk.Len() == 0 {
+		return EntrySizes{}
+	}
+	return bulk.sizes
+}
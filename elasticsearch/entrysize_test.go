@@ -0,0 +1,46 @@
+package elasticsearch
+
+import (
+	"testing"
+)
+
+func TestBulkBodyEntrySizes(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	if sizes := bulk.EntrySizes(); sizes.Avg() != 0 || sizes.Count != 0 {
+		t.Error("Expected no sizes of an empty body, got", sizes)
+	}
+
+	small := &rawEntry{"index", "testing", "user", "1", map[string]interface{}{"v": "a"}}
+	large := &rawEntry{"index", "testing", "user", "2", map[string]interface{}{"v": "abcdefghijklmnopqrstuvwxyz"}}
+	bulk.Add(small)
+	smallSize := ByteSize(bulk.Len())
+	bulk.Add(large)
+	largeSize := ByteSize(bulk.Len()) - smallSize
+	bulk.Add(small)
+
+	sizes := bulk.EntrySizes()
+	if sizes.Count != 3 || sizes.Total != ByteSize(bulk.Len()) {
+		t.Error("Expected every entry to be counted, got", sizes)
+	}
+	if sizes.Min != smallSize || sizes.Max != largeSize {
+		t.Error("Unexpected smallest and largest entry", sizes)
+	}
+	if avg := sizes.Avg(); avg != (2*smallSize+largeSize)/3 {
+		t.Error("Unexpected average", avg)
+	}
+
+	// Replaced entries are no longer counted
+	bulk.Add(&replacingEntry{*large})
+	if sizes := bulk.EntrySizes(); sizes.Count != 3 || sizes.Total != ByteSize(bulk.Len()) {
+		t.Error("Expected the replaced entry to be uncounted, got", sizes)
+	}
+
+	bulk.Reset()
+	if sizes := bulk.EntrySizes(); sizes.Count != 0 {
+		t.Error("Expected sizes to be reset with the body, got", sizes)
+	}
+	bulk.Add(small)
+	if sizes := bulk.EntrySizes(); sizes.Count != 1 || sizes.Max != smallSize {
+		t.Error("Expected only the entry added after the reset, got", sizes)
+	}
+}
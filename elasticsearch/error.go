@@ -0,0 +1,82 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrRequestTooLarge is returned when elasticsearch responds with 413 Request Entity Too Large.
+var ErrRequestTooLarge = errors.New("Bulk request exceeds http.max_content_length of elasticsearch, use a smaller max for the BulkBody")
+
+// ErrClusterReadOnly is returned when elasticsearch rejects writes with a cluster_block_exception,
+// as it does for indices it made read-only after hitting the flood-stage disk watermark. It's not
+// transient load, the block stays until disk is freed up and it's lifted, which takes an operator.
+var ErrClusterReadOnly = errors.New("Elasticsearch blocks writes with a cluster_block_exception, free up disk or lift the read-only block")
+
+// ErrMemoryPressure is returned when circuit breakers of ES protecting its heap rejected items of a
+// bulk request it otherwise accepted. The body is left with only those items, to be sent again once
+// ES has had time to collect garbage.
+var ErrMemoryPressure = errors.New("Elasticsearch is short of heap, a circuit breaker rejected items of the bulk request")
+
+// ESError is returned when elasticsearch rejects a whole request rather than single items in it,
+// for example when the bulk body can't be parsed or a mapping can't be applied.
+type ESError struct {
+	// The exception type, such as illegal_argument_exception or mapper_parsing_exception.
+	Type string
+
+	// Human readable explanation of what went wrong.
+	Reason string
+
+	// HTTP status code of the response.
+	Status int
+}
+
+func (e *ESError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("Elasticsearch error (status %d): %s", e.Status, e.Reason)
+	}
+	return fmt.Sprintf("Elasticsearch %s (status %d): %s", e.Type, e.Status, e.Reason)
+}
+
+// parseError creates an ESError out of a response body. Newer versions of elasticsearch reports
+// errors as an object with type and reason while older versions only gives a string, anything that
+// can't be parsed is used as the reason as it is.
+func parseError(status int, body []byte) *ESError {
+	esErr := &ESError{Status: status, Reason: string(body)}
+
+	var resp struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || len(resp.Error) == 0 {
+		return esErr
+	}
+
+	var reason string
+	if err := json.Unmarshal(resp.Error, &reason); err == nil {
+		esErr.Reason = reason
+		return esErr
+	}
+
+	var cause struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(resp.Error, &cause); err == nil {
+		esErr.Type = cause.Type
+		esErr.Reason = cause.Reason
+	}
+	return esErr
+}
+
+// circuitBreaking tells if esErr is a circuit breaker of ES rejecting the request to protect its
+// heap, rather than ES only being busy.
+func circuitBreaking(esErr *ESError) bool {
+	return esErr.Type == "circuit_breaking_exception"
+}
+
+// clusterBlocked tells if esErr is a write block of the cluster or of an index, rather than a
+// problem with what was sent.
+func clusterBlocked(esErr *ESError) bool {
+	return esErr.Type == "cluster_block_exception"
+}
@@ -0,0 +1,174 @@
+package elasticsearch
+
+import (
+	"github.com/duego/cryriver/redact"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseErrorObject(t *testing.T) {
+	body := []byte(`{"error":{"root_cause":[{"type":"mapper_parsing_exception","reason":"failed to parse [age]"}],"type":"mapper_parsing_exception","reason":"failed to parse [age]"},"status":400}`)
+	err := parseError(400, body)
+	if err.Type != "mapper_parsing_exception" {
+		t.Error("Unexpected type", err.Type)
+	}
+	if err.Reason != "failed to parse [age]" {
+		t.Error("Unexpected reason", err.Reason)
+	}
+	if err.Status != 400 {
+		t.Error("Unexpected status", err.Status)
+	}
+}
+
+func TestParseErrorString(t *testing.T) {
+	body := []byte(`{"error":"ActionRequestValidationException[Validation Failed: 1: no requests added;]","status":400}`)
+	err := parseError(400, body)
+	if err.Type != "" {
+		t.Error("Expected no type, got", err.Type)
+	}
+	if err.Reason != "ActionRequestValidationException[Validation Failed: 1: no requests added;]" {
+		t.Error("Unexpected reason", err.Reason)
+	}
+}
+
+func TestParseErrorGarbage(t *testing.T) {
+	err := parseError(502, []byte("Bad Gateway"))
+	if err.Reason != "Bad Gateway" || err.Status != 502 {
+		t.Error("Expected raw body as reason, got", err)
+	}
+}
+
+func TestBulkSendESError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error":{"type":"illegal_argument_exception","reason":"explicit index in bulk is not allowed"},"status":400}`))
+	}))
+	defer server.Close()
+
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+
+	err := NewClient(server.URL, 1).BulkSend(bulk)
+	esErr, ok := err.(*ESError)
+	if !ok {
+		t.Fatal("Expected an *ESError, got", err)
+	}
+	if esErr.Type != "illegal_argument_exception" {
+		t.Error("Unexpected type", esErr.Type)
+	}
+	if esErr.Status != 400 {
+		t.Error("Unexpected status", esErr.Status)
+	}
+}
+
+func TestBulkSendTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(413)
+	}))
+	defer server.Close()
+
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+
+	if err := NewClient(server.URL, 1).BulkSend(bulk); err != ErrRequestTooLarge {
+		t.Fatal("Expected ErrRequestTooLarge, got", err)
+	}
+}
+
+func TestBulkSendRedactsReason(t *testing.T) {
+	previous := redact.Paths
+	redact.Paths = []string{"email"}
+	defer func() { redact.Paths = previous }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error":{"type":"mapper_parsing_exception","reason":"failed to parse [email] with value [johnny@example.com]"},"status":400}`))
+	}))
+	defer server.Close()
+
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"email": "johnny@example.com"}})
+
+	err := NewClient(server.URL, 1).BulkSend(bulk)
+	if esErr, ok := err.(*ESError); !ok || esErr.Reason != "failed to parse [email] with value [****]" {
+		t.Error("Expected reason to be redacted, got", err)
+	}
+}
+
+func TestBulkSendClusterReadOnly(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(429)
+		w.Write([]byte(`{"error":{"root_cause":[{"type":"cluster_block_exception","reason":"index [testing] blocked by: [TOO_MANY_REQUESTS/12/disk usage exceeded flood-stage watermark, index has read-only-allow-delete block];"}],"type":"cluster_block_exception","reason":"index [testing] blocked by: [TOO_MANY_REQUESTS/12/disk usage exceeded flood-stage watermark, index has read-only-allow-delete block];"},"status":429}`))
+	}))
+	defer server.Close()
+
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+
+	if err := NewClient(server.URL, 1, WithRetries(0)).BulkSend(bulk); err != ErrClusterReadOnly {
+		t.Fatal("Expected ErrClusterReadOnly, got", err)
+	}
+	if bulk.Len() == 0 {
+		t.Error("Expected the body to be kept for sending again")
+	}
+}
+
+func TestBulkSendItemsClusterReadOnly(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, string(body))
+		if len(requests) == 1 {
+			// Only the index of the second entry is read-only
+			w.Write([]byte(`{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":429,"error":{"type":"cluster_block_exception","reason":"index [blocked] blocked by: [TOO_MANY_REQUESTS/12/disk usage exceeded flood-stage watermark, index has read-only-allow-delete block];"}}}]}`))
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`))
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, 1, WithRetries(0))
+
+	tracker := NewAckTracker(0)
+	tracker.Track(1)
+	tracker.Track(2)
+	bulk := NewBulkBody(MB, AckTo(tracker))
+	bulk.Add(&sequencedEntry{rawEntry{"index", "testing", "user", "a", map[string]interface{}{"v": "1"}}, 1, false})
+	bulk.Add(&sequencedEntry{rawEntry{"index", "blocked", "user", "b", map[string]interface{}{"v": "2"}}, 2, false})
+	if err := client.BulkSend(bulk); err != ErrClusterReadOnly {
+		t.Fatal("Expected ErrClusterReadOnly, got", err)
+	}
+	if checkpoint := tracker.Checkpoint(); checkpoint != 1 {
+		t.Error("Expected what ES accepted to be acknowledged, got", checkpoint)
+	}
+	if s := bulk.String(); strings.Contains(s, `"a"`) || !strings.Contains(s, `"b"`) {
+		t.Error("Expected only the blocked entry to be kept, got", s)
+	}
+
+	if err := client.BulkSend(bulk); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint := tracker.Checkpoint(); checkpoint != 2 {
+		t.Error("Expected the blocked entry to be acknowledged once accepted, got", checkpoint)
+	}
+	valid := `{"index":{"_index":"blocked","_type":"user","_id":"b"}}
+{"v":"2"}
+
+`
+	if len(requests) != 2 || requests[1] != valid {
+		t.Errorf("Expected the blocked entry to be sent again on its own, got %q", requests)
+	}
+}
+
+func TestClusterBlocked(t *testing.T) {
+	blocked := parseError(403, []byte(`{"error":{"type":"cluster_block_exception","reason":"blocked by: [FORBIDDEN/6/cluster read-only (api)];"},"status":403}`))
+	if !clusterBlocked(blocked) {
+		t.Error("Expected a cluster block, got", blocked)
+	}
+	busy := parseError(429, []byte(`{"error":{"type":"es_rejected_execution_exception","reason":"rejected execution"},"status":429}`))
+	if clusterBlocked(busy) {
+		t.Error("Expected transient load not to be a cluster block, got", busy)
+	}
+}
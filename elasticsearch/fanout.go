@@ -0,0 +1,317 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// FanOutAcks is how many targets of a FanOut have to accept an entry before it's acknowledged.
+type FanOutAcks int
+
+const (
+	// Every target has to accept an entry
+	AllTargets FanOutAcks = iota
+
+	// A majority of the targets has to accept an entry
+	QuorumTargets
+)
+
+// MaxFanOutLag is how far behind the other targets of a FanOut a target may fall, in bytes of
+// bodies it has yet to accept, before the oldest of them are dropped for it.
+var MaxFanOutLag = 256 * MB
+
+// FanOut is a BulkSender writing every body to several clusters, such as both the old and the new
+// one while migrating between them. Each target keeps the bodies it has yet to accept and sends
+// them again, in order and before anything else, on the next body sent. A target that is down
+// therefore holds nothing back for the others.
+//
+// Entries of an AckTo body are acknowledged once the targets required by FanOutAcks have accepted
+// them, the checkpoint never moves past what they haven't. With QuorumTargets, the bodies a target
+// is behind by only live in memory: they are lost by restarting before it has caught up. Bodies
+// dropped for being more than MaxFanOutLag behind are lost for the target as well, while holding
+// the checkpoint back with AllTargets so that resuming sends them again.
+//
+// Requests to a target are sent one at a time to keep them in order, whichever slurper sends them.
+// Targets may be of versions with and without mapping types, typeless ones are sent the bodies
+// without the types of the entries.
+type FanOut struct {
+	// Serializes queueing so that every target gets the bodies in the same order
+	queueLock sync.Mutex
+	acksLock  sync.Mutex
+	targets   []*fanOutTarget
+	required  int
+}
+
+// fanOutTarget is a client of a FanOut along with the bodies it has yet to accept.
+type fanOutTarget struct {
+	client  *Client
+	sending sync.Mutex
+
+	sync.Mutex
+	pending []*fanOutBody
+	size    ByteSize
+}
+
+// fanOutBody is a body sent through a FanOut, shared by its targets until they have sent it.
+type fanOutBody struct {
+	body []byte
+	// The body without types for typeless targets, when built with them for others
+	untyped      []byte
+	requireAlias bool
+	acks         *AckTracker
+	sequences    [][]uint64
+
+	// Number of targets having accepted each item
+	accepted []int
+}
+
+// NewFanOut returns a FanOut sending to each of clients, acknowledging entries once acks of them
+// have accepted them.
+func NewFanOut(acks FanOutAcks, clients ...*Client) *FanOut {
+	f := &FanOut{required: len(clients)}
+	if acks == QuorumTargets {
+		f.required = len(clients)/2 + 1
+	}
+	for _, c := range clients {
+		f.targets = append(f.targets, &fanOutTarget{client: c})
+	}
+	return f
+}
+
+// BulkSend sends b to every target, along with what each of them has yet to accept, and resets it.
+// Returns an error when fewer targets than required by FanOutAcks have accepted everything, the
+// targets behind are logged otherwise.
+func (f *FanOut) BulkSend(b *BulkBody) error {
+	return f.BulkSendContext(context.Background(), b)
+}
+
+// BulkSendContext is BulkSend within ctx.
+func (f *FanOut) BulkSendContext(ctx context.Context, b *BulkBody) error {
+	b.Done()
+	body := &fanOutBody{
+		body:         append([]byte(nil), b.Bytes()...),
+		requireAlias: b.requireAlias,
+		acks:         b.acks,
+		sequences:    b.sequences,
+		accepted:     make([]int, len(b.sequences)),
+	}
+	if !b.typeless && f.anyTypeless() {
+		var err error
+		if body.untyped, err = withoutTypes(body.body); err != nil {
+			log.Println("Unable to leave the types out of the bulk request for typeless targets:", err)
+		}
+	}
+	// Acknowledged here once enough targets have accepted it
+	b.sequences = nil
+	b.Reset()
+
+	f.queueLock.Lock()
+	for _, t := range f.targets {
+		t.queue(body)
+	}
+	f.queueLock.Unlock()
+
+	errs := make([]error, len(f.targets))
+	var wg sync.WaitGroup
+	for i, t := range f.targets {
+		wg.Add(1)
+		go func(i int, t *fanOutTarget) {
+			errs[i] = t.drain(ctx, f)
+			wg.Done()
+		}(i, t)
+	}
+	wg.Wait()
+
+	var behind []string
+	for i, err := range errs {
+		if err != nil {
+			behind = append(behind, fmt.Sprintf("%s: %s", f.targets[i].client.server, err))
+		}
+	}
+	if len(behind) == 0 {
+		return nil
+	}
+	if len(f.targets)-len(behind) < f.required {
+		return fmt.Errorf("Bulk request failed on %d of %d targets, %s", len(behind), len(f.targets), strings.Join(behind, ", "))
+	}
+	for _, target := range behind {
+		log.Println("Bulk request failed on a target, what ES was unavailable for is sent again with the next one:", target)
+	}
+	return nil
+}
+
+// queue appends body to what the target has yet to send, dropping the oldest bodies while it's
+// more than MaxFanOutLag behind.
+func (t *fanOutTarget) queue(body *fanOutBody) {
+	t.Lock()
+	defer t.Unlock()
+	t.pending = append(t.pending, body)
+	t.size += ByteSize(len(body.body))
+	for t.size > MaxFanOutLag && len(t.pending) > 1 {
+		log.Println("Dropping a bulk body for", t.client.server, "as it's too far behind")
+		t.size -= ByteSize(len(t.pending[0].body))
+		t.pending = t.pending[1:]
+	}
+}
+
+// drain sends what the target has yet to accept in order, stopping at the first failure. Bodies
+// rejected by ES are dropped, as they would be rejected again.
+func (t *fanOutTarget) drain(ctx context.Context, f *FanOut) error {
+	t.sending.Lock()
+	defer t.sending.Unlock()
+	for {
+		t.Lock()
+		if len(t.pending) == 0 {
+			t.Unlock()
+			return nil
+		}
+		body := t.pending[0]
+		t.Unlock()
+
+		payload := body.body
+		if body.untyped != nil && t.client.typeless() {
+			payload = body.untyped
+		}
+		bulk := &BulkBody{Buffer: bytes.NewBuffer(payload), max: MaxBulkSize, done: true, requireAlias: body.requireAlias}
+		respBody, err := t.client.bulkRequest(ctx, bulk)
+		if failed(err) {
+			return err
+		}
+		if err == nil {
+			f.accept(body, respBody)
+		}
+
+		t.Lock()
+		// Unless dropped for being too far behind meanwhile
+		if len(t.pending) > 0 && t.pending[0] == body {
+			t.size -= ByteSize(len(body.body))
+			t.pending = t.pending[1:]
+		}
+		t.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// accept counts the items of body accepted by a target in respBody, acknowledging those accepted
+// by as many targets as required.
+func (f *FanOut) accept(body *fanOutBody, respBody []byte) {
+	if body.acks == nil {
+		return
+	}
+	settled, err := settledItems(respBody)
+	if err != nil {
+		log.Println("Unable to read what ES accepted of the bulk request, nothing is acknowledged:", err)
+		return
+	}
+	f.acksLock.Lock()
+	defer f.acksLock.Unlock()
+	for i, ok := range settled {
+		if !ok || i >= len(body.sequences) {
+			continue
+		}
+		body.accepted[i]++
+		if body.accepted[i] != f.required {
+			continue
+		}
+		for _, sequence := range body.sequences[i] {
+			body.acks.Ack(sequence)
+		}
+	}
+}
+
+// typeless tells if every target does without mapping types, bodies are then built without them.
+// Otherwise they are built with types, which are left out for the typeless targets when sending.
+func (f *FanOut) typeless() bool {
+	for _, t := range f.targets {
+		if !t.client.typeless() {
+			return false
+		}
+	}
+	return len(f.targets) > 0
+}
+
+// anyTypeless tells if any of the targets does without mapping types.
+func (f *FanOut) anyTypeless() bool {
+	for _, t := range f.targets {
+		if t.client.typeless() {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutTypes returns body with the _type left out of the header of every entry, for clusters
+// rejecting them such as when mirroring from 6.x to 8.x.
+func withoutTypes(body []byte) ([]byte, error) {
+	entries, err := splitEntries(body)
+	if err != nil {
+		return nil, err
+	}
+	untyped := bytes.NewBuffer(make([]byte, 0, len(body)))
+	for _, entry := range entries {
+		var header map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(entry.Header, &header); err != nil {
+			return nil, err
+		}
+		for _, meta := range header {
+			delete(meta, "_type")
+		}
+		line, err := json.Marshal(header)
+		if err != nil {
+			return nil, err
+		}
+		untyped.Write(line)
+		untyped.WriteByte(newline)
+		if entry.Source != nil {
+			untyped.Write(entry.Source)
+			untyped.WriteByte(newline)
+		}
+	}
+	// Finished like Done does
+	untyped.WriteByte(newline)
+	return untyped.Bytes(), nil
+}
+
+// deleteUnrouted carries out d on every target once it has sent what it was behind by, failing
+// when fewer targets than required by FanOutAcks did.
+func (f *FanOut) deleteUnrouted(ctx context.Context, d *UnroutedDelete) error {
+	var behind []string
+	for _, t := range f.targets {
+		err := t.drain(ctx, f)
+		if err == nil {
+			t.sending.Lock()
+			err = t.client.deleteUnrouted(ctx, d)
+			t.sending.Unlock()
+		}
+		if err != nil {
+			behind = append(behind, fmt.Sprintf("%s: %s", t.client.server, err))
+		}
+	}
+	if len(behind) == 0 {
+		return nil
+	}
+	if len(f.targets)-len(behind) < f.required {
+		return fmt.Errorf("Delete by query failed on %d of %d targets, %s", len(behind), len(f.targets), strings.Join(behind, ", "))
+	}
+	for _, target := range behind {
+		log.Println("Delete by query failed on a target, it's not sent again:", target)
+	}
+	return nil
+}
+
+// ensureMapped prepares index on every target.
+func (f *FanOut) ensureMapped(index string) error {
+	for _, t := range f.targets {
+		if err := t.client.ensureMapped(index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
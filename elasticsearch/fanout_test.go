@@ -0,0 +1,163 @@
+package elasticsearch
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// flakyServer accepts bulk requests of a single entry, or answers 503 while down is set.
+type flakyServer struct {
+	*httptest.Server
+	sync.Mutex
+	down     bool
+	requests int
+}
+
+func newFlakyServer(down bool) *flakyServer {
+	s := &flakyServer{down: down}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Lock()
+		defer s.Unlock()
+		s.requests++
+		if s.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"took":1,"errors":false,"items":[{"index":{"_index":"testing","status":201}}]}`))
+	}))
+	return s
+}
+
+func (s *flakyServer) setDown(down bool) {
+	s.Lock()
+	s.down = down
+	s.Unlock()
+}
+
+// sendSequenced sends an entry of sequence through fanOut, tracked by tracker.
+func sendSequenced(t *testing.T, fanOut *FanOut, tracker *AckTracker, sequence uint64, id string) error {
+	if err := tracker.Track(sequence); err != nil {
+		t.Fatal(err)
+	}
+	bulk := NewBulkBody(MB, AckTo(tracker))
+	if err := bulk.Add(&sequencedEntry{rawEntry{"index", "testing", "", id, map[string]interface{}{"v": id}}, sequence, true}); err != nil {
+		t.Fatal(err)
+	}
+	err := fanOut.BulkSend(bulk)
+	if bulk.Len() != 0 {
+		t.Error("Expected the body to be reset")
+	}
+	return err
+}
+
+func TestFanOutAllTargets(t *testing.T) {
+	up := newFlakyServer(false)
+	defer up.Close()
+	down := newFlakyServer(true)
+	defer down.Close()
+
+	tracker := NewAckTracker(0)
+	fanOut := NewFanOut(AllTargets, NewClient(up.URL, 1), NewClient(down.URL, 1, WithRetries(0)))
+	if err := sendSequenced(t, fanOut, tracker, 1, "a"); err == nil {
+		t.Error("Expected an error when a required target fails")
+	}
+	if checkpoint := tracker.Checkpoint(); checkpoint != 0 {
+		t.Error("Expected the checkpoint to wait for the failed target, got", checkpoint)
+	}
+
+	// The failed target catches up on the next body, without sending the first one to the other again
+	down.setDown(false)
+	if err := sendSequenced(t, fanOut, tracker, 2, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint := tracker.Checkpoint(); checkpoint != 2 {
+		t.Error("Expected both entries to be acknowledged, got", checkpoint)
+	}
+	if up.requests != 2 {
+		t.Error("Expected one request per body to the target that was up, got", up.requests)
+	}
+	if down.requests != 3 {
+		t.Error("Expected the failed body to be sent again before the next one, got", down.requests)
+	}
+}
+
+func TestFanOutQuorumTargets(t *testing.T) {
+	first := newFlakyServer(false)
+	defer first.Close()
+	second := newFlakyServer(false)
+	defer second.Close()
+	down := newFlakyServer(true)
+	defer down.Close()
+
+	tracker := NewAckTracker(0)
+	fanOut := NewFanOut(QuorumTargets, NewClient(first.URL, 1), NewClient(second.URL, 1), NewClient(down.URL, 1, WithRetries(0)))
+	if err := sendSequenced(t, fanOut, tracker, 1, "a"); err != nil {
+		t.Error("Expected no error while a quorum of targets accepts, got", err)
+	}
+	if checkpoint := tracker.Checkpoint(); checkpoint != 1 {
+		t.Error("Expected the entry accepted by a quorum to be acknowledged, got", checkpoint)
+	}
+
+	// Without a quorum nothing more is acknowledged
+	second.setDown(true)
+	if err := sendSequenced(t, fanOut, tracker, 2, "b"); err == nil {
+		t.Error("Expected an error without a quorum")
+	}
+	if checkpoint := tracker.Checkpoint(); checkpoint != 1 {
+		t.Error("Expected the checkpoint to stay without a quorum, got", checkpoint)
+	}
+	if pending := len(fanOut.targets[2].pending); pending != 2 {
+		t.Error("Expected the failed target to keep both bodies, got", pending)
+	}
+}
+
+func TestFanOutMixedTypes(t *testing.T) {
+	bodies := make(map[string]string)
+	var lock sync.Mutex
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			lock.Lock()
+			bodies[name] = string(body)
+			lock.Unlock()
+			w.Write([]byte(`{"took":1,"errors":false,"items":[{"index":{"_index":"testing","status":201}},{"delete":{"_index":"testing","status":200}}]}`))
+		}))
+	}
+	old := newServer("6.x")
+	defer old.Close()
+	current := newServer("8.x")
+	defer current.Close()
+
+	typed := NewClient(old.URL, 1)
+	typed.version = &ClusterVersion{Major: 6, Minor: 8}
+	typeless := NewClient(current.URL, 1)
+	typeless.version = &ClusterVersion{Major: 8}
+	fanOut := NewFanOut(AllTargets, typed, typeless)
+
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"v": 1}})
+	bulk.Add(&rawEntry{"delete", "testing", "user", "2", nil})
+	if err := fanOut.BulkSend(bulk); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"6.x": `{"index":{"_index":"testing","_type":"user","_id":"1"}}
+{"v":1}
+{"delete":{"_index":"testing","_type":"user","_id":"2"}}
+
+`,
+		"8.x": `{"index":{"_id":"1","_index":"testing"}}
+{"v":1}
+{"delete":{"_id":"2","_index":"testing"}}
+
+`,
+	}
+	for name, body := range expected {
+		if bodies[name] != body {
+			t.Error("Unexpected body sent to", name, bodies[name])
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"sync"
+)
+
+// gzipper compresses request bodies at one level, reusing writers between requests as each holds
+// the large tables of its level.
+type gzipper struct {
+	level   int
+	writers sync.Pool
+}
+
+// ErrGzipLevel is returned by ValidGzipLevel for levels gzip doesn't have.
+var ErrGzipLevel = errors.New("Gzip level must be from -2, Huffman only, to 9, best compression")
+
+// ValidGzipLevel returns ErrGzipLevel unless level is one of compress/gzip, from gzip.HuffmanOnly
+// to gzip.BestCompression.
+func ValidGzipLevel(level int) error {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return ErrGzipLevel
+	}
+	return nil
+}
+
+// WithGzip makes the Client compress the bodies of its requests with gzip at level, from
+// gzip.BestSpeed to gzip.BestCompression or gzip.DefaultCompression. Compressing costs CPU on the
+// river for less bandwidth towards ES. BenchmarkGzip shows the tradeoff for a bulk body of typical
+// documents: BestSpeed takes about two thirds of the time of DefaultCompression for a body a
+// quarter larger, while BestCompression takes six times as long for nothing smaller. Any other
+// level is replaced by DefaultCompression, check it with ValidGzipLevel first.
+func WithGzip(level int) ClientOption {
+	if ValidGzipLevel(level) != nil {
+		level = gzip.DefaultCompression
+	}
+	return func(c *Client) {
+		c.gzip = &gzipper{level: level}
+	}
+}
+
+// compress returns body compressed.
+func (g *gzipper) compress(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, ok := g.writers.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		var err error
+		if w, err = gzip.NewWriterLevel(&buf, g.level); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	g.writers.Put(w)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,87 @@
+package elasticsearch
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithGzip(t *testing.T) {
+	var encoding, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		b, _ := ioutil.ReadAll(zr)
+		body = string(b)
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1, WithGzip(gzip.BestSpeed))
+	for i := 0; i < 2; i++ {
+		bulk := NewBulkBody(MB)
+		bulk.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}})
+		expected := bulk.String() + "\n"
+		if err := client.BulkSend(bulk); err != nil {
+			t.Fatal(err)
+		}
+		if encoding != "gzip" || body != expected {
+			t.Errorf("Expected gzipped %q, got %q encoded as %q", expected, body, encoding)
+		}
+	}
+}
+
+// BenchmarkGzip compresses a full bulk body of user documents at each level, reporting the
+// compressed size relative to the body along with the time taken.
+func BenchmarkGzip(b *testing.B) {
+	bulk := NewBulkBody(DefaultBulkSize)
+	for i := 0; bulk.Add(&rawEntry{"index", "users", "user", fmt.Sprint(i), map[string]interface{}{
+		"name":       fmt.Sprintf("User %d", i),
+		"email":      fmt.Sprintf("user%d@example.com", i),
+		"age":        20 + i%50,
+		"created_at": "2014-02-25T10:46:24Z",
+		"tags":       []string{"customer", fmt.Sprint("segment-", i%7)},
+	}}) == nil; i++ {
+	}
+	body := bulk.Bytes()
+
+	for _, level := range []int{gzip.BestSpeed, 3, gzip.DefaultCompression, gzip.BestCompression} {
+		b.Run(fmt.Sprint("level", level), func(b *testing.B) {
+			g := &gzipper{level: level}
+			b.SetBytes(int64(len(body)))
+			var compressed []byte
+			for i := 0; i < b.N; i++ {
+				var err error
+				if compressed, err = g.compress(body); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(compressed))/float64(len(body)), "ratio")
+		})
+	}
+}
+
+func TestValidGzipLevel(t *testing.T) {
+	for _, level := range []int{gzip.HuffmanOnly, gzip.DefaultCompression, gzip.NoCompression, gzip.BestSpeed, gzip.BestCompression} {
+		if err := ValidGzipLevel(level); err != nil {
+			t.Error("Expected", level, "to be valid, got", err)
+		}
+	}
+	for _, level := range []int{-3, 10} {
+		if err := ValidGzipLevel(level); err != ErrGzipLevel {
+			t.Error("Expected ErrGzipLevel for", level, "got", err)
+		}
+	}
+
+	// Invalid levels never get to fail requests
+	if c := NewClient("http://localhost:9200", 1, WithGzip(42)); c.gzip.level != gzip.DefaultCompression {
+		t.Error("Expected an invalid level to be replaced by the default, got", c.gzip.level)
+	}
+}
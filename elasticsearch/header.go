@@ -0,0 +1,71 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+)
+
+// headerTemplate is the start of the header last marshaled, for the headers of entries with the
+// same action, index and type to be written by appending their id rather than marshaling them
+// in full, which is the common case of a body of a single index.
+type headerTemplate struct {
+	action string
+	index  string
+	typ    string
+
+	// The marshaled header without an id and its closing braces
+	prefix []byte
+
+	// Number of headers marshaled rather than templated
+	marshaled int
+}
+
+// marshalHeader returns the header line of an entry of action. Headers carrying nothing but the
+// index, type and id come from the template, written exactly as json.Marshal would.
+func (bulk *BulkBody) marshalHeader(action string, header indexHeader) ([]byte, error) {
+	t := &bulk.template
+	if !header.templated() {
+		t.marshaled++
+		return json.Marshal(map[string]interface{}{action: header})
+	}
+	if t.prefix == nil || t.action != action || t.index != header.Name || t.typ != header.Type {
+		full, err := json.Marshal(map[string]interface{}{action: indexHeader{Name: header.Name, Type: header.Type}})
+		if err != nil {
+			return nil, err
+		}
+		t.marshaled++
+		t.action, t.index, t.typ = action, header.Name, header.Type
+		t.prefix = full[:len(full)-2]
+	}
+
+	b := make([]byte, 0, len(t.prefix)+len(header.Id)+11)
+	b = append(b, t.prefix...)
+	if header.Id != "" {
+		b = append(b, `,"_id":`...)
+		if plainJSON(header.Id) {
+			b = append(append(append(b, '"'), header.Id...), '"')
+		} else {
+			id, err := json.Marshal(header.Id)
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, id...)
+		}
+	}
+	return append(b, '}', '}'), nil
+}
+
+// templated tells if the header has nothing but its index, type and id set.
+func (h indexHeader) templated() bool {
+	return h.RetryOnConflict == 0 && h.Version == 0 && h.VersionType == "" && h.Pipeline == "" && h.Source == nil
+}
+
+// plainJSON tells if s is written as it is in a JSON string by json.Marshal, without escapes.
+func plainJSON(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c < 0x20, c >= 0x80, c == '"', c == '\\', c == '<', c == '>', c == '&':
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+func TestMarshalHeader(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	headers := []struct {
+		action string
+		header indexHeader
+	}{
+		{"index", indexHeader{Name: "users", Type: "user", Id: "1"}},
+		{"index", indexHeader{Name: "users", Type: "user", Id: "2"}},
+		// Changing index, type and action mid-batch
+		{"index", indexHeader{Name: "logs", Type: "user", Id: "3"}},
+		{"index", indexHeader{Name: "logs", Id: "4"}},
+		{"delete", indexHeader{Name: "logs", Id: "5"}},
+		{"index", indexHeader{Name: "logs"}},
+		// Ids to escape
+		{"index", indexHeader{Name: "logs", Id: `a"b\c`}},
+		{"index", indexHeader{Name: "logs", Id: "<ø>"}},
+		// Marshaled in full with more than the index, type and id
+		{"update", indexHeader{Name: "logs", Id: "6", RetryOnConflict: 3}},
+		{"index", indexHeader{Name: "logs", Id: "7", Pipeline: "geoip"}},
+		{"index", indexHeader{Name: "logs", Id: "8"}},
+	}
+	for _, h := range headers {
+		b, err := bulk.marshalHeader(h.action, h.header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, _ := json.Marshal(map[string]interface{}{h.action: h.header})
+		if string(b) != string(expected) {
+			t.Errorf("Expected %s, got %s", expected, b)
+		}
+	}
+	if bulk.template.marshaled != 7 {
+		t.Error("Expected headers of the same index to come from the template, marshaled", bulk.template.marshaled)
+	}
+}
+
+// BenchmarkBulkBodySameIndex adds 10k entries of the same index, reporting the headers marshaled
+// in full per body.
+func BenchmarkBulkBodySameIndex(b *testing.B) {
+	entries := make([]BulkEntry, 10000)
+	for i := range entries {
+		entries[i] = &rawEntry{"index", "users", "user", strconv.Itoa(i), map[string]interface{}{"name": "Johnny"}}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	var marshaled int
+	for i := 0; i < b.N; i++ {
+		bulk := NewBulkBody(MaxBulkSize)
+		for _, entry := range entries {
+			if err := bulk.Add(entry); err != nil {
+				b.Fatal(err)
+			}
+		}
+		marshaled += bulk.template.marshaled
+	}
+	b.ReportMetric(float64(marshaled)/float64(b.N), "marshals/op")
+}
@@ -0,0 +1,30 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// ClusterHealth is the part of /_cluster/health we care about.
+type ClusterHealth struct {
+	// Green, yellow or red
+	Status string `json:"status"`
+
+	NumberOfNodes int `json:"number_of_nodes"`
+	ActiveShards  int `json:"active_shards"`
+}
+
+// Ping checks that the cluster can be reached and returns its health. Use a context with a
+// deadline to not wait for long on a cluster that doesn't answer.
+func (c *Client) Ping(ctx context.Context) (ClusterHealth, error) {
+	var health ClusterHealth
+	resp, body, err := c.do(ctx, "GET", "/_cluster/health", "", nil)
+	if err != nil {
+		return health, err
+	}
+	if code := resp.StatusCode; code != 200 {
+		return health, parseError(code, body)
+	}
+	err = json.Unmarshal(body, &health)
+	return health, err
+}
@@ -0,0 +1,43 @@
+package elasticsearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_cluster/health" {
+			w.WriteHeader(404)
+			return
+		}
+		w.Write([]byte(`{"cluster_name":"testing","status":"yellow","timed_out":false,"number_of_nodes":3,"number_of_data_nodes":3,"active_primary_shards":5,"active_shards":10}`))
+	}))
+	defer server.Close()
+
+	health, err := NewClient(server.URL, 1).Ping(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.Status != "yellow" || health.NumberOfNodes != 3 || health.ActiveShards != 10 {
+		t.Error("Unexpected health", health)
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	block := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := NewClient(server.URL, 1).Ping(ctx); err == nil {
+		t.Error("Expected ping to time out")
+	}
+}
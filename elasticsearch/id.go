@@ -0,0 +1,45 @@
+package elasticsearch
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// IdGenerator creates ids for entries that doesn't have one of their own, such as documents in
+// capped collections or views.
+//
+// Generated ids must be deterministic: the same entry has to get the same id every time it's
+// generated, otherwise re-running the same operations will create duplicates instead of
+// overwriting what was indexed before.
+type IdGenerator interface {
+	GenerateId(v BulkEntry) (string, error)
+}
+
+// IdGeneratorFunc makes a function into an IdGenerator.
+type IdGeneratorFunc func(v BulkEntry) (string, error)
+
+func (f IdGeneratorFunc) GenerateId(v BulkEntry) (string, error) {
+	return f(v)
+}
+
+// HashFields returns an IdGenerator creating ids out of a SHA-1 hash of the given document fields.
+// Missing fields are hashed as null, the fields should together identify the document.
+func HashFields(fields ...string) IdGenerator {
+	return IdGeneratorFunc(func(v BulkEntry) (string, error) {
+		doc, err := v.Document()
+		if err != nil {
+			return "", err
+		}
+		values := make([]interface{}, len(fields))
+		for i, field := range fields {
+			values[i] = doc[field]
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			return "", err
+		}
+		sum := sha1.Sum(b)
+		return hex.EncodeToString(sum[:]), nil
+	})
+}
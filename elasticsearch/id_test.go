@@ -0,0 +1,62 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHashFields(t *testing.T) {
+	generator := HashFields("name", "created_at")
+	first := &rawEntry{"index", "testing", "user", "", map[string]interface{}{
+		"name":       "Johnny",
+		"created_at": "2014-01-07",
+		"alias":      "J",
+	}}
+	second := &rawEntry{"index", "testing", "user", "", map[string]interface{}{
+		"name":       "Johnny",
+		"created_at": "2014-01-07",
+		"alias":      "Changed alias",
+	}}
+
+	a, err := generator.GenerateId(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := generator.GenerateId(second)
+	if a == "" || a != b {
+		t.Error("Expected same id for the same field values, got", a, b)
+	}
+
+	second.values["name"] = "Someone else"
+	if c, _ := generator.GenerateId(second); c == a {
+		t.Error("Expected different field values to give a different id")
+	}
+}
+
+func TestBulkBodyIdGenerator(t *testing.T) {
+	bulk := NewBulkBody(MB, WithIdGenerator(IdGeneratorFunc(func(v BulkEntry) (string, error) {
+		return "generated", nil
+	})))
+	if err := bulk.Add(&rawEntry{"update", "testing", "user", "", map[string]interface{}{"foo": "bar"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(bulk.Bytes(), []byte(`{"update":{"_index":"testing","_type":"user","_id":"generated"}}`)) {
+		t.Error("Expected generated id in header, got", bulk.String())
+	}
+}
+
+func TestBulkBodyMissingId(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	if err := bulk.Add(&rawEntry{"index", "testing", "user", "", map[string]interface{}{"foo": "bar"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(bulk.Bytes(), []byte(`{"index":{"_index":"testing","_type":"user"}}`)) {
+		t.Error("Expected _id to be left out for ES to generate, got", bulk.String())
+	}
+
+	for _, action := range []string{"update", "delete"} {
+		if err := bulk.Add(&rawEntry{action, "testing", "user", "", map[string]interface{}{"foo": "bar"}}); err == nil {
+			t.Error("Expected", action, "without id to fail")
+		}
+	}
+}
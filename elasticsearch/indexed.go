@@ -0,0 +1,41 @@
+package elasticsearch
+
+import (
+	"time"
+)
+
+// DefaultIndexedAtField is the field WithIndexedAt is usually given.
+const DefaultIndexedAtField = "@indexed_at"
+
+// indexedAtLayout is RFC 3339 with milliseconds, enough to measure the lag of the river by.
+const indexedAtLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// indexedAt is the configuration of WithIndexedAt.
+type indexedAt struct {
+	field string
+	force bool
+	now   func() time.Time
+}
+
+// WithIndexedAt makes the BulkBody set field of every document added to the time it's added, in
+// UTC as RFC 3339 with milliseconds. Along with a time of the document itself it tells how long it
+// took to reach ES, such as in Kibana. Documents already having field keep their value unless force
+// is set. Deletes have no document to set it in.
+func WithIndexedAt(field string, force bool) BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.indexedAt = &indexedAt{field, force, time.Now}
+	}
+}
+
+// document returns doc with the time set, doc itself is left untouched.
+func (ia *indexedAt) document(doc map[string]interface{}) map[string]interface{} {
+	if _, ok := doc[ia.field]; ok && !ia.force {
+		return doc
+	}
+	withTime := make(map[string]interface{}, len(doc)+1)
+	for k, v := range doc {
+		withTime[k] = v
+	}
+	withTime[ia.field] = ia.now().UTC().Format(indexedAtLayout)
+	return withTime
+}
@@ -0,0 +1,43 @@
+package elasticsearch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithIndexedAt(t *testing.T) {
+	now := time.Date(2014, 2, 25, 10, 46, 24, 123e6, time.FixedZone("CET", 3600))
+	bulk := NewBulkBody(MB, WithIndexedAt(DefaultIndexedAtField, false))
+	bulk.indexedAt.now = func() time.Time { return now }
+
+	bulk.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}})
+	bulk.Add(&rawEntry{"index", "testing", "user", "2", map[string]interface{}{"@indexed_at": "earlier"}})
+	bulk.Add(&rawEntry{"delete", "testing", "user", "3", nil})
+	valid := `{"index":{"_index":"testing","_type":"user","_id":"1"}}
+{"@indexed_at":"2014-02-25T09:46:24.123Z","foo":"bar"}
+{"index":{"_index":"testing","_type":"user","_id":"2"}}
+{"@indexed_at":"earlier"}
+{"delete":{"_index":"testing","_type":"user","_id":"3"}}
+`
+	if bulk.String() != valid {
+		t.Errorf("\n'%s'\nNot equal to:\n'%s'", bulk.String(), valid)
+	}
+}
+
+func TestWithIndexedAtForce(t *testing.T) {
+	now := time.Date(2014, 2, 25, 10, 46, 24, 0, time.UTC)
+	bulk := NewBulkBody(MB, WithIndexedAt("indexed", true))
+	bulk.indexedAt.now = func() time.Time { return now }
+
+	doc := map[string]interface{}{"indexed": "earlier"}
+	bulk.Add(&rawEntry{"update", "testing", "user", "1", doc})
+	valid := `{"update":{"_index":"testing","_type":"user","_id":"1"}}
+{"doc":{"indexed":"2014-02-25T10:46:24.000Z"},"doc_as_upsert":true}
+`
+	if bulk.String() != valid {
+		t.Errorf("\n'%s'\nNot equal to:\n'%s'", bulk.String(), valid)
+	}
+	if doc["indexed"] != "earlier" {
+		t.Error("Expected the document of the entry to be left untouched, got", doc)
+	}
+}
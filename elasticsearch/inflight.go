@@ -0,0 +1,76 @@
+package elasticsearch
+
+import (
+	"context"
+	"github.com/duego/cryriver/stats"
+	"sync"
+)
+
+// InFlightLimiter bounds the total amount of bytes held by bulk bodies across all concurrent
+// slurpers sharing it, from the moment a body starts to fill up until it has been sent. Slurpers
+// block while the limit is reached, which holds up the channel they read from and thereby the
+// tailing of MongoDB until ES has caught up.
+//
+// A body reserves its max when the first entry is added and holds it until it has been sent,
+// whether it's sent for being full or by the linger timer. A slurper waiting to reserve has nothing
+// waiting in its body, so there is no linger timer held up by the limit.
+type InFlightLimiter struct {
+	max      ByteSize
+	inFlight ByteSize
+	lock     sync.Mutex
+
+	// Closed and replaced when bytes are released, to wake up those waiting
+	freed chan struct{}
+}
+
+// NewInFlightLimiter returns a limiter allowing at most max bytes to be in flight.
+func NewInFlightLimiter(max ByteSize) *InFlightLimiter {
+	return &InFlightLimiter{max: max, freed: make(chan struct{})}
+}
+
+// Acquire blocks until n bytes can be reserved and returns the amount reserved. A request larger
+// than the limit itself is reduced to the limit, it will proceed once nothing else is in flight.
+func (l *InFlightLimiter) Acquire(n ByteSize) ByteSize {
+	n, _ = l.AcquireContext(context.Background(), n)
+	return n
+}
+
+// AcquireContext is Acquire giving up with the error of ctx once it's done, nothing is reserved
+// then.
+func (l *InFlightLimiter) AcquireContext(ctx context.Context, n ByteSize) (ByteSize, error) {
+	if n > l.max {
+		n = l.max
+	}
+	l.lock.Lock()
+	for l.inFlight+n > l.max {
+		freed := l.freed
+		l.lock.Unlock()
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		l.lock.Lock()
+	}
+	l.inFlight += n
+	stats.InFlightBytes.Add(int64(n))
+	l.lock.Unlock()
+	return n, nil
+}
+
+// Release returns n previously acquired bytes to the limiter.
+func (l *InFlightLimiter) Release(n ByteSize) {
+	l.lock.Lock()
+	l.inFlight -= n
+	stats.InFlightBytes.Add(-int64(n))
+	close(l.freed)
+	l.freed = make(chan struct{})
+	l.lock.Unlock()
+}
+
+// InFlight returns the amount of bytes currently reserved.
+func (l *InFlightLimiter) InFlight() ByteSize {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.inFlight
+}
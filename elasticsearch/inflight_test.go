@@ -0,0 +1,162 @@
+package elasticsearch
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInFlightLimiterBlocks(t *testing.T) {
+	limiter := NewInFlightLimiter(2 * KB)
+	limiter.Acquire(KB)
+	limiter.Acquire(KB)
+
+	acquired := make(chan bool)
+	go func() {
+		limiter.Acquire(KB)
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Expected acquire to block while the limit is reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	limiter.Release(KB)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected acquire to proceed after release")
+	}
+	if n := limiter.InFlight(); n != 2*KB {
+		t.Error("Unexpected bytes in flight", n)
+	}
+}
+
+func TestInFlightLimiterOversized(t *testing.T) {
+	limiter := NewInFlightLimiter(KB)
+	if n := limiter.Acquire(MB); n != KB {
+		t.Error("Expected oversized acquire to be reduced to the limit, got", n)
+	}
+	limiter.Release(KB)
+	if n := limiter.InFlight(); n != 0 {
+		t.Error("Expected nothing in flight, got", n)
+	}
+}
+
+func TestInFlightLimiterContext(t *testing.T) {
+	limiter := NewInFlightLimiter(KB)
+	limiter.Acquire(KB)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if n, err := limiter.AcquireContext(ctx, KB); err != context.DeadlineExceeded || n != 0 {
+		t.Error("Expected to give up once the context is done, got", n, err)
+	}
+	if n := limiter.InFlight(); n != KB {
+		t.Error("Expected nothing reserved by giving up, got", n)
+	}
+
+	// Unblocked by the release of what was sent
+	acquired := make(chan ByteSize)
+	go func() {
+		n, _ := limiter.AcquireContext(context.Background(), KB)
+		acquired <- n
+	}()
+	time.Sleep(10 * time.Millisecond)
+	limiter.Release(KB)
+	select {
+	case n := <-acquired:
+		if n != KB {
+			t.Error("Expected the whole request reserved, got", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected acquire to proceed after release")
+	}
+}
+
+// limitedSender records how much was in flight for each body it's asked to send.
+type limitedSender struct {
+	recordingSender
+	limiter  *InFlightLimiter
+	inFlight []ByteSize
+}
+
+func (s *limitedSender) BulkSend(b *BulkBody) error {
+	s.Lock()
+	s.inFlight = append(s.inFlight, s.limiter.InFlight())
+	s.Unlock()
+	return s.recordingSender.BulkSend(b)
+}
+
+func TestSlurpInFlightReleasedOnFailedAdd(t *testing.T) {
+	limiter := NewInFlightLimiter(4 * DefaultBulkSize)
+	esc := make(chan Transaction)
+	done := make(chan bool)
+	go func() {
+		Slurp(&recordingSender{}, esc, SlurpConfig{Linger: time.Hour, InFlight: limiter})
+		close(done)
+	}()
+
+	// Deletes without an id fail to be added
+	esc <- &timedEntry{rawEntry{"delete", "testing", "user", "", nil}}
+	time.Sleep(50 * time.Millisecond)
+	if n := limiter.InFlight(); n != 0 {
+		t.Error("Expected nothing in flight for an empty body, got", n)
+	}
+	close(esc)
+	<-done
+}
+
+func TestSlurpInFlightOversize(t *testing.T) {
+	limiter := NewInFlightLimiter(8 * DefaultBulkSize)
+	sender := &limitedSender{limiter: limiter}
+	esc := make(chan Transaction)
+	done := make(chan bool)
+	go func() {
+		Slurp(sender, esc, SlurpConfig{Linger: time.Hour, InFlight: limiter, BulkOptions: []BulkOption{AllowOversizeSingles(4 * DefaultBulkSize)}})
+		close(done)
+	}()
+
+	esc <- &timedEntry{rawEntry{"index", "testing", "user", "1", map[string]interface{}{"data": strings.Repeat("a", 3*int(DefaultBulkSize))}}}
+	close(esc)
+	<-done
+	if len(sender.inFlight) != 1 || sender.inFlight[0] < 3*DefaultBulkSize {
+		t.Error("Expected the whole oversized body to be in flight, got", sender.inFlight)
+	}
+	if n := limiter.InFlight(); n != 0 {
+		t.Error("Expected everything to be released, got", n)
+	}
+}
+
+func TestSlurpInFlightCanceled(t *testing.T) {
+	limiter := NewInFlightLimiter(DefaultBulkSize)
+	// Held by someone else for the slurper to block
+	limiter.Acquire(DefaultBulkSize)
+	sender := &recordingSender{}
+	ctx, cancel := context.WithCancel(context.Background())
+	esc := make(chan Transaction)
+	done := make(chan bool)
+	go func() {
+		Slurp(sender, esc, SlurpConfig{Context: ctx, Linger: time.Hour, InFlight: limiter})
+		close(done)
+	}()
+
+	esc <- &timedEntry{rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}}}
+	cancel()
+	select {
+	case esc <- &timedEntry{rawEntry{"index", "testing", "user", "2", map[string]interface{}{"foo": "bar"}}}:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the slurper to give up waiting for room in flight")
+	}
+	close(esc)
+	<-done
+	if n := sender.count(); n != 0 {
+		t.Error("Expected nothing to be sent, got", sender.sent)
+	}
+	if n := limiter.InFlight(); n != DefaultBulkSize {
+		t.Error("Expected only what was held by someone else in flight, got", n)
+	}
+}
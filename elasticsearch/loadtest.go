@@ -0,0 +1,112 @@
+package elasticsearch
+
+import (
+	"github.com/duego/cryriver/stats"
+	"math"
+	"sync"
+	"time"
+)
+
+// Sampler keeps a fraction of the entries given to Keep, for load testing a cluster with part of
+// the real operations. It skips data on purpose and must never be used for production indexes.
+//
+// Entries are kept by a hash of their index and id, so that the same documents are sampled every
+// time and the kept ones get all of their operations. Entries without an id, new documents, are
+// kept in turn instead. It's safe for concurrent use.
+type Sampler struct {
+	sync.Mutex
+	fraction float64
+
+	// Entries without an id looked at so far
+	anonymous int
+}
+
+// NewSampler returns a Sampler keeping fraction of the entries, from 0 for none to 1 for all.
+func NewSampler(fraction float64) *Sampler {
+	return &Sampler{fraction: math.Max(0, math.Min(1, fraction))}
+}
+
+// Keep tells if v should be indexed, entries left out are counted in stats.SampledOut.
+func (s *Sampler) Keep(v Identifier) bool {
+	if s.keep(v) {
+		return true
+	}
+	stats.SampledOut.Add(1)
+	return false
+}
+
+func (s *Sampler) keep(v Identifier) bool {
+	index, err := v.Index()
+	if err != nil {
+		return true
+	}
+	id, err := v.Id()
+	if err != nil {
+		return true
+	}
+	if id != "" {
+		return float64(mix(FNVHash(index, id))) < s.fraction*(math.MaxUint32+1)
+	}
+	// Every time the fraction of the entries looked at passes a whole entry, one is kept
+	s.Lock()
+	defer s.Unlock()
+	n := float64(s.anonymous)
+	s.anonymous++
+	return math.Floor((n+1)*s.fraction) > math.Floor(n*s.fraction)
+}
+
+// RateLimiter caps the rate of entries fed to the slurpers to measure how a cluster behaves under
+// a known load, it holds back data on purpose and is for load testing only. It's a token bucket
+// allowing a burst of up to a second of entries. It's safe for concurrent use.
+type RateLimiter struct {
+	sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a RateLimiter allowing perSecond entries every second, which must be more
+// than zero.
+func NewRateLimiter(perSecond int) *RateLimiter {
+	return &RateLimiter{rate: float64(perSecond), tokens: float64(perSecond), last: time.Now()}
+}
+
+// Wait blocks until another entry may be fed, returning false if exit is closed first. Entries
+// that had to wait are counted in stats.RateLimited.
+func (r *RateLimiter) Wait(exit <-chan bool) bool {
+	r.Lock()
+	now := time.Now()
+	r.tokens = math.Min(r.rate, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	r.last = now
+	// Taken in advance, a negative balance is the time others are already waiting for
+	r.tokens--
+	var delay time.Duration
+	if r.tokens < 0 {
+		delay = time.Duration(-r.tokens / r.rate * float64(time.Second))
+	}
+	r.Unlock()
+	if delay == 0 {
+		return true
+	}
+
+	stats.RateLimited.Add(1)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-exit:
+		return false
+	}
+}
+
+// mix spreads the bits of a FNV hash over all of its range, FNV of short ids that only differ in
+// their last bytes is far from uniform in its high bits. It's the finalizer of MurmurHash3.
+func mix(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
@@ -0,0 +1,66 @@
+package elasticsearch
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSampler(t *testing.T) {
+	sampler := NewSampler(0.1)
+	var kept []string
+	for i := 0; i < 10000; i++ {
+		id := strconv.Itoa(i)
+		if sampler.Keep(&rawEntry{"index", "testing", "user", id, nil}) {
+			kept = append(kept, id)
+		}
+	}
+	if len(kept) < 900 || len(kept) > 1100 {
+		t.Error("Expected about a tenth to be kept, got", len(kept))
+	}
+
+	// The same documents every time
+	again := NewSampler(0.1)
+	for _, id := range kept {
+		if !again.Keep(&rawEntry{"delete", "testing", "user", id, nil}) {
+			t.Fatal("Expected", id, "to be kept again")
+		}
+	}
+
+	var anonymous int
+	for i := 0; i < 100; i++ {
+		if sampler.Keep(&rawEntry{"index", "testing", "user", "", nil}) {
+			anonymous++
+		}
+	}
+	if anonymous != 10 {
+		t.Error("Expected every tenth new document to be kept, got", anonymous)
+	}
+
+	if NewSampler(0).Keep(&rawEntry{"index", "testing", "user", "1", nil}) || !NewSampler(1).Keep(&rawEntry{"index", "testing", "user", "1", nil}) {
+		t.Error("Expected none and all to be kept for 0 and 1")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(100)
+	exit := make(chan bool)
+	start := time.Now()
+	for i := 0; i < 150; i++ {
+		if !limiter.Wait(exit) {
+			t.Fatal("Expected to be let through")
+		}
+	}
+	// A second worth as burst, then 50 more at 100 per second
+	if took := time.Since(start); took < 400*time.Millisecond || took > 2*time.Second {
+		t.Error("Expected about half a second, took", took)
+	}
+
+	close(exit)
+	for i := 0; i < 200; i++ {
+		if !limiter.Wait(exit) {
+			return
+		}
+	}
+	t.Error("Expected waiting to stop on exit")
+}
@@ -0,0 +1,47 @@
+package elasticsearch
+
+import (
+	"github.com/duego/cryriver/redact"
+	"net/http"
+	"time"
+)
+
+// Logger receives verbose logging of the requests sent to elasticsearch, *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// WithLogger enables logging of all requests and their responses to l, which is off by default.
+// Each request is logged with its URL, size, duration and response status. Request and response
+// bodies often contains personal data, they are only logged after the fields in redact.Paths has
+// been masked and being passed through redact, they are left out entirely if redact is nil.
+// Headers are never logged since they may carry credentials.
+func WithLogger(l Logger, redact func([]byte) []byte) ClientOption {
+	return func(c *Client) {
+		c.logger = l
+		c.redact = redact
+	}
+}
+
+// logRequest logs an exchange with elasticsearch if a Logger is configured.
+func (c *Client) logRequest(req *http.Request, body []byte, resp *http.Response, respBody []byte, took time.Duration, err error) {
+	if c.logger == nil {
+		return
+	}
+	// Any password in the URL is masked
+	url := req.URL.Redacted()
+	if err != nil {
+		c.logger.Printf("%s %s (%d bytes) failed after %s: %s", req.Method, url, len(body), took, err)
+	} else {
+		c.logger.Printf("%s %s (%d bytes) returned %s after %s", req.Method, url, len(body), resp.Status, took)
+	}
+	if c.redact == nil {
+		return
+	}
+	if len(body) > 0 {
+		c.logger.Printf("Request body:\n%s", c.redact(redact.JSON(body)))
+	}
+	if len(respBody) > 0 {
+		c.logger.Printf("Response body:\n%s", c.redact(redact.JSON(respBody)))
+	}
+}
@@ -0,0 +1,59 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoggerRedacts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	redact := func(b []byte) []byte {
+		return bytes.Replace(b, []byte("555-1234"), []byte("****"), -1)
+	}
+	url := strings.Replace(server.URL, "http://", "http://river:hunter2@", 1)
+	client := NewClient(url, 1, WithLogger(log.New(&out, "", 0), redact))
+
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"phone": "555-1234"}})
+	if err := client.BulkSend(bulk); err != nil {
+		t.Fatal(err)
+	}
+
+	logged := out.String()
+	if strings.Contains(logged, "555-1234") {
+		t.Error("Expected body to be redacted:\n", logged)
+	}
+	if !strings.Contains(logged, `{"phone":"****"}`) {
+		t.Error("Expected redacted body to be logged:\n", logged)
+	}
+	if strings.Contains(logged, "hunter2") {
+		t.Error("Expected password to be masked:\n", logged)
+	}
+	if !strings.Contains(logged, "200 OK") {
+		t.Error("Expected status to be logged:\n", logged)
+	}
+}
+
+func TestLoggerWithoutRedact(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	client := NewClient(server.URL, 1, WithLogger(log.New(&out, "", 0), nil))
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"phone": "555-1234"}})
+	client.BulkSend(bulk)
+
+	if logged := out.String(); strings.Contains(logged, "555-1234") || !strings.Contains(logged, "/_bulk") {
+		t.Error("Expected request to be logged without body:\n", logged)
+	}
+}
@@ -0,0 +1,89 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// DocumentRef identifies a document in ES.
+type DocumentRef struct {
+	Index string
+	Type  string
+	Id    string
+}
+
+// mgetDoc is one document of a multi get request, and of its response.
+type mgetDoc struct {
+	Index  string          `json:"_index"`
+	Type   string          `json:"_type,omitempty"`
+	Id     string          `json:"_id"`
+	Source *bool           `json:"_source,omitempty"`
+	Found  bool            `json:"found,omitempty"`
+	Error  json.RawMessage `json:"error,omitempty"`
+}
+
+// Exists tells which of docs are indexed, such as to only index operations on documents already
+// known to ES. All of them are checked by a single multi get request not fetching their sources.
+// Documents in indexes that doesn't exist are reported as missing. Types are left out of the
+// request and the refs returned once DetectVersion has found the cluster to be typeless.
+//
+// The answer may be outdated as soon as it's given, the documents can be created or deleted by other
+// writers between the check and the write depending on it. Entries that must never create documents
+// are better sent as updates without upsert.
+func (c *Client) Exists(ctx context.Context, docs []Identifier) (map[DocumentRef]bool, error) {
+	exists := make(map[DocumentRef]bool)
+	if len(docs) == 0 {
+		return exists, nil
+	}
+	noSource := false
+	typeless := c.typeless()
+	refs := make([]DocumentRef, len(docs))
+	request := struct {
+		Docs []mgetDoc `json:"docs"`
+	}{make([]mgetDoc, len(docs))}
+	for i, doc := range docs {
+		var err error
+		if refs[i].Index, err = doc.Index(); err != nil {
+			return nil, err
+		}
+		if refs[i].Type, err = doc.Type(); err != nil {
+			return nil, err
+		}
+		if typeless {
+			refs[i].Type = ""
+		}
+		if refs[i].Id, err = doc.Id(); err != nil {
+			return nil, err
+		}
+		request.Docs[i] = mgetDoc{Index: refs[i].Index, Type: refs[i].Type, Id: refs[i].Id, Source: &noSource}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, respBody, err := c.do(ctx, "POST", "/_mget", "application/json", body)
+	if err != nil {
+		return nil, err
+	}
+	if code := resp.StatusCode; code != 200 {
+		return nil, parseError(code, respBody)
+	}
+	var response struct {
+		Docs []mgetDoc `json:"docs"`
+	}
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, err
+	}
+	// Documents are answered in the order they were asked for
+	if len(response.Docs) != len(refs) {
+		return nil, fmt.Errorf("Expected %d documents in multi get response, got %d", len(refs), len(response.Docs))
+	}
+	for i, doc := range response.Docs {
+		if doc.Found {
+			exists[refs[i]] = true
+		}
+	}
+	return exists, nil
+}
@@ -0,0 +1,108 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExists(t *testing.T) {
+	var requests int
+	var request struct {
+		Docs []map[string]interface{} `json:"docs"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Method != "POST" || r.URL.Path != "/_mget" {
+			t.Error("Unexpected request", r.Method, r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &request)
+		w.Write([]byte(`{"docs":[
+			{"_index":"users","_type":"_doc","_id":"1","found":true},
+			{"_index":"users","_type":"_doc","_id":"2","found":false},
+			{"_index":"photos","_type":"_doc","_id":"1","found":true},
+			{"_index":"gone","_id":"1","error":{"type":"index_not_found_exception","reason":"no such index [gone]"}}
+		]}`))
+	}))
+	defer server.Close()
+
+	docs := []Identifier{
+		&rawEntry{"index", "users", "", "1", nil},
+		&rawEntry{"index", "users", "", "2", nil},
+		&rawEntry{"index", "photos", "photo", "1", nil},
+		&rawEntry{"index", "gone", "", "1", nil},
+	}
+	exists, err := NewClient(server.URL, 1).Exists(context.Background(), docs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Error("Expected a single request, got", requests)
+	}
+	if len(request.Docs) != 4 || request.Docs[0]["_source"] != false || request.Docs[2]["_type"] != "photo" {
+		t.Error("Unexpected request", request.Docs)
+	}
+	if _, ok := request.Docs[0]["_type"]; ok {
+		t.Error("Expected no type when the entry has none")
+	}
+	expected := map[DocumentRef]bool{
+		{"users", "", "1"}:       true,
+		{"photos", "photo", "1"}: true,
+	}
+	if len(exists) != len(expected) {
+		t.Error("Unexpected documents found", exists)
+	}
+	for ref := range expected {
+		if !exists[ref] {
+			t.Error("Expected", ref, "to exist")
+		}
+	}
+}
+
+func TestExistsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error":{"type":"action_request_validation_exception","reason":"no documents to get"},"status":400}`))
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, 1).Exists(context.Background(), []Identifier{&rawEntry{"index", "users", "", "1", nil}})
+	if esErr, ok := err.(*ESError); !ok || esErr.Type != "action_request_validation_exception" {
+		t.Error("Expected an *ESError, got", err)
+	}
+}
+
+func TestExistsTypeless(t *testing.T) {
+	var request struct {
+		Docs []map[string]interface{} `json:"docs"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Write([]byte(`{"version":{"number":"8.5.1"}}`))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &request)
+		w.Write([]byte(`{"docs":[{"_index":"photos","_id":"1","found":true}]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1)
+	if _, err := client.DetectVersion(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	exists, err := client.Exists(context.Background(), []Identifier{&rawEntry{"index", "photos", "photo", "1", nil}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := request.Docs[0]["_type"]; ok {
+		t.Error("Expected no type towards a typeless cluster, got", request.Docs)
+	}
+	if !exists[DocumentRef{"photos", "", "1"}] {
+		t.Error("Expected the typeless ref to exist, got", exists)
+	}
+}
@@ -0,0 +1,23 @@
+package elasticsearch
+
+// AddObserver is told of every entry added to a BulkBody, such as to audit what leaves for ES.
+// source is the document exactly as it's sent, nil for deletes. The body holds a copy of its own,
+// changing source changes nothing that is sent.
+type AddObserver func(index, typ, id, action string, source []byte)
+
+// OnAdd makes the BulkBody call observer for every entry once it has been added, with the header
+// as it's sent. Entries that fail to be added or are left out, such as expired ones of a
+// Retention, are not observed. observer is called from Add and holds it up, it should be quick.
+func OnAdd(observer AddObserver) BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.onAdd = observer
+	}
+}
+
+// observe calls the observer of the body, if any, for an entry just added.
+func (bulk *BulkBody) observe(action string, header *indexHeader, source []byte) {
+	if bulk.onAdd == nil {
+		return
+	}
+	bulk.onAdd(header.Name, header.Type, header.Id, action, source)
+}
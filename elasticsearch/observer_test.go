@@ -0,0 +1,49 @@
+package elasticsearch
+
+import (
+	"testing"
+)
+
+func TestBulkBodyOnAdd(t *testing.T) {
+	type observed struct {
+		index, typ, id, action, source string
+	}
+	var seen []observed
+	bulk := NewBulkBody(MB, OnAdd(func(index, typ, id, action string, source []byte) {
+		seen = append(seen, observed{index, typ, id, action, string(source)})
+		for i := range source {
+			source[i] = 'x'
+		}
+	}))
+	for _, entry := range []*rawEntry{
+		{"index", "testing", "user", "1", map[string]interface{}{"alias": "Johnny"}},
+		{"update", "testing", "user", "2", map[string]interface{}{"alias": "Jane"}},
+		{"delete", "testing", "user", "3", nil},
+		{"delete", "testing", "user", "", nil},
+		{"index", "testing", "user", "4", nil},
+	} {
+		bulk.Add(entry)
+	}
+
+	expected := []observed{
+		{"testing", "user", "1", "index", `{"alias":"Johnny"}`},
+		{"testing", "user", "2", "update", `{"doc":{"alias":"Jane"},"doc_as_upsert":true}`},
+		{"testing", "user", "3", "delete", ""},
+	}
+	if len(seen) != len(expected) {
+		t.Fatal("Expected each added entry to be observed once, got", seen)
+	}
+	for i := range expected {
+		if seen[i] != expected[i] {
+			t.Error("Expected", expected[i], "got", seen[i])
+		}
+	}
+	if s := string(bulk.Bytes()); s != `{"index":{"_index":"testing","_type":"user","_id":"1"}}
+{"alias":"Johnny"}
+{"update":{"_index":"testing","_type":"user","_id":"2"}}
+{"doc":{"alias":"Jane"},"doc_as_upsert":true}
+{"delete":{"_index":"testing","_type":"user","_id":"3"}}
+` {
+		t.Error("Expected the observer not to change the body, got", s)
+	}
+}
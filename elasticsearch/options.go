@@ -0,0 +1,74 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Version is the release of cryriver, used in the default User-Agent.
+const Version = "0.1.0"
+
+// DefaultUserAgent is sent with all requests unless another is given by WithUserAgent.
+const DefaultUserAgent = "cryriver/" + Version
+
+// ClientOption configures optional behaviour of a Client created by NewClient.
+type ClientOption func(*Client)
+
+// WithHTTPClient makes the Client send all requests through hc, for example to route them through
+// a proxy or share a tuned connection pool. The http.Client and its Transport are used as they
+// are and never modified.
+func WithHTTPClient(hc *http.Client) ClientOption {
+	return func(c *Client) {
+		c.Client = hc
+	}
+}
+
+// WithUserAgent makes the Client send s as User-Agent header on all requests, such as to let proxies
+// tell it apart from other clients.
+func WithUserAgent(s string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = s
+	}
+}
+
+// WithRequestTimeout limits every request sent by the Client to d, including reading the response.
+// The timeout is derived from the context given by the caller, so the sooner of its deadline and d
+// applies. A timed out bulk request is retried like other failed requests and each attempt gets d
+// of its own, while a deadline or cancelation of the caller's context stops them all. Zero means no
+// timeout besides the one of the context, which is the default.
+func WithRequestTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.requestTimeout = d
+	}
+}
+
+// WithCompatibility makes the Client ask for the REST API of elasticsearch major, 7 or later, with
+// the compatible-with parameter of the Accept and Content-Type headers. A cluster of a later major,
+// such as 8, then accepts and answers requests as the targeted major would, which lets the river
+// keep working against a fleet being upgraded. Without it, or for majors before 7 which don't know
+// of compatibility, plain media types are sent and every cluster answers with its own API, the
+// latest. OpenSearch doesn't know of compatibility either, see WithOpenSearch.
+func WithCompatibility(major int) ClientOption {
+	return func(c *Client) {
+		c.compatibleWith = major
+	}
+}
+
+// mediaTypes returns the Accept and Content-Type headers of a request with body of contentType,
+// compatible with the major version given to WithCompatibility. Accept is empty for no header.
+func (c *Client) mediaTypes(contentType string) (string, string) {
+	if c.compatibleWith < 7 || c.openSearch {
+		return "", contentType
+	}
+	accept := fmt.Sprintf("application/vnd.elasticsearch+json; compatible-with=%d", c.compatibleWith)
+	switch contentType {
+	case "":
+	case "application/json":
+		contentType = accept
+	default:
+		// Bulk bodies are newline delimited
+		contentType = fmt.Sprintf("application/vnd.elasticsearch+x-ndjson; compatible-with=%d", c.compatibleWith)
+	}
+	return accept, contentType
+}
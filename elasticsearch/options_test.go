@@ -0,0 +1,172 @@
+package elasticsearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// countingTransport counts the requests passing through it on their way to http.DefaultTransport.
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	tr := &countingTransport{}
+	hc := &http.Client{Transport: tr}
+	client := NewClient(server.URL, 1, WithHTTPClient(hc))
+	if client.Client != hc {
+		t.Fatal("Expected the injected http.Client to be used")
+	}
+
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+	if err := client.BulkSend(bulk); err != nil {
+		t.Fatal(err)
+	}
+	if tr.requests != 1 {
+		t.Error("Expected request to pass the injected transport, got", tr.requests)
+	}
+	if hc.Transport != tr {
+		t.Error("Expected the injected transport to be left untouched")
+	}
+}
+
+func TestDefaultHTTPClient(t *testing.T) {
+	client := NewClient("http://localhost:9200", 4)
+	tr, ok := client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatal("Expected a default transport")
+	}
+	if tr.MaxIdleConnsPerHost != 4 {
+		t.Error("Expected idle connections to follow maxConn, got", tr.MaxIdleConnsPerHost)
+	}
+	if tr == http.DefaultTransport {
+		t.Error("Expected http.DefaultTransport not to be shared")
+	}
+}
+
+func TestWithUserAgent(t *testing.T) {
+	var agents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents = append(agents, r.UserAgent())
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	for _, client := range []*Client{NewClient(server.URL, 1), NewClient(server.URL, 1, WithUserAgent("indexer/2"))} {
+		bulk := NewBulkBody(MB)
+		bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+		if err := client.BulkSend(bulk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(agents) != 2 || agents[0] != DefaultUserAgent || agents[1] != "indexer/2" {
+		t.Error("Unexpected user agents", agents)
+	}
+}
+
+func TestWithRequestTimeout(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewClient(server.URL, 1, WithRequestTimeout(20*time.Millisecond), WithRetries(0))
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+	start := time.Now()
+	if err := client.BulkSend(bulk); err == nil {
+		t.Fatal("Expected slow request to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("Expected request to be given up after the timeout, took", elapsed)
+	}
+	if bulk.Len() == 0 {
+		t.Error("Expected body to be kept for another try")
+	}
+}
+
+func TestRequestTimeoutSoonerDeadline(t *testing.T) {
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	// The deadline of the caller is sooner than the request timeout and stops the retries too
+	client := NewClient(server.URL, 1, WithRequestTimeout(time.Minute), WithRetries(5))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+	start := time.Now()
+	if err := client.BulkSendContext(ctx, bulk); err == nil {
+		t.Fatal("Expected request to stop at the deadline of the context")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("Expected the sooner deadline to apply, took", elapsed)
+	}
+}
+
+func TestWithCompatibility(t *testing.T) {
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header)
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	expected := []struct {
+		major               int
+		accept, contentType string
+	}{
+		{0, "", "application/x-www-form-urlencoded"},
+		{6, "", "application/x-www-form-urlencoded"},
+		{7, "application/vnd.elasticsearch+json; compatible-with=7", "application/vnd.elasticsearch+x-ndjson; compatible-with=7"},
+		{8, "application/vnd.elasticsearch+json; compatible-with=8", "application/vnd.elasticsearch+x-ndjson; compatible-with=8"},
+	}
+	for _, e := range expected {
+		bulk := NewBulkBody(MB)
+		bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+		if err := NewClient(server.URL, 1, WithCompatibility(e.major)).BulkSend(bulk); err != nil {
+			t.Fatal(err)
+		}
+		h := headers[len(headers)-1]
+		if h.Get("Accept") != e.accept || h.Get("Content-Type") != e.contentType {
+			t.Errorf("Unexpected headers for %d: Accept %q, Content-Type %q", e.major, h.Get("Accept"), h.Get("Content-Type"))
+		}
+	}
+
+	// JSON bodies and requests without one
+	client := NewClient(server.URL, 1, WithCompatibility(7))
+	client.Exists(context.Background(), []Identifier{&rawEntry{"index", "testing", "user", "1", nil}})
+	client.Ping(context.Background())
+	if h := headers[len(headers)-2]; h.Get("Content-Type") != "application/vnd.elasticsearch+json; compatible-with=7" {
+		t.Error("Unexpected Content-Type of a JSON body", h.Get("Content-Type"))
+	}
+	if h := headers[len(headers)-1]; h.Get("Accept") != "application/vnd.elasticsearch+json; compatible-with=7" || h.Get("Content-Type") != "" {
+		t.Errorf("Unexpected headers without a body: Accept %q, Content-Type %q", h.Get("Accept"), h.Get("Content-Type"))
+	}
+}
@@ -0,0 +1,44 @@
+package elasticsearch
+
+import (
+	"errors"
+	"log"
+)
+
+// ErrEntryTooLarge is returned by Add for an entry larger than the ceiling given to
+// AllowOversizeSingles, ES would reject a request holding it.
+var ErrEntryTooLarge = errors.New("Entry is too large to send even on its own")
+
+// AllowOversizeSingles makes the BulkBody send entries larger than its max in requests of their
+// own, for documents that are large but valid. Without it, such an entry is added to the body like
+// any other and makes the request larger than max for the entries sent along with it. Entries
+// making a request larger than ceiling fail with ErrEntryTooLarge instead, a ceiling larger than
+// MaxBulkSize is lowered to MaxBulkSize as ES rejects larger requests anyway.
+func AllowOversizeSingles(ceiling ByteSize) BulkOption {
+	if ceiling > MaxBulkSize {
+		ceiling = MaxBulkSize
+	}
+	return func(bulk *BulkBody) {
+		bulk.oversizeCeiling = ceiling
+	}
+}
+
+// writeOversized adds an entry larger than max as the only one of the body, which is done
+// afterwards. BulkBodyFull is returned if the body has other entries, to add it again once they
+// have been sent.
+func (bulk *BulkBody) writeOversized(v BulkEntry, action string, header *indexHeader, entry []byte) error {
+	// The final newline is sent along with it
+	size := ByteSize(len(entry) + 1)
+	if size > bulk.oversizeCeiling {
+		return ErrEntryTooLarge
+	}
+	if bulk.Len() > 0 {
+		bulk.Done()
+		return BulkBodyFull
+	}
+	log.Printf("Sending %s of %s in %s on its own, it's %d bytes while bulk requests are up to %d", action, header.Id, header.Name, size, bulk.max)
+	if err := bulk.write(v, action, header, entry); err != nil {
+		return err
+	}
+	return bulk.Done()
+}
@@ -0,0 +1,62 @@
+package elasticsearch
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAllowOversizeSingles(t *testing.T) {
+	bulk := NewBulkBody(KB, AllowOversizeSingles(4*KB))
+	small := &rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}}
+	large := &rawEntry{"index", "testing", "user", "2", map[string]interface{}{"data": strings.Repeat("a", 2*int(KB))}}
+	if err := bulk.Add(small); err != nil {
+		t.Fatal(err)
+	}
+	if err := bulk.Add(large); err != BulkBodyFull {
+		t.Fatal("Expected the oversized entry to wait for an empty body, got", err)
+	}
+
+	bulk.Reset()
+	if err := bulk.Add(large); err != nil {
+		t.Fatal(err)
+	}
+	if ByteSize(bulk.Len()) <= KB || !strings.HasSuffix(bulk.String(), "\n\n") {
+		t.Error("Expected the oversized entry to be added and the body done, got", bulk.Len())
+	}
+	if err := bulk.Add(small); err != BulkBodyFull {
+		t.Error("Expected nothing to be added along with the oversized entry, got", err)
+	}
+
+	bulk.Reset()
+	huge := &rawEntry{"index", "testing", "user", "3", map[string]interface{}{"data": strings.Repeat("a", 4*int(KB))}}
+	if err := bulk.Add(huge); err != ErrEntryTooLarge {
+		t.Error("Expected ErrEntryTooLarge above the ceiling, got", err)
+	}
+	if bulk.Len() != 0 {
+		t.Error("Expected nothing to be added, got", bulk.Len())
+	}
+}
+
+func TestSlurpOversizeSingle(t *testing.T) {
+	sender := &recordingSender{}
+	esc := make(chan Transaction)
+	done := make(chan bool)
+	go func() {
+		Slurp(sender, esc, SlurpConfig{Linger: time.Hour, BulkOptions: []BulkOption{AllowOversizeSingles(4 * DefaultBulkSize)}})
+		close(done)
+	}()
+
+	esc <- &timedEntry{rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}}}
+	esc <- &timedEntry{rawEntry{"index", "testing", "user", "2", map[string]interface{}{"data": strings.Repeat("a", 2*int(DefaultBulkSize))}}}
+	esc <- &timedEntry{rawEntry{"index", "testing", "user", "3", map[string]interface{}{"foo": "bar"}}}
+	close(esc)
+	<-done
+
+	if n := sender.count(); n != 3 {
+		t.Fatal("Expected the oversized entry to be sent on its own, got", n, "sends")
+	}
+	if !strings.Contains(sender.sent[1], `"_id":"2"`) || strings.Count(sender.sent[1], "_id") != 1 {
+		t.Error("Expected only the oversized entry in the second request")
+	}
+}
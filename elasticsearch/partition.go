@@ -0,0 +1,68 @@
+package elasticsearch
+
+import (
+	"hash/fnv"
+)
+
+// PartitionHash maps the document id in index to a number, the partition is that number modulo
+// the number of partitions. It must return the same number for the same document every time.
+type PartitionHash func(index, id string) uint32
+
+// FNVHash is the default PartitionHash, a 32 bit FNV-1a hash of index and id.
+func FNVHash(index, id string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(index))
+	h.Write([]byte{0})
+	h.Write([]byte(id))
+	return h.Sum32()
+}
+
+// Partition splits the transactions read from in over n channels, one for each slurper, by hash of
+// their index and id, FNVHash if nil. Several slurpers reading from the same channel may send two
+// transactions of a document in bulk requests running at the same time, which ES may apply in
+// either order and an older value win. A slurper sends one body at a time, and a body ES couldn't
+// be reached for is sent again before anything more is taken in, so with all transactions of a
+// document going to the same one they are applied in the order they were read.
+//
+// This comes at the cost of throughput: the transactions of a document can't be spread over more
+// than one slurper, and a partition that can't keep up, such as one getting most of the writes of
+// a busy document, holds up the reading of in and thereby every other partition. Transactions
+// without an id, which are new documents, are spread over all partitions in turn.
+//
+// The channels are closed once in is closed, and may be given to Slurp just like in.
+func Partition(in <-chan Transaction, n int, hash PartitionHash) []chan Transaction {
+	if hash == nil {
+		hash = FNVHash
+	}
+	partitions := make([]chan Transaction, n)
+	for i := range partitions {
+		partitions[i] = make(chan Transaction)
+	}
+	go func() {
+		var next int
+		for op := range in {
+			partitions[partitionOf(op, n, hash, &next)] <- op
+		}
+		for _, partition := range partitions {
+			close(partition)
+		}
+	}()
+	return partitions
+}
+
+// partitionOf returns the partition of op, the one after next for transactions without an id.
+func partitionOf(op Transaction, n int, hash PartitionHash, next *int) int {
+	index, err := op.Index()
+	if err != nil {
+		return 0
+	}
+	id, err := op.Id()
+	if err != nil {
+		return 0
+	}
+	if id == "" {
+		*next = (*next + 1) % n
+		return *next
+	}
+	return int(hash(index, id) % uint32(n))
+}
@@ -0,0 +1,93 @@
+package elasticsearch
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestPartition(t *testing.T) {
+	in := make(chan Transaction)
+	partitions := Partition(in, 4, nil)
+	if len(partitions) != 4 {
+		t.Fatal("Expected 4 partitions, got", len(partitions))
+	}
+
+	// Remember which partition each document went to, in the order received
+	var lock sync.Mutex
+	seen := make(map[string][]int)
+	order := make(map[string][]string)
+	var wg sync.WaitGroup
+	for i, partition := range partitions {
+		wg.Add(1)
+		go func(i int, partition chan Transaction) {
+			defer wg.Done()
+			for op := range partition {
+				id, _ := op.Id()
+				doc, _ := op.Document()
+				lock.Lock()
+				seen[id] = append(seen[id], i)
+				order[id] = append(order[id], doc["n"].(string))
+				lock.Unlock()
+			}
+		}(i, partition)
+	}
+	for n := 0; n < 10; n++ {
+		for id := 0; id < 20; id++ {
+			in <- &timedEntry{rawEntry{"update", "testing", "user", strconv.Itoa(id), map[string]interface{}{"n": strconv.Itoa(n)}}}
+		}
+	}
+	for n := 0; n < 8; n++ {
+		in <- &timedEntry{rawEntry{"index", "testing", "user", "", map[string]interface{}{"n": strconv.Itoa(n)}}}
+	}
+	close(in)
+	wg.Wait()
+
+	for id := 0; id < 20; id++ {
+		partitions := seen[strconv.Itoa(id)]
+		for _, p := range partitions {
+			if p != partitions[0] {
+				t.Error("Expected every operation of", id, "in the same partition, got", partitions)
+				break
+			}
+		}
+		for n, value := range order[strconv.Itoa(id)] {
+			if value != strconv.Itoa(n) {
+				t.Error("Expected operations of", id, "in order, got", order[strconv.Itoa(id)])
+				break
+			}
+		}
+	}
+	spread := make(map[int]int)
+	for _, p := range seen[""] {
+		spread[p]++
+	}
+	if len(spread) != 4 {
+		t.Error("Expected new documents over all partitions, got", spread)
+	}
+}
+
+func TestPartitionHash(t *testing.T) {
+	in := make(chan Transaction, 3)
+	partitions := Partition(in, 3, func(index, id string) uint32 {
+		n, _ := strconv.Atoi(id)
+		return uint32(n)
+	})
+	for _, id := range []string{"2", "0", "1"} {
+		in <- &timedEntry{rawEntry{"delete", "testing", "user", id, nil}}
+	}
+	close(in)
+	var wg sync.WaitGroup
+	for i, partition := range partitions {
+		wg.Add(1)
+		go func(i int, partition chan Transaction) {
+			defer wg.Done()
+			for op := range partition {
+				if id, _ := op.Id(); id != strconv.Itoa(i) {
+					t.Error("Expected", i, "in partition", i, "got", id)
+				}
+			}
+		}(i, partition)
+	}
+	wg.Wait()
+}
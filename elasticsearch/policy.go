@@ -0,0 +1,78 @@
+package elasticsearch
+
+import (
+	"fmt"
+	"log"
+)
+
+// MarshalError tells that an entry couldn't be marshaled into json, such as for a document holding
+// a NaN float.
+type MarshalError struct {
+	Entry BulkEntry
+	Index string
+	Id    string
+	Err   error
+}
+
+func (e *MarshalError) Error() string {
+	return fmt.Sprintf("Unable to marshal entry %s in %s: %s", e.Id, e.Index, e.Err)
+}
+
+// ValidationError is returned by the Document of entries whose document is invalid, such as for
+// failing a schema. It's handled by the MarshalPolicy like entries that can't be marshaled.
+type ValidationError struct {
+	// Dotted path of the invalid value in the document, empty for the document itself
+	Path   string
+	Reason string
+
+	// The document that failed, for dead-lettering it
+	Document map[string]interface{}
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return "Invalid document: " + e.Reason
+	}
+	return fmt.Sprintf("Invalid document at %s: %s", e.Path, e.Reason)
+}
+
+// MarshalPolicy decides what Add does with entries that can't be marshaled or whose document is
+// invalid, the error returned is returned by Add. Nothing of the entry is added either way.
+type MarshalPolicy func(err *MarshalError) error
+
+// SkipAndLog logs entries that can't be marshaled and leaves them out, so that one bad document
+// can't halt the whole river. This is the default.
+func SkipAndLog(err *MarshalError) error {
+	log.Println("Skipping", err)
+	return nil
+}
+
+// FailFast makes Add return the *MarshalError, leaving it to the caller to stop.
+func FailFast(err *MarshalError) error {
+	return err
+}
+
+// DeadLetter leaves out entries that can't be marshaled after handing them to f, such as to save
+// them for later investigation.
+func DeadLetter(f func(err *MarshalError)) MarshalPolicy {
+	return func(err *MarshalError) error {
+		f(err)
+		return nil
+	}
+}
+
+// WithMarshalPolicy makes the BulkBody use p for entries that can't be marshaled.
+func WithMarshalPolicy(p MarshalPolicy) BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.marshalPolicy = p
+	}
+}
+
+// marshalFailed applies the marshal policy on v failing with err.
+func (bulk *BulkBody) marshalFailed(v BulkEntry, header indexHeader, err error) error {
+	marshalErr := &MarshalError{Entry: v, Index: header.Name, Id: header.Id, Err: err}
+	if bulk.marshalPolicy == nil {
+		return SkipAndLog(marshalErr)
+	}
+	return bulk.marshalPolicy(marshalErr)
+}
@@ -0,0 +1,63 @@
+package elasticsearch
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMarshalPolicy(t *testing.T) {
+	invalid := &rawEntry{"index", "testing", "user", "123", map[string]interface{}{"score": math.NaN()}}
+	valid := &rawEntry{"index", "testing", "user", "456", map[string]interface{}{"score": 1}}
+
+	// Skipped by default
+	bulk := NewBulkBody(MB)
+	if err := bulk.Add(invalid); err != nil {
+		t.Error("Expected invalid entry to be skipped, got", err)
+	}
+	if bulk.Len() != 0 {
+		t.Error("Expected nothing of the invalid entry to be added, got", bulk.String())
+	}
+	if err := bulk.Add(valid); err != nil || bulk.Len() == 0 {
+		t.Error("Expected later entries to be added, got", err)
+	}
+
+	bulk = NewBulkBody(MB, WithMarshalPolicy(FailFast))
+	if err, ok := bulk.Add(invalid).(*MarshalError); !ok || err.Id != "123" || err.Entry != invalid {
+		t.Error("Expected a *MarshalError, got", err)
+	}
+
+	var letters []*MarshalError
+	bulk = NewBulkBody(MB, WithMarshalPolicy(DeadLetter(func(err *MarshalError) {
+		letters = append(letters, err)
+	})))
+	if err := bulk.Add(invalid); err != nil {
+		t.Error("Expected dead lettered entry to be left out, got", err)
+	}
+	if len(letters) != 1 || letters[0].Entry != invalid {
+		t.Error("Expected entry to be dead lettered, got", letters)
+	}
+}
+
+// invalidEntry has a document failing validation.
+type invalidEntry struct {
+	rawEntry
+}
+
+func (e *invalidEntry) Document() (map[string]interface{}, error) {
+	return nil, &ValidationError{Path: "age", Reason: "Expected integer, got string", Document: e.values}
+}
+
+func TestMarshalPolicyValidation(t *testing.T) {
+	invalid := &invalidEntry{rawEntry{"index", "testing", "user", "123", map[string]interface{}{"age": "old"}}}
+	bulk := NewBulkBody(MB, WithMarshalPolicy(FailFast))
+	err, ok := bulk.Add(invalid).(*MarshalError)
+	if !ok || err.Id != "123" {
+		t.Fatal("Expected a *MarshalError, got", err)
+	}
+	if validationErr, ok := err.Err.(*ValidationError); !ok || validationErr.Path != "age" {
+		t.Error("Expected the validation error to be attached, got", err.Err)
+	}
+	if bulk.Len() != 0 {
+		t.Error("Expected nothing of the invalid entry to be added, got", bulk.String())
+	}
+}
@@ -0,0 +1,33 @@
+package elasticsearch
+
+import (
+	"sync"
+)
+
+// BulkBodyPool hands out bulk bodies for reuse, saving the allocation of their buffers when many
+// bodies are built in parallel. It's safe for concurrent use.
+type BulkBodyPool struct {
+	pool sync.Pool
+}
+
+// NewBulkBodyPool returns a pool of bulk bodies created by NewBulkBody with max and options.
+func NewBulkBodyPool(max ByteSize, options ...BulkOption) *BulkBodyPool {
+	p := &BulkBodyPool{}
+	p.pool.New = func() interface{} {
+		return NewBulkBody(max, options...)
+	}
+	return p
+}
+
+// Get returns an empty bulk body from the pool, or a new one if there is none.
+func (p *BulkBodyPool) Get() *BulkBody {
+	return p.pool.Get().(*BulkBody)
+}
+
+// Put resets b and returns it to the pool, such as after it has been sent. Callers must not keep
+// any references to b, or to the bytes of it, after it has been put back as it will be handed out
+// again.
+func (p *BulkBodyPool) Put(b *BulkBody) {
+	b.Reset()
+	p.pool.Put(b)
+}
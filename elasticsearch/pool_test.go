@@ -0,0 +1,46 @@
+package elasticsearch
+
+import (
+	"testing"
+)
+
+func TestBulkBodyPool(t *testing.T) {
+	pool := NewBulkBodyPool(KB)
+	bulk := pool.Get()
+	if bulk.max != KB {
+		t.Error("Expected body to be sized by the pool, got", bulk.max)
+	}
+	bulk.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+	bulk.Done()
+	pool.Put(bulk)
+
+	bulk = pool.Get()
+	if bulk.Len() != 0 {
+		t.Error("Expected an empty body, got", bulk.String())
+	}
+	if err := bulk.Add(&rawEntry{"index", "testing", "user", "456", map[string]interface{}{"foo": "bar"}}); err != nil {
+		t.Error("Expected reused body to accept entries, got", err)
+	}
+}
+
+func benchmarkFill(b *BulkBody) {
+	for i := 0; i < 100; i++ {
+		b.Add(&rawEntry{"index", "testing", "user", "123", map[string]interface{}{"foo": "bar"}})
+	}
+	b.Done()
+}
+
+func BenchmarkBulkBodyFresh(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		benchmarkFill(NewBulkBody(DefaultBulkSize))
+	}
+}
+
+func BenchmarkBulkBodyPooled(b *testing.B) {
+	pool := NewBulkBodyPool(DefaultBulkSize)
+	for i := 0; i < b.N; i++ {
+		bulk := pool.Get()
+		benchmarkFill(bulk)
+		pool.Put(bulk)
+	}
+}
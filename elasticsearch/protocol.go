@@ -2,8 +2,13 @@ package elasticsearch
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"unicode/utf8"
 )
 
 type ByteSize int64
@@ -16,6 +21,15 @@ const (
 )
 const newline byte = 10
 
+const (
+	// DefaultBulkSize is the max size of bulk bodies built by Slurp.
+	DefaultBulkSize = MB
+
+	// MaxBulkSize is the largest max a BulkBody can be configured with. It's the default of
+	// http.max_content_length in elasticsearch, larger requests are rejected by ES.
+	MaxBulkSize = 100 * MB
+)
+
 // BulkEntry is one complete entry for elasticsearch bulk requests
 type BulkEntry interface {
 	Operationer
@@ -32,32 +46,218 @@ type BulkBody struct {
 	*bytes.Buffer
 	max  ByteSize
 	done bool
+
+	// Creates ids for entries that doesn't have one
+	idGenerator IdGenerator
+
+	// Appends all entries to a data stream when set
+	dataStream *dataStream
+
+	// Routes entries into time-bucketed indices when set
+	retention *Retention
+
+	// Sets the time of adding in documents when set
+	indexedAt *indexedAt
+
+	// Replace invalid UTF-8 in serialized entries
+	sanitizeUTF8 bool
+
+	// Used instead of the action of every entry when set
+	forceAction string
+
+	// Leave out the type of every entry
+	typeless bool
+
+	// Largest request an entry larger than max may be sent in on its own, zero to not send them
+	// on their own
+	oversizeCeiling ByteSize
+
+	// Header defaults by index
+	defaults map[string]IndexDefaults
+
+	// What to do with entries that can't be marshaled, SkipAndLog if nil
+	marshalPolicy MarshalPolicy
+
+	// Last index or update of each document, for Replacer entries to drop
+	last map[string]span
+
+	// Number of entries by action, for the Stats of the Client sending the body
+	actions map[string]int
+
+	// Marshals documents, json.Marshal if nil. Either way the keys of maps are written in sorted
+	// order, see CanonicalJSON.
+	marshal func(v interface{}) ([]byte, error)
+
+	// What to do with raw carriage returns in serialized entries
+	carriageReturns CarriageReturnPolicy
+
+	// Told of every entry added when set
+	onAdd AddObserver
+
+	// Acknowledges the sequences of entries accepted by ES when set, see AckTo
+	acks *AckTracker
+
+	// Sequences of the entries each item in the body was written for, in the order of the body,
+	// and those of a dropped entry to carry over to the one replacing it
+	sequences [][]uint64
+	carried   []uint64
+
+	// Number of items ever written, to tell entries that were skipped
+	writes int
+
+	// Sizes of the entries in the body
+	sizes EntrySizes
+
+	// Asks ES to only write to aliases, see RequireAlias
+	requireAlias bool
+
+	// Start of the last header, for the next ones of the same index to reuse
+	template headerTemplate
+}
+
+// BulkOption configures optional behaviour of a BulkBody created by NewBulkBody.
+type BulkOption func(*BulkBody)
+
+// WithIdGenerator makes the BulkBody use g to create ids for entries returning an empty id.
+func WithIdGenerator(g IdGenerator) BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.idGenerator = g
+	}
+}
+
+// WithUTF8Sanitize makes the BulkBody replace invalid UTF-8 sequences with the unicode replacement
+// character, elasticsearch fails the whole request on source that isn't valid UTF-8.
+// encoding/json already does this for plain strings but not for values marshaling themselves,
+// such as json.RawMessage. This changes the indexed data and is therefore opt-in.
+func WithUTF8Sanitize() BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.sanitizeUTF8 = true
+	}
+}
+
+// ForceAction makes the BulkBody use action for every entry added, whatever action they report.
+// Deletes are sent without a document and updates are wrapped as partial documents like usual.
+// This is a blunt instrument meant for backfills, such as forcing create to never overwrite
+// documents already indexed, and shouldn't be used for regular tailing.
+func ForceAction(action string) BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.forceAction = action
+	}
+}
+
+// WithMarshaler makes the BulkBody marshal documents with marshal rather than json.Marshal, such as
+// to render values of a database driver in a way ES understands. Headers are always marshaled by
+// json.Marshal. marshal must write the same document the same way every time for the body to be
+// stable, like json.Marshal does by sorting the keys of maps.
+func WithMarshaler(marshal func(v interface{}) ([]byte, error)) BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.marshal = marshal
+	}
+}
+
+// CarriageReturnPolicy is what a BulkBody does with raw carriage returns in serialized entries.
+type CarriageReturnPolicy int
+
+const (
+	// KeepCarriageReturns writes entries as they are marshaled.
+	KeepCarriageReturns CarriageReturnPolicy = iota
+
+	// StripCarriageReturns removes every raw carriage return.
+	StripCarriageReturns
+
+	// RejectCarriageReturns fails entries holding a raw carriage return with ErrCarriageReturn,
+	// handled by the MarshalPolicy of the body.
+	RejectCarriageReturns
+)
+
+// ErrEmbeddedNewline is the error of a MarshalError for entries serialized with a raw newline,
+// which would split them in two lines of the bulk body.
+var ErrEmbeddedNewline = errors.New("Serialized entry contains a raw newline")
+
+// ErrCarriageReturn is the error of a MarshalError for entries rejected by RejectCarriageReturns.
+var ErrCarriageReturn = errors.New("Serialized entry contains a raw carriage return")
+
+// WithCarriageReturns makes the BulkBody strip or reject raw carriage returns in serialized
+// entries, which strict NDJSON parsers may take as part of the line ending. encoding/json escapes
+// them within strings and compacts values marshaling themselves, so they can only come from a
+// marshaler given to WithMarshaler, such as one passing through data written on Windows.
+func WithCarriageReturns(policy CarriageReturnPolicy) BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.carriageReturns = policy
+	}
 }
 
 // indexHeader is the first part of a bulk request, the second part is the values
 type indexHeader struct {
 	Name string `json:"_index"`
-	Type string `json:"_type"`
-	Id   string `json:"_id"`
+	Type string `json:"_type,omitempty"`
+	Id   string `json:"_id,omitempty"`
+
+	RetryOnConflict int    `json:"retry_on_conflict,omitempty"`
+	Version         int64  `json:"version,omitempty"`
+	VersionType     string `json:"version_type,omitempty"`
+	Pipeline        string `json:"pipeline,omitempty"`
+
+	// What ES returns of updated documents, true or a sourceFilter, see SourceReturner
+	Source interface{} `json:"_source,omitempty"`
 }
 
 // NewBulkBody will return a new BulkBody configured to return an error upon adding more bytes than
-// max.
-func NewBulkBody(max ByteSize) *BulkBody {
-	return &BulkBody{
+// max. A max larger than MaxBulkSize is lowered to MaxBulkSize.
+func NewBulkBody(max ByteSize, options ...BulkOption) *BulkBody {
+	if max > MaxBulkSize {
+		max = MaxBulkSize
+	}
+	bulk := &BulkBody{
 		Buffer: bytes.NewBuffer(make([]byte, 0, max)),
 		max:    max,
 	}
+	for _, option := range options {
+		option(bulk)
+	}
+	return bulk
 }
 
 // Add will write one new bulk operation to the buffer. Returns BulkBodyFull when maxed out.
 // If BulkBodyFull has been returned, the buffer should be sent and Reset() until more operations
-// can be added.
+// can be added. The header and source of the entry are built before anything is written, so an entry
+// failing for any reason leaves the body as it was.
 func (bulk *BulkBody) Add(v BulkEntry) error {
-	// Clear done bool on resets
-	if bulk.Len() == 0 && bulk.done {
+	return bulk.AddContext(context.Background(), v)
+}
+
+// AddContext is Add giving up with the error of ctx once it's done, checked before the document is
+// fetched and marshaled, so that shutting down isn't held up by building an entry of a huge
+// document that will never be sent.
+func (bulk *BulkBody) AddContext(ctx context.Context, v BulkEntry) error {
+	// Clear done bool and tracked entries on resets
+	if bulk.Len() == 0 {
 		bulk.done = false
+		bulk.last = nil
+		bulk.actions = nil
+		bulk.sequences = nil
+		bulk.carried = nil
+		bulk.sizes = EntrySizes{}
+	}
+	if bulk.acks == nil {
+		return bulk.add(ctx, v)
+	}
+	writes := bulk.writes
+	err := bulk.add(ctx, v)
+	_, unrouted := err.(*UnroutedDelete)
+	switch {
+	case err == nil && bulk.writes == writes:
+		// Nothing to wait for, the entry is done with
+		bulk.acks.Ack(sequenceOf(v))
+	case err != nil && err != BulkBodyFull && !unrouted && ctx.Err() == nil:
+		// Fails the same way again, it's dropped once the error is logged
+		bulk.acks.Ack(sequenceOf(v))
 	}
+	return err
+}
+
+// add is AddContext once the body has been cleared after a reset.
+func (bulk *BulkBody) add(ctx context.Context, v BulkEntry) error {
 	// Don't allow more additions if we are full
 	if bulk.done {
 		return BulkBodyFull
@@ -88,48 +288,153 @@ func (bulk *BulkBody) Add(v BulkEntry) error {
 	if err != nil {
 		return err
 	}
-
-	parts := make([][]byte, 0, 3)
-	if headerJson, err := json.Marshal(map[string]interface{}{action: header}); err != nil {
+	if bulk.forceAction != "" {
+		action = bulk.forceAction
+	}
+	if bulk.dataStream != nil {
+		if action, err = bulk.dataStream.header(action, &header); err != nil {
+			return err
+		}
+	}
+	if header.Id == "" && bulk.idGenerator != nil {
+		if header.Id, err = bulk.idGenerator.GenerateId(v); err != nil {
+			return err
+		}
+	}
+	bulk.applyDefaults(v, action, &header)
+	if err := bulk.returnSource(v, action, &header); err != nil {
 		return err
-	} else {
-		parts = append(parts, headerJson)
+	}
+	if bulk.typeless {
+		header.Type = ""
+	}
+	// Without an id, ES can still generate one for new documents but it can't find existing ones
+	if header.Id == "" && action != "index" && action != "create" {
+		return fmt.Errorf("An id is required for %s operations", action)
 	}
 
 	// Then is the values that should be applied
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	doc, err := v.Document()
+	if validationErr, ok := err.(*ValidationError); ok {
+		return bulk.marshalFailed(v, header, validationErr)
+	} else if err != nil {
+		return err
+	}
+	script, err := scriptOf(v, action)
 	if err != nil {
 		return err
 	}
 
-	// No need to send operations that wouldn't change anything
-	if action != "delete" && len(doc) == 0 {
+	// No need to send operations that wouldn't change anything, scripts may change the document
+	// with nothing more than their params
+	if action != "delete" && len(doc) == 0 && script == nil {
 		return nil
 	}
 
+	if bulk.dataStream != nil {
+		if doc, err = bulk.dataStream.document(doc); err != nil {
+			return err
+		}
+	}
+
+	if bulk.indexedAt != nil && action != "delete" {
+		doc = bulk.indexedAt.document(doc)
+	}
+
+	if bulk.retention != nil {
+		if routed, err := bulk.retention.route(v, action, &header, doc); err != nil {
+			if action == "update" {
+				return bulk.marshalFailed(v, header, err)
+			}
+			return err
+		} else if !routed {
+			return nil
+		}
+	}
+
+	parts := make([][]byte, 0, 3)
+	if headerJson, err := bulk.marshalHeader(action, header); err != nil {
+		return bulk.marshalFailed(v, header, err)
+	} else {
+		parts = append(parts, bulk.sanitize(headerJson))
+	}
+
 	// Updates needs to be wrapped with additional options
-	if action == "update" {
-		doc = map[string]interface{}{
-			"doc":           doc,
-			"doc_as_upsert": true,
+	if script != nil {
+		upsert := doc
+		if !upserts(v) {
+			upsert = nil
+		}
+		doc = scriptedUpdate(script, upsert)
+	} else if action == "update" {
+		update := map[string]interface{}{"doc": doc}
+		if upserts(v) {
+			update["doc_as_upsert"] = true
 		}
+		doc = update
 	}
 
 	// Deletes doesn't need to provide values
+	var source []byte
 	if action != "delete" {
-		valuesJson, err := json.Marshal(doc)
-		if err != nil {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-		parts = append(parts, valuesJson)
+		marshal := json.Marshal
+		if bulk.marshal != nil {
+			marshal = bulk.marshal
+		}
+		valuesJson, err := marshal(doc)
+		if err == nil {
+			valuesJson, err = bulk.lineEndings(valuesJson)
+		}
+		if err != nil {
+			return bulk.marshalFailed(v, header, err)
+		}
+		source = bulk.sanitize(valuesJson)
+		parts = append(parts, source)
 	}
 
 	// Header, values (in case they exist) and final delimeter is separated by newlines
 	parts = append(parts, nil)
 	entry := bytes.Join(parts, []byte{newline})
-	_, err = (*bulk).Write(entry)
+	if bulk.oversizeCeiling > 0 && ByteSize(len(entry)) > bulk.max {
+		err = bulk.writeOversized(v, action, &header, entry)
+	} else {
+		err = bulk.write(v, action, &header, entry)
+	}
+	if err != nil {
+		return err
+	}
+	bulk.observe(action, &header, source)
+	return nil
+}
 
-	return err
+// sanitize replaces invalid UTF-8 in serialized json if configured to. Such bytes can only be found
+// within strings of valid json.
+func (bulk *BulkBody) sanitize(b []byte) []byte {
+	if !bulk.sanitizeUTF8 || utf8.Valid(b) {
+		return b
+	}
+	return bytes.ToValidUTF8(b, []byte(string(utf8.RuneError)))
+}
+
+// lineEndings rejects serialized json with raw newlines and applies the CarriageReturnPolicy of the
+// body.
+func (bulk *BulkBody) lineEndings(b []byte) ([]byte, error) {
+	if bytes.IndexByte(b, newline) != -1 {
+		return nil, ErrEmbeddedNewline
+	}
+	if bulk.carriageReturns == KeepCarriageReturns || bytes.IndexByte(b, '\r') == -1 {
+		return b, nil
+	}
+	if bulk.carriageReturns == RejectCarriageReturns {
+		return nil, ErrCarriageReturn
+	}
+	return bytes.Replace(b, []byte{'\r'}, nil, -1), nil
 }
 
 // Done will append the final byte to mark the end of a bulk body. Should be called after all
@@ -143,3 +448,168 @@ func (bulk *BulkBody) Done() error {
 	}
 	return nil
 }
+
+// WriteTo finalizes the body by calling Done, then writes all of it to w so that the body is sent
+// with its final newline whatever the caller does, such as io.Copy. Like for bytes.Buffer, what has
+// been written is consumed, leaving the body empty to add to again as after a Reset.
+func (bulk *BulkBody) WriteTo(w io.Writer) (int64, error) {
+	if err := bulk.Done(); err != nil {
+		return 0, err
+	}
+	return bulk.Buffer.WriteTo(w)
+}
+
+// ParsedOp is one operation read back from a bulk body by ParseBulkBody. It implements BulkEntry
+// so that it can be added to a new BulkBody and sent again.
+type ParsedOp struct {
+	action string
+	header indexHeader
+	doc    map[string]interface{}
+}
+
+func (p *ParsedOp) Action() (string, error) {
+	return p.action, nil
+}
+
+func (p *ParsedOp) Index() (string, error) {
+	return p.header.Name, nil
+}
+
+func (p *ParsedOp) Type() (string, error) {
+	return p.header.Type, nil
+}
+
+func (p *ParsedOp) Id() (string, error) {
+	return p.header.Id, nil
+}
+
+func (p *ParsedOp) RetryOnConflict() int {
+	return p.header.RetryOnConflict
+}
+
+func (p *ParsedOp) Version() int64 {
+	return p.header.Version
+}
+
+func (p *ParsedOp) VersionType() string {
+	return p.header.VersionType
+}
+
+func (p *ParsedOp) Pipeline() string {
+	return p.header.Pipeline
+}
+
+// Document returns the values of the operation, updates are unwrapped from their options to be
+// the same as what was once added.
+func (p *ParsedOp) Document() (map[string]interface{}, error) {
+	return p.doc, nil
+}
+
+// ParseBulkBody reads back the operations of a bulk body, the reverse of adding them to a
+// BulkBody. Each action line is paired with the source line following it, except for deletes that
+// has none. Empty lines, such as the final delimiter, are skipped.
+func ParseBulkBody(r io.Reader) ([]ParsedOp, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := splitEntries(b)
+	if err != nil {
+		return nil, err
+	}
+
+	ops := make([]ParsedOp, 0, len(entries))
+	for _, entry := range entries {
+		op := ParsedOp{action: entry.Action}
+		var header map[string]indexHeader
+		if err := json.Unmarshal(entry.Header, &header); err != nil {
+			return ops, fmt.Errorf("Invalid header on line %d: %s", entry.line, err)
+		}
+		op.header = header[op.action]
+
+		if entry.Source != nil {
+			decoder := json.NewDecoder(bytes.NewReader(entry.Source))
+			decoder.UseNumber()
+			if err := decoder.Decode(&op.doc); err != nil {
+				return ops, fmt.Errorf("Invalid source on line %d: %s", entry.line+1, err)
+			}
+			// Updates was wrapped with options when added
+			if op.action == "update" {
+				if doc, ok := op.doc["doc"].(map[string]interface{}); ok {
+					op.doc = doc
+				}
+			}
+		} else {
+			op.doc = make(map[string]interface{})
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// RawEntry is the header line and source line of one entry in a bulk body, without their
+// delimiters. Source is nil for deletes as they have none.
+type RawEntry struct {
+	Action string
+	Header []byte
+	Source []byte
+
+	// Line number of the header
+	line int
+}
+
+// Entries splits the body into the header and source lines of each entry added. The body is left
+// as it is, the returned lines points into the buffer and are only valid until it's modified.
+func (bulk *BulkBody) Entries() ([]RawEntry, error) {
+	return splitEntries(bulk.Bytes())
+}
+
+// splitEntries pairs each header line in b with the source line following it unless it's a delete.
+// Empty lines, such as the final delimiter, are skipped.
+func splitEntries(b []byte) ([]RawEntry, error) {
+	var entries []RawEntry
+	lineNum := 0
+
+	// nextLine returns the next line without its delimiter, nil when there are no more lines.
+	nextLine := func() []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		var line []byte
+		if i := bytes.IndexByte(b, newline); i >= 0 {
+			line, b = b[:i], b[i+1:]
+		} else {
+			line, b = b, nil
+		}
+		lineNum++
+		return bytes.TrimRight(line, "\r")
+	}
+
+	for len(b) > 0 {
+		line := nextLine()
+		if len(line) == 0 {
+			continue
+		}
+
+		var header map[string]json.RawMessage
+		if err := json.Unmarshal(line, &header); err != nil {
+			return entries, fmt.Errorf("Invalid header on line %d: %s", lineNum, err)
+		}
+		if len(header) != 1 {
+			return entries, fmt.Errorf("Expected one action in header on line %d, got %d", lineNum, len(header))
+		}
+		entry := RawEntry{Header: line, line: lineNum}
+		for action := range header {
+			entry.Action = action
+		}
+
+		if entry.Action != "delete" {
+			entry.Source = nextLine()
+			if len(entry.Source) == 0 {
+				return entries, fmt.Errorf("No source found for %s header on line %d", entry.Action, entry.line)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -2,8 +2,15 @@ package elasticsearch
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
+	"math"
+	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
 type rawEntry struct {
@@ -138,3 +145,366 @@ func TestBulkDone(t *testing.T) {
 		t.Fatal("Expected done flag to be reset on new addition")
 	}
 }
+
+func TestParseBulkBody(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	entries := []rawEntry{
+		{"index", "testing", "user", "1", map[string]interface{}{"alias": "Johnny"}},
+		{"delete", "testing", "user", "2", nil},
+		{"update", "testing", "user", "3", map[string]interface{}{"age": 9007199254740993}},
+	}
+	for i := range entries {
+		if err := bulk.Add(&entries[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bulk.Done()
+
+	ops, err := ParseBulkBody(bytes.NewReader(bulk.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != len(entries) {
+		t.Fatal("Expected", len(entries), "operations, got", len(ops))
+	}
+	for i, op := range ops {
+		if a, _ := op.Action(); a != entries[i].action {
+			t.Error("Unexpected action", a)
+		}
+		if id, _ := op.Id(); id != entries[i].id {
+			t.Error("Unexpected id", id)
+		}
+	}
+	if doc, _ := ops[1].Document(); len(doc) != 0 {
+		t.Error("Expected delete to have no values, got", doc)
+	}
+	if doc, _ := ops[2].Document(); doc["age"].(json.Number).String() != "9007199254740993" {
+		t.Error("Expected update to be unwrapped with exact numbers, got", doc)
+	}
+
+	// Added again it should produce the very same body
+	resend := NewBulkBody(MB)
+	for i := range ops {
+		if err := resend.Add(&ops[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resend.Done()
+	if !bytes.Equal(resend.Bytes(), bulk.Bytes()) {
+		t.Errorf("\n'%s'\nNot equal to:\n'%s'", resend.String(), bulk.String())
+	}
+}
+
+func TestParseBulkBodyMissingSource(t *testing.T) {
+	body := []byte(`{"delete":{"_index":"testing","_type":"user","_id":"1"}}
+{"index":{"_index":"testing","_type":"user","_id":"2"}}
+`)
+	if _, err := ParseBulkBody(bytes.NewReader(body)); err == nil {
+		t.Fatal("Expected an error on header without source")
+	} else if !strings.Contains(err.Error(), "line 2") {
+		t.Error("Expected error to point out the header line, got", err)
+	}
+}
+
+func TestBulkBodyMaxCeiling(t *testing.T) {
+	if bulk := NewBulkBody(200 * MB); bulk.max != MaxBulkSize {
+		t.Error("Expected max to be lowered to", MaxBulkSize, "got", bulk.max)
+	}
+}
+
+func TestBulkBodyEntries(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	entries := []rawEntry{
+		{"index", "testing", "user", "1", map[string]interface{}{"alias": "Johnny"}},
+		{"delete", "testing", "user", "2", nil},
+		{"update", "testing", "user", "3", map[string]interface{}{"alias": "New Johnny"}},
+	}
+	for i := range entries {
+		if err := bulk.Add(&entries[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bulk.Done()
+	size := bulk.Len()
+
+	raw, err := bulk.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 3 {
+		t.Fatal("Expected 3 entries, got", len(raw))
+	}
+	valid := []struct{ header, source string }{
+		{`{"index":{"_index":"testing","_type":"user","_id":"1"}}`, `{"alias":"Johnny"}`},
+		{`{"delete":{"_index":"testing","_type":"user","_id":"2"}}`, ``},
+		{`{"update":{"_index":"testing","_type":"user","_id":"3"}}`, `{"doc":{"alias":"New Johnny"},"doc_as_upsert":true}`},
+	}
+	for i, v := range valid {
+		if string(raw[i].Header) != v.header {
+			t.Errorf("Unexpected header '%s'", raw[i].Header)
+		}
+		if string(raw[i].Source) != v.source {
+			t.Errorf("Unexpected source '%s'", raw[i].Source)
+		}
+		if raw[i].Action != entries[i].action {
+			t.Error("Unexpected action", raw[i].Action)
+		}
+	}
+	if raw[1].Source != nil {
+		t.Error("Expected delete to have no source line")
+	}
+	if bulk.Len() != size {
+		t.Error("Expected body to be left untouched")
+	}
+}
+
+// retryingEntry asks ES to retry its updates on conflicts.
+type retryingEntry struct {
+	rawEntry
+	retries int
+}
+
+func (r *retryingEntry) RetryOnConflict() int {
+	return r.retries
+}
+
+func TestBulkBodyRetryOnConflict(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	entry := &retryingEntry{rawEntry{"update", "testing", "user", "123", map[string]interface{}{"visits": 1}}, 3}
+	if err := bulk.Add(entry); err != nil {
+		t.Fatal(err)
+	}
+	valid := []byte(`{"update":{"_index":"testing","_type":"user","_id":"123","retry_on_conflict":3}}`)
+	if !bytes.HasPrefix(bulk.Bytes(), valid) {
+		t.Errorf("\n'%s'\nExpected to start with:\n'%s'", bulk.String(), valid)
+	}
+
+	// Not implementing the interface leaves the header as it was
+	bulk.Reset()
+	bulk.Add(&entry.rawEntry)
+	if bytes.Contains(bulk.Bytes(), []byte("retry_on_conflict")) {
+		t.Error("Expected no retry_on_conflict, got", bulk.String())
+	}
+
+	// Only updates can be retried
+	bulk.Reset()
+	entry.action = "index"
+	bulk.Add(entry)
+	if bytes.Contains(bulk.Bytes(), []byte("retry_on_conflict")) {
+		t.Error("Expected no retry_on_conflict on index, got", bulk.String())
+	}
+}
+
+func TestBulkBodyUTF8Sanitize(t *testing.T) {
+	invalid := &rawEntry{"index", "testing", "user", "123", map[string]interface{}{
+		"name": json.RawMessage("\"caf\xe9\""),
+	}}
+
+	bulk := NewBulkBody(MB)
+	if err := bulk.Add(invalid); err != nil {
+		t.Fatal(err)
+	}
+	if utf8.Valid(bulk.Bytes()) {
+		t.Fatal("Expected invalid UTF-8 to pass through without sanitizing")
+	}
+
+	bulk = NewBulkBody(MB, WithUTF8Sanitize())
+	if err := bulk.Add(invalid); err != nil {
+		t.Fatal(err)
+	}
+	if !utf8.Valid(bulk.Bytes()) {
+		t.Error("Expected body to be valid UTF-8:", bulk.Bytes())
+	}
+	if !bytes.Contains(bulk.Bytes(), []byte("{\"name\":\"caf\uFFFD\"}")) {
+		t.Error("Expected invalid byte to be replaced, got", bulk.String())
+	}
+	if ops, err := ParseBulkBody(bytes.NewReader(bulk.Bytes())); err != nil || len(ops) != 1 {
+		t.Error("Expected sanitized body to parse, got", ops, err)
+	}
+}
+
+func TestBulkBodyForceAction(t *testing.T) {
+	entry := &rawEntry{"index", "testing", "user", "123", map[string]interface{}{"alias": "Johnny"}}
+	for action, valid := range map[string]string{
+		"create": `{"create":{"_index":"testing","_type":"user","_id":"123"}}
+{"alias":"Johnny"}
+`,
+		"update": `{"update":{"_index":"testing","_type":"user","_id":"123"}}
+{"doc":{"alias":"Johnny"},"doc_as_upsert":true}
+`,
+		"delete": `{"delete":{"_index":"testing","_type":"user","_id":"123"}}
+`,
+	} {
+		bulk := NewBulkBody(MB, ForceAction(action))
+		if err := bulk.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+		if bulk.String() != valid {
+			t.Errorf("\n'%s'\nExpected:\n'%s'", bulk.String(), valid)
+		}
+	}
+}
+
+func TestBulkBodyWithMarshaler(t *testing.T) {
+	var marshaled []interface{}
+	marshal := func(v interface{}) ([]byte, error) {
+		marshaled = append(marshaled, v)
+		return []byte(`{"foo":"marshaled"}`), nil
+	}
+	bulk := NewBulkBody(MB, WithMarshaler(marshal))
+	if err := bulk.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"index":{"_index":"testing","_type":"user","_id":"1"}}` + "\n" + `{"foo":"marshaled"}` + "\n"
+	if s := bulk.String(); s != expected {
+		t.Error("Expected document to be marshaled by the marshaler, got", s)
+	}
+	if len(marshaled) != 1 {
+		t.Error("Expected only the document to be marshaled by the marshaler, got", marshaled)
+	}
+}
+
+func TestBulkBodyCarriageReturns(t *testing.T) {
+	// Passes a document through with the line ending it was written with on Windows
+	marshal := func(v interface{}) ([]byte, error) {
+		return []byte("{\"text\":\"line\"}\r"), nil
+	}
+	entry := &rawEntry{"index", "testing", "user", "1", map[string]interface{}{"text": "line\r\n"}}
+
+	bulk := NewBulkBody(MB)
+	bulk.Add(entry)
+	if s := bulk.String(); strings.Contains(s, "\r") {
+		t.Error("Expected encoding/json to escape carriage returns, got", s)
+	}
+
+	bulk = NewBulkBody(MB, WithMarshaler(marshal), WithCarriageReturns(StripCarriageReturns))
+	if err := bulk.Add(entry); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"index":{"_index":"testing","_type":"user","_id":"1"}}` + "\n" + `{"text":"line"}` + "\n"
+	if s := bulk.String(); s != expected {
+		t.Errorf("Expected carriage returns to be stripped, got %q", s)
+	}
+
+	bulk = NewBulkBody(MB, WithMarshaler(marshal), WithCarriageReturns(RejectCarriageReturns), WithMarshalPolicy(FailFast))
+	err := bulk.Add(entry)
+	if marshalErr, ok := err.(*MarshalError); !ok || marshalErr.Err != ErrCarriageReturn {
+		t.Error("Expected ErrCarriageReturn, got", err)
+	}
+	if bulk.Len() != 0 {
+		t.Error("Expected rejected entry to be left out, got", bulk.String())
+	}
+}
+
+func TestBulkBodyEmbeddedNewline(t *testing.T) {
+	marshal := func(v interface{}) ([]byte, error) {
+		return []byte("{\"text\":\r\n\"line\"}"), nil
+	}
+	bulk := NewBulkBody(MB, WithMarshaler(marshal), WithCarriageReturns(StripCarriageReturns), WithMarshalPolicy(FailFast))
+	err := bulk.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"text": "line\r\n"}})
+	if marshalErr, ok := err.(*MarshalError); !ok || marshalErr.Err != ErrEmbeddedNewline {
+		t.Error("Expected ErrEmbeddedNewline, got", err)
+	}
+	if bulk.Len() != 0 {
+		t.Error("Expected entry to be left out, got", bulk.String())
+	}
+}
+
+func TestBulkBodyWriteTo(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}})
+	// Done already called by the caller must not add another newline
+	bulk.Done()
+	var w bytes.Buffer
+	n, err := io.Copy(&w, bulk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"index":{"_index":"testing","_type":"user","_id":"1"}}` + "\n" + `{"foo":"bar"}` + "\n\n"
+	if s := w.String(); s != expected || n != int64(len(expected)) {
+		t.Errorf("Expected %d bytes %q, got %d %q", len(expected), expected, n, s)
+	}
+
+	// Finalized by WriteTo itself and usable again afterwards
+	bulk.Add(&rawEntry{"index", "testing", "user", "2", map[string]interface{}{"foo": "bar"}})
+	w.Reset()
+	if _, err := bulk.WriteTo(&w); err != nil {
+		t.Fatal(err)
+	}
+	if s := w.String(); !strings.HasSuffix(s, "}\n\n") || strings.HasSuffix(s, "\n\n\n") {
+		t.Errorf("Expected a single final newline, got %q", s)
+	}
+}
+
+// failingEntry can't produce its document.
+type failingEntry struct {
+	rawEntry
+}
+
+func (e *failingEntry) Document() (map[string]interface{}, error) {
+	return nil, errors.New("Document unavailable")
+}
+
+func TestBulkBodyAddAtomic(t *testing.T) {
+	bulk := NewBulkBody(MB, WithMarshalPolicy(FailFast))
+	if err := bulk.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}}); err != nil {
+		t.Fatal(err)
+	}
+	before := bulk.String()
+
+	if err := bulk.Add(&failingEntry{rawEntry{"index", "testing", "user", "2", nil}}); err == nil {
+		t.Fatal("Expected the error of Document")
+	}
+	if err := bulk.Add(&rawEntry{"index", "testing", "user", "3", map[string]interface{}{"foo": math.Inf(1)}}); err == nil {
+		t.Fatal("Expected document that can't be marshaled to fail")
+	}
+	if s := bulk.String(); s != before {
+		t.Error("Expected failed entries to leave the body as it was, got", s)
+	}
+
+	if err := bulk.Add(&rawEntry{"delete", "testing", "user", "4", nil}); err != nil {
+		t.Fatal(err)
+	}
+	bulk.Done()
+	ops, err := ParseBulkBody(bulk)
+	if err != nil {
+		t.Fatal("Expected a valid body, got", err)
+	}
+	if len(ops) != 2 || ops[0].header.Id != "1" || ops[1].header.Id != "4" {
+		t.Error("Expected only the entries that succeeded, got", ops)
+	}
+}
+
+// cancelingEntry cancels the context of Add once its document has been fetched.
+type cancelingEntry struct {
+	rawEntry
+	cancel context.CancelFunc
+}
+
+func (e *cancelingEntry) Document() (map[string]interface{}, error) {
+	e.cancel()
+	return e.values, nil
+}
+
+func TestBulkBodyAddContext(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := bulk.AddContext(ctx, &rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}}); err != context.Canceled {
+		t.Error("Expected context.Canceled, got", err)
+	}
+
+	// Canceled while building the entry, before marshaling the document
+	ctx, cancel = context.WithCancel(context.Background())
+	entry := &cancelingEntry{rawEntry{"index", "testing", "user", "2", map[string]interface{}{"foo": "bar"}}, cancel}
+	if err := bulk.AddContext(ctx, entry); err != context.Canceled {
+		t.Error("Expected context.Canceled, got", err)
+	}
+	if bulk.Len() != 0 {
+		t.Error("Expected nothing to be added, got", bulk.String())
+	}
+
+	if err := bulk.AddContext(context.Background(), &rawEntry{"index", "testing", "user", "3", map[string]interface{}{"foo": "bar"}}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,48 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+)
+
+// DisableRefresh turns off the periodic refresh of index, which speeds up bulk indexing a lot
+// during a backfill at the cost of new documents not being searchable until it's restored. index
+// may also be an alias or a pattern, every index it matches is changed.
+//
+// Always bracket the backfill with RestoreRefresh, also when it fails:
+//
+//	if err := client.DisableRefresh(ctx, index); err != nil {
+//		return err
+//	}
+//	defer client.RestoreRefresh(context.Background(), index, "")
+func (c *Client) DisableRefresh(ctx context.Context, index string) error {
+	return c.setRefreshInterval(ctx, index, "-1")
+}
+
+// RestoreRefresh sets the refresh interval of index back to interval, such as 30s, or to the
+// default of the cluster if empty.
+func (c *Client) RestoreRefresh(ctx context.Context, index, interval string) error {
+	if interval == "" {
+		return c.setRefreshInterval(ctx, index, nil)
+	}
+	return c.setRefreshInterval(ctx, index, interval)
+}
+
+// setRefreshInterval updates the refresh_interval setting of index to interval, nil resetting it.
+func (c *Client) setRefreshInterval(ctx context.Context, index string, interval interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"index": map[string]interface{}{"refresh_interval": interval},
+	})
+	if err != nil {
+		return err
+	}
+	resp, respBody, err := c.do(ctx, "PUT", "/"+url.PathEscape(index)+"/_settings", "application/json", body)
+	if err != nil {
+		return err
+	}
+	if code := resp.StatusCode; code != 200 {
+		return parseError(code, respBody)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package elasticsearch
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRefresh(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+		if r.URL.Path == "/missing/_settings" {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":{"type":"index_not_found_exception","reason":"no such index [missing]"},"status":404}`))
+			return
+		}
+		w.Write([]byte(`{"acknowledged":true}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1)
+	if err := client.DisableRefresh(context.Background(), "users"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RestoreRefresh(context.Background(), "users", "30s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RestoreRefresh(context.Background(), "users", ""); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`PUT /users/_settings {"index":{"refresh_interval":"-1"}}`,
+		`PUT /users/_settings {"index":{"refresh_interval":"30s"}}`,
+		`PUT /users/_settings {"index":{"refresh_interval":null}}`,
+	}
+	if len(requests) != len(expected) {
+		t.Fatal("Unexpected requests", requests)
+	}
+	for i := range expected {
+		if requests[i] != expected[i] {
+			t.Error("Expected", expected[i], "got", requests[i])
+		}
+	}
+
+	if err := client.DisableRefresh(context.Background(), "missing"); err == nil {
+		t.Error("Expected an error for a missing index")
+	}
+}
@@ -0,0 +1,141 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/duego/cryriver/redact"
+	"io"
+	"time"
+)
+
+// ReplayResult is the outcome of sending one entry again by Replay.
+type ReplayResult struct {
+	Entry BulkEntry
+
+	// Why the entry still fails, nil if it was accepted. An *ESError when rejected by ES, such as a
+	// mapper_parsing_exception still not fixed.
+	Err error
+}
+
+// Replay sends entries again, such as those once saved as dead letters, packed into as few bulk
+// requests as they fit in. Requests are retried with backoff as usual but never spooled, so that
+// the outcome of every entry can be told, which is returned in the order of entries. Entries that
+// can't be added to a bulk body fail with the error of Add, and entries with nothing to change
+// succeed without being sent.
+//
+// An error is only returned when a whole request fails, results are then returned for the entries
+// before the first one of that request.
+func Replay(ctx context.Context, c *Client, entries []BulkEntry, options ...BulkOption) ([]ReplayResult, error) {
+	return replay(ctx, c, entries, DefaultBulkSize, options)
+}
+
+// ReplayBody sends the entries of the bulk body read from r again, such as one dumped from failed
+// requests once the reason they failed has been fixed, in requests of up to max bytes like a
+// BulkBody of max. Entries are sent like by Replay, the result tells what became of them with the
+// entries still rejected by ES in Failures. Entries that can't be added to a bulk body are also
+// failures, with the error of Add as the reason and no status.
+func (c *Client) ReplayBody(ctx context.Context, r io.Reader, max ByteSize) (*BulkResult, error) {
+	ops, err := ParseBulkBody(r)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]BulkEntry, len(ops))
+	for i := range ops {
+		entries[i] = &ops[i]
+	}
+	start := time.Now()
+	results, err := replay(ctx, c, entries, max, nil)
+	result := &BulkResult{Took: time.Since(start)}
+	for _, replayed := range results {
+		action, _ := replayed.Entry.Action()
+		if replayed.Err == nil {
+			switch action {
+			case "index", "create":
+				result.Indexed++
+			case "update":
+				result.Updated++
+			case "delete":
+				result.Deleted++
+			}
+			continue
+		}
+		esErr, ok := replayed.Err.(*ESError)
+		if !ok {
+			esErr = &ESError{Reason: replayed.Err.Error()}
+		}
+		index, _ := replayed.Entry.Index()
+		id, _ := replayed.Entry.Id()
+		result.Failed++
+		result.Failures = append(result.Failures, ItemFailure{Action: action, Index: index, Id: id, Err: esErr})
+	}
+	return result, err
+}
+
+// replay is Replay in bulk bodies of max.
+func replay(ctx context.Context, c *Client, entries []BulkEntry, max ByteSize, options []BulkOption) ([]ReplayResult, error) {
+	options = append(options, WithMarshalPolicy(FailFast))
+	if c.typeless() {
+		options = append(options, Typeless())
+	}
+	bulk := NewBulkBody(max, options...)
+	results := make([]ReplayResult, 0, len(entries))
+	// Results of the entries in the current body
+	var pending []int
+
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		bulk.Done()
+		sent := bulk.Bytes()
+		body, err := c.bulkRequest(ctx, bulk)
+		if err != nil {
+			return err
+		}
+		var resp struct {
+			Items []map[string]json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return err
+		}
+		if len(resp.Items) != len(pending) {
+			return fmt.Errorf("Expected %d items in bulk response, got %d", len(pending), len(resp.Items))
+		}
+		for i, item := range resp.Items {
+			for _, raw := range item {
+				var result bulkItem
+				if err := json.Unmarshal(raw, &result); err != nil {
+					return err
+				}
+				if len(result.Error) > 0 {
+					esErr := parseError(result.Status, raw)
+					esErr.Reason = redact.Text(esErr.Reason, redact.ValuesJSON(sent))
+					results[pending[i]].Err = esErr
+				}
+			}
+		}
+		pending = pending[:0]
+		return nil
+	}
+
+	for _, entry := range entries {
+		before := bulk.Len()
+		err := bulk.Add(entry)
+		if err == BulkBodyFull {
+			if err := flush(); err != nil {
+				return results[:pending[0]], err
+			}
+			before = bulk.Len()
+			err = bulk.Add(entry)
+		}
+		results = append(results, ReplayResult{Entry: entry, Err: err})
+		if err == nil && bulk.Len() > before {
+			pending = append(pending, len(results)-1)
+		}
+	}
+	if err := flush(); err != nil {
+		return results[:pending[0]], err
+	}
+	return results, nil
+}
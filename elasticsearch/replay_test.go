@@ -0,0 +1,141 @@
+package elasticsearch
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// itemServer answers bulk requests with an item for every entry, failing those with ids in fail.
+func itemServer(t *testing.T, requests *int, fail ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		ops, err := ParseBulkBody(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var items []string
+		for _, op := range ops {
+			item := `{"index":{"_index":"testing","_id":"` + op.header.Id + `","status":201}}`
+			for _, id := range fail {
+				if op.header.Id == id {
+					item = `{"index":{"_index":"testing","_id":"` + id + `","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse [age]"}}}`
+				}
+			}
+			items = append(items, item)
+		}
+		w.Write([]byte(`{"took":1,"errors":true,"items":[` + strings.Join(items, ",") + `]}`))
+	}))
+}
+
+func TestReplay(t *testing.T) {
+	var requests int
+	server := itemServer(t, &requests, "2")
+	defer server.Close()
+
+	entries := []BulkEntry{
+		&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"age": 1}},
+		&rawEntry{"index", "testing", "user", "2", map[string]interface{}{"age": "old"}},
+		&rawEntry{"index", "testing", "user", "3", map[string]interface{}{"age": math.NaN()}},
+		&rawEntry{"update", "testing", "user", "4", map[string]interface{}{}},
+		&rawEntry{"index", "testing", "user", "5", map[string]interface{}{"age": 5}},
+	}
+	results, err := Replay(context.Background(), NewClient(server.URL, 1), entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(entries) {
+		t.Fatal("Expected a result for every entry, got", len(results))
+	}
+	if requests != 1 {
+		t.Error("Expected entries to be sent in a single request, got", requests)
+	}
+	for i, failed := range []bool{false, true, true, false, false} {
+		if results[i].Entry != entries[i] {
+			t.Error("Expected results in the order of entries")
+		}
+		if (results[i].Err != nil) != failed {
+			t.Error("Expected entry", i+1, "to fail:", failed, "got", results[i].Err)
+		}
+	}
+	if esErr, ok := results[1].Err.(*ESError); !ok || esErr.Type != "mapper_parsing_exception" || esErr.Status != 400 {
+		t.Error("Expected the error of the item, got", results[1].Err)
+	}
+	if _, ok := results[2].Err.(*MarshalError); !ok {
+		t.Error("Expected entry that can't be marshaled to fail, got", results[2].Err)
+	}
+}
+
+func TestReplaySeveralRequests(t *testing.T) {
+	var requests int
+	server := itemServer(t, &requests, "2")
+	defer server.Close()
+
+	big := map[string]interface{}{"data": string(make([]byte, DefaultBulkSize))}
+	entries := []BulkEntry{
+		&rawEntry{"index", "testing", "user", "1", big},
+		&rawEntry{"index", "testing", "user", "2", map[string]interface{}{"foo": "bar"}},
+	}
+	results, err := Replay(context.Background(), NewClient(server.URL, 1), entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Error("Expected entries not fitting to be sent in another request, got", requests)
+	}
+	if results[0].Err != nil || results[1].Err == nil {
+		t.Error("Expected items of each request to be matched with their entries, got", results)
+	}
+}
+
+func TestReplayRequestFails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error":{"type":"illegal_argument_exception","reason":"bad"},"status":400}`))
+	}))
+	defer server.Close()
+
+	entries := []BulkEntry{
+		&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"age": math.NaN()}},
+		&rawEntry{"index", "testing", "user", "2", map[string]interface{}{"age": 2}},
+	}
+	results, err := Replay(context.Background(), NewClient(server.URL, 1), entries)
+	if _, ok := err.(*ESError); !ok {
+		t.Fatal("Expected the error of the request, got", err)
+	}
+	if len(results) != 1 || results[0].Err == nil {
+		t.Error("Expected results only for the entries before the failed request, got", results)
+	}
+}
+
+func TestClientReplayBody(t *testing.T) {
+	var requests int
+	server := itemServer(t, &requests, "3")
+	defer server.Close()
+
+	// Each entry is about half of max, two of them fill up a request
+	value := strings.Repeat("x", 500)
+	bulk := NewBulkBody(MB)
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		bulk.Add(&rawEntry{"index", "testing", "user", id, map[string]interface{}{"v": value}})
+	}
+	bulk.Add(&rawEntry{"delete", "testing", "user", "6", nil})
+
+	result, err := NewClient(server.URL, 1).ReplayBody(context.Background(), bulk, KB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 {
+		t.Error("Expected the body to be sent in requests of up to max, got", requests)
+	}
+	if result.Indexed != 4 || result.Deleted != 1 || result.Failed != 1 {
+		t.Error("Unexpected counts", result)
+	}
+	if len(result.Failures) != 1 || result.Failures[0].Id != "3" || result.Failures[0].Err.Type != "mapper_parsing_exception" {
+		t.Error("Expected the rejected entry to be told, got", result.Failures)
+	}
+}
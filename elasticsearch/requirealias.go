@@ -0,0 +1,58 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// RequireAlias makes ES reject the entries of the BulkBody whose index isn't an alias, rather than
+// creating an index of that name, as a safety rail for clusters only written to through aliases.
+// It's the require_alias parameter of bulk requests, which ES has since 7.10 and older versions
+// reject the whole request for. Rejected entries are logged with the index, see
+// ItemFailure.AliasRequired to tell them from the result of Client.Bulk. Bodies replayed from a
+// Spool are sent without it, the spool only keeps what was sent.
+func RequireAlias() BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.requireAlias = true
+	}
+}
+
+// path returns where the body is sent to.
+func (bulk *BulkBody) path() string {
+	if bulk.requireAlias {
+		return "/_bulk?require_alias=true"
+	}
+	return "/_bulk"
+}
+
+// aliasRequired tells if esErr is ES refusing to write to an index that isn't an alias, as asked
+// by require_alias.
+func aliasRequired(esErr *ESError) bool {
+	return esErr.Type == "index_not_found_exception" && strings.Contains(esErr.Reason, "require_alias")
+}
+
+// logMissingAliases logs each index that items in respBody, the response to a bulk request, were
+// rejected for by require_alias.
+func logMissingAliases(respBody []byte) {
+	var resp struct {
+		Errors bool                  `json:"errors"`
+		Items  []map[string]bulkItem `json:"items"`
+	}
+	if json.Unmarshal(respBody, &resp) != nil || !resp.Errors {
+		return
+	}
+	logged := make(map[string]bool)
+	for _, item := range resp.Items {
+		for _, outcome := range item {
+			if len(outcome.Error) == 0 || logged[outcome.Index] {
+				continue
+			}
+			errBody, _ := json.Marshal(map[string]json.RawMessage{"error": outcome.Error})
+			if aliasRequired(parseError(outcome.Status, errBody)) {
+				log.Println("Refusing to write to", outcome.Index, "which is not an alias, is the index name right?")
+				logged[outcome.Index] = true
+			}
+		}
+	}
+}
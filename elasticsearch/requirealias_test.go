@@ -0,0 +1,50 @@
+package elasticsearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAlias(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte(`{"took":1,"errors":true,"items":[
+			{"index":{"_index":"users","_id":"1","status":201}},
+			{"index":{"_index":"raw","_id":"2","status":404,"error":{"type":"index_not_found_exception","reason":"no such index [raw] and [require_alias] request flag is [true] and [raw] is not an alias","index":"raw"}}}]}`))
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, 1)
+
+	bulk := NewBulkBody(MB, RequireAlias())
+	bulk.Add(&rawEntry{"index", "users", "", "1", map[string]interface{}{"foo": "bar"}})
+	bulk.Add(&rawEntry{"index", "raw", "", "2", map[string]interface{}{"foo": "bar"}})
+	result, err := client.Bulk(context.Background(), bulk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query != "require_alias=true" {
+		t.Error("Expected require_alias in the query, got", query)
+	}
+	if len(result.Failures) != 1 || !result.Failures[0].AliasRequired() || result.Failures[0].Index != "raw" {
+		t.Error("Expected the write to the raw index to fail for not being an alias, got", result.Failures)
+	}
+
+	bulk = NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "users", "", "1", map[string]interface{}{"foo": "bar"}})
+	if err := client.BulkSend(bulk); err != nil {
+		t.Fatal(err)
+	}
+	if query != "" {
+		t.Error("Expected no query by default, got", query)
+	}
+}
+
+func TestAliasRequired(t *testing.T) {
+	missing := parseError(404, []byte(`{"error":{"type":"index_not_found_exception","reason":"no such index [logs]"},"status":404}`))
+	if aliasRequired(missing) {
+		t.Error("Expected a missing index without require_alias not to be taken for it, got", missing)
+	}
+}
@@ -0,0 +1,149 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/duego/cryriver/redact"
+	"time"
+)
+
+// BulkResult is what became of a bulk request sent by Client.Bulk.
+type BulkResult struct {
+	// Time from sending the request until the response was read, retries included
+	Took time.Duration
+
+	// Milliseconds ES reports having spent on the request, and on running it through ingest
+	// pipelines if any
+	ESTook     int
+	IngestTook int
+
+	// Items that succeeded by action, creates are counted as indexed
+	Indexed int
+	Updated int
+	Deleted int
+
+	// Items rejected by ES
+	Failed int
+
+	// Why each of the items rejected by ES was, in the order of the body
+	Failures []ItemFailure
+
+	// Documents returned by ES for updated items of SourceReturner entries, in the order of the body
+	Sources []ItemSource
+}
+
+// ItemFailure is an item of a bulk request rejected by ES.
+type ItemFailure struct {
+	Action string
+	Index  string
+	Id     string
+	Err    *ESError
+
+	// PipelineFailure is set when the item failed in an ingest pipeline, such as a script or
+	// enrichment processor, rather than when being indexed like for mapping errors
+	PipelineFailure bool
+}
+
+// Retryable tells if the item may succeed if sent again, as when ES was too busy to take it.
+// Pipeline failures are never retried, they fail again the same way for the same document.
+func (f ItemFailure) Retryable() bool {
+	return !f.PipelineFailure && retryable(f.Err.Status)
+}
+
+// AliasRequired tells if the item was rejected for an index that isn't an alias, by a body of
+// RequireAlias.
+func (f ItemFailure) AliasRequired() bool {
+	return aliasRequired(f.Err)
+}
+
+// bulkItem is the result of one entry in the response to a bulk request.
+type bulkItem struct {
+	Index  string          `json:"_index"`
+	Id     string          `json:"_id"`
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+
+	// The document of an update asking for it
+	Get struct {
+		Source json.RawMessage `json:"_source"`
+	} `json:"get"`
+}
+
+// itemError returns the error of an item rejected by ES.
+func itemError(outcome bulkItem) *ESError {
+	errBody, _ := json.Marshal(map[string]json.RawMessage{"error": outcome.Error})
+	return parseError(outcome.Status, errBody)
+}
+
+// pipelineFailure tells if the error of an item was raised by an ingest processor, which ES tells
+// by the type of processor in a header of the error or of what caused it.
+func pipelineFailure(itemError json.RawMessage) bool {
+	var cause struct {
+		Header struct {
+			ProcessorType json.RawMessage `json:"processor_type"`
+		} `json:"header"`
+		CausedBy json.RawMessage `json:"caused_by"`
+	}
+	if err := json.Unmarshal(itemError, &cause); err != nil {
+		return false
+	}
+	if len(cause.Header.ProcessorType) > 0 {
+		return true
+	}
+	return len(cause.CausedBy) > 0 && pipelineFailure(cause.CausedBy)
+}
+
+// Bulk sends b like BulkSendContext and returns the outcome of its items, such as to log the
+// throughput of every request. The body is never spooled as that would leave nothing to tell.
+func (c *Client) Bulk(ctx context.Context, b *BulkBody) (BulkResult, error) {
+	var result BulkResult
+	b.Done()
+	// Still holds what was sent after a reset, for redacting reasons of failures
+	sent := b.Bytes()
+	start := time.Now()
+	body, err := c.bulkRequest(ctx, b)
+	result.Took = time.Since(start)
+	if err != nil {
+		return result, err
+	}
+
+	var resp struct {
+		Took       int                   `json:"took"`
+		IngestTook int                   `json:"ingest_took"`
+		Items      []map[string]bulkItem `json:"items"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return result, err
+	}
+	result.ESTook = resp.Took
+	result.IngestTook = resp.IngestTook
+	for _, item := range resp.Items {
+		for action, outcome := range item {
+			if len(outcome.Error) > 0 {
+				result.Failed++
+				esErr := itemError(outcome)
+				esErr.Reason = redact.Text(esErr.Reason, redact.ValuesJSON(sent))
+				result.Failures = append(result.Failures, ItemFailure{
+					Action:          action,
+					Index:           outcome.Index,
+					Id:              outcome.Id,
+					Err:             esErr,
+					PipelineFailure: pipelineFailure(outcome.Error),
+				})
+				continue
+			}
+			switch action {
+			case "index", "create":
+				result.Indexed++
+			case "update":
+				result.Updated++
+				if len(outcome.Get.Source) > 0 {
+					result.Sources = append(result.Sources, ItemSource{outcome.Index, outcome.Id, outcome.Get.Source})
+				}
+			case "delete":
+				result.Deleted++
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,97 @@
+package elasticsearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientBulk(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"took":42,"errors":true,"items":[
+			{"index":{"_id":"1","status":201,"result":"created"}},
+			{"create":{"_id":"2","status":201,"result":"created"}},
+			{"update":{"_id":"3","status":200,"result":"updated"}},
+			{"update":{"_id":"4","status":400,"error":{"type":"mapper_parsing_exception","reason":"bad"}}},
+			{"delete":{"_id":"5","status":200,"result":"deleted"}},
+			{"index":{"_id":"6","status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}}
+		]}`))
+	}))
+	defer server.Close()
+
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}})
+	result, err := NewClient(server.URL, 1).Bulk(context.Background(), bulk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ESTook != 42 {
+		t.Error("Expected took of ES, got", result.ESTook)
+	}
+	if result.Took <= 0 {
+		t.Error("Expected measured time, got", result.Took)
+	}
+	if result.Indexed != 2 || result.Updated != 1 || result.Deleted != 1 || result.Failed != 2 {
+		t.Error("Unexpected counts", result)
+	}
+	if bulk.Len() != 0 {
+		t.Error("Expected body to be reset once accepted")
+	}
+}
+
+func TestClientBulkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(413)
+	}))
+	defer server.Close()
+
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}})
+	if _, err := NewClient(server.URL, 1).Bulk(context.Background(), bulk); err != ErrRequestTooLarge {
+		t.Error("Expected ErrRequestTooLarge, got", err)
+	}
+}
+
+func TestClientBulkPipelineFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"took":12,"ingest_took":7,"errors":true,"items":[
+			{"index":{"_index":"users","_id":"1","status":201,"result":"created"}},
+			{"index":{"_index":"users","_id":"2","status":500,"error":{"type":"script_exception","reason":"runtime error","header":{"processor_type":"script"}}}},
+			{"index":{"_index":"users","_id":"3","status":400,"error":{"type":"illegal_argument_exception","reason":"pipeline failed","caused_by":{"type":"exception","reason":"field [geo] not present","header":{"processor_type":"enrich"}}}}},
+			{"index":{"_index":"users","_id":"4","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse field [age]"}}},
+			{"index":{"_index":"users","_id":"5","status":429,"error":{"type":"es_rejected_execution_exception","reason":"queue full"}}}
+		]}`))
+	}))
+	defer server.Close()
+
+	bulk := NewBulkBody(MB)
+	bulk.Add(&rawEntry{"index", "users", "", "1", map[string]interface{}{"foo": "bar"}})
+	result, err := NewClient(server.URL, 1).Bulk(context.Background(), bulk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ESTook != 12 || result.IngestTook != 7 {
+		t.Error("Expected took and ingest took of ES, got", result.ESTook, result.IngestTook)
+	}
+	if result.Indexed != 1 || result.Failed != 4 || len(result.Failures) != 4 {
+		t.Fatal("Unexpected counts", result)
+	}
+	for i, expected := range []struct {
+		id, errType        string
+		pipeline, retrying bool
+	}{
+		{"2", "script_exception", true, false},
+		{"3", "illegal_argument_exception", true, false},
+		{"4", "mapper_parsing_exception", false, false},
+		{"5", "es_rejected_execution_exception", false, true},
+	} {
+		failure := result.Failures[i]
+		if failure.Action != "index" || failure.Index != "users" || failure.Id != expected.id || failure.Err.Type != expected.errType {
+			t.Error("Unexpected failure", failure, failure.Err)
+		}
+		if failure.PipelineFailure != expected.pipeline || failure.Retryable() != expected.retrying {
+			t.Error("Expected", expected.id, "pipeline failure", expected.pipeline, "and retryable", expected.retrying, "got", failure.PipelineFailure, failure.Retryable())
+		}
+	}
+}
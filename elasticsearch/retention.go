@@ -0,0 +1,156 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/duego/cryriver/stats"
+	"net/url"
+	"time"
+)
+
+// Retention routes documents into indices bucketed by the time in TimestampField of each document,
+// so that they expire Duration after that time. ES has no _ttl anymore, deleting is left to an ILM
+// policy on the ES side: an index template matching the buckets of an index with a delete phase
+// of min_age Duration. The river never deletes anything itself, it only picks the bucket.
+//
+// ILM counts min_age from the creation of each bucket, documents are therefore kept for at least
+// Duration and at most Duration plus the length of a bucket, see Bucket.
+type Retention struct {
+	Duration       time.Duration
+	TimestampField string
+}
+
+// Bucket returns the length of time each index covers, hours for a retention of less than two days
+// and days otherwise.
+func (r Retention) Bucket() time.Duration {
+	if r.Duration < 48*time.Hour {
+		return time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// IndexName returns the bucket of index the time ts belongs to, such as users-2014.02.25 for daily
+// buckets and users-2014.02.25.10 for hourly ones. Times are bucketed in UTC.
+func (r Retention) IndexName(index string, ts time.Time) string {
+	layout := "2006.01.02"
+	if r.Bucket() == time.Hour {
+		layout = "2006.01.02.15"
+	}
+	return index + "-" + ts.UTC().Format(layout)
+}
+
+// Expired tells if a document of time ts would already have been deleted by ILM at now.
+func (r Retention) Expired(ts, now time.Time) bool {
+	return now.Sub(ts) > r.Duration
+}
+
+// Timestamp reads TimestampField of doc, either a time.Time, a RFC 3339 string or milliseconds
+// since epoch like date fields of ES.
+func (r Retention) Timestamp(doc map[string]interface{}) (time.Time, error) {
+	switch ts := doc[r.TimestampField].(type) {
+	case time.Time:
+		return ts, nil
+	case *time.Time:
+		if ts != nil {
+			return *ts, nil
+		}
+	case string:
+		return time.Parse(time.RFC3339, ts)
+	case int64:
+		return time.Unix(0, ts*int64(time.Millisecond)), nil
+	case int:
+		return time.Unix(0, int64(ts)*int64(time.Millisecond)), nil
+	case float64:
+		return time.Unix(0, int64(ts)*int64(time.Millisecond)), nil
+	}
+	return time.Time{}, fmt.Errorf("Document has no time in %s to pick an index of the retention by", r.TimestampField)
+}
+
+// WithRetention makes the BulkBody route every entry into the bucket of its index given by r.
+// Entries are routed by their own document, new documents without the timestamp field fail to be
+// added. Updates not carrying it are handled by the MarshalPolicy, as the bucket holding their
+// document is unknown and an upsert into the current one would only create a stub. Deletes not
+// carrying it make Add return an *UnroutedDelete, Slurp then deletes their document from every
+// bucket. Entries that have already expired are left out, as they would only recreate a bucket
+// deleted by ILM.
+func WithRetention(r Retention) BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.retention = &r
+	}
+}
+
+// route sets the bucket of doc as the index of header, returning false for expired documents.
+func (r *Retention) route(v BulkEntry, action string, header *indexHeader, doc map[string]interface{}) (bool, error) {
+	ts, err := r.Timestamp(doc)
+	if err != nil {
+		if action == "delete" {
+			return false, &UnroutedDelete{Entry: v, Index: header.Name, Id: header.Id}
+		}
+		return false, err
+	}
+	if r.Expired(ts, time.Now()) {
+		stats.Expired.Add(1)
+		return false, nil
+	}
+	header.Name = r.IndexName(header.Name, ts)
+	return true, nil
+}
+
+// UnroutedDelete is returned by Add for a delete without the time of its document, which may then
+// be in any bucket of Index. Nothing is added for it. Slurp sends what was read before it and then
+// deletes the document by id from all the buckets at once, acknowledging the entry once done.
+type UnroutedDelete struct {
+	Entry BulkEntry
+	Index string
+	Id    string
+}
+
+func (e *UnroutedDelete) Error() string {
+	return fmt.Sprintf("Delete of %s in %s has no time to pick a bucket of the retention by", e.Id, e.Index)
+}
+
+// unroutedDeleter is implemented by senders able to carry out an UnroutedDelete.
+type unroutedDeleter interface {
+	deleteUnrouted(ctx context.Context, d *UnroutedDelete) error
+}
+
+// deleteUnrouted deletes the document of d from every bucket of its index by a delete by query.
+// The buckets are refreshed first, a document indexed moments ago isn't found by the query
+// otherwise.
+func (c *Client) deleteUnrouted(ctx context.Context, d *UnroutedDelete) error {
+	buckets := "/" + url.PathEscape(d.Index) + "-*"
+	resp, body, err := c.do(ctx, "POST", buckets+"/_refresh", "", nil)
+	if err != nil {
+		return err
+	}
+	if code := resp.StatusCode; code != 200 {
+		return parseError(code, body)
+	}
+
+	query, err := json.Marshal(map[string]interface{}{
+		"query": map[string]interface{}{"ids": map[string]interface{}{"values": []string{d.Id}}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, body, err = c.do(ctx, "POST", buckets+"/_delete_by_query?conflicts=proceed", "application/json", query)
+	if err != nil {
+		return err
+	}
+	if code := resp.StatusCode; code != 200 {
+		return parseError(code, body)
+	}
+	var result struct {
+		Deleted  int               `json:"deleted"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+	if len(result.Failures) > 0 {
+		return fmt.Errorf("Unable to delete %s from the buckets of %s: %s", d.Id, d.Index, result.Failures[0])
+	}
+	stats.DeletedByQuery.Add(int64(result.Deleted))
+	return nil
+}
@@ -0,0 +1,139 @@
+package elasticsearch
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetentionIndexName(t *testing.T) {
+	ts := time.Date(2014, 2, 25, 10, 46, 24, 0, time.FixedZone("CET", 3600))
+	if name := (Retention{Duration: 30 * 24 * time.Hour}).IndexName("users", ts); name != "users-2014.02.25" {
+		t.Error("Expected daily bucket, got", name)
+	}
+	if name := (Retention{Duration: 6 * time.Hour}).IndexName("users", ts); name != "users-2014.02.25.09" {
+		t.Error("Expected hourly bucket in UTC, got", name)
+	}
+}
+
+func TestRetentionTimestamp(t *testing.T) {
+	r := Retention{Duration: time.Hour, TimestampField: "created_at"}
+	expected := time.Date(2014, 2, 25, 10, 46, 24, 0, time.UTC)
+	for _, v := range []interface{}{expected, &expected, "2014-02-25T10:46:24Z", expected.UnixNano() / int64(time.Millisecond), float64(expected.UnixNano() / int64(time.Millisecond))} {
+		ts, err := r.Timestamp(map[string]interface{}{"created_at": v})
+		if err != nil || !ts.Equal(expected) {
+			t.Errorf("Expected %v from %#v, got %v %v", expected, v, ts, err)
+		}
+	}
+	if _, err := r.Timestamp(map[string]interface{}{"foo": "bar"}); err == nil {
+		t.Error("Expected an error without the timestamp field")
+	}
+}
+
+func TestBulkBodyWithRetention(t *testing.T) {
+	bulk := NewBulkBody(MB, WithRetention(Retention{Duration: 7 * 24 * time.Hour, TimestampField: "created_at"}))
+	now := time.Now().UTC()
+	if err := bulk.Add(&rawEntry{"index", "sessions", "session", "1", map[string]interface{}{"created_at": now}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"index":{"_index":"sessions-` + now.Format("2006.01.02") + `","_type":"session","_id":"1"}}`
+	if s := bulk.String(); !strings.HasPrefix(s, expected) {
+		t.Error("Expected document routed to the bucket of today, got", s)
+	}
+
+	// Expired documents would only recreate a deleted bucket
+	size := bulk.Len()
+	if err := bulk.Add(&rawEntry{"index", "sessions", "session", "2", map[string]interface{}{"created_at": now.AddDate(0, 0, -8)}}); err != nil {
+		t.Fatal(err)
+	}
+	if bulk.Len() != size {
+		t.Error("Expected expired document to be left out, got", bulk.String())
+	}
+
+	// Deletes without a time are deleted from every bucket, updates can't be sent anywhere
+	bulk.Reset()
+	err := bulk.Add(&rawEntry{"delete", "sessions", "session", "1", nil})
+	if unrouted, ok := err.(*UnroutedDelete); !ok || unrouted.Index != "sessions" || unrouted.Id != "1" {
+		t.Error("Expected a delete without a time to be unrouted, got", err)
+	}
+	if err := bulk.Add(&rawEntry{"update", "sessions", "session", "1", map[string]interface{}{"active": false}}); err != nil {
+		t.Error("Expected an update without a time to be skipped, got", err)
+	}
+	if bulk.Len() != 0 {
+		t.Error("Expected nothing to be sent to the index itself, got", bulk.String())
+	}
+	if err := bulk.Add(&rawEntry{"index", "sessions", "session", "3", map[string]interface{}{"active": true}}); err == nil {
+		t.Error("Expected a new document without a timestamp to fail")
+	}
+
+	failing := NewBulkBody(MB, WithRetention(Retention{Duration: time.Hour, TimestampField: "created_at"}), WithMarshalPolicy(FailFast))
+	if err := failing.Add(&rawEntry{"update", "sessions", "session", "1", map[string]interface{}{"active": false}}); err == nil {
+		t.Error("Expected an update without a time to be handled by the marshal policy")
+	}
+}
+
+// timedSequencedEntry is a sequencedEntry that can be slurped.
+type timedSequencedEntry struct {
+	sequencedEntry
+}
+
+func (e *timedSequencedEntry) Time() *time.Time {
+	now := time.Now()
+	return &now
+}
+
+func TestSlurpUnroutedDelete(t *testing.T) {
+	var lock sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		requests = append(requests, r.URL.RequestURI()+" "+strings.TrimSpace(string(body)))
+		lock.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_delete_by_query"):
+			w.Write([]byte(`{"deleted":1,"failures":[]}`))
+		case strings.HasSuffix(r.URL.Path, "/_bulk"):
+			w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, 1)
+
+	tracker := NewAckTracker(0)
+	tracker.Track(1)
+	tracker.Track(2)
+	retention := WithRetention(Retention{Duration: 7 * 24 * time.Hour, TimestampField: "created_at"})
+	esc := make(chan Transaction)
+	done := make(chan bool)
+	go func() {
+		Slurp(client, esc, SlurpConfig{BulkOptions: []BulkOption{retention, AckTo(tracker)}, Linger: time.Hour})
+		close(done)
+	}()
+	esc <- &timedSequencedEntry{sequencedEntry{rawEntry{"index", "sessions", "session", "1", map[string]interface{}{"created_at": time.Now()}}, 1, false}}
+	esc <- &timedSequencedEntry{sequencedEntry{rawEntry{"delete", "sessions", "session", "1", nil}, 2, false}}
+	close(esc)
+	<-done
+
+	if len(requests) != 3 {
+		t.Fatal("Expected a bulk request, a refresh and a delete by query, got", requests)
+	}
+	if !strings.HasPrefix(requests[0], "/_bulk") {
+		t.Error("Expected what was read before the delete to be sent first, got", requests[0])
+	}
+	if !strings.HasPrefix(requests[1], "/sessions-*/_refresh") {
+		t.Error("Expected the buckets to be refreshed, got", requests[1])
+	}
+	if expected := `_delete_by_query?conflicts=proceed {"query":{"ids":{"values":["1"]}}}`; !strings.HasSuffix(requests[2], expected) {
+		t.Error("Expected the document to be deleted from every bucket, got", requests[2])
+	}
+	if checkpoint := tracker.Checkpoint(); checkpoint != 2 {
+		t.Error("Expected the delete to be acknowledged once carried out, got", checkpoint)
+	}
+}
@@ -0,0 +1,70 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+)
+
+// StoredScripter is optionally implemented by entries to update documents with a script stored in
+// ES rather than by merging their document, such as to increment a counter. StoredScript returns
+// the id of the script, as stored by Client.PutStoredScript, and the params given to it, with ok
+// false to update as usual. Only the id and params are sent, which keeps bodies small and lets ES
+// reuse the compiled script. It's only asked of updates.
+//
+// The document of the entry is upserted when the document to update doesn't exist, updates of
+// documents that don't exist fail without one.
+type StoredScripter interface {
+	StoredScript() (id string, params map[string]interface{}, ok bool, err error)
+}
+
+// storedScript references a script stored in ES.
+type storedScript struct {
+	Id     string                 `json:"id"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// scriptOf returns the stored script of an update by v, nil if v doesn't update by one.
+func scriptOf(v BulkEntry, action string) (*storedScript, error) {
+	var scripter StoredScripter
+	if !entryAs(v, &scripter) || action != "update" {
+		return nil, nil
+	}
+	id, params, ok, err := scripter.StoredScript()
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &storedScript{id, params}, nil
+}
+
+// scriptedUpdate returns the source of an update by script, upserting doc unless it's empty.
+func scriptedUpdate(script *storedScript, doc map[string]interface{}) map[string]interface{} {
+	update := map[string]interface{}{"script": script}
+	if len(doc) > 0 {
+		update["upsert"] = doc
+	}
+	return update
+}
+
+// PutStoredScript stores the script source under id in ES for StoredScripter entries to update
+// with, such as on start. lang is the language of the script, painless if empty. Storing a script
+// that already exists replaces it.
+func (c *Client) PutStoredScript(ctx context.Context, id, lang, source string) error {
+	if lang == "" {
+		lang = "painless"
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"script": map[string]string{"lang": lang, "source": source},
+	})
+	if err != nil {
+		return err
+	}
+	resp, respBody, err := c.do(ctx, "PUT", "/_scripts/"+url.PathEscape(id), "application/json", body)
+	if err != nil {
+		return err
+	}
+	if code := resp.StatusCode; code != 200 {
+		return parseError(code, respBody)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package elasticsearch
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// scriptedEntry updates by the stored script id with params.
+type scriptedEntry struct {
+	rawEntry
+	script string
+	params map[string]interface{}
+}
+
+func (e *scriptedEntry) StoredScript() (string, map[string]interface{}, bool, error) {
+	return e.script, e.params, e.script != "", nil
+}
+
+func TestBulkBodyStoredScript(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	entries := []BulkEntry{
+		&scriptedEntry{rawEntry{"update", "testing", "user", "1", map[string]interface{}{"visits": 1}}, "increment", map[string]interface{}{"field": "visits", "by": 1}},
+		// Nothing to upsert, still sent for the script to change
+		&scriptedEntry{rawEntry{"update", "testing", "user", "2", nil}, "touch", nil},
+		&scriptedEntry{rawEntry{"update", "testing", "user", "3", map[string]interface{}{"v": 3}}, "", nil},
+		// Only updates run scripts
+		&scriptedEntry{rawEntry{"index", "testing", "user", "4", map[string]interface{}{"v": 4}}, "increment", nil},
+	}
+	for _, entry := range entries {
+		if err := bulk.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := `{"update":{"_index":"testing","_type":"user","_id":"1"}}
+{"script":{"id":"increment","params":{"by":1,"field":"visits"}},"upsert":{"visits":1}}
+{"update":{"_index":"testing","_type":"user","_id":"2"}}
+{"script":{"id":"touch"}}
+{"update":{"_index":"testing","_type":"user","_id":"3"}}
+{"doc":{"v":3},"doc_as_upsert":true}
+{"index":{"_index":"testing","_type":"user","_id":"4"}}
+{"v":4}
+`
+	if s := bulk.String(); s != expected {
+		t.Error("Unexpected body", s)
+	}
+}
+
+func TestClientPutStoredScript(t *testing.T) {
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.Method + " " + r.URL.Path
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.Write([]byte(`{"acknowledged":true}`))
+	}))
+	defer server.Close()
+
+	err := NewClient(server.URL, 1).PutStoredScript(context.Background(), "increment", "", "ctx._source[params.field] += params.by")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "PUT /_scripts/increment" {
+		t.Error("Unexpected request", path)
+	}
+	if body != `{"script":{"lang":"painless","source":"ctx._source[params.field] += params.by"}}` {
+		t.Error("Unexpected script", body)
+	}
+}
@@ -0,0 +1,153 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+)
+
+// ScrollKeepAlive is how long ES keeps a scroll of Scroll between two pages, in ES time units.
+var ScrollKeepAlive = "1m"
+
+// ErrScrollExpired is returned by Next of a ScrollIterator whose scroll was let go by ES, either
+// because reading a page took longer than ScrollKeepAlive or the node holding it restarted.
+var ErrScrollExpired = errors.New("Scroll expired before reaching the end, pages must be read within the scroll keep alive")
+
+// Hit is a document found by a Scroll.
+type Hit struct {
+	Index  string          `json:"_index"`
+	Type   string          `json:"_type"`
+	Id     string          `json:"_id"`
+	Source json.RawMessage `json:"_source"`
+}
+
+// ScrollIterator pages through the documents found by Scroll. It reads the index as it was when the
+// scroll started, documents changed since are seen the way they were then.
+type ScrollIterator struct {
+	client *Client
+	ctx    context.Context
+	id     string
+	hits   []Hit
+	hit    Hit
+	done   bool
+	err    error
+}
+
+// Scroll searches index for documents matching query, all of them if nil, to be read size at a time
+// with the returned ScrollIterator. The iterator must be closed once done with for ES to free the
+// scroll. ctx is used for every page as well as for closing it.
+//
+//	it, err := client.Scroll(ctx, "users", nil, 500)
+//	if err != nil {
+//		return err
+//	}
+//	defer it.Close()
+//	for it.Next() {
+//		hit := it.Hit()
+//	}
+//	return it.Err()
+func (c *Client) Scroll(ctx context.Context, index string, query json.RawMessage, size int) (*ScrollIterator, error) {
+	request := map[string]interface{}{
+		"size": size,
+		// Documents in the order of the index are the cheapest to scroll
+		"sort": []string{"_doc"},
+	}
+	if query != nil {
+		request["query"] = query
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	it := &ScrollIterator{client: c, ctx: ctx}
+	path := "/" + url.PathEscape(index) + "/_search?scroll=" + url.QueryEscape(ScrollKeepAlive)
+	if err := it.page("POST", path, body); err != nil {
+		return nil, err
+	}
+	return it, nil
+}
+
+// Next moves to the next document found, returning false once there are no more or a page fails
+// to be read, which is told by Err.
+func (it *ScrollIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if len(it.hits) == 0 && !it.done {
+		body, err := json.Marshal(map[string]string{"scroll": ScrollKeepAlive, "scroll_id": it.id})
+		if err == nil {
+			err = it.page("POST", "/_search/scroll", body)
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+	}
+	if len(it.hits) == 0 {
+		return false
+	}
+	it.hit, it.hits = it.hits[0], it.hits[1:]
+	return true
+}
+
+// Hit returns the document Next moved to.
+func (it *ScrollIterator) Hit() Hit {
+	return it.hit
+}
+
+// Err returns the error reading the scroll, ErrScrollExpired if ES let it go before it was read to
+// the end.
+func (it *ScrollIterator) Err() error {
+	return it.err
+}
+
+// Close frees the scroll in ES, a scroll already expired or freed is not an error.
+func (it *ScrollIterator) Close() error {
+	if it.id == "" {
+		return nil
+	}
+	body, err := json.Marshal(map[string][]string{"scroll_id": {it.id}})
+	if err != nil {
+		return err
+	}
+	it.id = ""
+	resp, respBody, err := it.client.do(it.ctx, "DELETE", "/_search/scroll", "application/json", body)
+	if err != nil {
+		return err
+	}
+	if code := resp.StatusCode; code != 200 && code != 404 {
+		return parseError(code, respBody)
+	}
+	return nil
+}
+
+// page reads the next page of hits from the search or scroll request to path.
+func (it *ScrollIterator) page(method, path string, body []byte) error {
+	resp, respBody, err := it.client.do(it.ctx, method, path, "application/json", body)
+	if err != nil {
+		return err
+	}
+	if code := resp.StatusCode; code != 200 {
+		// Paging an expired scroll fails with search_context_missing_exception
+		if code == 404 && it.id != "" {
+			return ErrScrollExpired
+		}
+		return parseError(code, respBody)
+	}
+	var page struct {
+		ScrollId string `json:"_scroll_id"`
+		Hits     struct {
+			Hits []Hit `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(respBody, &page); err != nil {
+		return err
+	}
+	if page.ScrollId != "" {
+		it.id = page.ScrollId
+	}
+	it.hits = page.Hits.Hits
+	it.done = len(it.hits) == 0
+	return nil
+}
@@ -0,0 +1,112 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// scrollServer pages through pages of hits, one per request, recording the requests it gets.
+func scrollServer(pages [][]string, requests *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		*requests = append(*requests, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+		if r.Method == "DELETE" {
+			w.Write([]byte(`{"succeeded":true,"num_freed":1}`))
+			return
+		}
+		if len(pages) == 0 {
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error":{"type":"search_context_missing_exception","reason":"No search context found for id [1]"},"status":404}`))
+			return
+		}
+		var hits []Hit
+		for _, id := range pages[0] {
+			hits = append(hits, Hit{Index: "users", Id: id, Source: json.RawMessage(`{"name":"` + id + `"}`)})
+		}
+		pages = pages[1:]
+		b, _ := json.Marshal(map[string]interface{}{
+			"_scroll_id": "scroll-1",
+			"hits":       map[string]interface{}{"hits": hits},
+		})
+		w.Write(b)
+	}))
+}
+
+func TestScroll(t *testing.T) {
+	var requests []string
+	server := scrollServer([][]string{{"1", "2"}, {"3"}, {}}, &requests)
+	defer server.Close()
+
+	it, err := NewClient(server.URL, 1).Scroll(context.Background(), "users", json.RawMessage(`{"match_all":{}}`), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for it.Next() {
+		hit := it.Hit()
+		if string(hit.Source) != `{"name":"`+hit.Id+`"}` {
+			t.Error("Unexpected source of", hit.Id, string(hit.Source))
+		}
+		ids = append(ids, hit.Id)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 3 || ids[0] != "1" || ids[1] != "2" || ids[2] != "3" {
+		t.Error("Expected every hit in order, got", ids)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := it.Close(); err != nil || len(requests) != 4 {
+		t.Error("Expected closing twice to free the scroll once", err, requests)
+	}
+
+	expected := []string{
+		`POST /users/_search?scroll=1m {"query":{"match_all":{}},"size":2,"sort":["_doc"]}`,
+		`POST /_search/scroll {"scroll":"1m","scroll_id":"scroll-1"}`,
+		`POST /_search/scroll {"scroll":"1m","scroll_id":"scroll-1"}`,
+		`DELETE /_search/scroll {"scroll_id":["scroll-1"]}`,
+	}
+	for i := range expected {
+		if i >= len(requests) || requests[i] != expected[i] {
+			t.Error("Expected", expected[i], "got", requests)
+			break
+		}
+	}
+}
+
+func TestScrollExpired(t *testing.T) {
+	var requests []string
+	server := scrollServer([][]string{{"1"}}, &requests)
+	defer server.Close()
+
+	it, err := NewClient(server.URL, 1).Scroll(context.Background(), "users", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	if !it.Next() || it.Hit().Id != "1" {
+		t.Fatal("Expected the first hit")
+	}
+	if it.Next() {
+		t.Error("Expected no more hits once expired")
+	}
+	if err := it.Err(); err != ErrScrollExpired {
+		t.Error("Expected ErrScrollExpired, got", err)
+	}
+}
+
+func TestScrollMissingIndex(t *testing.T) {
+	var requests []string
+	server := scrollServer(nil, &requests)
+	defer server.Close()
+
+	if _, err := NewClient(server.URL, 1).Scroll(context.Background(), "users", nil, 1); err == nil || err == ErrScrollExpired {
+		t.Error("Expected the error of ES, got", err)
+	}
+}
@@ -2,12 +2,20 @@
 package elasticsearch
 
 import (
-	"errors"
-	"fmt"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"github.com/duego/cryriver/redact"
 	"github.com/duego/cryriver/stats"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -30,6 +38,36 @@ type Operationer interface {
 	Action() (string, error)
 }
 
+// ConflictRetrier is optionally implemented by entries wanting ES to retry their updates on version
+// conflicts, RetryOnConflict returns the number of retries before giving up.
+type ConflictRetrier interface {
+	RetryOnConflict() int
+}
+
+// Versioner is optionally implemented by entries having a version of their own, such as to let ES
+// ignore operations older than what is already indexed.
+type Versioner interface {
+	Version() int64
+}
+
+// VersionTyper is optionally implemented by entries to tell how ES should compare their version,
+// such as external or external_gte.
+type VersionTyper interface {
+	VersionType() string
+}
+
+// Pipeliner is optionally implemented by entries that should pass an ingest pipeline.
+type Pipeliner interface {
+	Pipeline() string
+}
+
+// Replacer is optionally implemented by entries carrying the whole document. When Replaces is true,
+// earlier indexes and updates of the same document in the bulk body are dropped as they have no
+// effect on the end result. Deletes in between are kept, as is everything before them.
+type Replacer interface {
+	Replaces() bool
+}
+
 // Transaction as in one complete set of values to perform an operation towards elasticsearch.
 type Transaction interface {
 	Operationer
@@ -45,48 +83,622 @@ type BulkSender interface {
 // Client is used for sending the actual requests to elasticsearch.
 type Client struct {
 	*http.Client
-	url string
+	server    string
+	userAgent string
+
+	// Longest time for each request, no limit if zero
+	requestTimeout time.Duration
+
+	// Compresses request bodies when set
+	gzip *gzipper
+
+	// Trims bulk bodies to end with a single newline, see WithExactTerminator
+	exactTerminator bool
+
+	// Major version of the REST API to ask for, the one of the cluster if less than 7
+	compatibleWith int
+
+	// Indexing into OpenSearch, see WithOpenSearch
+	openSearch bool
+
+	// TLS of the transport created by NewClient, its defaults if nil
+	tlsConfig *tls.Config
+
+	// Mappings holds the mapping and settings to create indexes with, keyed by index name or a
+	// pattern of names such as logs-* for indexes named by time.
+	// Indexes found here are created before the first bulk request towards them unless they
+	// already exist.
+	Mappings map[string]json.RawMessage
+
+	ensuredLock sync.Mutex
+	ensured     map[string]bool
+
+	aliasLock    sync.Mutex
+	writeIndexes map[string]string
+
+	logger Logger
+	redact func([]byte) []byte
+	tracer Tracer
+
+	// Receives the bodies of failed bulk requests, see WithBulkDebug
+	bulkDebug    BulkDebugger
+	bulkDebugMax int
+
+	backoff         Backoff
+	pressureBackoff Backoff
+	retries         int
+	breaker         *CircuitBreaker
+	spool           *Spool
+
+	counters *counters
+
+	// Detected by DetectVersion, or the fallback given by WithClusterVersion
+	versionLock     sync.Mutex
+	version         *ClusterVersion
+	fallbackVersion *ClusterVersion
 }
 
-func NewClient(url string, maxConn int) *Client {
-	tr := &http.Transport{
-		MaxIdleConnsPerHost: maxConn,
+// NewClient returns a client for the elasticsearch server at url, such as http://localhost:9200.
+// Unless an http.Client is given by WithHTTPClient, requests are sent by a transport based on
+// http.DefaultTransport keeping up to maxConn idle connections to the server.
+func NewClient(url string, maxConn int, options ...ClientOption) *Client {
+	c := &Client{
+		server:          strings.TrimRight(url, "/"),
+		userAgent:       DefaultUserAgent,
+		ensured:         make(map[string]bool),
+		writeIndexes:    make(map[string]string),
+		backoff:         DefaultBackoff,
+		pressureBackoff: DefaultPressureBackoff,
+		retries:         DefaultRetries,
+		counters:        new(counters),
 	}
-	return &Client{
-		&http.Client{Transport: tr},
-		url,
+	for _, option := range options {
+		option(c)
 	}
+	if c.Client == nil {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.MaxIdleConnsPerHost = maxConn
+		if c.tlsConfig != nil {
+			tr.TLSClientConfig = c.tlsConfig
+		}
+		c.Client = &http.Client{Transport: tr}
+	}
+	return c
 }
 
 // BulkSend will accept a populated BulkBody that will be sent using POST.
 // If the Post doesn't return any errors, the BulkBody will be Reset to accept new operations.
-// Will return an error on non-200 return codes.
-func (c Client) BulkSend(b *BulkBody) error {
+// Connection errors and responses telling that ES is busy or unavailable are retried with backoff.
+// Will return ErrRequestTooLarge on 413 and an *ESError on other non-200 return codes.
+func (c *Client) BulkSend(b *BulkBody) error {
+	return c.BulkSendContext(context.Background(), b)
+}
+
+// BulkSendContext is BulkSend within ctx, which is also where the span is started from when
+// tracing is enabled by WithTracer. With a Spool, bodies failing because ES is unavailable are
+// spooled and nil is returned, as is done for all bodies while there are spooled ones waiting to
+// be replayed to keep them in order.
+func (c *Client) BulkSendContext(ctx context.Context, b *BulkBody) error {
+	if c.spool == nil {
+		return c.bulkSend(ctx, b)
+	}
 	b.Done()
-	log.Println("Send that buffer!", string(b.Bytes()))
-	resp, err := c.Post(c.url, "application/x-www-form-urlencoded", b)
-	if err != nil {
+	// Still holds what was sent after a reset as nothing is written to the buffer meanwhile
+	sent := b.Bytes()
+	var err error
+	if c.spool.Pending() == 0 {
+		if err = c.bulkSend(ctx, b); !failed(err) {
+			return err
+		}
+		// Only what ES didn't take when it's kept
+		if b.Len() > 0 {
+			sent = b.Bytes()
+		}
+	}
+	if spoolErr := c.spool.Write(sent); spoolErr != nil {
+		log.Println("Unable to spool bulk body:", spoolErr)
+		if err == nil {
+			return spoolErr
+		}
 		return err
 	}
-	defer resp.Body.Close()
+	b.acknowledgeAll()
+	b.Reset()
+	return nil
+}
+
+// Index sends v on its own in a bulk request, for the occasional operation that isn't worth
+// batching. The body is sized after v rather than preallocated like NewBulkBody does, the request is
+// retried and errors are returned the same way as for BulkSend.
+func (c *Client) Index(ctx context.Context, v BulkEntry) error {
+	bulk := &BulkBody{Buffer: new(bytes.Buffer), max: MaxBulkSize, typeless: c.typeless()}
+	if err := bulk.Add(v); err != nil {
+		return err
+	}
+	// Nothing to change
+	if bulk.Len() == 0 {
+		return nil
+	}
+	return c.BulkSendContext(ctx, bulk)
+}
+
+// bulkSend sends b as described by BulkSend.
+func (c *Client) bulkSend(ctx context.Context, b *BulkBody) error {
+	_, err := c.bulkRequest(ctx, b)
+	return err
+}
+
+// bulkRequest sends b like bulkSend, returning the response body when accepted by ES.
+func (c *Client) bulkRequest(ctx context.Context, b *BulkBody) (respBody []byte, err error) {
+	b.Done()
+	if c.breaker != nil {
+		if err := c.breaker.Allow(); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err == ErrClusterReadOnly {
+				c.breaker.Trip()
+				return
+			}
+			c.breaker.Done(!failed(err))
+		}()
+	}
+	ctx, endSpan := c.traceBulk(ctx, b)
+	var resp *http.Response
+	defer func() {
+		var status int
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		endSpan(status, err)
+	}()
+
+	sent, err := c.terminate(b.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	for attempt := 0; ; attempt++ {
+		c.counters.request(len(sent), attempt > 0)
+		resp, body, err = c.do(ctx, "POST", b.path(), "application/x-www-form-urlencoded", sent)
+		pressure := err == nil && underPressure(resp.StatusCode, body)
+		if (err == nil && !retryable(resp.StatusCode)) || attempt >= c.retries || ctx.Err() != nil {
+			break
+		}
+		delay := c.backoff.Delay(attempt)
+		if pressure {
+			delay = c.pressureBackoff.Delay(attempt)
+			log.Println("ES is short of heap, a circuit breaker rejected the bulk request, retrying in", delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if code := resp.StatusCode; code != 200 && code != 413 {
+		if esErr := parseError(code, body); clusterBlocked(esErr) {
+			// Not reset, the body is sent again once the block is lifted
+			stats.ClusterBlocked.Add(1)
+			esErr.Reason = redact.Text(esErr.Reason, redact.ValuesJSON(sent))
+			c.debugBulk(sent, esErr)
+			return nil, ErrClusterReadOnly
+		}
+	}
+	if resp.StatusCode == 200 {
+		// Not reset either, the items ES couldn't take are sent again on their own
+		if err := c.retainRejected(b, sent, body); err != nil {
+			return nil, err
+		}
+	}
 	b.Reset()
 
 	// XXX: Do we really need to iterate all items returned to see if all has ok: true?
-	if code := resp.StatusCode; code != 200 {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return errors.New(fmt.Sprintf("Unexpected status code: %d\n%s", code, string(body)))
+	switch code := resp.StatusCode; code {
+	case 200:
+		stats.LastBulk.Set(time.Now().Unix())
+		c.counters.accepted(b, sent, body)
+		if b.requireAlias {
+			logMissingAliases(body)
+		}
+		b.acknowledge(body)
+	case 413:
+		c.debugBulk(sent, ErrRequestTooLarge)
+		return nil, ErrRequestTooLarge
+	default:
+		esErr := parseError(code, body)
+		// Reasons may echo the values of what we sent
+		esErr.Reason = redact.Text(esErr.Reason, redact.ValuesJSON(sent))
+		if unterminated(esErr) {
+			esErr.Reason += " (it was sent terminated, something in between such as a proxy strips trailing newlines)"
+		}
+		c.debugBulk(sent, esErr)
+		return nil, esErr
+	}
+	return body, nil
+}
+
+// retainRejected leaves b with only the entries whose items in respBody, the response to it, were
+// rejected by a write block or a circuit breaker, as when ES accepted the request but some of its
+// shards couldn't take writes. What ES did accept is counted and acknowledged. Returns the error
+// telling why items were rejected, ErrClusterReadOnly before ErrMemoryPressure, or nil leaving b as
+// it is when none were.
+func (c *Client) retainRejected(b *BulkBody, sent, respBody []byte) error {
+	if !bytes.Contains(respBody, []byte("cluster_block_exception")) && !bytes.Contains(respBody, []byte("circuit_breaking_exception")) {
+		return nil
+	}
+	var resp struct {
+		Items []map[string]bulkItem `json:"items"`
+	}
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return nil
+	}
+	entries, err := splitEntries(b.Bytes())
+	if err != nil || len(entries) != len(resp.Items) {
+		return nil
+	}
+
+	var rejection error
+	kept := make([]bool, len(resp.Items))
+	for i, item := range resp.Items {
+		for _, outcome := range item {
+			if len(outcome.Error) == 0 {
+				continue
+			}
+			if esErr := itemError(outcome); clusterBlocked(esErr) {
+				kept[i], rejection = true, ErrClusterReadOnly
+			} else if circuitBreaking(esErr) {
+				kept[i] = true
+				if rejection == nil {
+					rejection = ErrMemoryPressure
+				}
+			}
+		}
+	}
+	if rejection == nil {
+		return nil
+	}
+	if rejection == ErrClusterReadOnly {
+		stats.ClusterBlocked.Add(1)
+	} else {
+		stats.MemoryPressure.Add(1)
+	}
+	stats.LastBulk.Set(time.Now().Unix())
+	c.counters.accepted(b, sent, respBody)
+
+	retained := new(bytes.Buffer)
+	var sequences [][]uint64
+	for i, entry := range entries {
+		if !kept[i] {
+			if b.acks != nil && i < len(b.sequences) && settledItem(firstOutcome(resp.Items[i])) {
+				for _, sequence := range b.sequences[i] {
+					b.acks.Ack(sequence)
+				}
+			}
+			continue
+		}
+		retained.Write(entry.Header)
+		retained.WriteByte(newline)
+		if entry.Source != nil {
+			retained.Write(entry.Source)
+			retained.WriteByte(newline)
+		}
+		if i < len(b.sequences) {
+			sequences = append(sequences, b.sequences[i])
+		}
+	}
+	// A buffer of its own, what was sent may be shared such as by the targets of a FanOut
+	retained.WriteByte(newline)
+	b.Buffer = retained
+	b.sequences = sequences
+	// Spans and counts of what is no longer in the body
+	b.last = nil
+	b.actions = nil
+	return rejection
+}
+
+// firstOutcome returns the outcome of an item of a bulk response, keyed by its action.
+func firstOutcome(item map[string]bulkItem) bulkItem {
+	for _, outcome := range item {
+		return outcome
+	}
+	return bulkItem{}
+}
+
+// underPressure tells if a response with status and body is a circuit breaker of ES rejecting a
+// bulk request, counting it.
+func underPressure(status int, body []byte) bool {
+	if status != 429 || !circuitBreaking(parseError(status, body)) {
+		return false
 	}
+	stats.MemoryPressure.Add(1)
+	return true
+}
+
+// EnsureIndex creates the index name using mapping as the request body unless it already exists.
+// Indexes that has been seen once are remembered and will not be checked again. Creating an index
+// that was created by someone else since it was checked, such as another river, is not an error.
+func (c *Client) EnsureIndex(ctx context.Context, name string, mapping json.RawMessage) error {
+	c.ensuredLock.Lock()
+	defer c.ensuredLock.Unlock()
+	if c.ensured[name] {
+		return nil
+	}
+
+	path := "/" + url.PathEscape(name)
+	resp, _, err := c.do(ctx, "HEAD", path, "", nil)
+	if err != nil {
+		return err
+	}
+
+	switch resp.StatusCode {
+	case 200:
+	case 404:
+		resp, body, err := c.do(ctx, "PUT", path, "application/json", mapping)
+		if err != nil {
+			return err
+		}
+		if code := resp.StatusCode; code != 200 {
+			esErr := parseError(code, body)
+			if !alreadyExists(esErr) {
+				return esErr
+			}
+		} else {
+			log.Println("Created index", name)
+		}
+	default:
+		return &ESError{Status: resp.StatusCode, Reason: "Unable to check if index exists: " + name}
+	}
+
+	c.ensured[name] = true
 	return nil
 }
 
+// do sends a request to path on the server and reads the whole response body.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body []byte) (*http.Response, []byte, error) {
+	if c.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+		defer cancel()
+	}
+	// Logged uncompressed below
+	sent := body
+	compressed := c.gzip != nil && len(body) > 0
+	if compressed {
+		var err error
+		if sent, err = c.gzip.compress(body); err != nil {
+			return nil, nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.server+path, bytes.NewReader(sent))
+	if err != nil {
+		return nil, nil, err
+	}
+	accept, contentType := c.mediaTypes(contentType)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+
+	start := time.Now()
+	resp, err := c.Do(req)
+	var respBody []byte
+	if err == nil {
+		respBody, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	c.logRequest(req, body, resp, respBody, time.Since(start), err)
+	return resp, respBody, err
+}
+
+// alreadyExists tells if creating an index failed for it existing, reported as
+// index_already_exists_exception before elasticsearch 6.
+func alreadyExists(err *ESError) bool {
+	return err.Status == 400 && (err.Type == "resource_already_exists_exception" || err.Type == "index_already_exists_exception")
+}
+
+// ensureMapped makes sure the index exists if we have a mapping configured for it.
+func (c *Client) ensureMapped(index string) error {
+	mapping, ok := c.mapping(index)
+	if !ok {
+		return nil
+	}
+	return c.EnsureIndex(context.Background(), index, mapping)
+}
+
+// mapping returns the mapping of index in Mappings, trying the keys that are patterns matching it,
+// such as logs-*, in sorted order if there is none of its own.
+func (c *Client) mapping(index string) (json.RawMessage, bool) {
+	if mapping, ok := c.Mappings[index]; ok {
+		return mapping, true
+	}
+	patterns := make([]string, 0, len(c.Mappings))
+	for pattern := range c.Mappings {
+		if strings.ContainsAny(pattern, "*?[") {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, index); ok {
+			return c.Mappings[pattern], true
+		}
+	}
+	return nil, false
+}
+
+// indexEnsurer is implemented by senders that wants to prepare indexes before they are written to.
+type indexEnsurer interface {
+	ensureMapped(index string) error
+}
+
+// typelessSender is implemented by senders that knows if the cluster does without mapping types.
+type typelessSender interface {
+	typeless() bool
+}
+
+// DefaultLinger is how long Slurp lets transactions wait for more to fill up a bulk body unless
+// configured otherwise.
+const DefaultLinger = time.Second
+
+// SlurpConfig controls how Slurp batches transactions, the zero value is usable.
+type SlurpConfig struct {
+	// InFlight limits the bytes held by bulk bodies across all slurpers sharing it, a body reserves
+	// its maximum size before it starts to fill up and releases it once it has been sent.
+	InFlight *InFlightLimiter
+
+	// BulkOptions are applied to the bulk body being built.
+	BulkOptions []BulkOption
+
+	// Linger is the longest time a transaction waits in a bulk body before it's sent even though
+	// the body isn't full, DefaultLinger if zero. The timer starts when the first transaction is
+	// added to an empty body.
+	Linger time.Duration
+
+	// Context makes the slurper give up waiting for InFlight once it's done, such as when shutting
+	// down, as well as building entries of huge documents. Transactions read meanwhile are dropped
+	// without being acknowledged, while what is already in the body is still sent once the channel is
+	// closed. Nil is context.Background().
+	Context context.Context
+
+	// Throttle caps the entries per second sent across all slurpers sharing it. A body waits for
+	// it right before being sent, whether full or lingered, and nothing is added meanwhile: the
+	// wait holds back incoming transactions rather than growing the body. A body sent again, such
+	// as while ES is unreachable, only waits the first time.
+	Throttle *Throttle
+}
+
 // Slurp collects transactions that will be sent towards elasticsearch in batches.
 // Closing the channel will make the function return. Any pending transactions will be flushed before
 // returning.
-func Slurp(client BulkSender, esc chan Transaction) {
+func Slurp(client BulkSender, esc chan Transaction, config SlurpConfig) {
 	defer log.Println("Slurper stopped")
 
-	bulkBuf := NewBulkBody(MB)
-	bulkTicker := time.NewTicker(time.Second)
+	options := config.BulkOptions
+	if typer, ok := client.(typelessSender); ok && typer.typeless() {
+		options = append(options[:len(options):len(options)], Typeless())
+	}
+	bulkBuf := NewBulkBody(DefaultBulkSize, options...)
+	if config.Linger <= 0 {
+		config.Linger = DefaultLinger
+	}
+	// Only armed while there is something waiting to be sent
+	lingerTimer := time.NewTimer(config.Linger)
+	lingerTimer.Stop()
+	defer lingerTimer.Stop()
+
+	ctx := config.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Bytes reserved from the in flight limiter for the current body
+	var reserved ByteSize
+	// Whether the current body has taken its tokens of the throttle, once however often it's sent
+	var throttled bool
+	send := func() error {
+		if config.Throttle != nil && !throttled {
+			config.Throttle.Wait(bulkBuf.EntrySizes().Count)
+			throttled = true
+		}
+		err := client.BulkSend(bulkBuf)
+		if bulkBuf.Len() == 0 {
+			throttled = false
+			lingerTimer.Stop()
+			if reserved > 0 {
+				config.InFlight.Release(reserved)
+				reserved = 0
+			}
+		}
+		return err
+	}
+	add := func(op Transaction) error {
+		if config.InFlight != nil && reserved == 0 {
+			var err error
+			if reserved, err = config.InFlight.AcquireContext(ctx, bulkBuf.max); err != nil {
+				return err
+			}
+		}
+		empty := bulkBuf.Len() == 0
+		err := bulkBuf.AddContext(ctx, op)
+		if empty && bulkBuf.Len() > 0 {
+			lingerTimer.Reset(config.Linger)
+		}
+		if reserved > 0 {
+			if size := ByteSize(bulkBuf.Len()); size == 0 {
+				// Nothing to send for a skipped or failed entry, there is no timer to release it
+				config.InFlight.Release(reserved)
+				reserved = 0
+			} else if size > reserved {
+				// Entries sent on their own may be larger than the max of the body, nothing is held
+				// for them once ctx is done
+				config.InFlight.Release(reserved)
+				reserved, _ = config.InFlight.AcquireContext(ctx, size)
+			}
+		}
+		return err
+	}
+
+	// flush sends the full body, sending it again in place for as long as it's kept, such as while
+	// ES is unreachable or blocks writes, so that nothing read after it overtakes it. The incoming
+	// transactions are held back meanwhile. Gives up once ctx is done.
+	flush := func() error {
+		err := send()
+		if err == ErrClusterReadOnly {
+			log.Println(err, "- pausing until it's lifted")
+		}
+		for err != nil && bulkBuf.Len() > 0 && ctx.Err() == nil {
+			if err != ErrCircuitOpen && err != ErrClusterReadOnly {
+				log.Println(err, "- sending the bulk request again")
+			}
+			select {
+			case <-time.After(pause(err, config.Linger)):
+			case <-ctx.Done():
+				return err
+			}
+			err = send()
+		}
+		return err
+	}
+
+	// deleteUnrouted deletes the document of d from every bucket of its retention once what was read
+	// before it has been sent, trying again like flush until ctx is done.
+	deleteUnrouted := func(d *UnroutedDelete) error {
+		deleter, ok := client.(unroutedDeleter)
+		if !ok {
+			// Fails the same way again, it's dropped once the error is logged
+			bulkBuf.acknowledgeEntry(d.Entry)
+			return d
+		}
+		if bulkBuf.Len() > 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		err := deleter.deleteUnrouted(ctx, d)
+		for err != nil && ctx.Err() == nil {
+			log.Println(err, "- deleting it again")
+			select {
+			case <-time.After(config.Linger):
+			case <-ctx.Done():
+				return err
+			}
+			err = deleter.deleteUnrouted(ctx, d)
+		}
+		if err == nil {
+			bulkBuf.acknowledgeEntry(d.Entry)
+		}
+		return err
+	}
 
 	// Loop all incoming operations and send them to the bulk indexer.
 	for {
@@ -94,33 +706,68 @@ func Slurp(client BulkSender, esc chan Transaction) {
 		case op := <-esc:
 			if op == nil {
 				if bulkBuf.Len() > 0 {
-					if err := client.BulkSend(bulkBuf); err != nil {
+					if err := send(); err != nil {
 						log.Println(err)
 					}
 				}
+				if reserved > 0 {
+					config.InFlight.Release(reserved)
+				}
 				return
 			}
-			err := bulkBuf.Add(op)
-			switch err {
-			case nil:
-			case BulkBodyFull:
+			if ensurer, ok := client.(indexEnsurer); ok {
+				if index, err := op.Index(); err == nil {
+					if err := ensurer.ensureMapped(index); err != nil {
+						log.Println(err)
+					}
+				}
+			}
+			err := add(op)
+			if err == BulkBodyFull {
 				stats.BulkFull.Add(1)
-				if err := client.BulkSend(bulkBuf); err != nil {
+				if err := flush(); err != nil {
 					log.Println(err)
-					// XXX: There is no limit on the amount of pending go routines doing it like this
-					// but at least we won't block
-					go func() { esc <- op }()
 				}
-			default:
+				// The operation that didn't fit goes into the fresh body, after everything read
+				// before it. It's dropped if the body is still kept once ctx is done.
+				err = add(op)
+			}
+			if unrouted, ok := err.(*UnroutedDelete); ok {
+				err = deleteUnrouted(unrouted)
+			}
+			// Dropped on purpose once ctx is done
+			if err != nil && ctx.Err() == nil {
 				log.Println(err)
 			}
-		case <-bulkTicker.C:
+		case <-lingerTimer.C:
 			if bulkBuf.Len() > 0 {
 				stats.BulkTime.Add(1)
-				if err := client.BulkSend(bulkBuf); err != nil {
+				if err := send(); err != nil {
 					log.Println(err)
+					// Try again later
+					lingerTimer.Reset(pause(err, config.Linger))
 				}
 			}
 		}
 	}
 }
+
+// ReadOnlyPause is how long slurpers wait before sending again to an ES blocking writes, checking
+// if the block has been lifted. It's longer than the linger as the block won't go away on its own.
+var ReadOnlyPause = 30 * time.Second
+
+// PressurePause is how long slurpers wait before sending items rejected by a circuit breaker of ES
+// again, giving it time to collect garbage.
+var PressurePause = 5 * time.Second
+
+// pause returns how long to wait after err before sending again, linger unless ES blocks writes or
+// is short of heap.
+func pause(err error, linger time.Duration) time.Duration {
+	if err == ErrClusterReadOnly && ReadOnlyPause > linger {
+		return ReadOnlyPause
+	}
+	if err == ErrMemoryPressure && PressurePause > linger {
+		return PressurePause
+	}
+	return linger
+}
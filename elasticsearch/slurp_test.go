@@ -0,0 +1,354 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// indexServer pretends to be elasticsearch for index HEAD/PUT requests, recording what it got.
+type indexServer struct {
+	sync.Mutex
+	exists   map[string]bool
+	requests []string
+	bodies   map[string]string
+}
+
+func newIndexServer(existing ...string) (*indexServer, *httptest.Server) {
+	s := &indexServer{exists: make(map[string]bool), bodies: make(map[string]string)}
+	for _, name := range existing {
+		s.exists[name] = true
+	}
+	return s, httptest.NewServer(s)
+}
+
+func (s *indexServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	name := r.URL.Path[1:]
+	switch r.Method {
+	case "HEAD":
+		if !s.exists[name] {
+			w.WriteHeader(404)
+		}
+	case "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		s.bodies[name] = string(body)
+		s.exists[name] = true
+		w.Write([]byte(`{"acknowledged":true}`))
+	}
+}
+
+func TestEnsureIndexCreates(t *testing.T) {
+	es, server := newIndexServer()
+	defer server.Close()
+
+	mapping := json.RawMessage(`{"mappings":{"user":{"properties":{"age":{"type":"integer"}}}}}`)
+	client := NewClient(server.URL, 1)
+	if err := client.EnsureIndex(context.Background(), "users", mapping); err != nil {
+		t.Fatal(err)
+	}
+	if b := es.bodies["users"]; b != string(mapping) {
+		t.Error("Expected index to be created with mapping, got", b)
+	}
+
+	// Second call should be answered from cache
+	if err := client.EnsureIndex(context.Background(), "users", mapping); err != nil {
+		t.Fatal(err)
+	}
+	if len(es.requests) != 2 {
+		t.Error("Expected HEAD and PUT only, got", es.requests)
+	}
+}
+
+func TestEnsureIndexExists(t *testing.T) {
+	es, server := newIndexServer("users")
+	defer server.Close()
+
+	client := NewClient(server.URL, 1)
+	if err := client.EnsureIndex(context.Background(), "users", json.RawMessage(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(es.requests) != 1 || es.requests[0] != "HEAD /users" {
+		t.Error("Expected a single existence check, got", es.requests)
+	}
+}
+
+func TestEnsureIndexError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			w.WriteHeader(404)
+			return
+		}
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error":{"type":"mapper_parsing_exception","reason":"no handler for type [integr]"},"status":400}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1)
+	err := client.EnsureIndex(context.Background(), "users", json.RawMessage(`{}`))
+	if esErr, ok := err.(*ESError); !ok || esErr.Type != "mapper_parsing_exception" {
+		t.Fatal("Expected mapper_parsing_exception, got", err)
+	}
+	if client.ensured["users"] {
+		t.Error("Failed index creation should not be cached")
+	}
+}
+
+func TestEnsureIndexAlreadyExists(t *testing.T) {
+	// Created by someone else between the check and the creation
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			w.WriteHeader(404)
+			return
+		}
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error":{"type":"resource_already_exists_exception","reason":"index [users-2014.02.25/abc] already exists"},"status":400}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1)
+	if err := client.EnsureIndex(context.Background(), "users-2014.02.25", json.RawMessage(`{}`)); err != nil {
+		t.Fatal("Expected an index already existing to be ensured, got", err)
+	}
+	if !client.ensured["users-2014.02.25"] {
+		t.Error("Expected the index to be remembered")
+	}
+}
+
+func TestEnsureMappedPattern(t *testing.T) {
+	es, server := newIndexServer()
+	defer server.Close()
+
+	client := NewClient(server.URL, 1)
+	client.Mappings = map[string]json.RawMessage{
+		"users":  json.RawMessage(`{"users":true}`),
+		"logs-*": json.RawMessage(`{"logs":true}`),
+	}
+	for _, index := range []string{"users", "logs-2014.02.25", "other"} {
+		if err := client.ensureMapped(index); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(es.bodies) != 2 || es.bodies["users"] != `{"users":true}` || es.bodies["logs-2014.02.25"] != `{"logs":true}` {
+		t.Error("Expected indexes created by name and pattern, got", es.bodies)
+	}
+}
+
+// timedEntry is a complete Transaction.
+type timedEntry struct {
+	rawEntry
+}
+
+func (e *timedEntry) Time() *time.Time {
+	now := time.Now()
+	return &now
+}
+
+// recordingSender keeps the bodies it has been asked to send.
+type recordingSender struct {
+	sync.Mutex
+	sent []string
+}
+
+func (s *recordingSender) BulkSend(b *BulkBody) error {
+	s.Lock()
+	defer s.Unlock()
+	b.Done()
+	s.sent = append(s.sent, b.String())
+	b.Reset()
+	return nil
+}
+
+func (s *recordingSender) count() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.sent)
+}
+
+func TestSlurpLinger(t *testing.T) {
+	sender := &recordingSender{}
+	esc := make(chan Transaction)
+	done := make(chan bool)
+	go func() {
+		Slurp(sender, esc, SlurpConfig{Linger: 20 * time.Millisecond})
+		close(done)
+	}()
+
+	esc <- &timedEntry{rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}}}
+	time.Sleep(100 * time.Millisecond)
+	if n := sender.count(); n != 1 {
+		t.Fatal("Expected one send after lingering, got", n)
+	}
+
+	// Nothing buffered should not send anything
+	time.Sleep(100 * time.Millisecond)
+	if n := sender.count(); n != 1 {
+		t.Error("Expected no empty sends, got", n)
+	}
+
+	// Pending entries are flushed on shutdown without waiting for the timer
+	esc <- &timedEntry{rawEntry{"index", "testing", "user", "2", map[string]interface{}{"foo": "bar"}}}
+	close(esc)
+	<-done
+	if n := sender.count(); n != 2 {
+		t.Error("Expected pending entry to be flushed on shutdown, got", n)
+	}
+}
+
+func TestSlurpFullKeepsEntry(t *testing.T) {
+	sender := &recordingSender{}
+	esc := make(chan Transaction)
+	done := make(chan bool)
+	go func() {
+		Slurp(sender, esc, SlurpConfig{Linger: time.Hour})
+		close(done)
+	}()
+
+	// Fill up the body so that the next entry doesn't fit
+	big := make(map[string]interface{})
+	big["data"] = string(make([]byte, DefaultBulkSize))
+	esc <- &timedEntry{rawEntry{"index", "testing", "user", "1", big}}
+	esc <- &timedEntry{rawEntry{"index", "testing", "user", "2", map[string]interface{}{"foo": "bar"}}}
+	close(esc)
+	<-done
+
+	if n := sender.count(); n != 2 {
+		t.Fatal("Expected two sends, got", n)
+	}
+	if !strings.Contains(sender.sent[1], `"_id":"2"`) {
+		t.Error("Expected entry not fitting to be sent in the next body, got", sender.sent[1])
+	}
+}
+
+// unreachableSender fails to send the first bodies while keeping them, like ES being unreachable.
+type unreachableSender struct {
+	recordingSender
+	failures int
+}
+
+func (s *unreachableSender) BulkSend(b *BulkBody) error {
+	s.Lock()
+	if s.failures > 0 {
+		s.failures--
+		s.Unlock()
+		return errors.New("Connection refused")
+	}
+	s.Unlock()
+	return s.recordingSender.BulkSend(b)
+}
+
+func TestSlurpFullSendsAgainInOrder(t *testing.T) {
+	sender := &unreachableSender{failures: 2}
+	esc := make(chan Transaction)
+	done := make(chan bool)
+	go func() {
+		Slurp(sender, esc, SlurpConfig{Linger: 10 * time.Millisecond})
+		close(done)
+	}()
+
+	big := make(map[string]interface{})
+	big["data"] = string(make([]byte, DefaultBulkSize))
+	esc <- &timedEntry{rawEntry{"index", "testing", "user", "1", big}}
+	// Doesn't fit, waits for the first body to be sent rather than going around it
+	esc <- &timedEntry{rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}}}
+	esc <- &timedEntry{rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "baz"}}}
+	close(esc)
+	<-done
+
+	if n := sender.count(); n != 2 {
+		t.Fatal("Expected two sends, got", n)
+	}
+	if !strings.Contains(sender.sent[0], `"data"`) {
+		t.Error("Expected the body that failed to be sent first, got", sender.sent[0])
+	}
+	if bar, baz := strings.Index(sender.sent[1], "bar"), strings.Index(sender.sent[1], "baz"); bar < 0 || baz < bar {
+		t.Error("Expected the entries after it in the order they were read, got", sender.sent[1])
+	}
+}
+
+func TestClientIndex(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1)
+	if err := client.Index(context.Background(), &rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"index":{"_index":"testing","_type":"user","_id":"1"}}` + "\n" + `{"foo":"bar"}` + "\n\n"
+	if len(bodies) != 1 || bodies[0] != expected {
+		t.Error("Expected a single entry body, got", bodies)
+	}
+
+	// Updates without changes are not sent at all
+	if err := client.Index(context.Background(), &rawEntry{"update", "testing", "user", "1", map[string]interface{}{}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 1 {
+		t.Error("Expected no request for an empty update, got", bodies)
+	}
+}
+
+func TestClientIndexError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error":{"type":"illegal_argument_exception","reason":"bad"},"status":400}`))
+	}))
+	defer server.Close()
+
+	err := NewClient(server.URL, 1).Index(context.Background(), &rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}})
+	if esErr, ok := err.(*ESError); !ok || esErr.Type != "illegal_argument_exception" {
+		t.Error("Expected an *ESError, got", err)
+	}
+}
+
+// benchmarkServer accepts every bulk request.
+func benchmarkServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+}
+
+func BenchmarkClientIndex(b *testing.B) {
+	server := benchmarkServer()
+	defer server.Close()
+	client := NewClient(server.URL, 1)
+	entry := &rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.Index(context.Background(), entry); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkClientBulkBodySingle(b *testing.B) {
+	server := benchmarkServer()
+	defer server.Close()
+	client := NewClient(server.URL, 1)
+	entry := &rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bulk := NewBulkBody(DefaultBulkSize)
+		if err := bulk.Add(entry); err != nil {
+			b.Fatal(err)
+		}
+		if err := client.BulkSendContext(context.Background(), bulk); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+)
+
+// SourceReturner is optionally implemented by entries wanting ES to return the document once
+// updated, such as to tell others about the change. ReturnSource returns the fields to include and
+// exclude like the _source parameter of ES, the whole document if both are empty, and ok false to
+// leave it out. It's only asked of updates, ES has nothing to return for other actions. What ES
+// returned is found in BulkResult.Sources.
+type SourceReturner interface {
+	ReturnSource() (include, exclude []string, ok bool, err error)
+}
+
+// sourceFilter is the _source of an update header returning some fields of the document.
+type sourceFilter struct {
+	Includes []string `json:"includes,omitempty"`
+	Excludes []string `json:"excludes,omitempty"`
+}
+
+// returnSource sets the _source of the header of an update if v is a SourceReturner.
+func (bulk *BulkBody) returnSource(v BulkEntry, action string, header *indexHeader) error {
+	var returner SourceReturner
+	if !entryAs(v, &returner) || action != "update" {
+		return nil
+	}
+	include, exclude, ok, err := returner.ReturnSource()
+	if err != nil || !ok {
+		return err
+	}
+	if len(include) == 0 && len(exclude) == 0 {
+		header.Source = true
+	} else {
+		header.Source = sourceFilter{include, exclude}
+	}
+	return nil
+}
+
+// ItemSource is the document ES returned for an updated item of a bulk request.
+type ItemSource struct {
+	Index  string
+	Id     string
+	Source json.RawMessage
+}
@@ -0,0 +1,71 @@
+package elasticsearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// returningEntry asks for include and exclude of the updated document.
+type returningEntry struct {
+	rawEntry
+	include, exclude []string
+	ok               bool
+}
+
+func (e *returningEntry) ReturnSource() ([]string, []string, bool, error) {
+	return e.include, e.exclude, e.ok, nil
+}
+
+func TestBulkBodyReturnSource(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	entries := []BulkEntry{
+		&returningEntry{rawEntry{"update", "testing", "user", "1", map[string]interface{}{"v": 1}}, nil, nil, true},
+		&returningEntry{rawEntry{"update", "testing", "user", "2", map[string]interface{}{"v": 2}}, []string{"name", "profile.*"}, []string{"profile.secret"}, true},
+		&returningEntry{rawEntry{"update", "testing", "user", "3", map[string]interface{}{"v": 3}}, nil, nil, false},
+		// Only updates return anything
+		&returningEntry{rawEntry{"index", "testing", "user", "4", map[string]interface{}{"v": 4}}, nil, nil, true},
+	}
+	for _, entry := range entries {
+		if err := bulk.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := `{"update":{"_index":"testing","_type":"user","_id":"1","_source":true}}
+{"doc":{"v":1},"doc_as_upsert":true}
+{"update":{"_index":"testing","_type":"user","_id":"2","_source":{"includes":["name","profile.*"],"excludes":["profile.secret"]}}}
+{"doc":{"v":2},"doc_as_upsert":true}
+{"update":{"_index":"testing","_type":"user","_id":"3"}}
+{"doc":{"v":3},"doc_as_upsert":true}
+{"index":{"_index":"testing","_type":"user","_id":"4"}}
+{"v":4}
+`
+	if s := bulk.String(); s != expected {
+		t.Error("Unexpected body:\n", s)
+	}
+}
+
+func TestClientBulkSources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"took":1,"errors":false,"items":[
+			{"update":{"_index":"testing","_id":"1","status":200,"result":"updated","get":{"found":true,"_source":{"name":"Joe"}}}},
+			{"update":{"_index":"testing","_id":"2","status":200,"result":"updated"}}
+		]}`))
+	}))
+	defer server.Close()
+
+	bulk := NewBulkBody(MB)
+	bulk.Add(&returningEntry{rawEntry{"update", "testing", "", "1", map[string]interface{}{"name": "Joe"}}, nil, nil, true})
+	bulk.Add(&rawEntry{"update", "testing", "", "2", map[string]interface{}{"name": "Jane"}})
+	result, err := NewClient(server.URL, 1).Bulk(context.Background(), bulk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Sources) != 1 {
+		t.Fatal("Expected the returned document of the first update, got", result.Sources)
+	}
+	if source := result.Sources[0]; source.Index != "testing" || source.Id != "1" || string(source.Source) != `{"name":"Joe"}` {
+		t.Error("Unexpected returned document", source)
+	}
+}
@@ -0,0 +1,148 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSpoolFull is returned when spooling a body would make the spool exceed its max size.
+var ErrSpoolFull = errors.New("Spool directory has reached its max size")
+
+const spoolSuffix = ".bulk"
+
+// rejectedSuffix is appended to the files of spooled bodies ES has rejected, to keep them for
+// investigation without replaying them again.
+const rejectedSuffix = ".rejected"
+
+// Spool keeps bulk bodies on disk while elasticsearch is unavailable so that they can be replayed
+// once it has recovered, turning an outage into a delay. Each body is a file named by the time it
+// was spooled and is only removed once ES has accepted it.
+//
+// The files in the spool directory are never more than max bytes in total. Bodies that doesn't fit
+// are not spooled and fails like they would without a spool, their operations are lost. It's safe
+// for concurrent use.
+type Spool struct {
+	sync.Mutex
+	dir  string
+	max  ByteSize
+	size ByteSize
+
+	// Spooled files not yet replayed
+	files []string
+	seq   int
+}
+
+// NewSpool returns a spool keeping bodies in dir, which is created if needed. Any bodies already
+// in dir, such as from before a restart, are picked up to be replayed.
+func NewSpool(dir string, max ByteSize) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, max: max}
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), spoolSuffix) {
+			s.files = append(s.files, info.Name())
+			s.size += ByteSize(info.Size())
+		}
+	}
+	sort.Strings(s.files)
+	return s, nil
+}
+
+// WithSpool makes the Client spool bodies to s instead of failing them when ES is unavailable,
+// nothing is spooled by default. Use Replay to send them once ES has recovered.
+func WithSpool(s *Spool) ClientOption {
+	return func(c *Client) {
+		c.spool = s
+	}
+}
+
+// Pending returns the number of spooled bodies waiting to be replayed.
+func (s *Spool) Pending() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.files)
+}
+
+// Write spools a finished bulk body. Returns ErrSpoolFull if it doesn't fit.
+func (s *Spool) Write(body []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.size+ByteSize(len(body)) > s.max {
+		return ErrSpoolFull
+	}
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, spoolSuffix)
+
+	// Written to a temporary file first to never replay a partly written body
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.files = append(s.files, name)
+	s.size += ByteSize(len(body))
+	return nil
+}
+
+// Replay sends the spooled bodies through c oldest first, removing each once accepted by ES. It
+// stops at the first body that fails because ES is unavailable and returns its error, the rest are
+// kept for the next replay. A body ES rejects on its own, such as with a 400, would never be
+// accepted and is set aside in the spool directory with the suffix .rejected instead, so that it
+// doesn't hold back the bodies after it. Returns the number of bodies replayed.
+func (s *Spool) Replay(ctx context.Context, c *Client) (int, error) {
+	var replayed int
+	for {
+		s.Lock()
+		if len(s.files) == 0 {
+			s.Unlock()
+			return replayed, nil
+		}
+		name := s.files[0]
+		s.Unlock()
+
+		path := filepath.Join(s.dir, name)
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return replayed, err
+		}
+		bulk := &BulkBody{Buffer: bytes.NewBuffer(body), max: MaxBulkSize, done: true}
+		err = c.bulkSend(ctx, bulk)
+		if failed(err) {
+			return replayed, err
+		}
+		if err != nil {
+			log.Println("Setting aside spooled bulk request", name, "rejected by ES:", err)
+			err = os.Rename(path, path+rejectedSuffix)
+		} else {
+			err = os.Remove(path)
+			replayed++
+		}
+		if err != nil {
+			return replayed, err
+		}
+
+		s.Lock()
+		s.files = s.files[1:]
+		s.size -= ByteSize(len(body))
+		s.Unlock()
+	}
+}
@@ -0,0 +1,144 @@
+package elasticsearch
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cryriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var lock sync.Mutex
+	available := false
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if !available {
+			w.WriteHeader(503)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, string(body))
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	spool, err := NewSpool(dir, MB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient(server.URL, 1, WithRetries(0), WithSpool(spool))
+	send := func(id string) error {
+		bulk := NewBulkBody(MB)
+		bulk.Add(&rawEntry{"index", "testing", "user", id, map[string]interface{}{"foo": "bar"}})
+		return client.BulkSend(bulk)
+	}
+
+	// Spooled while ES is down
+	if err := send("1"); err != nil {
+		t.Fatal("Expected failing body to be spooled, got", err)
+	}
+	// Spooled while there are older bodies waiting, even though ES is back
+	lock.Lock()
+	available = true
+	lock.Unlock()
+	if err := send("2"); err != nil {
+		t.Fatal(err)
+	}
+	if n := spool.Pending(); n != 2 {
+		t.Fatal("Expected two spooled bodies, got", n)
+	}
+
+	// Survives a restart
+	spool, err = NewSpool(dir, MB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := spool.Replay(context.Background(), client); err != nil || n != 2 {
+		t.Fatal("Expected two bodies to be replayed, got", n, err)
+	}
+	if len(received) != 2 || received[0] >= received[1] {
+		t.Error("Expected bodies to be replayed in order, got", received)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Error("Expected replayed bodies to be removed, got", len(files))
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cryriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := NewSpool(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Write([]byte("a")); err != ErrSpoolFull {
+		t.Error("Expected ErrSpoolFull, got", err)
+	}
+}
+
+func TestSpoolRejectedBody(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cryriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(string(body), "bad") {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":{"type":"parse_exception","reason":"bad"},"status":400}`))
+			return
+		}
+		received = append(received, string(body))
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	spool, err := NewSpool(dir, MB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool.Write([]byte("bad\n\n"))
+	good := NewBulkBody(MB)
+	good.Add(&rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}})
+	good.Done()
+	spool.Write(good.Bytes())
+
+	client := NewClient(server.URL, 1, WithRetries(0), WithSpool(spool))
+	if n, err := spool.Replay(context.Background(), client); err != nil || n != 1 {
+		t.Fatal("Expected the good body to be replayed past the rejected one, got", n, err)
+	}
+	if len(received) != 1 || spool.Pending() != 0 {
+		t.Error("Expected only the good body to reach ES and nothing left pending, got", received, spool.Pending())
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 || !strings.HasSuffix(files[0].Name(), spoolSuffix+rejectedSuffix) {
+		t.Error("Expected the rejected body to be set aside, got", files)
+	}
+
+	// Not picked up again after a restart
+	if spool, err = NewSpool(dir, MB); err != nil || spool.Pending() != 0 {
+		t.Error("Expected the rejected body to stay aside, got", spool.Pending(), err)
+	}
+}
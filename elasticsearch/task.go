@@ -0,0 +1,113 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"time"
+)
+
+// TaskID identifies a task running in the background of elasticsearch, such as node:123.
+type TaskID string
+
+// TaskStatus is the progress of a task as reported by the tasks API.
+type TaskStatus struct {
+	Completed bool
+
+	// Documents to process and processed so far
+	Total   int64
+	Updated int64
+
+	// Set by ES if the task failed
+	Error *ESError
+}
+
+// UpdateByQuery starts updating all documents of index matching query with script, like the
+// update by query API, without waiting for it to finish. A nil query matches every document.
+// Version conflicts doesn't stop the update. Use PollTask with the returned id to wait for it.
+func (c *Client) UpdateByQuery(ctx context.Context, index string, query, script json.RawMessage) (TaskID, error) {
+	request := make(map[string]json.RawMessage)
+	if query != nil {
+		request["query"] = query
+	}
+	if script != nil {
+		request["script"] = script
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	path := "/" + url.PathEscape(index) + "/_update_by_query?wait_for_completion=false&conflicts=proceed"
+	resp, respBody, err := c.do(ctx, "POST", path, "application/json", body)
+	if err != nil {
+		return "", err
+	}
+	if code := resp.StatusCode; code != 200 {
+		return "", parseError(code, respBody)
+	}
+	var started struct {
+		Task TaskID `json:"task"`
+	}
+	if err := json.Unmarshal(respBody, &started); err != nil {
+		return "", err
+	}
+	return started.Task, nil
+}
+
+// Task returns the current status of the task id.
+func (c *Client) Task(ctx context.Context, id TaskID) (TaskStatus, error) {
+	var status TaskStatus
+	resp, body, err := c.do(ctx, "GET", "/_tasks/"+url.PathEscape(string(id)), "", nil)
+	if err != nil {
+		return status, err
+	}
+	if code := resp.StatusCode; code != 200 {
+		return status, parseError(code, body)
+	}
+
+	var task struct {
+		Completed bool `json:"completed"`
+		Task      struct {
+			Status struct {
+				Total   int64 `json:"total"`
+				Updated int64 `json:"updated"`
+			} `json:"status"`
+		} `json:"task"`
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &task); err != nil {
+		return status, err
+	}
+	status.Completed = task.Completed
+	status.Total = task.Task.Status.Total
+	status.Updated = task.Task.Status.Updated
+	if len(task.Error) > 0 {
+		status.Error = parseError(resp.StatusCode, body)
+	}
+	return status, nil
+}
+
+// PollTask checks the task id every interval until it has completed or ctx is done. Returns the
+// error of the task if it failed.
+func (c *Client) PollTask(ctx context.Context, id TaskID, interval time.Duration) (TaskStatus, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := c.Task(ctx, id)
+		if err != nil {
+			return status, err
+		}
+		if status.Completed {
+			if status.Error != nil {
+				return status, status.Error
+			}
+			return status, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return status, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdateByQueryRequest(t *testing.T) {
+	var method, path, query, contentType string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, query = r.Method, r.URL.Path, r.URL.RawQuery
+		contentType = r.Header.Get("Content-Type")
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte(`{"task":"oTUltX4IQMOUUVeiohTt8A:12345"}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1)
+	id, err := client.UpdateByQuery(context.Background(), "users",
+		json.RawMessage(`{"term":{"country":"se"}}`),
+		json.RawMessage(`{"source":"ctx._source.region = 'eu'"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "oTUltX4IQMOUUVeiohTt8A:12345" {
+		t.Error("Unexpected task id", id)
+	}
+
+	if method != "POST" || path != "/users/_update_by_query" {
+		t.Error("Unexpected request", method, path)
+	}
+	if query != "wait_for_completion=false&conflicts=proceed" {
+		t.Error("Expected update to run async and proceed on conflicts, got", query)
+	}
+	if contentType != "application/json" {
+		t.Error("Unexpected content type", contentType)
+	}
+	var request map[string]map[string]interface{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		t.Fatal(err)
+	}
+	if request["query"]["term"] == nil || request["script"]["source"] == nil {
+		t.Error("Expected query and script in body, got", string(body))
+	}
+}
+
+func TestUpdateByQueryError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+		w.Write([]byte(`{"error":{"type":"index_not_found_exception","reason":"no such index [users]"},"status":404}`))
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, 1).UpdateByQuery(context.Background(), "users", nil, nil)
+	if esErr, ok := err.(*ESError); !ok || esErr.Type != "index_not_found_exception" {
+		t.Error("Expected index_not_found_exception, got", err)
+	}
+}
+
+func TestPollTask(t *testing.T) {
+	var lock sync.Mutex
+	var polls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_tasks/node:1" {
+			t.Error("Unexpected path", r.URL.Path)
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		polls++
+		if polls < 3 {
+			w.Write([]byte(`{"completed":false,"task":{"status":{"total":10,"updated":4}}}`))
+			return
+		}
+		w.Write([]byte(`{"completed":true,"task":{"status":{"total":10,"updated":10}},"response":{"updated":10}}`))
+	}))
+	defer server.Close()
+
+	status, err := NewClient(server.URL, 1).PollTask(context.Background(), "node:1", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Completed || status.Total != 10 || status.Updated != 10 {
+		t.Error("Unexpected status", status)
+	}
+	if polls != 3 {
+		t.Error("Expected to poll until completed, got", polls)
+	}
+}
+
+func TestPollTaskFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"completed":true,"task":{"status":{"total":10,"updated":2}},"error":{"type":"script_exception","reason":"runtime error"}}`))
+	}))
+	defer server.Close()
+
+	status, err := NewClient(server.URL, 1).PollTask(context.Background(), "node:1", time.Millisecond)
+	if esErr, ok := err.(*ESError); !ok || esErr.Type != "script_exception" {
+		t.Error("Expected the error of the task, got", err)
+	}
+	if status.Updated != 2 {
+		t.Error("Expected progress until failing, got", status.Updated)
+	}
+}
+
+func TestPollTaskCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"completed":false,"task":{"status":{"total":10,"updated":0}}}`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := NewClient(server.URL, 1).PollTask(ctx, "node:1", time.Millisecond); err == nil {
+		t.Error("Expected polling to stop when ctx is done")
+	}
+}
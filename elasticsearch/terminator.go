@@ -0,0 +1,41 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// ErrUnterminatedBody is returned instead of sending a bulk body that doesn't end with a newline,
+// which ES would reject, such as one read from somewhere without Done having been called on it.
+var ErrUnterminatedBody = errors.New("Bulk body doesn't end with a newline, Done has to be called on it before sending")
+
+// WithExactTerminator makes the Client trim every bulk body to end with exactly one newline before
+// sending it, dropping extra newlines and carriage returns, such as for bodies replayed from
+// elsewhere. Bodies built by a BulkBody already do, this is a safety net rather than a fix for
+// proxies stripping newlines on the way, which ES still rejects as not terminated.
+func WithExactTerminator() ClientOption {
+	return func(c *Client) {
+		c.exactTerminator = true
+	}
+}
+
+// terminate returns body as it should be sent, failing with ErrUnterminatedBody unless it ends
+// with a newline. It's only copied when trimmed.
+func (c *Client) terminate(body []byte) ([]byte, error) {
+	if c.exactTerminator {
+		trimmed := bytes.TrimRight(body, "\r\n")
+		if len(trimmed) != len(body)-1 || body[len(body)-1] != newline {
+			body = append(trimmed[:len(trimmed):len(trimmed)], newline)
+		}
+	}
+	if len(body) == 0 || body[len(body)-1] != newline {
+		return nil, ErrUnterminatedBody
+	}
+	return body, nil
+}
+
+// unterminated tells if esErr is ES rejecting a bulk body for not ending with a newline.
+func unterminated(esErr *ESError) bool {
+	return strings.Contains(esErr.Reason, "must be terminated by a newline")
+}
@@ -0,0 +1,62 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTerminate(t *testing.T) {
+	entry := []byte(`{"delete":{"_index":"testing","_id":"1"}}`)
+	exact := &Client{exactTerminator: true}
+	for _, body := range [][]byte{
+		append(entry, '\n'),
+		append(entry, '\n', '\n'),
+		append(entry, '\r', '\n'),
+		entry,
+	} {
+		sent, err := exact.terminate(body)
+		if err != nil || !bytes.Equal(sent, append(entry, '\n')) {
+			t.Errorf("Expected %q to end with a single newline, got %q %v", body, sent, err)
+		}
+	}
+
+	if _, err := (&Client{}).terminate(entry); err != ErrUnterminatedBody {
+		t.Error("Expected ErrUnterminatedBody, got", err)
+	}
+}
+
+func TestBulkSendUnterminated(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := ioutil.ReadAll(r.Body)
+		if !bytes.HasSuffix(body, []byte("\n")) {
+			t.Errorf("Expected the body to be terminated, got %q", body)
+		}
+		// As behind a proxy stripping it
+		w.WriteHeader(400)
+		w.Write([]byte(`{"error":{"type":"illegal_argument_exception","reason":"The bulk request must be terminated by a newline [\n]"},"status":400}`))
+	}))
+	defer server.Close()
+	client := NewClient(server.URL, 1, WithExactTerminator())
+
+	body := &BulkBody{Buffer: bytes.NewBufferString(`{"delete":{"_index":"testing","_id":"1"}}`), max: MB, done: true}
+	if err := NewClient(server.URL, 1).BulkSend(body); err != ErrUnterminatedBody {
+		t.Error("Expected ErrUnterminatedBody, got", err)
+	}
+	if requests != 0 {
+		t.Error("Expected nothing to be sent, got", requests)
+	}
+
+	err := client.BulkSend(body)
+	esErr, ok := err.(*ESError)
+	if !ok || !unterminated(esErr) {
+		t.Fatal("Expected ES to reject the body as not terminated, got", err)
+	}
+	if requests != 1 {
+		t.Error("Expected the body to be sent once terminated, got", requests)
+	}
+}
@@ -0,0 +1,70 @@
+package elasticsearch
+
+import (
+	"github.com/duego/cryriver/stats"
+	"math"
+	"sync"
+	"time"
+)
+
+// Throttle caps the entries per second sent to ES by the slurpers sharing it, such as to stay under
+// the indexing rate agreed for a shared cluster. It's a token bucket holding up to a second of
+// entries, bulk bodies take a token per entry before they are sent and wait for them to refill.
+// Bodies of more entries than a second allows take them in advance, making the bodies after them
+// wait longer, so that the rate evens out over time rather than bodies being split. It's safe for
+// concurrent use.
+type Throttle struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+
+	// Entries sent since the start of the window, for the rate published in the stats
+	window time.Time
+	sent   int
+}
+
+// NewThrottle returns a Throttle allowing perSecond entries every second, which must be more than
+// zero.
+func NewThrottle(perSecond int) *Throttle {
+	now := time.Now()
+	return &Throttle{rate: float64(perSecond), tokens: float64(perSecond), last: now, window: now}
+}
+
+// Reserve takes n tokens and returns how long to wait before sending the entries they are for.
+func (t *Throttle) Reserve(n int) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.tokens = math.Min(t.rate, t.tokens+now.Sub(t.last).Seconds()*t.rate)
+	t.last = now
+	// Taken in advance, a negative balance is the time others are already waiting for
+	t.tokens -= float64(n)
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / t.rate * float64(time.Second))
+}
+
+// Wait reserves n tokens and blocks until the entries they are for may be sent, counting the time
+// waited in stats.ThrottledMs.
+func (t *Throttle) Wait(n int) {
+	if delay := t.Reserve(n); delay > 0 {
+		stats.ThrottledMs.Add(int64(delay / time.Millisecond))
+		time.Sleep(delay)
+	}
+	t.record(n)
+}
+
+// record counts n entries as sent, publishing the rate of the last window of a second or more in
+// stats.ThrottleRate.
+func (t *Throttle) record(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sent += n
+	if elapsed := time.Since(t.window); elapsed >= time.Second {
+		stats.ThrottleRate.Set(int64(float64(t.sent) / elapsed.Seconds()))
+		t.sent = 0
+		t.window = time.Now()
+	}
+}
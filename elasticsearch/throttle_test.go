@@ -0,0 +1,79 @@
+package elasticsearch
+
+import (
+	"github.com/duego/cryriver/stats"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottleReserve(t *testing.T) {
+	throttle := NewThrottle(100)
+	if delay := throttle.Reserve(100); delay != 0 {
+		t.Error("Expected a second of entries to be let through at once, got", delay)
+	}
+	// Bodies are never split, the next ones wait for what was taken in advance
+	if delay := throttle.Reserve(50); delay < 400*time.Millisecond || delay > 500*time.Millisecond {
+		t.Error("Expected to wait about half a second, got", delay)
+	}
+	if delay := throttle.Reserve(10); delay < 500*time.Millisecond || delay > 600*time.Millisecond {
+		t.Error("Expected to wait behind the body before, got", delay)
+	}
+}
+
+func TestSlurpThrottle(t *testing.T) {
+	sender := &recordingSender{}
+	esc := make(chan Transaction)
+	done := make(chan bool)
+	go func() {
+		Slurp(sender, esc, SlurpConfig{Linger: 10 * time.Millisecond, Throttle: NewThrottle(20)})
+		close(done)
+	}()
+
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		esc <- &timedEntry{rawEntry{"index", "testing", "user", strconv.Itoa(i), map[string]interface{}{"n": i}}}
+	}
+	esc <- nil
+	<-done
+	// A second worth right away, the other 10 at 20 per second
+	if took := time.Since(start); took < 400*time.Millisecond || took > 2*time.Second {
+		t.Error("Expected about half a second, took", took)
+	}
+	var sent int
+	for _, body := range sender.sent {
+		sent += strings.Count(body, `{"index":`)
+	}
+	if sent != 30 {
+		t.Error("Expected every entry to be sent, got", sent)
+	}
+}
+
+func TestSlurpThrottleOncePerBody(t *testing.T) {
+	throttle := NewThrottle(10)
+	sender := &unreachableSender{failures: 3}
+	esc := make(chan Transaction)
+	done := make(chan bool)
+	go func() {
+		Slurp(sender, esc, SlurpConfig{Linger: 10 * time.Millisecond, Throttle: throttle})
+		close(done)
+	}()
+
+	before := stats.ThrottledMs.Value()
+	for i := 0; i < 10; i++ {
+		esc <- &timedEntry{rawEntry{"index", "testing", "user", strconv.Itoa(i), map[string]interface{}{"n": i}}}
+	}
+	for deadline := time.Now().Add(5 * time.Second); sender.count() == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(esc)
+	<-done
+	if n := sender.count(); n != 1 {
+		t.Fatal("Expected the body to be sent once ES was reachable, got", n)
+	}
+	// A second worth of entries takes all the tokens, taking them again for each retry waits a second
+	if waited := stats.ThrottledMs.Value() - before; waited != 0 {
+		t.Error("Expected the body to take its tokens once, waited ms", waited)
+	}
+}
@@ -0,0 +1,46 @@
+package elasticsearch
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+// ErrInvalidCACert is returned by TLSConfig when the CA file holds no certificates.
+var ErrInvalidCACert = errors.New("No certificates found in CA file")
+
+// TLSConfig loads the TLS configuration for an elasticsearch behind TLS, verified against the
+// certificates in caFile or the system roots if empty. For mutual TLS the Client presents the
+// certificate and key in the PEM files certFile and keyFile, as none if both are empty. Everything
+// is loaded right away for bad files to fail on startup rather than on the first request.
+func TLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, ErrInvalidCACert
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// WithTLS makes the Client connect to elasticsearch with config, such as one of TLSConfig. It's
+// only used by the transport of the Client itself, an http.Client given by WithHTTPClient is used
+// as it is and needs to be configured for TLS on its own.
+func WithTLS(config *tls.Config) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
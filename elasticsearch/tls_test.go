@@ -0,0 +1,122 @@
+package elasticsearch
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeClientCert writes a self-signed client certificate and its key to dir.
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "cryriver"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "client.crt")
+	keyFile = filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestWithTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cryriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var presented string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			presented = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		w.Write([]byte(`{"status":"green","number_of_nodes":1}`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := writeClientCert(t, dir)
+
+	config, err := TLSConfig(caFile, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClient(server.URL, 1, WithTLS(config)).Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if presented != "cryriver" {
+		t.Error("Expected the client certificate to be presented, got", presented)
+	}
+
+	// Without the CA the server isn't trusted
+	config, err = TLSConfig("", certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClient(server.URL, 1, WithTLS(config)).Ping(context.Background()); err == nil {
+		t.Error("Expected an unknown CA to fail")
+	}
+}
+
+func TestTLSConfigInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cryriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeClientCert(t, dir)
+
+	if _, err := TLSConfig(certFile, "", ""); err != nil {
+		t.Error("Expected a certificate to be a valid CA file, got", err)
+	}
+	if _, err := TLSConfig(keyFile, "", ""); err != ErrInvalidCACert {
+		t.Error("Expected ErrInvalidCACert, got", err)
+	}
+	if _, err := TLSConfig("", certFile, certFile); err == nil {
+		t.Error("Expected a certificate without its key to fail")
+	}
+	if _, err := TLSConfig("", certFile, ""); err == nil {
+		t.Error("Expected a certificate without a key file to fail")
+	}
+	if _, err := TLSConfig(filepath.Join(dir, "missing.crt"), "", ""); err == nil {
+		t.Error("Expected a missing CA file to fail")
+	}
+}
@@ -0,0 +1,67 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+)
+
+// Tracer starts spans around bulk requests, such as an adapter to OpenTelemetry. The returned
+// context carries the span to whatever is traced within it.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is one traced bulk request.
+type Span interface {
+	SetAttribute(key string, value interface{})
+
+	// SetError marks the span as failed by err.
+	SetError(err error)
+
+	End()
+}
+
+// WithTracer makes the Client trace each bulk request as a span started from the context given to
+// BulkSendContext, there is no tracing by default.
+func WithTracer(t Tracer) ClientOption {
+	return func(c *Client) {
+		c.tracer = t
+	}
+}
+
+// traceBulk starts a span for sending the bulk body b, the returned func ends it with the outcome.
+// Nothing is traced without a Tracer.
+func (c *Client) traceBulk(ctx context.Context, b *BulkBody) (context.Context, func(status int, err error)) {
+	if c.tracer == nil {
+		return ctx, func(int, error) {}
+	}
+	ctx, span := c.tracer.Start(ctx, "elasticsearch.bulk")
+	span.SetAttribute("bulk.size", b.Len())
+
+	entries, _ := b.Entries()
+	span.SetAttribute("bulk.operations", len(entries))
+	indexes := make(map[string]bool)
+	for _, entry := range entries {
+		var header map[string]indexHeader
+		if err := json.Unmarshal(entry.Header, &header); err == nil {
+			indexes[header[entry.Action].Name] = true
+		}
+	}
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	span.SetAttribute("bulk.indexes", names)
+
+	return ctx, func(status int, err error) {
+		if status != 0 {
+			span.SetAttribute("http.status_code", status)
+		}
+		if err != nil {
+			span.SetError(err)
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,73 @@
+package elasticsearch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// recordingSpan keeps what was set on it.
+type recordingSpan struct {
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+func (s *recordingSpan) SetAttribute(key string, value interface{}) {
+	s.attributes[key] = value
+}
+
+func (s *recordingSpan) SetError(err error) {
+	s.err = err
+}
+
+func (s *recordingSpan) End() {
+	s.ended = true
+}
+
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &recordingSpan{attributes: make(map[string]interface{})}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestTracer(t *testing.T) {
+	status := 200
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	tracer := &recordingTracer{}
+	client := NewClient(server.URL, 1, WithTracer(tracer), WithRetries(0))
+	for _, status = range []int{200, 400} {
+		bulk := NewBulkBody(MB)
+		bulk.Add(&rawEntry{"index", "users", "user", "1", map[string]interface{}{"foo": "bar"}})
+		bulk.Add(&rawEntry{"delete", "photos", "photo", "2", nil})
+		client.BulkSendContext(context.Background(), bulk)
+	}
+
+	if len(tracer.spans) != 2 {
+		t.Fatal("Expected a span per bulk request, got", len(tracer.spans))
+	}
+	ok, failed := tracer.spans[0], tracer.spans[1]
+	if !ok.ended || ok.err != nil {
+		t.Error("Expected successful span to end without error", ok)
+	}
+	if ok.attributes["bulk.operations"] != 2 || ok.attributes["http.status_code"] != 200 {
+		t.Error("Unexpected attributes", ok.attributes)
+	}
+	if indexes := ok.attributes["bulk.indexes"]; !reflect.DeepEqual(indexes, []string{"photos", "users"}) {
+		t.Error("Unexpected indexes", indexes)
+	}
+	if !failed.ended || failed.err == nil {
+		t.Error("Expected failed batch to record an error", failed)
+	}
+}
@@ -0,0 +1,18 @@
+package elasticsearch
+
+// Upserter is optionally implemented by entries to tell if an update creates the document when
+// it isn't indexed, which is what updates do otherwise. Updates not upserting only change documents
+// already indexed and are rejected by ES with a document_missing_exception for others, such as
+// partial updates that must never create a document of the fields they set alone.
+type Upserter interface {
+	Upsert() bool
+}
+
+// upserts tells if an update by v creates the document when it isn't indexed.
+func upserts(v BulkEntry) bool {
+	var upserter Upserter
+	if entryAs(v, &upserter) {
+		return upserter.Upsert()
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package elasticsearch
+
+import (
+	"testing"
+)
+
+// updateOnlyEntry is an update that must not create the document.
+type updateOnlyEntry struct {
+	rawEntry
+	upsert bool
+}
+
+func (e *updateOnlyEntry) Upsert() bool {
+	return e.upsert
+}
+
+func TestBulkBodyUpsert(t *testing.T) {
+	bulk := NewBulkBody(MB)
+	entries := []BulkEntry{
+		&updateOnlyEntry{rawEntry{"update", "testing", "user", "1", map[string]interface{}{"v": 1}}, false},
+		&updateOnlyEntry{rawEntry{"update", "testing", "user", "2", map[string]interface{}{"v": 2}}, true},
+		&scriptedEntry{rawEntry{"update", "testing", "user", "3", map[string]interface{}{"v": 3}}, "increment", nil},
+	}
+	for _, entry := range entries {
+		if err := bulk.Add(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := `{"update":{"_index":"testing","_type":"user","_id":"1"}}
+{"doc":{"v":1}}
+{"update":{"_index":"testing","_type":"user","_id":"2"}}
+{"doc":{"v":2},"doc_as_upsert":true}
+{"update":{"_index":"testing","_type":"user","_id":"3"}}
+{"script":{"id":"increment"},"upsert":{"v":3}}
+`
+	if body := bulk.String(); body != expected {
+		t.Error("Unexpected body", body)
+	}
+}
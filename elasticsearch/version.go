@@ -0,0 +1,165 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// OpenSearch is the distribution reported in the version of OpenSearch clusters.
+const OpenSearch = "opensearch"
+
+// ClusterVersion is the release of elasticsearch a cluster runs, such as 7.10.2.
+type ClusterVersion struct {
+	Major, Minor, Patch int
+
+	// OpenSearch for OpenSearch clusters, which number their releases from 1, empty for
+	// elasticsearch
+	Distribution string
+}
+
+// ParseClusterVersion parses the version number reported by elasticsearch, trailing qualifiers such
+// as -SNAPSHOT are ignored.
+func ParseClusterVersion(s string) (ClusterVersion, error) {
+	var v ClusterVersion
+	parts := strings.SplitN(strings.SplitN(s, "-", 2)[0], ".", 3)
+	for i, n := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		if i >= len(parts) {
+			break
+		}
+		var err error
+		if *n, err = strconv.Atoi(parts[i]); err != nil {
+			return ClusterVersion{}, fmt.Errorf("Invalid elasticsearch version %q", s)
+		}
+	}
+	return v, nil
+}
+
+func (v ClusterVersion) String() string {
+	if v.Distribution != "" {
+		return fmt.Sprintf("%s %d.%d.%d", v.Distribution, v.Major, v.Minor, v.Patch)
+	}
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Typeless tells if the cluster does without mapping types. They are deprecated from 7 and
+// removed in 8, which rejects bulk headers with a _type. OpenSearch forked from 7.10 and removed
+// them in 2, every release of it is typeless.
+func (v ClusterVersion) Typeless() bool {
+	return v.Distribution == OpenSearch || v.Major >= 7
+}
+
+// BulkOptions returns what bulk bodies sent to the cluster need to be created with.
+func (v ClusterVersion) BulkOptions() []BulkOption {
+	if v.Typeless() {
+		return []BulkOption{Typeless()}
+	}
+	return nil
+}
+
+// WithClusterVersion makes DetectVersion fall back to v when the cluster can't tell its version,
+// such as when a proxy in front of it doesn't allow requests to /. Together with WithOpenSearch, v
+// is taken to be a release of OpenSearch.
+func WithClusterVersion(v ClusterVersion) ClientOption {
+	return func(c *Client) {
+		c.fallbackVersion = &v
+	}
+}
+
+// DetectVersion asks the cluster for its version, which is then cached by the Client for the bulk
+// bodies of Slurp and Index to be typeless on clusters that need it. Bodies created by NewBulkBody
+// for other uses needs the BulkOptions of the version. If the version can't be detected, the one
+// given by WithClusterVersion is used, otherwise the error is returned. Call it before starting to
+// slurp, bodies already created are not changed.
+func (c *Client) DetectVersion(ctx context.Context) (ClusterVersion, error) {
+	v, err := c.clusterVersion(ctx)
+	if err != nil {
+		if c.fallbackVersion == nil {
+			return v, err
+		}
+		v = *c.fallbackVersion
+		if c.openSearch {
+			v.Distribution = OpenSearch
+		}
+	}
+	c.versionLock.Lock()
+	c.version = &v
+	c.versionLock.Unlock()
+	return v, nil
+}
+
+// clusterVersion reads the version number of the cluster from its root.
+func (c *Client) clusterVersion(ctx context.Context) (ClusterVersion, error) {
+	resp, body, err := c.do(ctx, "GET", "/", "", nil)
+	if err != nil {
+		return ClusterVersion{}, err
+	}
+	if code := resp.StatusCode; code != 200 {
+		return ClusterVersion{}, parseError(code, body)
+	}
+	var root struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}
+	if err := json.Unmarshal(body, &root); err != nil {
+		return ClusterVersion{}, err
+	}
+	v, err := ParseClusterVersion(root.Version.Number)
+	if err != nil {
+		return v, err
+	}
+	if root.Version.Distribution == OpenSearch {
+		v.Distribution = OpenSearch
+		return v, nil
+	}
+	if c.openSearch {
+		log.Println("Cluster was expected to be OpenSearch but claims to be elasticsearch", v)
+		v.Distribution = OpenSearch
+		return v, nil
+	}
+	// Like the official clients, make sure it's elasticsearch answering from the version it tells
+	if (v.Major > 7 || v.Major == 7 && v.Minor >= 14) && resp.Header.Get("X-Elastic-Product") != "Elasticsearch" {
+		log.Println("Cluster claims to be elasticsearch", v, "but doesn't send X-Elastic-Product, it may be something compatible")
+	}
+	return v, nil
+}
+
+// typeless tells if the version detected by DetectVersion is typeless.
+func (c *Client) typeless() bool {
+	c.versionLock.Lock()
+	defer c.versionLock.Unlock()
+	return c.version != nil && c.version.Typeless()
+}
+
+// Typeless makes the BulkBody leave out the _type of every entry, for clusters without mapping
+// types.
+func Typeless() BulkOption {
+	return func(bulk *BulkBody) {
+		bulk.typeless = true
+	}
+}
+
+// WithOpenSearch makes the Client index into OpenSearch rather than elasticsearch. Bulk requests
+// are the same for both, the differences the Client adjusts to are:
+//
+// The root of OpenSearch reports a distribution of opensearch besides a version number of its own,
+// 1 and 2 being forks of elasticsearch 7.10. DetectVersion takes any version found, as well as the
+// one of WithClusterVersion, to be OpenSearch and therefore typeless.
+//
+// OpenSearch never sends X-Elastic-Product, which isn't expected of it.
+//
+// The compatible-with media types of elasticsearch 8 are unknown to OpenSearch, WithCompatibility
+// is ignored and plain JSON is sent.
+//
+// Clusters reporting the opensearch distribution are recognized by DetectVersion without it, but
+// only for what the root tells.
+func WithOpenSearch() ClientOption {
+	return func(c *Client) {
+		c.openSearch = true
+	}
+}
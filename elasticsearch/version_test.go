@@ -0,0 +1,140 @@
+package elasticsearch
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseClusterVersion(t *testing.T) {
+	for s, expected := range map[string]ClusterVersion{
+		"6.8.23":         {Major: 6, Minor: 8, Patch: 23},
+		"7.10.2":         {Major: 7, Minor: 10, Patch: 2},
+		"8.0.0-SNAPSHOT": {Major: 8},
+		"1.7":            {Major: 1, Minor: 7},
+	} {
+		if v, err := ParseClusterVersion(s); err != nil || v != expected {
+			t.Error("Expected", expected, "from", s, "got", v, err)
+		}
+	}
+	if _, err := ParseClusterVersion("unknown"); err == nil {
+		t.Error("Expected an error for an invalid version")
+	}
+}
+
+func TestDetectVersion(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Write([]byte(`{"name":"node-1","cluster_name":"es","version":{"number":"8.5.1","lucene_version":"9.4.1"},"tagline":"You Know, for Search"}`))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1)
+	v, err := client.DetectVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != (ClusterVersion{Major: 8, Minor: 5, Patch: 1}) {
+		t.Error("Unexpected version", v)
+	}
+	if err := client.Index(context.Background(), &rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(bodies) != 1 || !strings.HasPrefix(bodies[0], `{"index":{"_index":"testing","_id":"1"}}`) {
+		t.Error("Expected a typeless header, got", bodies)
+	}
+}
+
+func TestDetectVersionFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(403)
+		w.Write([]byte(`{"error":{"type":"security_exception","reason":"action [cluster:monitor/main] is unauthorized"},"status":403}`))
+	}))
+	defer server.Close()
+
+	if _, err := NewClient(server.URL, 1).DetectVersion(context.Background()); err == nil {
+		t.Error("Expected an error without a fallback")
+	}
+	client := NewClient(server.URL, 1, WithClusterVersion(ClusterVersion{Major: 6}))
+	if v, err := client.DetectVersion(context.Background()); err != nil || v.Major != 6 {
+		t.Error("Expected the fallback version, got", v, err)
+	}
+	if client.typeless() {
+		t.Error("Expected 6 to keep types")
+	}
+}
+
+func TestDetectVersionOpenSearch(t *testing.T) {
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			// Root of OpenSearch 2.11.0, as recorded
+			w.Write([]byte(`{
+  "name" : "opensearch-node1",
+  "cluster_name" : "opensearch-cluster",
+  "cluster_uuid" : "HyWmvDfKQxOd7aUm3aFynQ",
+  "version" : {
+    "distribution" : "opensearch",
+    "number" : "2.11.0",
+    "build_type" : "tar",
+    "build_hash" : "4dcad6dd1fd45b6bd91f041a041829c8687278fa",
+    "build_date" : "2023-10-13T02:55:55.511945994Z",
+    "build_snapshot" : false,
+    "lucene_version" : "9.7.0",
+    "minimum_wire_compatibility_version" : "7.10.0",
+    "minimum_index_compatibility_version" : "7.0.0"
+  },
+  "tagline" : "The OpenSearch Project: https://opensearch.org/"
+}`))
+			return
+		}
+		headers = append(headers, r.Header)
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1, WithOpenSearch(), WithCompatibility(7))
+	v, err := client.DetectVersion(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != (ClusterVersion{Major: 2, Minor: 11, Distribution: OpenSearch}) {
+		t.Error("Unexpected version", v)
+	}
+	if !client.typeless() {
+		t.Error("Expected OpenSearch to be typeless")
+	}
+	if err := client.Index(context.Background(), &rawEntry{"index", "testing", "user", "1", map[string]interface{}{"foo": "bar"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 1 || headers[0].Get("Accept") != "" || strings.Contains(headers[0].Get("Content-Type"), "compatible-with") {
+		t.Error("Expected plain media types towards OpenSearch, got", headers)
+	}
+
+	// Recognized by the root alone
+	if v, err := NewClient(server.URL, 1).DetectVersion(context.Background()); err != nil || v.Distribution != OpenSearch {
+		t.Error("Expected OpenSearch to be detected, got", v, err)
+	}
+}
+
+func TestDetectVersionOpenSearchFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(403)
+		w.Write([]byte(`{"error":{"type":"security_exception","reason":"no permissions for [cluster:monitor/main]"},"status":403}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, 1, WithOpenSearch(), WithClusterVersion(ClusterVersion{Major: 1, Minor: 3}))
+	if v, err := client.DetectVersion(context.Background()); err != nil || v.Distribution != OpenSearch || !v.Typeless() {
+		t.Error("Expected the fallback to be typeless OpenSearch, got", v, err)
+	}
+}
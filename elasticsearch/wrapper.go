@@ -0,0 +1,30 @@
+package elasticsearch
+
+import (
+	"reflect"
+)
+
+// Wrapper is optionally implemented by entries wrapping another one, such as to give it a time or a
+// sequence of its own. The optional interfaces of entries, such as StoredScripter or Versioner, are
+// looked up on the wrapped entry when the wrapper doesn't implement them itself.
+type Wrapper interface {
+	Unwrap() BulkEntry
+}
+
+// entryAs sets target, a pointer to an optional interface, to the first of v and the entries it
+// wraps implementing it, like errors.As does for errors. Returns false if none does.
+func entryAs(v BulkEntry, target interface{}) bool {
+	value := reflect.ValueOf(target).Elem()
+	for v != nil {
+		if reflect.TypeOf(v).Implements(value.Type()) {
+			value.Set(reflect.ValueOf(v))
+			return true
+		}
+		wrapper, ok := v.(Wrapper)
+		if !ok {
+			return false
+		}
+		v = wrapper.Unwrap()
+	}
+	return false
+}
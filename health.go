@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/duego/cryriver/elasticsearch"
+	"github.com/duego/cryriver/mongodb"
+	"github.com/duego/cryriver/stats"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// health keeps track of how the river is doing for the /healthz and /readyz endpoints.
+type health struct {
+	sync.Mutex
+	tailing bool
+	lastOp  *time.Time
+
+	// Last time ES answered a probe, and why the last probe failed if it did
+	esSeen  time.Time
+	esError string
+
+	maxLag time.Duration
+	esDown time.Duration
+}
+
+// healthStatus is reported as json by the endpoints.
+type healthStatus struct {
+	Tailing  bool       `json:"tailing"`
+	LastOp   *time.Time `json:"last_op,omitempty"`
+	Lag      string     `json:"lag,omitempty"`
+	LastBulk *time.Time `json:"last_bulk,omitempty"`
+	ESSeen   *time.Time `json:"es_seen,omitempty"`
+	ESError  string     `json:"es_error,omitempty"`
+	Ready    bool       `json:"ready"`
+	NotReady string     `json:"not_ready,omitempty"`
+}
+
+func newHealth(maxLag, esDown time.Duration) *health {
+	// Give ES the full window to answer the first probe
+	return &health{maxLag: maxLag, esDown: esDown, esSeen: time.Now()}
+}
+
+func (h *health) setTailing(tailing bool) {
+	h.Lock()
+	defer h.Unlock()
+	h.tailing = tailing
+}
+
+// processed records the timestamp of the last operation handed off towards ES.
+func (h *health) processed(ts *mongodb.Timestamp) {
+	h.Lock()
+	defer h.Unlock()
+	h.lastOp = ts.Time()
+}
+
+// probe checks that ES answers every interval until exit is closed.
+func (h *health) probe(client *elasticsearch.Client, interval time.Duration, exit chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		_, err := client.Ping(ctx)
+		cancel()
+		h.Lock()
+		if err == nil {
+			h.esSeen = time.Now()
+			h.esError = ""
+		} else {
+			h.esError = err.Error()
+		}
+		h.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-exit:
+			return
+		}
+	}
+}
+
+func (h *health) status() healthStatus {
+	h.Lock()
+	defer h.Unlock()
+	now := time.Now()
+	s := healthStatus{Tailing: h.tailing, LastOp: h.lastOp, ESError: h.esError, Ready: true}
+	esSeen := h.esSeen
+	s.ESSeen = &esSeen
+	if last := stats.LastBulk.Value(); last > 0 {
+		t := time.Unix(last, 0)
+		s.LastBulk = &t
+	}
+
+	// Quiet namespaces will look like they are lagging as lag is measured from the last operation
+	var lag time.Duration
+	if h.lastOp != nil {
+		lag = now.Sub(*h.lastOp)
+		s.Lag = lag.String()
+	}
+
+	switch {
+	case !h.tailing:
+		s.NotReady = "Oplog is not being tailed"
+	case h.maxLag > 0 && h.lastOp != nil && lag > h.maxLag:
+		s.NotReady = "Oplog lag exceeds " + h.maxLag.String()
+	case h.esDown > 0 && now.Sub(h.esSeen) > h.esDown:
+		s.NotReady = "Elasticsearch has been unreachable for more than " + h.esDown.String()
+	}
+	s.Ready = s.NotReady == ""
+	return s
+}
+
+// ServeHTTP reports the status, /healthz is only failing when the oplog isn't tailed anymore
+// while /readyz also fails when lagging behind or ES can't be reached.
+func (h *health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := h.status()
+	ok := s.Ready
+	if r.URL.Path == "/healthz" {
+		ok = s.Tailing
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(s)
+}
+
+// serveHealth listens on addr for the health endpoints.
+func serveHealth(addr string, h *health) {
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", h)
+	mux.Handle("/readyz", h)
+	log.Println(http.ListenAndServe(addr, mux))
+}
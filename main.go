@@ -2,11 +2,12 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"flag"
-	"fmt"
 	"github.com/duego/cryriver/elasticsearch"
 	"github.com/duego/cryriver/mongodb"
-	"labix.org/v2/mgo"
+	"github.com/duego/cryriver/redact"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
@@ -22,13 +23,85 @@ import (
 var (
 	mongoServer   = flag.String("mongo", "localhost", "Specific server to tail")
 	mongoInitial  = flag.Bool("initial", false, "True if we want to force initial sync from the full collection, otherwise resume reading oplog if possible")
+	mongoUser     = flag.String("mongouser", "", "User to authenticate to MongoDB as, the password is read from $CRYRIVER_MONGO_PASSWORD unless given")
+	mongoPassword = flag.String("mongopassword", "", "Password of mongouser, prefer $CRYRIVER_MONGO_PASSWORD to keep it out of the process list")
+	mongoAuthDb   = flag.String("authsource", "", "Database mongouser is defined in, defaults to the database of the mongo URL or admin")
+	mongoTLS      = flag.Bool("mongotls", false, "Connect to MongoDB with TLS")
+	mongoCA       = flag.String("mongoca", "", "File with CA certificates to verify MongoDB with when using TLS, the system roots are used otherwise")
+	mongoReadPref = flag.String("readpref", "primary", "Read preference towards MongoDB: primary, secondaryPreferred or nearest")
 	mongoTimeout  = flag.Int("timeout", 1, "Minutes to wait before timing out reading operations from MongoDB")
 	esServer      = flag.String("es", "http://localhost:9200", "Elasticsearch server to index to")
+	esMirror      = flag.String("mirror", "", "Comma separated ES nodes of other clusters to also send every bulk request to, such as while migrating")
+	esMirrorAcks  = flag.String("mirroracks", "all", "Clusters that have to accept an operation before the saved timestamp moves past it with -mirror: all or quorum")
 	esConcurrency = flag.Int("concurrency", 1, "Maximum number of simultaneous ES connections")
+	esPartition   = flag.Bool("partition", false, "Send all operations of a document through the same connection, keeping them in order with a concurrency above 1")
+	loadSample    = flag.Float64("sample", 0, "Load testing only: fraction of documents to index, such as 0.1, skipping the rest")
+	loadRate      = flag.Int("ratelimit", 0, "Load testing only: most operations per second to index, 0 for no limit")
+	esMaxRate     = flag.Int("maxrate", 0, "Most documents per second to send to ES, smoothing out bursts to stay under the indexing rate of a shared cluster, 0 for no limit")
+	esLinger      = flag.Duration("linger", elasticsearch.DefaultLinger, "Longest time to wait for more operations before sending a bulk request")
+	esInFlight    = byteSize("inflight", 0, "Maximum size of bulk requests in flight towards ES across all connections, such as 512MB, 0 for no limit")
+	esOversize    = byteSize("oversize", 0, "Maximum size of a document too large for a bulk request to send on its own, such as 20MB, 0 to batch it like others")
 	esIndex       = flag.String("index", "testing", "Elasticsearch index to use")
+	esVerbose     = flag.Bool("verbose", false, "Log every request sent to ES, without request and response bodies")
+	esMapping     = flag.String("mapping", "", "File with mapping and settings to create the index with if it doesn't exist")
+	esVersion     = flag.String("esversion", "", "Elasticsearch version to assume if the cluster can't tell, such as 7.10.2")
+	esCompatible  = flag.Int("compatible", 0, "Major version of the ES REST API to ask for, such as 7 to index into 8 as into 7, 0 for the API of the cluster")
+	esOpenSearch  = flag.Bool("opensearch", false, "Index into OpenSearch rather than elasticsearch")
+	esCA          = flag.String("esca", "", "File with CA certificates to verify ES with, the system roots are used otherwise")
+	esCert        = flag.String("escert", "", "File with a client certificate to present to ES for mutual TLS, along with -eskey")
+	esKey         = flag.String("eskey", "", "File with the key of the client certificate given by -escert")
+	esGzip        = flag.Bool("gzip", false, "Compress requests towards ES with gzip")
+	esGzipLevel   = flag.Int("gziplevel", gzip.DefaultCompression, "Level of gzip compression from 1, fastest, to 9, smallest, or -1 for the default")
+	esNewline     = flag.Bool("exactnewline", false, "Trim every bulk request to end with exactly one newline before sending it")
+	esDebugBulk   = flag.Int("debugbulk", 0, "Log up to this many bytes of bulk requests refused by ES, with the redact fields masked, 0 to not log them")
+	esTimeout     = flag.Duration("estimeout", 0, "Longest time for each request towards ES, every retry of a bulk request gets its own, 0 for no limit")
+	esBreaker     = flag.Int("breaker", 0, "Consecutive failed bulk requests before pausing requests towards ES, 0 to never pause")
+	esCooldown    = flag.Duration("cooldown", 30*time.Second, "How long to pause requests towards ES once the breaker has opened")
+	esSpool       = flag.String("spool", "", "Directory to keep bulk requests in while ES is unavailable, to send them once it has recovered")
+	esSpoolMax    = byteSize("spoolmax", elasticsearch.GB, "Maximum size of what is kept in the spool directory, such as 10GB")
+	esCheckAlias  = flag.Bool("checkalias", false, "Verify that indexes which are aliases have a single write index before starting")
+	esScripts     = flag.String("storescript", "", "Comma separated id=file of painless scripts to store in ES on start, for hooks updating by stored scripts")
+	esReqAlias    = flag.Bool("requirealias", false, "Only write to indexes that are aliases, ES rejects writes to any other index rather than creating it")
+	esIdFields    = flag.String("idfields", "", "Comma separated fields to hash into an id for documents without an _id, such as in capped collections")
+	esAction      = flag.String("action", "", "Bulk action to use for every operation regardless of its kind, such as create for backfills")
+	esIndexedAt   = flag.String("indexedat", "", "Field to set to the time documents are sent to ES, such as @indexed_at, empty for none")
+	esForceIdxAt  = flag.Bool("forceindexedat", false, "Replace the indexedat field also in documents that already have it")
+	esRetention   = flag.Duration("retention", 0, "How long documents are kept before ILM deletes them, routes them into time-bucketed indexes by retentionfield when set")
+	esRetField    = flag.String("retentionfield", "created_at", "Field holding the time documents are bucketed and expired by with retention")
+	esTargets     = flag.String("target", "", "Comma separated namespaces to index elsewhere, like mydb.users=users-v2 or mydb.users=users-v2/user to also set the type")
+	esAuditIndex  = flag.String("audit", "", "Elasticsearch index to also record deletes in, empty for no audit")
+	tailNoops     = flag.Bool("noops", true, "Move the oplog checkpoint on the noop entries MongoDB writes as heartbeats, so that idle collections doesn't fall behind")
 	optimeStore   = flag.String("db", "/tmp/cryriver.db", "What file to save progress on for oplog resumes")
-	ns            = flag.String("ns", "api.users", "The namespace to tail on")
+	mongoSource   = flag.String("source", "oplog", "Where to read changes from, oplog or changestream which also works through mongos")
+	fullDocument  = flag.String("fulldocument", "", "Comma separated namespaces or patterns to index whole documents for on change stream updates, rather than the changed fields")
+	tokenStore    = flag.String("tokendb", "/tmp/cryriver.token", "What file to save progress on for change stream resumes")
+	optimeDefault = flag.Int64("dbfallback", 0, "Oplog timestamp to resume from if the progress file is corrupt, 0 for an initial import")
+	ns            = flag.String("ns", "api.users", "Comma separated namespaces to tail on, may be patterns like app_*.users")
+	nsExclude     = flag.String("exclude", "", "Comma separated namespaces or patterns to not tail on, takes precedence over -ns")
+	nsCoalesce    = flag.String("coalesce", "", "Comma separated namespaces or patterns to only index the last state of documents for within each bulk request")
+	maxFields     = flag.Int("maxfields", 0, "Maximum number of fields in a document, 0 for no limit")
+	maxDepth      = flag.Int("maxdepth", mongodb.MaxDepth, "Maximum nesting of documents and arrays, deeper documents are handled by onmarshal")
+	staticFields  = flag.String("static", "", "Comma separated fields to set in every document, like source=cryriver,env=prod")
+	staticWins    = flag.Bool("staticwins", false, "Let static fields replace fields of the same name in documents rather than keep them")
+	renameFields  = flag.String("rename", "", "Comma separated fields to index under another name, as path=name such as _class=class or profile._tmp=tmp")
+	dotKeys       = flag.Bool("dotkeys", false, "Replace dots in field names with underscores, rather than letting ES take them for sub-documents")
+	flattenKeys   = flag.String("flatten", "", "Comma separated sub-documents with dynamic keys to index as key and value pairs, as namespace=path such as mydb.users=stats.byUser")
+	flattenDepth  = flag.String("flattendepth", "", "Comma separated namespace=depth to flatten objects nested deeper than depth into keys joined by -flattensep, such as mydb.logs=5")
+	flattenSep    = flag.String("flattensep", "_", "Separator joining the keys of objects flattened by -flattendepth")
+	wherePreds    = flag.String("where", "", "Comma separated namespace=path:value to only index documents with the field equal to value, or namespace=path to require it to be set, deleting the others")
+	softDelete    = flag.String("softdelete", "", "Field to set to true on documents deleted from MongoDB instead of deleting them from ES, empty to delete them")
+	injectTs      = flag.String("timestamp", "", "Field to set to the time of the operation in documents lacking it, such as @timestamp, empty for none")
+	validateOnly  = flag.Bool("validate", false, "Check the configuration and connectivity towards MongoDB and ES, reporting every problem found, and exit")
+	onMarshal     = flag.String("onmarshal", "skip", "What to do with documents that can't be marshaled into JSON or fail -schema: skip, deadletter or fail")
+	schemaFiles   = flag.String("schema", "", "Comma separated namespace=file of JSON Schemas to validate documents against before indexing, such as mydb.users=users.schema.json")
+	replayFile    = flag.String("replay", "", "Dead-letter file or saved bulk body to send to ES again and exit, instead of tailing")
+	replayFailed  = flag.String("replayfailed", "", "File to save what still fails to replay to, in the format it was read in")
+	deadLetter    = flag.String("deadletter", "", "File to save documents that can't be indexed to, otherwise they are dropped or trimmed")
+	redactPaths   = flag.String("redact", "", "Comma separated field paths to mask in logs and errors, such as email,address.street")
 	debugAddr     = flag.String("debug", "127.0.0.1:5000", "Which address to listen on for debug, empty for no debug")
+	healthAddr    = flag.String("health", "", "Which address to serve /healthz and /readyz on, empty for no health endpoints")
+	healthLag     = flag.Duration("maxlag", 5*time.Minute, "Longest oplog lag before /readyz fails, 0 to never fail on lag")
+	healthEsDown  = flag.Duration("esdown", time.Minute, "Longest time ES may be unreachable before /readyz fails, 0 to never fail on it")
 	numCpu        = flag.Int("cpu", 0, "Maximum number of parallell tasks to do, defaults to number of available CPUs")
 )
 
@@ -40,6 +113,9 @@ func main() {
 	}
 	flag.Parse()
 	log.SetFlags(log.Lshortfile | log.LstdFlags)
+	if *redactPaths != "" {
+		redact.Paths = strings.Split(*redactPaths, ",")
+	}
 
 	// Enable http server for debug endpoint
 	go func() {
@@ -51,30 +127,216 @@ func main() {
 	interrupt := make(chan os.Signal)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
-	mgoSession, err := mgo.DialWithTimeout(*mongoServer+"?connect=direct", time.Duration(*mongoTimeout)*time.Minute)
+	mongoc := make(chan *mongodb.Operation)
+	mongoErr := make(chan error)
+	exit := make(chan bool)
+	// Closed once the slurpers have returned, to save the last checkpoint of what they sent
+	slurped := make(chan bool)
+	checkpointed := make(chan bool)
+
+	// Validating only reports what is wrong with the configuration, without starting the river
+	if *validateOnly {
+		if errs := validate(); len(errs) > 0 {
+			for _, err := range errs {
+				log.Println(err)
+			}
+			os.Exit(1)
+		}
+		log.Println("Configuration is valid")
+		return
+	}
+
+	filter, err := namespaceFilter()
+	if err != nil {
+		log.Fatal(err)
+	}
+	mongodb.TailNoops = *tailNoops
+	mongodb.MaxDepth = *maxDepth
+	if mongodb.Coalesce, err = coalesceFilter(); err != nil {
+		log.Fatal(err)
+	}
+	targets, err := parseTargets(*esTargets)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for namespace, target := range targets {
+		mongodb.Targets[namespace] = target
+	}
+	options, err := clientOptions()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Replaying only needs to know where operations are indexed, not MongoDB
+	if *replayFile != "" {
+		client := elasticsearch.NewClient(*esServer, *esConcurrency, options...)
+		detectVersion(client)
+		failed, err := replay(client, *replayFile, *replayFailed, indexMap(filter))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	dialConfig := dialConfig()
+	log.Println("Connecting to MongoDB", dialConfig)
+	mgoSession, err := mongodb.Dial(dialConfig)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer mgoSession.Close()
-	mongoc := make(chan *mongodb.Operation)
-	mongoErr := make(chan error)
-	exit := make(chan bool)
+
+	// Changes are read from the oplog or a change stream, each checkpointed in their own way
+	var source mongodb.Source
+	var lag *mongodb.LagMeter
+	var acks *elasticsearch.AckTracker
+	switch *mongoSource {
+	case "oplog":
+		resumed := loadLastEsSeen(mongodb.Timestamp(*optimeDefault))
+		lag = new(mongodb.LagMeter)
+		go lag.WatchHead(mgoSession.Copy(), 10*time.Second, exit)
+		// The checkpoint follows what ES has accepted rather than what has been sent
+		acks = elasticsearch.NewAckTracker(uint64(resumed))
+		go checkpointAcks(acks, lag, time.Second, slurped, checkpointed)
+		source = func(opc chan<- *mongodb.Operation, exit chan bool) error {
+			return mongodb.Tail(mgoSession, filter, *mongoInitial, lastEsSeen, opc, exit)
+		}
+	case "changestream":
+		loadLastToken()
+		lookup, err := lookupFilter()
+		if err != nil {
+			log.Fatal(err)
+		}
+		source = func(opc chan<- *mongodb.Operation, exit chan bool) error {
+			return mongodb.ChangeStream(mgoSession, filter, lookup, *mongoInitial, lastToken, opc, exit)
+		}
+	default:
+		log.Fatal("Unknown source: ", *mongoSource)
+	}
+
+	health := newHealth(*healthLag, *healthEsDown)
 	go func() {
-		mongoErr <- mongodb.Tail(mgoSession, *ns, *mongoInitial, lastEsSeen, mongoc, exit)
+		health.setTailing(true)
+		err := source(mongoc, exit)
+		health.setTailing(false)
+		mongoErr <- err
 	}()
 
+	// Mapping to use for the index the namespace is mapped to
+	mappings, err := loadMappings()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The client will have the transport configured to allow the same amount of connections
+	// as go routines towards ES, each connection may be re-used between slurpers.
+	var spool *elasticsearch.Spool
+	if *esSpool != "" {
+		if spool, err = elasticsearch.NewSpool(*esSpool, *esSpoolMax); err != nil {
+			log.Fatal(err)
+		}
+		options = append(options, elasticsearch.WithSpool(spool))
+	}
+	client := elasticsearch.NewClient(*esServer, *esConcurrency, options...)
+	if spool != nil {
+		go replaySpool(spool, client, exit)
+	}
+	client.Mappings = mappings
+
+	// Fail fast rather than on the first bulk request
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if cluster, err := client.Ping(ctx); err != nil {
+		log.Fatal("Unable to reach ES at ", *esServer, ": ", err)
+	} else if cluster.Status == "red" {
+		log.Fatal("ES cluster health is red, refusing to start")
+	} else {
+		log.Printf("ES cluster is %s with %d nodes and %d active shards", cluster.Status, cluster.NumberOfNodes, cluster.ActiveShards)
+	}
+	cancel()
+	detectVersion(client)
+	if *esCheckAlias {
+		checkAliases(client)
+	}
+	if *esScripts != "" {
+		storeScripts(client)
+	}
+
+	var sender elasticsearch.BulkSender = client
+	if *esMirror != "" {
+		sender = mirrorTo(client, options)
+	}
+
+	if *healthAddr != "" {
+		go health.probe(client, 10*time.Second, exit)
+		go serveHealth(*healthAddr, health)
+	}
+
+	var deadLetters chan mongodb.DeadLetter
+	if *deadLetter != "" {
+		deadLetters = make(chan mongodb.DeadLetter)
+		go saveDeadLetters(*deadLetter, deadLetters)
+	}
+	marshalPolicy := marshalPolicy(*onMarshal, deadLetters)
+
 	esc := make(chan elasticsearch.Transaction)
 	esDone := make(chan bool)
 	go func() {
 		// Boot up our slurpers.
-		// The client will have the transport configured to allow the same amount of connections
-		// as go routines towards ES, each connection may be re-used between slurpers.
-		client := elasticsearch.NewClient(fmt.Sprintf("%s/_bulk", *esServer), *esConcurrency)
+		// Done on exit, for slurpers not to be held up waiting for room in flight
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-exit
+			cancel()
+		}()
+		config := elasticsearch.SlurpConfig{Context: ctx, Linger: *esLinger}
+		if *esMaxRate > 0 {
+			config.Throttle = elasticsearch.NewThrottle(*esMaxRate)
+		}
+		if *esInFlight > 0 {
+			config.InFlight = elasticsearch.NewInFlightLimiter(*esInFlight)
+		}
+		config.BulkOptions = append(config.BulkOptions,
+			elasticsearch.WithMarshalPolicy(marshalPolicy),
+			elasticsearch.WithMarshaler(mongodb.MarshalJSON),
+		)
+		if acks != nil {
+			config.BulkOptions = append(config.BulkOptions, elasticsearch.AckTo(acks))
+		}
+		if *esReqAlias {
+			config.BulkOptions = append(config.BulkOptions, elasticsearch.RequireAlias())
+		}
+		if *esAction != "" {
+			config.BulkOptions = append(config.BulkOptions, elasticsearch.ForceAction(*esAction))
+		}
+		if *esIdFields != "" {
+			config.BulkOptions = append(config.BulkOptions, elasticsearch.WithIdGenerator(elasticsearch.HashFields(strings.Split(*esIdFields, ",")...)))
+		}
+		if *esIndexedAt != "" {
+			config.BulkOptions = append(config.BulkOptions, elasticsearch.WithIndexedAt(*esIndexedAt, *esForceIdxAt))
+		}
+		if *esOversize > 0 {
+			config.BulkOptions = append(config.BulkOptions, elasticsearch.AllowOversizeSingles(*esOversize))
+		}
+		if *esRetention > 0 {
+			config.BulkOptions = append(config.BulkOptions, elasticsearch.WithRetention(elasticsearch.Retention{
+				Duration:       *esRetention,
+				TimestampField: *esRetField,
+			}))
+		}
+		partitions := []chan elasticsearch.Transaction{esc}
+		if *esPartition {
+			partitions = elasticsearch.Partition(esc, *esConcurrency, nil)
+		}
 		var slurpers sync.WaitGroup
 		slurpers.Add(*esConcurrency)
 		for n := 0; n < *esConcurrency; n++ {
+			partition := partitions[n%len(partitions)]
 			go func() {
-				elasticsearch.Slurp(client, esc)
+				elasticsearch.Slurp(sender, partition, config)
 				slurpers.Done()
 			}()
 		}
@@ -82,21 +344,143 @@ func main() {
 		close(esDone)
 	}()
 
+	manipulators := mongodb.DefaultManipulators
+	if *maxFields > 0 {
+		manipulators = append(manipulators, mongodb.FieldCountGuard(*maxFields, deadLetters))
+	}
+	if *renameFields != "" || *dotKeys {
+		renames, err := parseRenames(*renameFields)
+		if err != nil {
+			log.Fatal(err)
+		}
+		manipulators = append(manipulators, mongodb.KeyRewriter(renames, *dotKeys))
+	}
+	if *staticFields != "" {
+		fields, err := parseStatic(*staticFields)
+		if err != nil {
+			log.Fatal(err)
+		}
+		conflict := mongodb.SourceWins
+		if *staticWins {
+			conflict = mongodb.StaticWins
+		}
+		manipulators = append(manipulators, mongodb.StaticFields(fields, conflict))
+	}
+	if *flattenKeys != "" {
+		paths, err := parseFlatten(*flattenKeys)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for ns, nsPaths := range paths {
+			namespaces := mongodb.NamespaceFilter{Include: []string{ns}}
+			manipulators = append(manipulators, mongodb.FlattenKeys(namespaces, nsPaths...))
+		}
+	}
+	if *flattenDepth != "" {
+		depths, err := parseFlattenDepth(*flattenDepth)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for ns, depth := range depths {
+			namespaces := mongodb.NamespaceFilter{Include: []string{ns}}
+			manipulators = append(manipulators, mongodb.Flatten(namespaces, depth, *flattenSep))
+		}
+	}
+	if *injectTs != "" {
+		manipulators = append(manipulators, mongodb.InjectTimestamp(*injectTs))
+	}
+	if *schemaFiles != "" {
+		schemas, err := loadSchemas(*schemaFiles)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Last, to validate documents as they are indexed
+		for ns, schema := range schemas {
+			namespaces := mongodb.NamespaceFilter{Include: []string{ns}}
+			manipulators = append(manipulators, mongodb.ValidateSchema(namespaces, schema))
+		}
+	}
+
+	var transform mongodb.Transform
+	if *esAuditIndex != "" {
+		transform = mongodb.AuditDeletes(*esAuditIndex)
+	}
+	if *wherePreds != "" {
+		predicates, err := parsePredicates(*wherePreds)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transform = mongodb.Where(predicates, transform)
+	}
+	if *softDelete != "" {
+		// Last, to tell the deletes read from MongoDB from the documents left out by the predicates
+		transform = mongodb.SoftDelete(*softDelete, transform)
+	}
+
+	// Load testing skips data and must never be used for production indexes
+	var sampler *elasticsearch.Sampler
+	if *loadSample > 0 {
+		log.Println("LOAD TESTING: only indexing a fraction of", *loadSample, "of the documents")
+		sampler = elasticsearch.NewSampler(*loadSample)
+	}
+	var limiter *elasticsearch.RateLimiter
+	if *loadRate > 0 {
+		log.Println("LOAD TESTING: indexing at most", *loadRate, "operations per second")
+		limiter = elasticsearch.NewRateLimiter(*loadRate)
+	}
+
 	tailDone := make(chan bool)
 	go func() {
-		// Map mongo collections to es index
-		indexes := map[string]string{
-			strings.Split(*ns, ".")[0]: *esIndex,
-		}
+		indexes := indexMap(filter)
+		// Holds the checkpoint back until every part of a transaction has been delivered
+		held := false
+	tail:
 		for op := range mongoc {
 			// Wrap all mongo operations to comply with ES interface, then send them off to the slurper.
-			esOp := mongodb.NewEsOperation(indexes, nil, op)
-			select {
-			case esc <- esOp:
+			esOp := mongodb.NewEsOperation(indexes, manipulators, op)
+			entries, err := transform.Apply(esOp)
+			if err != nil {
+				log.Println(err)
+			}
+			// Operations of the initial import have no timestamp and are checkpointed as they are
+			tracked := acks != nil && op.Timestamp > 0
+			if tracked && !held {
+				if err := acks.Track(uint64(op.Timestamp)); err != nil {
+					log.Println(err)
+				}
+				held = true
+			}
+			for _, entry := range entries {
+				if sampler != nil && !sampler.Keep(entry) {
+					continue
+				}
+				if limiter != nil && !limiter.Wait(exit) {
+					break tail
+				}
+				if sequencer, ok := entry.(elasticsearch.Sequencer); ok && tracked {
+					if err := acks.Track(sequencer.Sequence()); err != nil {
+						log.Println(err)
+					}
+				}
+				select {
+				case esc <- entry:
+				// Abort delivering any pending EsOperations we might block for
+				case <-exit:
+					break tail
+				}
+			}
+			health.processed(&op.Timestamp)
+			// Only move the checkpoint once all entries of the operation are delivered
+			if op.Partial {
+				continue
+			}
+			if op.ResumeToken != nil {
+				lastTokenC <- op.ResumeToken
+			} else if held {
+				acks.Ack(uint64(op.Timestamp))
+				held = false
+			} else if *mongoSource == "oplog" {
 				lastEsSeenC <- &op.Timestamp
-			// Abort delivering any pending EsOperations we might block for
-			case <-exit:
-				break
 			}
 		}
 		// If mongoc closed, tailer has stopped
@@ -131,5 +515,150 @@ func main() {
 	// We are the producer for this channel, close it down and wait for ES slurpers to return
 	close(esc)
 	<-esDone
+	if acks != nil {
+		log.Println("Saving the last checkpoint")
+		close(slurped)
+		<-checkpointed
+	}
 	log.Println("Bye!")
 }
+
+// checkAliases makes sure that the indexes we write to can be written to in case they are aliases,
+// as writes fails for aliases pointing at several indexes without a write index.
+func checkAliases(client *elasticsearch.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	indexes := []string{*esIndex}
+	for _, target := range mongodb.Targets {
+		indexes = append(indexes, target.Index)
+	}
+	for _, index := range indexes {
+		if alias, err := client.IsAlias(ctx, index); err != nil {
+			log.Fatal(err)
+		} else if !alias {
+			continue
+		}
+		if writeIndex, err := client.ResolveWriteIndex(ctx, index); err != nil {
+			log.Fatal(err)
+		} else {
+			log.Println("Writing to", writeIndex, "through alias", index)
+		}
+	}
+}
+
+// replaySpool regularly sends what has been spooled while ES was unavailable until exit closes.
+func replaySpool(spool *elasticsearch.Spool, client *elasticsearch.Client, exit chan bool) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-exit:
+			return
+		}
+		if spool.Pending() == 0 {
+			continue
+		}
+		n, err := spool.Replay(context.Background(), client)
+		if n > 0 {
+			log.Println("Replayed", n, "spooled bulk requests")
+		}
+		if err != nil {
+			log.Println("Replaying spool stopped:", err)
+		}
+	}
+}
+
+// detectVersion finds out which version of ES we talk to, for the client to adjust to it.
+func detectVersion(client *elasticsearch.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	version, err := client.DetectVersion(ctx)
+	if err != nil {
+		log.Fatal("Unable to detect the version of ES, set it with -esversion: ", err)
+	}
+	log.Println("ES version is", version)
+}
+
+// storeScripts stores the scripts of -storescript in ES, failing fast unless they can all be.
+func storeScripts(client *elasticsearch.Client) {
+	scripts, err := loadScripts(*esScripts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for id, source := range scripts {
+		if err := client.PutStoredScript(ctx, id, "", source); err != nil {
+			log.Fatal("Unable to store script ", id, ": ", err)
+		}
+		log.Println("Stored script", id)
+	}
+}
+
+// mirrorTo returns a FanOut sending to client and to each cluster of -mirror, failing fast unless
+// they can all be reached.
+func mirrorTo(client *elasticsearch.Client, options []elasticsearch.ClientOption) *elasticsearch.FanOut {
+	if *esSpool != "" {
+		log.Fatal("Bulk requests to mirrors are kept in memory, -spool can't be used with -mirror")
+	}
+	acks, err := mirrorAcks(*esMirrorAcks)
+	if err != nil {
+		log.Fatal(err)
+	}
+	clients := []*elasticsearch.Client{client}
+	for _, server := range strings.Split(*esMirror, ",") {
+		mirror := elasticsearch.NewClient(server, *esConcurrency, options...)
+		mirror.Mappings = client.Mappings
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if _, err := mirror.Ping(ctx); err != nil {
+			log.Fatal("Unable to reach ES at ", server, ": ", err)
+		}
+		cancel()
+		detectVersion(mirror)
+		clients = append(clients, mirror)
+	}
+	log.Println("Mirroring every bulk request to", *esMirror)
+	return elasticsearch.NewFanOut(acks, clients...)
+}
+
+// indexMap maps the mongo databases of filter to the es index.
+func indexMap(filter mongodb.NamespaceFilter) map[string]string {
+	indexes := make(map[string]string)
+	for _, pattern := range filter.Include {
+		indexes[strings.Split(pattern, ".")[0]] = *esIndex
+	}
+	return indexes
+}
+
+// marshalPolicy returns the policy named by name for documents that can't be marshaled.
+func marshalPolicy(name string, deadLetters chan<- mongodb.DeadLetter) elasticsearch.MarshalPolicy {
+	switch name {
+	case "skip":
+		return elasticsearch.SkipAndLog
+	case "fail":
+		return func(err *elasticsearch.MarshalError) error {
+			log.Fatal(err)
+			return err
+		}
+	case "deadletter":
+		if deadLetters == nil {
+			log.Fatal("A dead-letter file is required to dead-letter documents that can't be marshaled")
+		}
+		return elasticsearch.DeadLetter(func(err *elasticsearch.MarshalError) {
+			op, ok := err.Entry.(*mongodb.EsOperation)
+			if !ok {
+				log.Println("Skipping", err)
+				return
+			}
+			doc, _ := op.Document()
+			if invalid, ok := err.Err.(*elasticsearch.ValidationError); ok {
+				doc = invalid.Document
+			}
+			deadLetters <- mongodb.NewDeadLetter(op.Operation, doc, err.Err.Error())
+		})
+	default:
+		log.Fatal("Unknown marshal policy: ", name)
+		return nil
+	}
+}
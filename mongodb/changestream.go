@@ -0,0 +1,280 @@
+package mongodb
+
+import (
+	"errors"
+	"github.com/duego/cryriver/stats"
+	"io"
+	"io/ioutil"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"log"
+	"os"
+	"strings"
+)
+
+// Source sends operations on opc until exit is closed or it fails, closing opc when it returns.
+// Both tailing the oplog and following a change stream are sources.
+type Source func(opc chan<- *Operation, exit chan bool) error
+
+// ResumeToken identifies an event of a change stream, the stream can be resumed after it.
+type ResumeToken bson.M
+
+// ErrChangeStreamInvalidated is returned when the change stream can't continue, such as when the
+// whole database has been dropped.
+var ErrChangeStreamInvalidated = errors.New("Change stream was invalidated")
+
+// ErrInvalidResumeToken is returned when loading a resume token that isn't one, like
+// ErrInvalidTimestamp.
+var ErrInvalidResumeToken = errors.New("Stored resume token is invalid")
+
+// SaveFile replaces the file at path with the token, the same way as Timestamp.SaveFile.
+func (t ResumeToken) SaveFile(path string) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		b, err := bson.Marshal(bson.M(t))
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	})
+}
+
+// LoadResumeToken reads a token saved by SaveFile.
+func LoadResumeToken(path string) (ResumeToken, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var token bson.M
+	if err := bson.Unmarshal(b, &token); err != nil || len(token) == 0 {
+		return nil, ErrInvalidResumeToken
+	}
+	return ResumeToken(token), nil
+}
+
+// changeEvent is one event of a change stream.
+type changeEvent struct {
+	Id            bson.M    `bson:"_id"`
+	OperationType string    `bson:"operationType"`
+	ClusterTime   Timestamp `bson:"clusterTime"`
+	Ns            struct {
+		Db   string `bson:"db"`
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey       bson.M `bson:"documentKey"`
+	FullDocument      bson.M `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+// operation returns the event as the oplog operation it corresponds to, nil for events not
+// changing any documents.
+func (e *changeEvent) operation() *Operation {
+	op := &Operation{
+		Timestamp:   e.ClusterTime,
+		Namespace:   e.Ns.Db + "." + e.Ns.Coll,
+		ResumeToken: ResumeToken(e.Id),
+	}
+	switch e.OperationType {
+	case "insert":
+		op.Op = Insert
+		op.Object = e.FullDocument
+	case "update":
+		op.Op = Update
+		op.UpdateObject = e.DocumentKey
+		sets := e.UpdateDescription.UpdatedFields
+		if sets == nil {
+			sets = make(bson.M)
+		}
+		op.Object = bson.M{"$set": sets}
+		if len(e.UpdateDescription.RemovedFields) > 0 {
+			unsets := make(bson.M)
+			for _, field := range e.UpdateDescription.RemovedFields {
+				unsets[field] = 1
+			}
+			op.Object["$unset"] = unsets
+		}
+	case "replace":
+		// Like a full document update in the oplog
+		op.Op = Update
+		op.UpdateObject = e.DocumentKey
+		op.Object = e.FullDocument
+	case "delete":
+		op.Op = Delete
+		op.Object = e.DocumentKey
+	default:
+		return nil
+	}
+	return op
+}
+
+// fullDocument turns the update op of e into an insert of the whole document, fetched by find
+// unless e has it already. If the document has been deleted since the event, it's turned into a
+// delete instead.
+func fullDocument(e *changeEvent, op *Operation, find func(ns string, id interface{}) (bson.M, error)) (*Operation, error) {
+	doc := e.FullDocument
+	if e.OperationType != "replace" || doc == nil {
+		var err error
+		doc, err = find(op.Namespace, op.UpdateObject["_id"])
+		if err == mgo.ErrNotFound {
+			full := *op
+			full.Op = Delete
+			full.Object = op.UpdateObject
+			full.UpdateObject = nil
+			return &full, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	full := *op
+	full.Op = Insert
+	full.Object = doc
+	full.UpdateObject = nil
+	return &full, nil
+}
+
+// changeBatch is the reply of the aggregate and getMore commands of a change stream.
+type changeBatch struct {
+	Cursor struct {
+		Id                   int64         `bson:"id"`
+		FirstBatch           []changeEvent `bson:"firstBatch"`
+		NextBatch            []changeEvent `bson:"nextBatch"`
+		PostBatchResumeToken bson.M        `bson:"postBatchResumeToken"`
+	} `bson:"cursor"`
+}
+
+// ChangeStream sends the changes of the namespaces selected by filter on the specified channel,
+// as an alternative to Tail that also works through mongos on sharded clusters. The stream resumes
+// after resume if given, otherwise an initial import is done first. Operations carry the
+// ResumeToken to checkpoint with instead of their timestamp. Interrupts if exit chan closes.
+//
+// Updates only carry the changed fields and are sent as partial updates, except for namespaces
+// selected by lookup which has the current document fetched to index it as a whole.
+func ChangeStream(session *mgo.Session, filter, lookup NamespaceFilter, initial bool, resume ResumeToken, opc chan<- *Operation, exit chan bool) error {
+	defer close(opc)
+	defer session.Close()
+	admin := session.DB("admin")
+	find := func(ns string, id interface{}) (bson.M, error) {
+		parts := strings.SplitN(ns, ".", 2)
+		var doc bson.M
+		err := session.DB(parts[0]).C(parts[1]).FindId(id).One(&doc)
+		return doc, err
+	}
+
+	// Open the stream before importing so that nothing changed during the import is missed
+	batch, err := openChangeStream(admin, resume)
+	if err != nil {
+		return err
+	}
+	if resume == nil || initial {
+		namespaces, err := Namespaces(session, filter)
+		if err != nil {
+			return err
+		}
+		log.Println("Doing initial import, this may take a while...")
+		for _, ns := range namespaces {
+			if interrupted, err := importCollection(session, ns, opc, exit); err != nil || interrupted {
+				return err
+			}
+		}
+		log.Println("Initial import has completed")
+
+		// The cursor may have timed out during a long import, reopen it where it was
+		if token := batch.Cursor.PostBatchResumeToken; token != nil && len(batch.Cursor.FirstBatch) == 0 {
+			killCursor(admin, batch.Cursor.Id)
+			if batch, err = openChangeStream(admin, ResumeToken(token)); err != nil {
+				return err
+			}
+		}
+	}
+
+	id := batch.Cursor.Id
+	events := batch.Cursor.FirstBatch
+	for {
+		for i := range events {
+			if events[i].OperationType == "invalidate" {
+				return ErrChangeStreamInvalidated
+			}
+			op := events[i].operation()
+			if op == nil || !filter.Match(op.Namespace) {
+				continue
+			}
+			// The bytes are counted by batch
+			countRead(string(op.Op), 0, op.Timestamp)
+			if op.Op == Update && lookup.Match(op.Namespace) {
+				if op, err = fullDocument(&events[i], op, find); err != nil {
+					return err
+				}
+			}
+			select {
+			case opc <- op:
+			case <-exit:
+				killCursor(admin, id)
+				return nil
+			}
+		}
+
+		select {
+		case <-exit:
+			killCursor(admin, id)
+			return nil
+		default:
+		}
+		// Waits for up to a second for more events so that exit is checked regularly
+		var next changeBatch
+		err := runCounted(admin, bson.D{
+			{Name: "getMore", Value: id},
+			{Name: "collection", Value: "$cmd.aggregate"},
+			{Name: "maxTimeMS", Value: 1000},
+		}, &next)
+		if err != nil {
+			stats.MongoCursorErrors.Add(1)
+			return err
+		}
+		events = next.Cursor.NextBatch
+	}
+}
+
+// openChangeStream starts a change stream of the whole cluster after resume, if given.
+func openChangeStream(admin *mgo.Database, resume ResumeToken) (*changeBatch, error) {
+	options := bson.M{"allChangesForCluster": true}
+	if resume != nil {
+		options["resumeAfter"] = bson.M(resume)
+		log.Println("Resuming change stream from the saved resume token")
+	}
+	var batch changeBatch
+	err := runCounted(admin, bson.D{
+		{Name: "aggregate", Value: 1},
+		{Name: "pipeline", Value: []bson.M{{"$changeStream": options}}},
+		{Name: "cursor", Value: bson.M{}},
+	}, &batch)
+	return &batch, err
+}
+
+// runCounted runs cmd on db into result like Run, counting the bytes of the reply in the stats.
+func runCounted(db *mgo.Database, cmd interface{}, result interface{}) error {
+	var raw bson.Raw
+	if err := db.Run(cmd, &raw); err != nil {
+		return err
+	}
+	stats.MongoReadBytes.Add(int64(len(raw.Data)))
+	return raw.Unmarshal(result)
+}
+
+// killCursor releases the cursor of a change stream we are done with.
+func killCursor(admin *mgo.Database, id int64) {
+	if id == 0 {
+		return
+	}
+	admin.Run(bson.D{
+		{Name: "killCursors", Value: "$cmd.aggregate"},
+		{Name: "cursors", Value: []int64{id}},
+	}, nil)
+}
@@ -0,0 +1,122 @@
+package mongodb
+
+import (
+	"io/ioutil"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func changeEventFor(operationType string) *changeEvent {
+	e := &changeEvent{
+		Id:            bson.M{"_data": "825F"},
+		OperationType: operationType,
+		ClusterTime:   5984286097973182465,
+		DocumentKey:   bson.M{"_id": bson.ObjectIdHex("52e7db73f4eb27371874b289")},
+		FullDocument:  bson.M{"_id": bson.ObjectIdHex("52e7db73f4eb27371874b289"), "alias": "Johnny"},
+	}
+	e.Ns.Db = "test"
+	e.Ns.Coll = "users"
+	e.UpdateDescription.UpdatedFields = bson.M{"address.city": "Stockholm"}
+	e.UpdateDescription.RemovedFields = []string{"phone"}
+	return e
+}
+
+func TestChangeEventOperations(t *testing.T) {
+	for kind, expected := range map[string]OplogOperation{
+		"insert":  Insert,
+		"update":  Update,
+		"replace": Update,
+		"delete":  Delete,
+	} {
+		op := changeEventFor(kind).operation()
+		if op.Op != expected {
+			t.Error("Expected", kind, "to be", expected, "got", op.Op)
+		}
+		if op.Namespace != "test.users" || op.Timestamp != 5984286097973182465 || op.ResumeToken["_data"] != "825F" {
+			t.Error("Unexpected operation for", kind, op)
+		}
+		if id, err := op.ObjectId(); err != nil || id.Hex() != "52e7db73f4eb27371874b289" {
+			t.Error("Unexpected id for", kind, id, err)
+		}
+	}
+
+	if op := changeEventFor("drop").operation(); op != nil {
+		t.Error("Expected no operation for drop, got", op)
+	}
+
+	doc, err := getEsOp(changeEventFor("update").operation()).Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if city := NewBsonTraverser(bson.M(doc)).Next("address").Next("city").Value(); city != "Stockholm" {
+		t.Error("Expected updated fields to be set, got", doc)
+	}
+	if phone, ok := doc["phone"]; !ok || phone != nil {
+		t.Error("Expected removed fields to be unset, got", doc)
+	}
+}
+
+func TestResumeTokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cryriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cryriver.token")
+
+	if err := (ResumeToken{"_data": "825F"}).SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	token, err := LoadResumeToken(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token["_data"] != "825F" {
+		t.Error("Unexpected token", token)
+	}
+}
+
+func TestFullDocument(t *testing.T) {
+	current := bson.M{"_id": bson.ObjectIdHex("52e7db73f4eb27371874b289"), "alias": "Johnny", "address": bson.M{"city": "Stockholm"}}
+	var lookups int
+	find := func(ns string, id interface{}) (bson.M, error) {
+		lookups++
+		if ns != "test.users" || id != current["_id"] {
+			t.Error("Unexpected lookup", ns, id)
+		}
+		return current, nil
+	}
+
+	e := changeEventFor("update")
+	op, err := fullDocument(e, e.operation(), find)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Op != Insert || op.Object["alias"] != "Johnny" || op.ResumeToken == nil {
+		t.Error("Expected update to be indexed as the current document, got", op)
+	}
+
+	// Replaces already has the document
+	e = changeEventFor("replace")
+	if op, err := fullDocument(e, e.operation(), find); err != nil || op.Op != Insert || lookups != 1 {
+		t.Error("Expected replace to be indexed without a lookup, got", op, err, lookups)
+	}
+
+	// Deleted since the event
+	e = changeEventFor("update")
+	op, err = fullDocument(e, e.operation(), func(string, interface{}) (bson.M, error) {
+		return nil, mgo.ErrNotFound
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Op != Delete {
+		t.Fatal("Expected a delete for a document that is gone, got", op.Op)
+	}
+	if id, err := op.ObjectId(); err != nil || id.Hex() != "52e7db73f4eb27371874b289" {
+		t.Error("Unexpected id of delete", id, err)
+	}
+}
@@ -0,0 +1,96 @@
+package mongodb
+
+import (
+	"errors"
+	"github.com/duego/cryriver/redact"
+	"labix.org/v2/mgo/bson"
+)
+
+// ErrDeadLetterId is returned for dead letters without the ObjectId of their document.
+var ErrDeadLetterId = errors.New("Dead letter has no valid document id")
+
+// DeadLetter is a document kept from being indexed, saved for later investigation.
+type DeadLetter struct {
+	Id        string `json:"id"`
+	Namespace string `json:"ns"`
+	Reason    string `json:"reason"`
+	Document  bson.M `json:"document"`
+}
+
+// NewDeadLetter creates a DeadLetter for the document of op, sensitive fields are redacted.
+func NewDeadLetter(op *Operation, doc bson.M, reason string) DeadLetter {
+	letter := DeadLetter{
+		Namespace: op.Namespace,
+		Reason:    reason,
+		Document:  bson.M(redact.Document(doc)),
+	}
+	if id, err := op.ObjectId(); err == nil {
+		letter.Id = id.Hex()
+	}
+	return letter
+}
+
+// Operation returns the dead letter as an update of the document it holds, to index it again once
+// the reason it was kept is fixed. Fields that were redacted when it was saved are left out rather
+// than overwriting what is indexed with the mask, as are arrays holding any redacted values.
+func (l DeadLetter) Operation() (*Operation, error) {
+	if !bson.IsObjectIdHex(l.Id) {
+		return nil, ErrDeadLetterId
+	}
+	return &Operation{
+		Namespace:    l.Namespace,
+		Op:           Update,
+		UpdateObject: bson.M{"_id": bson.ObjectIdHex(l.Id)},
+		Object:       bson.M{"$set": bson.M(unredacted(l.Document))},
+	}, nil
+}
+
+// unredacted returns doc without the fields redacted by redact.Document, sub-documents left
+// without fields are left out as well.
+func unredacted(doc map[string]interface{}) map[string]interface{} {
+	kept := make(map[string]interface{}, len(doc))
+	for key, value := range doc {
+		switch v := value.(type) {
+		case bson.M:
+			if sub := unredacted(v); len(sub) > 0 || len(v) == 0 {
+				kept[key] = sub
+			}
+		case map[string]interface{}:
+			if sub := unredacted(v); len(sub) > 0 || len(v) == 0 {
+				kept[key] = sub
+			}
+		default:
+			if !redacted(value) {
+				kept[key] = value
+			}
+		}
+	}
+	return kept
+}
+
+// redacted tells if v is the mask of redact or an array holding it anywhere.
+func redacted(v interface{}) bool {
+	switch t := v.(type) {
+	case string:
+		return t == redact.Mask
+	case []interface{}:
+		for _, elem := range t {
+			if redacted(elem) {
+				return true
+			}
+		}
+	case bson.M:
+		for _, elem := range t {
+			if redacted(elem) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, elem := range t {
+			if redacted(elem) {
+				return true
+			}
+		}
+	}
+	return false
+}
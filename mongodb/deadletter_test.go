@@ -0,0 +1,54 @@
+package mongodb
+
+import (
+	"github.com/duego/cryriver/redact"
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+func TestDeadLetterOperation(t *testing.T) {
+	id := bson.NewObjectId()
+	letter := NewDeadLetter(&Operation{Namespace: "test.users", Op: Insert, Object: bson.M{"_id": id}}, bson.M{"name": "johnny"}, "Too many fields")
+	op, err := letter.Operation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	esOp := NewEsOperation(map[string]string{"test": "testing"}, []Manipulator{}, op)
+	if esId, err := esOp.Id(); err != nil || esId != id.Hex() {
+		t.Error("Expected the id of the dead letter, got", esId, err)
+	}
+	if action, _ := esOp.Action(); action != "update" {
+		t.Error("Expected dead letter to be upserted, got", action)
+	}
+	if doc, _ := esOp.Document(); doc["name"] != "johnny" {
+		t.Error("Expected the document of the dead letter, got", doc)
+	}
+
+	if _, err := (DeadLetter{Namespace: "test.users"}).Operation(); err != ErrDeadLetterId {
+		t.Error("Expected ErrDeadLetterId, got", err)
+	}
+}
+
+func TestDeadLetterOperationRedacted(t *testing.T) {
+	previous := redact.Paths
+	redact.Paths = []string{"email", "address.street", "phones"}
+	defer func() { redact.Paths = previous }()
+
+	letter := NewDeadLetter(&Operation{Namespace: "test.users", Op: Insert, Object: bson.M{"_id": bson.NewObjectId()}}, bson.M{
+		"name":    "johnny",
+		"email":   "johnny@example.com",
+		"address": bson.M{"street": "Main St 1", "city": "Springfield"},
+		"phones":  []interface{}{"555-1234"},
+	}, "Too many fields")
+	op, err := letter.Operation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := op.Object["$set"].(bson.M)
+	if len(sets) != 2 || sets["name"] != "johnny" {
+		t.Error("Expected redacted fields to be left out, got", sets)
+	}
+	if address, ok := sets["address"].(map[string]interface{}); !ok || len(address) != 1 || address["city"] != "Springfield" {
+		t.Error("Expected only the unredacted fields of the address, got", sets["address"])
+	}
+}
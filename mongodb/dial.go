@@ -0,0 +1,144 @@
+package mongodb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"labix.org/v2/mgo"
+	"net"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCAFile is returned when the CA file given for TLS holds no certificates.
+var ErrInvalidCAFile = errors.New("No certificates found in CA file")
+
+// DialConfig is how to connect to the MongoDB we read from, for tailing as well as initial imports.
+type DialConfig struct {
+	// Server or mongodb:// URL to connect to
+	URL string
+
+	// Credentials to authenticate with, the mechanism is negotiated with the server which is
+	// SCRAM-SHA-1 on MongoDB 3.0 and later. AuthSource is the database the user is defined in.
+	Username   string
+	Password   string
+	AuthSource string
+
+	// Connect with TLS, verifying the server against CAFile if given or the system roots otherwise
+	TLS    bool
+	CAFile string
+
+	// primary, secondaryPreferred or nearest. A primary read preference connects directly to the
+	// server given, the others discover the replica set to find a secondary.
+	ReadPreference string
+
+	Timeout time.Duration
+}
+
+// String describes the connection for logs, leaving out any password.
+func (c DialConfig) String() string {
+	s := redactURL(c.URL)
+	if c.Username != "" {
+		s = c.Username + "@" + s
+	}
+	var options []string
+	if c.AuthSource != "" {
+		options = append(options, "authSource="+c.AuthSource)
+	}
+	if c.TLS {
+		options = append(options, "tls")
+	}
+	if c.ReadPreference != "" {
+		options = append(options, "readPreference="+c.ReadPreference)
+	}
+	if len(options) > 0 {
+		s += " (" + strings.Join(options, ", ") + ")"
+	}
+	return s
+}
+
+// redactURL masks the password of credentials given in a mongodb:// URL.
+func redactURL(url string) string {
+	at := strings.LastIndex(url, "@")
+	if at < 0 {
+		return url
+	}
+	start := strings.Index(url, "://") + 3
+	if start < 3 || start > at {
+		start = 0
+	}
+	if colon := strings.Index(url[start:at], ":"); colon >= 0 {
+		return url[:start+colon] + ":****" + url[at:]
+	}
+	return url
+}
+
+// ReadMode returns the session mode for a read preference, empty being primary.
+func ReadMode(preference string) (mgo.Mode, error) {
+	switch preference {
+	case "", "primary":
+		return mgo.Strong, nil
+	case "secondaryPreferred":
+		// Reads from a secondary if there is one, we never write to switch back to the primary
+		return mgo.Monotonic, nil
+	case "nearest":
+		return mgo.Eventual, nil
+	}
+	return mgo.Strong, fmt.Errorf("Unsupported read preference %s, expected primary, secondaryPreferred or nearest", preference)
+}
+
+// Dial connects to MongoDB as configured.
+func Dial(config DialConfig) (*mgo.Session, error) {
+	info, mode, err := dialInfo(config)
+	if err != nil {
+		return nil, err
+	}
+	session, err := mgo.DialWithInfo(info)
+	if err != nil {
+		return nil, err
+	}
+	session.SetMode(mode, true)
+	return session, nil
+}
+
+// dialInfo translates config into what the driver needs to connect and the mode to read with.
+func dialInfo(config DialConfig) (*mgo.DialInfo, mgo.Mode, error) {
+	mode, err := ReadMode(config.ReadPreference)
+	if err != nil {
+		return nil, mode, err
+	}
+	info, err := mgo.ParseURL(config.URL)
+	if err != nil {
+		return nil, mode, err
+	}
+	info.Direct = mode == mgo.Strong
+	info.Timeout = config.Timeout
+	if config.Username != "" {
+		info.Username = config.Username
+		info.Password = config.Password
+	}
+	if config.AuthSource != "" {
+		info.Source = config.AuthSource
+	}
+
+	if config.TLS {
+		tlsConfig := &tls.Config{}
+		if config.CAFile != "" {
+			pem, err := ioutil.ReadFile(config.CAFile)
+			if err != nil {
+				return nil, mode, err
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, mode, ErrInvalidCAFile
+			}
+		}
+		dialer := &net.Dialer{Timeout: config.Timeout}
+		info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+			return tls.DialWithDialer(dialer, "tcp", addr.String(), tlsConfig)
+		}
+	}
+	return info, mode, nil
+}
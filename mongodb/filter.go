@@ -0,0 +1,119 @@
+package mongodb
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// NamespaceFilter selects namespaces by glob patterns like app_*.users, matched as by path.Match
+// where * also matches dots. A namespace is selected if it matches any of Include and none of
+// Exclude, exclusion takes precedence.
+type NamespaceFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Match tells if the namespace ns is selected by the filter.
+func (f NamespaceFilter) Match(ns string) bool {
+	if matchAny(f.Exclude, ns) {
+		return false
+	}
+	return matchAny(f.Include, ns)
+}
+
+// Validate returns path.ErrBadPattern if any of the patterns is malformed.
+func (f NamespaceFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// literals returns the included namespaces that are plain names rather than patterns.
+func (f NamespaceFilter) literals() (names []string, patterns bool) {
+	for _, pattern := range f.Include {
+		if isPattern(pattern) {
+			patterns = true
+		} else if !matchAny(f.Exclude, pattern) {
+			names = append(names, pattern)
+		}
+	}
+	return names, patterns
+}
+
+// regex returns a regular expression matching the same namespaces as the include patterns, to
+// let MongoDB do a first pass of the filtering.
+func (f NamespaceFilter) regex() string {
+	alternatives := make([]string, len(f.Include))
+	for i, pattern := range f.Include {
+		alternatives[i] = globToRegex(pattern)
+	}
+	return "^(?:" + strings.Join(alternatives, "|") + ")$"
+}
+
+func matchAny(patterns []string, ns string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, ns); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isPattern(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
+
+// globToRegex converts a path.Match pattern into a regular expression.
+func globToRegex(pattern string) string {
+	var re strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			re.WriteString(".*")
+		case '?':
+			re.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				re.WriteString(regexp.QuoteMeta(pattern[i:]))
+				return re.String()
+			}
+			re.WriteString(pattern[i : i+end+1])
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return re.String()
+}
+
+// lookupPattern finds the value of key in m, trying keys that are patterns matching key if there
+// is no exact match. Patterns are tried in sorted order.
+func lookupPattern(m map[string]string, key string) (string, bool) {
+	if v, ok := m[key]; ok {
+		return v, true
+	}
+	patterns := make([]string, 0, len(m))
+	for pattern := range m {
+		if isPattern(pattern) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return m[pattern], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,69 @@
+package mongodb
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestNamespaceFilter(t *testing.T) {
+	filter := NamespaceFilter{
+		Include: []string{"app_*.users", "billing.invoices", "logs.?"},
+		Exclude: []string{"app_test*.*", "*.system.*"},
+	}
+	tests := []struct {
+		ns    string
+		match bool
+	}{
+		{"app_se.users", true},
+		{"app_no.users", true},
+		{"app_.users", true},
+		{"app_se.groups", false},
+		{"app_testing.users", false},
+		{"billing.invoices", true},
+		{"billing.invoices2", false},
+		{"logs.a", true},
+		{"logs.ab", false},
+		{"app_se.system.users", false},
+		{"other.users", false},
+	}
+	re := regexp.MustCompile(filter.regex())
+	for _, test := range tests {
+		if match := filter.Match(test.ns); match != test.match {
+			t.Error("Expected", test.ns, "to match:", test.match)
+		}
+		// The regex is a first pass, it must select at least what the filter does
+		if test.match && !re.MatchString(test.ns) {
+			t.Error("Expected regex", re, "to match", test.ns)
+		}
+	}
+}
+
+func TestNamespaceFilterLiterals(t *testing.T) {
+	filter := NamespaceFilter{Include: []string{"api.users", "api.*", "api.secret"}, Exclude: []string{"api.secret"}}
+	names, patterns := filter.literals()
+	if len(names) != 1 || names[0] != "api.users" || !patterns {
+		t.Error("Unexpected literals", names, patterns)
+	}
+}
+
+func TestNamespaceFilterValidate(t *testing.T) {
+	if err := (NamespaceFilter{Include: []string{"api.[users"}}).Validate(); err == nil {
+		t.Error("Expected malformed pattern to fail")
+	}
+	if err := (NamespaceFilter{Include: []string{"api.*"}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLookupPattern(t *testing.T) {
+	m := map[string]string{"api": "exact", "app_*": "apps"}
+	if v, _ := lookupPattern(m, "api"); v != "exact" {
+		t.Error("Expected exact match, got", v)
+	}
+	if v, _ := lookupPattern(m, "app_se"); v != "apps" {
+		t.Error("Expected pattern match, got", v)
+	}
+	if _, ok := lookupPattern(m, "other"); ok {
+		t.Error("Expected no match")
+	}
+}
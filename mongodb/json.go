@@ -0,0 +1,154 @@
+package mongodb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"labix.org/v2/mgo/bson"
+	"reflect"
+	"time"
+)
+
+// DateFormat is how BSON dates are rendered for ES, they have millisecond precision.
+const DateFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// binaryUUID is the BSON binary subtype of UUIDs.
+const binaryUUID = 0x04
+
+// MaxDepth is how deeply documents, counting arrays, may be nested for MarshalJSON. Deeper
+// documents fail with ErrMaxDepth rather than having their values rendered, which would otherwise
+// take as deep a recursion.
+var MaxDepth = 100
+
+// ErrMaxDepth is returned by MarshalJSON for documents nested deeper than MaxDepth.
+var ErrMaxDepth = errors.New("Document is nested deeper than the max depth")
+
+// MarshalJSON marshals documents holding BSON values for ES, to be used as the marshaler of bulk
+// bodies. ObjectIds are rendered as their hex string, dates in UTC by DateFormat and binary data
+// as base64 except for UUIDs that are rendered as usual. bson.D keeps the order of its fields,
+// everything else is marshaled by encoding/json which sorts the keys of maps. The same document is
+// thereby always marshaled to the same bytes, elasticsearch.CanonicalJSON also sorts the fields of
+// bson.D for comparing documents by content.
+//
+// Decimal128 is not supported by this version of the driver, documents holding one fail to be read
+// from MongoDB before they get here.
+func MarshalJSON(v interface{}) ([]byte, error) {
+	rendered, err := jsonValue(v, 0)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(rendered)
+}
+
+// jsonValue replaces the BSON values within v, found depth levels down, with what they should be
+// marshaled as.
+func jsonValue(v interface{}, depth int) (interface{}, error) {
+	switch t := v.(type) {
+	case bson.ObjectId:
+		return t.Hex(), nil
+	case time.Time:
+		return t.UTC().Format(DateFormat), nil
+	case bson.Binary:
+		if t.Kind == binaryUUID && len(t.Data) == 16 {
+			return formatUUID(t.Data), nil
+		}
+		return base64.StdEncoding.EncodeToString(t.Data), nil
+	case bson.D:
+		if depth >= MaxDepth {
+			return nil, ErrMaxDepth
+		}
+		d := make(orderedDocument, len(t))
+		for i, elem := range t {
+			value, err := jsonValue(elem.Value, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			d[i] = bson.DocElem{Name: elem.Name, Value: value}
+		}
+		return d, nil
+	case bson.M:
+		return jsonMap(t, depth)
+	case map[string]interface{}:
+		return jsonMap(t, depth)
+	case []interface{}:
+		if depth >= MaxDepth {
+			return nil, ErrMaxDepth
+		}
+		values := make([]interface{}, len(t))
+		for i := range t {
+			var err error
+			if values[i], err = jsonValue(t[i], depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+
+	// Arrays of any other kind, such as []bson.M set by a manipulator, are kept as arrays with each
+	// element rendered on its own. Arrays of sub-documents must stay that way for the nested type of
+	// ES to index each of them as a document of its own.
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 && !rv.IsNil() {
+		if depth >= MaxDepth {
+			return nil, ErrMaxDepth
+		}
+		values := make([]interface{}, rv.Len())
+		for i := range values {
+			var err error
+			if values[i], err = jsonValue(rv.Index(i).Interface(), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return v, nil
+}
+
+func jsonMap(m map[string]interface{}, depth int) (map[string]interface{}, error) {
+	if depth >= MaxDepth {
+		return nil, ErrMaxDepth
+	}
+	values := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		var err error
+		if values[key], err = jsonValue(value, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// formatUUID renders the 16 bytes of a UUID like 4a6f2fa9-1e8c-4c28-9a3b-2e8d5a3c1f00.
+func formatUUID(b []byte) string {
+	s := hex.EncodeToString(b)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// orderedDocument is a bson.D marshaled as an object with its fields in order, its values already
+// rendered by jsonValue.
+type orderedDocument bson.D
+
+func (d orderedDocument) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, elem := range d {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(elem.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(elem.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
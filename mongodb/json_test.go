@@ -0,0 +1,140 @@
+package mongodb
+
+import (
+	"github.com/duego/cryriver/elasticsearch"
+	"labix.org/v2/mgo/bson"
+	"testing"
+	"time"
+)
+
+func TestMarshalJSONObjectId(t *testing.T) {
+	id := bson.ObjectIdHex("5f1b2c3d4e5f6a7b8c9d0e1f")
+	b, err := MarshalJSON(bson.M{"_id": id, "owners": []interface{}{id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != `{"_id":"5f1b2c3d4e5f6a7b8c9d0e1f","owners":["5f1b2c3d4e5f6a7b8c9d0e1f"]}` {
+		t.Error("Expected ObjectIds as hex strings, got", s)
+	}
+}
+
+func TestMarshalJSONDate(t *testing.T) {
+	stockholm := time.FixedZone("CEST", 2*60*60)
+	date := time.Date(2020, 7, 24, 14, 30, 0, 123456789, stockholm)
+	b, err := MarshalJSON(map[string]interface{}{"created": date})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != `{"created":"2020-07-24T12:30:00.123Z"}` {
+		t.Error("Expected date in UTC with milliseconds, got", s)
+	}
+}
+
+func TestMarshalJSONBinary(t *testing.T) {
+	b, err := MarshalJSON(bson.M{
+		"data": bson.Binary{Kind: 0x00, Data: []byte("hello")},
+		"uuid": bson.Binary{Kind: 0x04, Data: []byte{0x4a, 0x6f, 0x2f, 0xa9, 0x1e, 0x8c, 0x4c, 0x28, 0x9a, 0x3b, 0x2e, 0x8d, 0x5a, 0x3c, 0x1f, 0x00}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != `{"data":"aGVsbG8=","uuid":"4a6f2fa9-1e8c-4c28-9a3b-2e8d5a3c1f00"}` {
+		t.Error("Expected binary as base64 and UUIDs as strings, got", s)
+	}
+}
+
+func TestMarshalJSONOrdered(t *testing.T) {
+	b, err := MarshalJSON(bson.M{"address": bson.D{
+		{Name: "street", Value: "Götgatan"},
+		{Name: "city", Value: "Stockholm"},
+		{Name: "geo", Value: bson.D{{Name: "lon", Value: 18.07}, {Name: "lat", Value: 59.31}}},
+		{Name: "since", Value: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != `{"address":{"street":"Götgatan","city":"Stockholm","geo":{"lon":18.07,"lat":59.31},"since":"2020-01-01T00:00:00.000Z"}}` {
+		t.Error("Expected fields of bson.D in order, got", s)
+	}
+}
+
+func TestMarshalJSONLargeIntegers(t *testing.T) {
+	b, err := MarshalJSON(bson.M{"n": int64(9007199254740993)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != `{"n":9007199254740993}` {
+		t.Error("Expected exact integer, got", s)
+	}
+}
+
+func TestMarshalJSONTypedArrays(t *testing.T) {
+	id := bson.ObjectIdHex("5f1b2c3d4e5f6a7b8c9d0e1f")
+	b, err := MarshalJSON(bson.M{
+		"members": []bson.M{{"id": id}},
+		"tags":    []string{"a", "b"},
+		"none":    []bson.M(nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != `{"members":[{"id":"5f1b2c3d4e5f6a7b8c9d0e1f"}],"none":null,"tags":["a","b"]}` {
+		t.Error("Expected typed arrays to keep their elements, got", s)
+	}
+}
+
+func TestMarshalJSONMaxDepth(t *testing.T) {
+	deep := bson.M{"leaf": 1}
+	for i := 0; i < 100000; i++ {
+		if i%2 == 0 {
+			deep = bson.M{"next": []interface{}{deep}}
+		} else {
+			deep = bson.M{"next": bson.D{{Name: "doc", Value: deep}}}
+		}
+	}
+	if _, err := MarshalJSON(deep); err != ErrMaxDepth {
+		t.Error("Expected ErrMaxDepth, got", err)
+	}
+
+	// Left to the marshal policy of the bulk body
+	op := NewEsOperation(map[string]string{"test": "test"}, nil, &Operation{Namespace: "test.users", Op: Insert, Object: bson.M{"_id": bson.ObjectIdHex("50eadae392cd864e50cd0dbc"), "next": deep}})
+	bulk := elasticsearch.NewBulkBody(elasticsearch.MB, elasticsearch.WithMarshaler(MarshalJSON), elasticsearch.WithMarshalPolicy(elasticsearch.FailFast))
+	err := bulk.Add(op)
+	if marshalErr, ok := err.(*elasticsearch.MarshalError); !ok || marshalErr.Err != ErrMaxDepth {
+		t.Error("Expected a *MarshalError of ErrMaxDepth, got", err)
+	}
+
+	within := bson.M{"leaf": 1}
+	for i := 1; i < MaxDepth; i++ {
+		within = bson.M{"next": within}
+	}
+	if _, err := MarshalJSON(within); err != nil {
+		t.Error("Expected a document at the max depth to be marshaled, got", err)
+	}
+	if _, err := MarshalJSON(bson.M{"next": within}); err != ErrMaxDepth {
+		t.Error("Expected ErrMaxDepth one level deeper, got", err)
+	}
+}
+
+func TestMarshalJSONCanonical(t *testing.T) {
+	a, err := MarshalJSON(bson.D{{Name: "name", Value: "Johnny"}, {Name: "tags", Value: bson.M{"b": 2, "a": 1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := MarshalJSON(bson.D{{Name: "tags", Value: bson.M{"a": 1, "b": 2}}, {Name: "name", Value: "Johnny"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) == string(b) {
+		t.Error("Expected bson.D to keep its order")
+	}
+	if a, err = elasticsearch.CanonicalJSON(a); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = elasticsearch.CanonicalJSON(b); err != nil {
+		t.Fatal(err)
+	}
+	if s := string(a); s != string(b) || s != `{"name":"Johnny","tags":{"a":1,"b":2}}` {
+		t.Errorf("Expected the same canonical json, got %s and %s", a, b)
+	}
+}
@@ -0,0 +1,81 @@
+package mongodb
+
+import (
+	"github.com/duego/cryriver/stats"
+	"labix.org/v2/mgo"
+	"log"
+	"sync"
+	"time"
+)
+
+// LagMeter measures how far the river is behind MongoDB, as the time between the head of the oplog
+// and the last oplog timestamp applied. Both are also published in the stats along with the lag.
+// The zero value is ready to use.
+type LagMeter struct {
+	mu      sync.Mutex
+	applied Timestamp
+	head    Timestamp
+}
+
+// Applied records ts as applied, unless a later timestamp already has been.
+func (m *LagMeter) Applied(ts Timestamp) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ts > m.applied {
+		m.applied = ts
+		stats.OplogApplied.Set(m.applied.Time().Unix())
+	}
+	m.publish()
+}
+
+// Head records ts as the latest timestamp of the oplog, unless a later one already has been.
+func (m *LagMeter) Head(ts Timestamp) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ts > m.head {
+		m.head = ts
+		stats.OplogHead.Set(m.head.Time().Unix())
+	}
+	m.publish()
+}
+
+// Lag returns the time between the head of the oplog and the last timestamp applied, to the second
+// like oplog timestamps. It's 0 until both are known, and when the head is behind what has been
+// applied as it's only read now and then.
+func (m *LagMeter) Lag() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lag()
+}
+
+func (m *LagMeter) lag() time.Duration {
+	if m.applied == 0 || m.head <= m.applied {
+		return 0
+	}
+	return m.head.Time().Sub(*m.applied.Time())
+}
+
+func (m *LagMeter) publish() {
+	stats.OplogLag.Set(int64(m.lag() / time.Second))
+}
+
+// WatchHead reads the head of the oplog by Optime every interval until exit is closed, session
+// should be a direct session of its own as it's closed when done. Failed reads are logged and
+// left for the next one.
+func (m *LagMeter) WatchHead(session *mgo.Session, interval time.Duration, exit chan bool) {
+	defer session.Close()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if ts, err := Optime(session); err != nil {
+			log.Println("Unable to read the head of the oplog:", err)
+		} else {
+			m.Head(*ts)
+		}
+		select {
+		case <-ticker.C:
+		case <-exit:
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package mongodb
+
+import (
+	"github.com/duego/cryriver/stats"
+	"testing"
+	"time"
+)
+
+// timestampAt returns the oplog timestamp of the ordinal operation in the second sec.
+func timestampAt(sec int64, ordinal int) Timestamp {
+	return Timestamp(sec<<32 | int64(ordinal))
+}
+
+func TestLagMeter(t *testing.T) {
+	var m LagMeter
+	if lag := m.Lag(); lag != 0 {
+		t.Error("Expected no lag before anything is known, got", lag)
+	}
+
+	m.Head(timestampAt(1000, 1))
+	if lag := m.Lag(); lag != 0 {
+		t.Error("Expected no lag before anything is applied, got", lag)
+	}
+
+	m.Applied(timestampAt(940, 3))
+	if lag := m.Lag(); lag != time.Minute {
+		t.Error("Expected a minute of lag, got", lag)
+	}
+	if v := stats.OplogLag.Value(); v != 60 {
+		t.Error("Expected the lag to be published, got", v)
+	}
+	if v := stats.OplogApplied.Value(); v != 940 {
+		t.Error("Expected the applied timestamp to be published, got", v)
+	}
+
+	// Timestamps going back are ignored
+	m.Applied(timestampAt(900, 1))
+	m.Head(timestampAt(990, 1))
+	if lag := m.Lag(); lag != time.Minute {
+		t.Error("Expected the lag to stay, got", lag)
+	}
+
+	// The head is only read now and then and may be behind
+	m.Applied(timestampAt(1010, 1))
+	if lag := m.Lag(); lag != 0 {
+		t.Error("Expected no lag when caught up past the head, got", lag)
+	}
+}
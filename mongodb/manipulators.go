@@ -0,0 +1,424 @@
+package mongodb
+
+import (
+	"fmt"
+	"labix.org/v2/mgo/bson"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// fieldCountGuard implements FieldCountGuard.
+type fieldCountGuard struct {
+	limit       int
+	deadLetters chan<- DeadLetter
+}
+
+// FieldCountGuard returns a Manipulator protecting indexes from mapping explosions caused by
+// documents with too many fields, as in index.mapping.total_fields.limit of ES. Fields of nested
+// objects are also counted.
+//
+// Documents with more than limit fields has the fields exceeding it dropped, or if deadLetters is
+// non-nil, are sent there instead of being indexed. Sending blocks until the dead letter is
+// received.
+func FieldCountGuard(limit int, deadLetters chan<- DeadLetter) Manipulator {
+	return &fieldCountGuard{limit, deadLetters}
+}
+
+func (g *fieldCountGuard) Manipulate(doc *bson.M, op OplogOperation) error {
+	return g.ManipulateOperation(doc, &Operation{Op: op})
+}
+
+func (g *fieldCountGuard) ManipulateOperation(doc *bson.M, op *Operation) error {
+	count := countFields(*doc)
+	if count <= g.limit {
+		return nil
+	}
+
+	if g.deadLetters != nil {
+		g.deadLetters <- NewDeadLetter(op, *doc, fmt.Sprintf("Document has %d fields, limit is %d", count, g.limit))
+		// Nothing is sent for empty documents
+		*doc = bson.M{}
+		return nil
+	}
+
+	// Keep fields in sorted order until the limit is reached, to drop the same fields every time
+	keys := make([]string, 0, len(*doc))
+	for key := range *doc {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	kept := make(bson.M)
+	total := 0
+	for _, key := range keys {
+		n := 1 + countFields((*doc)[key])
+		if total+n > g.limit {
+			continue
+		}
+		total += n
+		kept[key] = (*doc)[key]
+	}
+	*doc = kept
+	return nil
+}
+
+// countFields counts the fields of v if it's a document, including fields of nested documents
+// also within arrays.
+func countFields(v interface{}) int {
+	rv := reflect.ValueOf(v)
+	switch {
+	case !rv.IsValid():
+		return 0
+	case rv.Kind() == reflect.Map:
+		n := 0
+		for _, key := range rv.MapKeys() {
+			n += 1 + countFields(rv.MapIndex(key).Interface())
+		}
+		return n
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8:
+		n := 0
+		for i := 0; i < rv.Len(); i++ {
+			n += countFields(rv.Index(i).Interface())
+		}
+		return n
+	}
+	return 0
+}
+
+// injectTimestamp implements InjectTimestamp.
+type injectTimestamp struct {
+	field string
+}
+
+// InjectTimestamp returns a Manipulator setting field, such as @timestamp, to the time of the
+// operation in the oplog for documents not having the field already, for time-based queries and
+// index lifecycle management on documents not carrying a time of their own. The oplog only has the
+// time to the second. Partial updates are left alone, as the field would then replace the time
+// already indexed for the document, and so are documents of the initial import which have no
+// operation time.
+func InjectTimestamp(field string) Manipulator {
+	return &injectTimestamp{field}
+}
+
+func (m *injectTimestamp) Manipulate(doc *bson.M, op OplogOperation) error {
+	// Without the operation there is no time to inject
+	return nil
+}
+
+func (m *injectTimestamp) ManipulateOperation(doc *bson.M, op *Operation) error {
+	if _, ok := (*doc)[m.field]; ok || isPartial(op) || op.Timestamp == 0 {
+		return nil
+	}
+	withTs := make(bson.M, len(*doc)+1)
+	for k, v := range *doc {
+		withTs[k] = v
+	}
+	withTs[m.field] = *op.Timestamp.Time()
+	*doc = withTs
+	return nil
+}
+
+// isPartial tells if op is an update of some fields rather than the whole document.
+func isPartial(op *Operation) bool {
+	if op.Op != Update {
+		return false
+	}
+	_, sets := op.Object["$set"]
+	_, unsets := op.Object["$unset"]
+	return sets || unsets
+}
+
+// FieldConflict decides which value a field gets when it's found both in a document and among the
+// fields given to StaticFields.
+type FieldConflict int
+
+const (
+	// SourceWins keeps the value of the document, this is the default.
+	SourceWins FieldConflict = iota
+
+	// StaticWins replaces the value of the document with the static one.
+	StaticWins
+)
+
+// staticFields implements StaticFields.
+type staticFields struct {
+	fields   bson.M
+	conflict FieldConflict
+}
+
+// StaticFields returns a Manipulator setting fields in every document indexed, such as
+// "source":"cryriver" to tell where documents come from. They are set in inserted and replaced
+// documents, deletes are left as they are. Fields already in a document are resolved by conflict.
+// Partial updates only get them with StaticWins: with SourceWins the document indexed already
+// holds either its own value or the static one, which a partial update must not overwrite.
+func StaticFields(fields map[string]interface{}, conflict FieldConflict) Manipulator {
+	return &staticFields{bson.M(fields), conflict}
+}
+
+func (m *staticFields) ManipulateOperation(doc *bson.M, op *Operation) error {
+	if m.conflict == SourceWins && isPartial(op) {
+		return nil
+	}
+	return m.Manipulate(doc, op.Op)
+}
+
+func (m *staticFields) Manipulate(doc *bson.M, op OplogOperation) error {
+	if len(m.fields) == 0 {
+		return nil
+	}
+	withStatic := make(bson.M, len(*doc)+len(m.fields))
+	for k, v := range *doc {
+		withStatic[k] = v
+	}
+	for k, v := range m.fields {
+		if _, ok := withStatic[k]; ok && m.conflict == SourceWins {
+			continue
+		}
+		withStatic[k] = v
+	}
+	*doc = withStatic
+	return nil
+}
+
+// flattenKeys implements FlattenKeys.
+type flattenKeys struct {
+	namespaces NamespaceFilter
+	paths      [][]string
+}
+
+// FlattenKeys returns a Manipulator turning the sub-documents at paths, such as stats.byUser, into
+// arrays of {"key": key, "value": value} in documents of namespaces. Sub-documents keyed by
+// something like user ids otherwise add a field to the mapping for every key, until ES rejects
+// writes by index.mapping.total_fields.limit. Flattened, the keys are values of a single field
+// which can be mapped as nested to query a key and its value together. Pairs are sorted by key.
+//
+// ES replaces arrays as a whole: a partial update setting some keys of a flattened sub-document
+// replaces the array with only the keys it sets. Flatten sub-documents that are only ever set as a
+// whole, or namespaces whose updates are looked up as full documents.
+func FlattenKeys(namespaces NamespaceFilter, paths ...string) Manipulator {
+	m := &flattenKeys{namespaces: namespaces}
+	for _, p := range paths {
+		m.paths = append(m.paths, strings.Split(p, "."))
+	}
+	return m
+}
+
+func (m *flattenKeys) Manipulate(doc *bson.M, op OplogOperation) error {
+	// Without the namespace there is no telling if it should be flattened
+	return nil
+}
+
+func (m *flattenKeys) ManipulateOperation(doc *bson.M, op *Operation) error {
+	if !m.namespaces.Match(op.Namespace) {
+		return nil
+	}
+	flattened := *doc
+	for _, p := range m.paths {
+		flattened = flattenPath(flattened, p)
+	}
+	*doc = flattened
+	return nil
+}
+
+// flattenPath returns doc with the sub-document at path flattened into key and value pairs. doc is
+// copied along the path rather than changed, it's also the document of the operation.
+func flattenPath(doc bson.M, path []string) bson.M {
+	value, ok := doc[path[0]]
+	if !ok {
+		return doc
+	}
+	var sub bson.M
+	switch t := value.(type) {
+	case bson.M:
+		sub = t
+	case map[string]interface{}:
+		sub = bson.M(t)
+	case bson.D:
+		sub = t.Map()
+	default:
+		return doc
+	}
+
+	copied := make(bson.M, len(doc))
+	for k, v := range doc {
+		copied[k] = v
+	}
+	if len(path) > 1 {
+		copied[path[0]] = flattenPath(sub, path[1:])
+		return copied
+	}
+	keys := make([]string, 0, len(sub))
+	for key := range sub {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]interface{}, len(keys))
+	for i, key := range keys {
+		pairs[i] = bson.M{"key": key, "value": sub[key]}
+	}
+	copied[path[0]] = pairs
+	return copied
+}
+
+type keyRewriter struct {
+	renames     map[string]string
+	replaceDots bool
+}
+
+// KeyRewriter returns a Manipulator renaming fields of documents, and of the fields set by partial
+// updates, before they are indexed. renames maps the dotted path of a field, such as _class or
+// profile._tmp, to its new name. With replaceDots, dots in the names of other fields are replaced
+// with underscores, as ES would otherwise take them for sub-documents. Fields of documents in
+// arrays are renamed by the path of the array, as in the mapping of ES.
+//
+// A field renamed into the name of another field replaces it in the indexed document.
+func KeyRewriter(renames map[string]string, replaceDots bool) Manipulator {
+	return &keyRewriter{renames, replaceDots}
+}
+
+func (m *keyRewriter) Manipulate(doc *bson.M, op OplogOperation) error {
+	*doc = m.rewriteDoc(*doc, "")
+	return nil
+}
+
+// rewriteDoc returns a copy of doc at path with its fields renamed. Fields keeping their name are
+// copied first, so that renamed fields replace them.
+func (m *keyRewriter) rewriteDoc(doc bson.M, path string) bson.M {
+	rewritten := make(bson.M, len(doc))
+	renamed := make(map[string]string)
+	for key, value := range doc {
+		if name := m.name(key, path+key); name != key {
+			renamed[key] = name
+		} else {
+			rewritten[key] = m.rewrite(value, path+key+".")
+		}
+	}
+	for key, name := range renamed {
+		rewritten[name] = m.rewrite(doc[key], path+key+".")
+	}
+	return rewritten
+}
+
+// name returns the name key at the dotted fieldPath is renamed to.
+func (m *keyRewriter) name(key, fieldPath string) string {
+	if name, ok := m.renames[fieldPath]; ok {
+		return name
+	}
+	if m.replaceDots {
+		return strings.Replace(key, ".", "_", -1)
+	}
+	return key
+}
+
+// rewrite returns v with the fields of any documents in it renamed, path is the prefix of their
+// paths such as "profile.".
+func (m *keyRewriter) rewrite(v interface{}, path string) interface{} {
+	switch t := v.(type) {
+	case bson.M:
+		return m.rewriteDoc(t, path)
+	case map[string]interface{}:
+		return m.rewriteDoc(bson.M(t), path)
+	case bson.D:
+		return m.rewriteDoc(t.Map(), path)
+	case []interface{}:
+		rewritten := make([]interface{}, len(t))
+		for i, elem := range t {
+			rewritten[i] = m.rewrite(elem, path)
+		}
+		return rewritten
+	}
+	return v
+}
+
+// flattenDepth implements Flatten.
+type flattenDepth struct {
+	namespaces NamespaceFilter
+	maxDepth   int
+	separator  string
+}
+
+// Flatten returns a Manipulator keeping documents of namespaces within maxDepth levels of objects,
+// as ES rejects documents nested deeper than index.mapping.depth.limit. Sub-documents of the fields
+// at maxDepth are flattened into them as keys joined by separator, {"a": {"b": {"c": 1}}} becomes
+// {"a": {"b_c": 1}} for a maxDepth of 2 and a separator of _. Flattened keys replace fields of the
+// same name. A separator of . is expanded into objects again by ES.
+//
+// Arrays are kept as they are rather than flattened by index. Documents in arrays beyond maxDepth
+// have their own sub-documents flattened into them, they end up one level deeper than maxDepth.
+// Partial updates are flattened by the expanded paths of the fields they set.
+func Flatten(namespaces NamespaceFilter, maxDepth int, separator string) Manipulator {
+	return &flattenDepth{namespaces, maxDepth, separator}
+}
+
+func (m *flattenDepth) Manipulate(doc *bson.M, op OplogOperation) error {
+	// Without the namespace there is no telling if it should be flattened
+	return nil
+}
+
+func (m *flattenDepth) ManipulateOperation(doc *bson.M, op *Operation) error {
+	if !m.namespaces.Match(op.Namespace) {
+		return nil
+	}
+	*doc = m.flattenDoc(*doc, 1)
+	return nil
+}
+
+// flattenDoc returns a copy of doc, whose fields are at depth, with the sub-documents beyond
+// maxDepth flattened into it. doc is copied rather than changed, it's also the document of the
+// operation.
+func (m *flattenDepth) flattenDoc(doc bson.M, depth int) bson.M {
+	flattened := make(bson.M, len(doc))
+	deep := make(map[string]bson.M)
+	for key, value := range doc {
+		if sub, ok := subDocument(value); ok && depth >= m.maxDepth {
+			deep[key] = sub
+			continue
+		}
+		flattened[key] = m.flatten(value, depth)
+	}
+	for key, sub := range deep {
+		m.collapse(flattened, key, sub)
+	}
+	return flattened
+}
+
+// collapse sets the fields of sub, and those of its own sub-documents, in doc by their keys joined
+// to prefix.
+func (m *flattenDepth) collapse(doc bson.M, prefix string, sub bson.M) {
+	for key, value := range sub {
+		if s, ok := subDocument(value); ok {
+			m.collapse(doc, prefix+m.separator+key, s)
+			continue
+		}
+		doc[prefix+m.separator+key] = m.flatten(value, m.maxDepth)
+	}
+}
+
+// flatten returns v, a field at depth, with the documents in it flattened. Documents in arrays are
+// at the depth of the array, as in the mapping of ES.
+func (m *flattenDepth) flatten(v interface{}, depth int) interface{} {
+	if sub, ok := subDocument(v); ok {
+		return m.flattenDoc(sub, depth+1)
+	}
+	if elems, ok := v.([]interface{}); ok {
+		flattened := make([]interface{}, len(elems))
+		for i, elem := range elems {
+			flattened[i] = m.flatten(elem, depth)
+		}
+		return flattened
+	}
+	return v
+}
+
+// subDocument returns v as a bson.M if it's a document.
+func subDocument(v interface{}) (bson.M, bool) {
+	switch t := v.(type) {
+	case bson.M:
+		return t, true
+	case map[string]interface{}:
+		return bson.M(t), true
+	case bson.D:
+		return t.Map(), true
+	}
+	return nil, false
+}
@@ -0,0 +1,303 @@
+package mongodb
+
+import (
+	"labix.org/v2/mgo/bson"
+	"testing"
+	"time"
+)
+
+func manyFields() *Operation {
+	return &Operation{
+		Namespace: "test.users",
+		Op:        Insert,
+		Object: bson.M{
+			"_id": bson.ObjectIdHex("50eadae392cd864e50cd0dbc"),
+			"a":   1,
+			"b":   bson.M{"c": 2, "d": 3},
+			"e":   4,
+		},
+	}
+}
+
+func TestFieldCountGuardDrops(t *testing.T) {
+	op := manyFields()
+	esOp := NewEsOperation(map[string]string{"test": "test"}, []Manipulator{FieldCountGuard(4, nil)}, op)
+	doc, err := esOp.Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// _id, a and b with its 2 fields are 5, leaving room for _id, a and e
+	if len(doc) != 3 {
+		t.Fatal("Expected 3 fields to be kept, got", doc)
+	}
+	for _, key := range []string{"_id", "a", "e"} {
+		if _, ok := doc[key]; !ok {
+			t.Error("Expected", key, "to be kept, got", doc)
+		}
+	}
+}
+
+func TestFieldCountGuardDeadLetter(t *testing.T) {
+	deadLetters := make(chan DeadLetter, 1)
+	op := manyFields()
+	esOp := NewEsOperation(map[string]string{"test": "test"}, []Manipulator{FieldCountGuard(4, deadLetters)}, op)
+	doc, err := esOp.Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc) != 0 {
+		t.Error("Expected nothing to be indexed, got", doc)
+	}
+
+	letter := <-deadLetters
+	if letter.Id != "50eadae392cd864e50cd0dbc" {
+		t.Error("Expected document id in dead letter, got", letter.Id)
+	}
+	if len(letter.Document) != 4 || letter.Namespace != "test.users" || letter.Reason == "" {
+		t.Error("Unexpected dead letter", letter)
+	}
+}
+
+func TestFieldCountGuardWithinLimit(t *testing.T) {
+	op := manyFields()
+	esOp := NewEsOperation(map[string]string{"test": "test"}, []Manipulator{FieldCountGuard(6, nil)}, op)
+	if doc, _ := esOp.Document(); len(doc) != 4 {
+		t.Error("Expected document to be left alone, got", doc)
+	}
+}
+
+func TestInjectTimestamp(t *testing.T) {
+	op := manyFields()
+	op.Timestamp = Timestamp(1393325184 << 32)
+	esOp := NewEsOperation(map[string]string{"test": "test"}, []Manipulator{InjectTimestamp("@timestamp")}, op)
+	doc, err := esOp.Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts, ok := doc["@timestamp"].(time.Time); !ok || !ts.Equal(time.Unix(1393325184, 0)) {
+		t.Error("Expected the time of the operation to be injected, got", doc["@timestamp"])
+	}
+	if _, ok := op.Object["@timestamp"]; ok {
+		t.Error("Expected the oplog entry to be left untouched, got", op.Object)
+	}
+}
+
+func TestInjectTimestampPresent(t *testing.T) {
+	created := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	op := manyFields()
+	op.Timestamp = Timestamp(1393325184 << 32)
+	op.Object["@timestamp"] = created
+	esOp := NewEsOperation(map[string]string{"test": "test"}, []Manipulator{InjectTimestamp("@timestamp")}, op)
+	doc, err := esOp.Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc["@timestamp"] != created {
+		t.Error("Expected the existing value to be kept, got", doc["@timestamp"])
+	}
+
+	// Partial updates would replace the time already indexed
+	update := &Operation{
+		Timestamp:    Timestamp(1393325184 << 32),
+		Namespace:    "test.users",
+		Op:           Update,
+		Object:       bson.M{"$set": bson.M{"a": 2}},
+		UpdateObject: bson.M{"_id": bson.ObjectIdHex("50eadae392cd864e50cd0dbc")},
+	}
+	esOp = NewEsOperation(map[string]string{"test": "test"}, []Manipulator{InjectTimestamp("@timestamp")}, update)
+	if doc, err := esOp.Document(); err != nil || doc["@timestamp"] != nil {
+		t.Error("Expected no time injected into partial updates, got", doc, err)
+	}
+}
+
+func TestStaticFieldsSourceWins(t *testing.T) {
+	op := manyFields()
+	op.Object["env"] = "staging"
+	static := StaticFields(map[string]interface{}{"source": "cryriver", "env": "prod"}, SourceWins)
+	doc, err := NewEsOperation(map[string]string{"test": "test"}, []Manipulator{static}, op).Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc["source"] != "cryriver" || doc["env"] != "staging" {
+		t.Error("Expected static fields missing from the document to be added, got", doc)
+	}
+	if _, ok := op.Object["source"]; ok {
+		t.Error("Expected the oplog entry to be left untouched, got", op.Object)
+	}
+
+	// Partial updates would overwrite the value of the document already indexed
+	update := &Operation{
+		Namespace:    "test.users",
+		Op:           Update,
+		Object:       bson.M{"$set": bson.M{"a": 2}},
+		UpdateObject: bson.M{"_id": bson.ObjectIdHex("50eadae392cd864e50cd0dbc")},
+	}
+	doc, err = NewEsOperation(map[string]string{"test": "test"}, []Manipulator{static}, update).Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc) != 1 || doc["a"] != 2 {
+		t.Error("Expected no static fields in the partial update, got", doc)
+	}
+}
+
+func TestStaticFieldsStaticWins(t *testing.T) {
+	op := manyFields()
+	op.Object["env"] = "staging"
+	static := StaticFields(map[string]interface{}{"source": "cryriver", "env": "prod"}, StaticWins)
+	doc, err := NewEsOperation(map[string]string{"test": "test"}, []Manipulator{static}, op).Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc["source"] != "cryriver" || doc["env"] != "prod" {
+		t.Error("Expected static fields to replace those of the document, got", doc)
+	}
+
+	// Partial updates get them along with the fields set
+	update := &Operation{
+		Namespace:    "test.users",
+		Op:           Update,
+		Object:       bson.M{"$set": bson.M{"a": 2, "env": "staging"}},
+		UpdateObject: bson.M{"_id": bson.ObjectIdHex("50eadae392cd864e50cd0dbc")},
+	}
+	doc, err = NewEsOperation(map[string]string{"test": "test"}, []Manipulator{static}, update).Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc) != 3 || doc["a"] != 2 || doc["source"] != "cryriver" || doc["env"] != "prod" {
+		t.Error("Expected static fields in the partial update, got", doc)
+	}
+}
+
+func TestInjectTimestampImport(t *testing.T) {
+	// Documents read by the initial import have no operation time
+	esOp := NewEsOperation(map[string]string{"test": "test"}, []Manipulator{InjectTimestamp("@timestamp")}, manyFields())
+	doc, err := esOp.Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := doc["@timestamp"]; ok {
+		t.Error("Expected no time injected without an operation time, got", v)
+	}
+}
+
+func TestFlattenKeys(t *testing.T) {
+	op := &Operation{
+		Namespace: "test.users",
+		Op:        Insert,
+		Object: bson.M{
+			"_id":   bson.ObjectIdHex("50eadae392cd864e50cd0dbc"),
+			"name":  "Johnny",
+			"stats": bson.M{"visits": 3, "byUser": bson.M{"u2": 1, "u1": bson.M{"likes": 2}}},
+		},
+	}
+	flatten := FlattenKeys(NamespaceFilter{Include: []string{"test.*"}}, "stats.byUser", "missing.path")
+	doc, err := NewEsOperation(map[string]string{"test": "test"}, []Manipulator{flatten}, op).Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := MarshalJSON(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != `{"_id":"50eadae392cd864e50cd0dbc","name":"Johnny","stats":{"byUser":[{"key":"u1","value":{"likes":2}},{"key":"u2","value":1}],"visits":3}}` {
+		t.Error("Expected the dynamic keys as key and value pairs, got", s)
+	}
+	if _, ok := op.Object["stats"].(bson.M)["byUser"].(bson.M); !ok {
+		t.Error("Expected the oplog entry to be left untouched, got", op.Object)
+	}
+
+	// Other namespaces are left as they are
+	other := &Operation{Namespace: "other.users", Op: Insert, Object: bson.M{"stats": bson.M{"byUser": bson.M{"u1": 1}}}}
+	doc, err = NewEsOperation(map[string]string{"other": "other"}, []Manipulator{flatten}, other).Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc["stats"].(bson.M)["byUser"].(bson.M); !ok {
+		t.Error("Expected other namespaces not to be flattened, got", doc)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	op := &Operation{
+		Namespace: "test.users",
+		Op:        Insert,
+		Object: bson.M{
+			"name":    "Johnny",
+			"profile": bson.M{"site": bson.M{"url": "example.com", "owner": bson.M{"name": "Jane"}}},
+			"tags":    []interface{}{"a", bson.M{"kind": bson.M{"id": 1}}},
+		},
+	}
+	flatten := Flatten(NamespaceFilter{Include: []string{"test.*"}}, 2, "_")
+	doc, err := NewEsOperation(map[string]string{"test": "test"}, []Manipulator{flatten}, op).Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := MarshalJSON(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != `{"name":"Johnny","profile":{"site_owner_name":"Jane","site_url":"example.com"},"tags":["a",{"kind_id":1}]}` {
+		t.Error("Expected objects beyond the depth to be flattened, got", s)
+	}
+	if _, ok := op.Object["profile"].(bson.M)["site"].(bson.M); !ok {
+		t.Error("Expected the oplog entry to be left untouched, got", op.Object)
+	}
+
+	// Other namespaces are left as they are
+	other := &Operation{Namespace: "other.users", Op: Insert, Object: bson.M{"a": bson.M{"b": bson.M{"c": 1}}}}
+	doc, err = NewEsOperation(map[string]string{"other": "other"}, []Manipulator{flatten}, other).Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := doc["a"].(bson.M)["b"].(bson.M); !ok {
+		t.Error("Expected other namespaces not to be flattened, got", doc)
+	}
+}
+
+func TestKeyRewriter(t *testing.T) {
+	op := &Operation{
+		Namespace: "test.users",
+		Op:        Insert,
+		Object: bson.M{
+			"_id":    bson.ObjectIdHex("50eadae392cd864e50cd0dbc"),
+			"_class": "User",
+			"class":  "replaced",
+			"a.b":    1,
+			"profile": bson.M{
+				"_tmp":     true,
+				"site.url": "example.com",
+			},
+			"addresses": []interface{}{bson.M{"_kind": "home", "zip.code": "12345"}, "plain"},
+		},
+	}
+	rewriter := KeyRewriter(map[string]string{"_class": "class", "profile._tmp": "tmp", "addresses._kind": "kind"}, true)
+	doc, err := NewEsOperation(map[string]string{"test": "test"}, []Manipulator{rewriter}, op).Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := MarshalJSON(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != `{"_id":"50eadae392cd864e50cd0dbc","a_b":1,"addresses":[{"kind":"home","zip_code":"12345"},"plain"],"class":"User","profile":{"site_url":"example.com","tmp":true}}` {
+		t.Error("Expected fields to be renamed, got", s)
+	}
+	if _, ok := op.Object["_class"]; !ok {
+		t.Error("Expected the oplog entry to be left untouched, got", op.Object)
+	}
+
+	// Fields set by partial updates are renamed by their expanded paths
+	update := &Operation{
+		Namespace: "test.users",
+		Op:        Update,
+		Object:    bson.M{"$set": bson.M{"profile._tmp": false, "stats.last.visit": 3}},
+	}
+	doc, err = NewEsOperation(map[string]string{"test": "test"}, []Manipulator{rewriter}, update).Document()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ = MarshalJSON(doc); string(b) != `{"profile":{"tmp":false},"stats":{"last":{"visit":3}}}` {
+		t.Error("Expected the fields of the update to be renamed, got", string(b))
+	}
+}
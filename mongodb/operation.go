@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/duego/cryriver/redact"
 	"github.com/duego/cryriver/stats"
 	"labix.org/v2/mgo/bson"
 	"strings"
@@ -18,6 +19,9 @@ const (
 	Insert  OplogOperation = "i"
 	Delete  OplogOperation = "d"
 	Command OplogOperation = "c"
+
+	// Noop entries changes nothing, MongoDB writes them as heartbeats on idle replica sets
+	Noop OplogOperation = "n"
 )
 
 // OperationError formats errors to have a pretty printed json object to accompany the message.
@@ -30,7 +34,24 @@ type OperationError struct {
 }
 
 func (oe OperationError) Error() string {
-	return fmt.Sprintf("%s\n%s", oe.msg, oe.op)
+	return fmt.Sprintf("%s\n%s", oe.msg, redactOperation(oe.op))
+}
+
+// redactOperation masks sensitive fields of operations before they are printed.
+func redactOperation(op interface{}) interface{} {
+	var o *Operation
+	switch t := op.(type) {
+	case *Operation:
+		o = t
+	case *EsOperation:
+		o = t.Operation
+	default:
+		return op
+	}
+	redacted := *o
+	redacted.Object = bson.M(redact.Document(o.Object))
+	redacted.UpdateObject = bson.M(redact.Document(o.UpdateObject))
+	return redacted
 }
 
 // Operation maps one oplog entry into a structure.
@@ -44,27 +65,42 @@ type Operation struct {
 
 	// The target document on update queires, should contain an id.
 	UpdateObject bson.M `bson:"o2"`
+
+	// Identifies the transaction of the operation, if any.
+	Session   bson.M `bson:"lsid,omitempty"`
+	TxnNumber int64  `bson:"txnNumber,omitempty"`
+
+	// Partial is set when the checkpoint can't be moved to Timestamp yet after delivering this
+	// operation, such as for operations in the middle of a transaction sharing the same timestamp.
+	Partial bool `bson:"-"`
+
+	// ResumeToken is set for operations from a change stream, it's checkpointed instead of
+	// Timestamp.
+	ResumeToken ResumeToken `bson:"-"`
 }
 
+// operationFields is an Operation without its String method, to format the fields of one that
+// can't be marshaled without calling String again.
+type operationFields Operation
+
 func (op Operation) String() string {
 	if b, err := json.MarshalIndent(op, "", "\t"); err != nil {
-		return fmt.Sprint(op)
+		return fmt.Sprintf("%+v", operationFields(op))
 	} else {
 		return string(b)
 	}
 }
 
-func (op *Operation) ObjectId() (bson.ObjectId, error) {
-	var object bson.M
-
-	switch op.Op {
-	case Update:
-		object = op.UpdateObject
-	default:
-		object = op.Object
+// idObject returns the part of the operation identifying the document.
+func (op *Operation) idObject() bson.M {
+	if op.Op == Update {
+		return op.UpdateObject
 	}
+	return op.Object
+}
 
-	id, ok := object["_id"]
+func (op *Operation) ObjectId() (bson.ObjectId, error) {
+	id, ok := op.idObject()["_id"]
 	if !ok {
 		return bson.ObjectId(""), OperationError{"_id does not exist in object", op}
 	}
@@ -84,8 +120,16 @@ type EsOperation struct {
 	namespaceSplit *[2]string
 	doc            map[string]interface{}
 	action         string
+
+	// Set for updates that must not create the document, see Upsert
+	noUpsert bool
+
+	// Set for deletes of documents a Transform left out of ES, see SoftDelete
+	leftOut bool
 }
 
+// NewEsOperation wraps op to be indexed into ES. Indexes maps database names, or patterns of them,
+// to the ES index to use.
 func NewEsOperation(indexes map[string]string, manips []Manipulator, op *Operation) *EsOperation {
 	if manips == nil {
 		manips = DefaultManipulators
@@ -113,8 +157,13 @@ func NewEsOperation(indexes map[string]string, manips []Manipulator, op *Operati
 	return &esOp
 }
 
-// Id returns the object id as a hex string for the current Operation.
+// Id returns the object id as a hex string for the current Operation. It's empty for documents
+// without an _id, such as in capped collections created without one, for the IdGenerator of the
+// bulk body to give one.
 func (op *EsOperation) Id() (string, error) {
+	if _, ok := op.idObject()["_id"]; !ok {
+		return "", nil
+	}
 	id, err := op.Operation.ObjectId()
 	if err != nil {
 		return "", err
@@ -139,7 +188,8 @@ func (op *EsOperation) Action() (string, error) {
 	return op.action, nil
 }
 
-// Document returns the changed document for Insert or Update.
+// Document returns the changed document for Insert or Update. Values keep the types decoded from
+// BSON, int64 is marshaled as an exact integer and never goes through float64.
 func (op *EsOperation) Document() (map[string]interface{}, error) {
 	if op.doc != nil {
 		return op.doc, nil
@@ -167,7 +217,8 @@ func (op *EsOperation) Document() (map[string]interface{}, error) {
 			}
 		}
 		if sets != nil {
-			changes = sets.(bson.M)
+			// Only the changed fields are sent, as a partial document upserted by ES
+			changes = expandPaths(sets.(bson.M))
 			break
 		}
 		// All other updates is a full document(?)
@@ -181,7 +232,15 @@ func (op *EsOperation) Document() (map[string]interface{}, error) {
 
 	// Run the document through the manipulators to make it look like we want it to before it hits ES
 	for _, manip := range op.manipulators {
-		if err := manip.Manipulate(&changes, op.Op); err != nil {
+		var err error
+		if opManip, ok := manip.(OperationManipulator); ok {
+			err = opManip.ManipulateOperation(&changes, op.Operation)
+		} else {
+			err = manip.Manipulate(&changes, op.Op)
+		}
+		if err != nil {
+			// Not cached, every call fails the same way
+			op.doc = nil
 			return nil, err
 		}
 	}
@@ -203,32 +262,91 @@ func (op *EsOperation) nsSplit() (string, string, error) {
 	return parts[0], parts[1], nil
 }
 
+// Target is where operations on a namespace are indexed instead of what is derived from it.
+type Target struct {
+	Index string
+
+	// Type is optional, the collection name is used when empty
+	Type string
+}
+
+// Targets overrides the index and type for specific namespaces, such as to index mydb.users into a
+// new index during a reindex without renaming the collection. Namespaces not found here are
+// indexed by the index map given to NewEsOperation and typed by their collection name.
+var Targets = make(map[string]Target)
+
 func (op *EsOperation) Index() (string, error) {
+	if target, ok := Targets[op.Namespace]; ok && target.Index != "" {
+		return target.Index, nil
+	}
 	i, _, e := op.nsSplit()
 	if e != nil {
 		return i, e
 	}
-	if mapped, ok := op.indexMap[i]; !ok {
+	if mapped, ok := lookupPattern(op.indexMap, i); !ok {
 		return i, errors.New(fmt.Sprint("No mapped index found for:", i))
 	} else {
 		return mapped, nil
 	}
 }
 func (op *EsOperation) Type() (string, error) {
+	if target, ok := Targets[op.Namespace]; ok && target.Type != "" {
+		return target.Type, nil
+	}
 	_, t, e := op.nsSplit()
 	return t, e
 }
 
+// Coalesce selects the namespaces whose documents are only indexed in their last state within a
+// bulk body, dropping earlier operations replaced by an insert or full document update. Meant for
+// documents updated many times a second, where most of the operations would be wasted on ES.
+var Coalesce NamespaceFilter
+
+// Replaces is true for operations carrying the whole document in namespaces selected by Coalesce.
+// Partial updates depends on what came before them and never replaces anything.
+func (op *EsOperation) Replaces() bool {
+	if !Coalesce.Match(op.Namespace) {
+		return false
+	}
+	switch op.Op {
+	case Insert:
+		return true
+	case Update:
+		_, sets := op.Object["$set"]
+		_, unsets := op.Object["$unset"]
+		return !sets && !unsets
+	}
+	return false
+}
+
+// Upsert tells if an update creates the document when it isn't indexed, as partial updates do
+// unless a Transform may have left the document out of ES.
+func (op *EsOperation) Upsert() bool {
+	return !op.noUpsert
+}
+
 func (op *EsOperation) Time() *time.Time {
 	return op.Timestamp.Time()
 }
 
+// Sequence is the timestamp of the operation, which increases in the order of the oplog.
+func (op *EsOperation) Sequence() uint64 {
+	return uint64(op.Timestamp)
+}
+
 // Manipulator is used for changing documents in specific ways. These can get added to
 // the EsOperation to have changes applied on all mapped operations.
 type Manipulator interface {
 	Manipulate(doc *bson.M, op OplogOperation) error
 }
 
+// OperationManipulator is a Manipulator that needs to know more about the operation than its kind,
+// such as the id or timestamp. It's used instead of Manipulate when implemented.
+type OperationManipulator interface {
+	Manipulator
+	ManipulateOperation(doc *bson.M, op *Operation) error
+}
+
 // ManipulateFunc makes a function into a Manipulator
 type ManipulateFunc func(doc *bson.M, op OplogOperation) error
 
@@ -1,7 +1,10 @@
 package mongodb
 
 import (
+	"github.com/duego/cryriver/elasticsearch"
+	"github.com/duego/cryriver/redact"
 	"labix.org/v2/mgo/bson"
+	"strings"
 	"testing"
 	"time"
 )
@@ -225,3 +228,177 @@ func TestInsertOperation(t *testing.T) {
 		}
 	}
 }
+
+func TestOperationErrorRedacts(t *testing.T) {
+	previous := redact.Paths
+	redact.Paths = []string{"email"}
+	defer func() { redact.Paths = previous }()
+
+	op := &Operation{
+		Namespace: "test.users",
+		Op:        "x",
+		Object:    bson.M{"email": "johnny@example.com", "alias": "Johnny"},
+	}
+	_, err := (&EsOperation{Operation: op}).Action()
+	if err == nil {
+		t.Fatal("Expected unsupported operation")
+	}
+	if msg := err.Error(); strings.Contains(msg, "johnny@example.com") || !strings.Contains(msg, "Johnny") {
+		t.Error("Expected only email to be masked:\n", msg)
+	}
+	if op.Object["email"] != "johnny@example.com" {
+		t.Error("Expected operation to be left untouched")
+	}
+}
+
+func TestLargeIntegersPreserved(t *testing.T) {
+	// Above 2^53 where a float64 can no longer tell it apart from 9007199254740992
+	const large int64 = 9007199254740993
+	ops := []*Operation{
+		bsonToOperation(t, &bson.M{
+			"op": "i",
+			"ns": "test.users",
+			"o":  map[string]interface{}{"_id": bson.ObjectIdHex("50eadae392cd864e50cd0dbc"), "counter": large},
+		}),
+		bsonToOperation(t, &bson.M{
+			"op": "u",
+			"ns": "test.users",
+			"o2": map[string]interface{}{"_id": bson.ObjectIdHex("50eadae392cd864e50cd0dbc")},
+			"o":  map[string]interface{}{"$set": map[string]interface{}{"stats": map[string]interface{}{"counter": large}}},
+		}),
+	}
+	for _, op := range ops {
+		bulk := elasticsearch.NewBulkBody(elasticsearch.MB)
+		if err := bulk.Add(getEsOp(op)); err != nil {
+			t.Fatal(err)
+		}
+		if source := bulk.String(); !strings.Contains(source, `"counter":9007199254740993`) {
+			t.Error("Expected integer to be preserved exactly, got", source)
+		}
+	}
+}
+
+func TestUpdateOperationNestedSet(t *testing.T) {
+	op := bsonToOperation(t, &bson.M{
+		"op": "u",
+		"ns": "test.users",
+		"o2": map[string]interface{}{"_id": bson.ObjectIdHex("52e7db73f4eb27371874b289")},
+		"o": map[string]interface{}{"$set": map[string]interface{}{
+			"alias":             "Johnny",
+			"photo_tally.total": 2,
+			"a.b.c":             true,
+		}},
+	})
+
+	bulk := elasticsearch.NewBulkBody(elasticsearch.MB)
+	if err := bulk.Add(getEsOp(op)); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"update":{"_index":"test","_type":"users","_id":"52e7db73f4eb27371874b289"}}
+{"doc":{"a":{"b":{"c":true}},"alias":"Johnny","photo_tally":{"total":2}},"doc_as_upsert":true}
+`
+	if s := bulk.String(); s != expected {
+		t.Error("Expected only the changed fields as a nested partial document, got", s)
+	}
+}
+
+func TestEsOperationTargets(t *testing.T) {
+	previous := Targets
+	Targets = map[string]Target{
+		"test.users":  {Index: "users-v2", Type: "user"},
+		"test.photos": {Index: "photos-v2"},
+	}
+	defer func() { Targets = previous }()
+
+	for ns, expected := range map[string][2]string{
+		"test.users":  {"users-v2", "user"},
+		"test.photos": {"photos-v2", "photos"},
+		"test.albums": {"test", "albums"},
+	} {
+		op := getEsOp(&Operation{Namespace: ns, Op: Insert, Object: bson.M{"_id": bson.NewObjectId()}})
+		if index, err := op.Index(); err != nil || index != expected[0] {
+			t.Error("Expected index", expected[0], "for", ns, "got", index, err)
+		}
+		if typ, err := op.Type(); err != nil || typ != expected[1] {
+			t.Error("Expected type", expected[1], "for", ns, "got", typ, err)
+		}
+	}
+}
+
+func TestEsOperationReplaces(t *testing.T) {
+	previous := Coalesce
+	Coalesce = NamespaceFilter{Include: []string{"test.*"}, Exclude: []string{"test.photos"}}
+	defer func() { Coalesce = previous }()
+
+	id := bson.NewObjectId()
+	tests := []struct {
+		op       *Operation
+		replaces bool
+	}{
+		{&Operation{Namespace: "test.users", Op: Insert, Object: bson.M{"_id": id}}, true},
+		{&Operation{Namespace: "test.users", Op: Update, UpdateObject: bson.M{"_id": id}, Object: bson.M{"_id": id, "name": "johnny"}}, true},
+		{&Operation{Namespace: "test.users", Op: Update, UpdateObject: bson.M{"_id": id}, Object: bson.M{"$set": bson.M{"name": "johnny"}}}, false},
+		{&Operation{Namespace: "test.users", Op: Update, UpdateObject: bson.M{"_id": id}, Object: bson.M{"$unset": bson.M{"name": 1}}}, false},
+		{&Operation{Namespace: "test.users", Op: Delete, Object: bson.M{"_id": id}}, false},
+		{&Operation{Namespace: "test.photos", Op: Insert, Object: bson.M{"_id": id}}, false},
+	}
+	for _, test := range tests {
+		if replaces := getEsOp(test.op).Replaces(); replaces != test.replaces {
+			t.Error("Expected", test.op, "to replace:", test.replaces)
+		}
+	}
+}
+
+func TestArrayOfDocumentsPreserved(t *testing.T) {
+	id := bson.ObjectIdHex("52e7db73f4eb27371874b289")
+	comments := []interface{}{
+		bson.M{"author": "johnny", "posted": time.Date(2014, time.January, 28, 16, 0, 0, 0, time.UTC)},
+		bson.M{"author": "jane", "likes": []interface{}{bson.M{"by": "johnny"}}},
+	}
+	insert := &Operation{Namespace: "test.posts", Op: Insert, Object: bson.M{"_id": id, "comments": comments}}
+	update := &Operation{Namespace: "test.posts", Op: Update, UpdateObject: bson.M{"_id": id}, Object: bson.M{"$set": bson.M{
+		"meta.comments": []bson.M{{"author": "johnny"}, {"author": "jane"}},
+	}}}
+
+	bulk := elasticsearch.NewBulkBody(elasticsearch.MB, elasticsearch.WithMarshaler(MarshalJSON))
+	for _, op := range []*Operation{insert, update} {
+		if err := bulk.Add(getEsOp(op)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := `{"index":{"_index":"test","_type":"posts","_id":"52e7db73f4eb27371874b289"}}
+{"_id":"52e7db73f4eb27371874b289","comments":[{"author":"johnny","posted":"2014-01-28T16:00:00.000Z"},{"author":"jane","likes":[{"by":"johnny"}]}]}
+{"update":{"_index":"test","_type":"posts","_id":"52e7db73f4eb27371874b289"}}
+{"doc":{"meta":{"comments":[{"author":"johnny"},{"author":"jane"}]}},"doc_as_upsert":true}
+`
+	if s := bulk.String(); s != expected {
+		t.Error("Expected arrays of sub-documents to be kept as arrays, got", s)
+	}
+}
+
+func TestOperationStringUnmarshalable(t *testing.T) {
+	op := Operation{Namespace: "test.users", Op: Insert, Object: bson.M{"f": func() {}}}
+	if s := op.String(); !strings.Contains(s, "test.users") {
+		t.Error("Expected the fields of the operation, got", s)
+	}
+}
+
+func TestEsOperationWithoutId(t *testing.T) {
+	op := getEsOp(&Operation{Namespace: "test.events", Op: Insert, Object: bson.M{"kind": "login", "at": 1393325184}})
+	if id, err := op.Id(); err != nil || id != "" {
+		t.Fatal("Expected no id and no error without _id, got", id, err)
+	}
+	bulk := elasticsearch.NewBulkBody(elasticsearch.MB, elasticsearch.WithIdGenerator(elasticsearch.HashFields("kind", "at")))
+	if err := bulk.Add(op); err != nil {
+		t.Fatal(err)
+	}
+	if s := bulk.String(); !strings.Contains(s, `"_id":"`) {
+		t.Error("Expected an id from the generator, got", s)
+	}
+
+	// An _id of another kind is still an error
+	op = getEsOp(&Operation{Namespace: "test.events", Op: Insert, Object: bson.M{"_id": 1}})
+	if _, err := op.Id(); err == nil {
+		t.Error("Expected an error for an _id that isn't an ObjectId")
+	}
+}
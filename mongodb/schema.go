@@ -0,0 +1,277 @@
+package mongodb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/duego/cryriver/elasticsearch"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a JSON Schema documents are validated against before being indexed. The keywords
+// understood are type, properties, required, additionalProperties, items, enum, minimum, maximum,
+// minLength, maxLength, pattern, minItems and maxItems, others such as $schema, title or format
+// are ignored.
+type Schema struct {
+	Type                 schemaTypes        `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+
+	pattern    *regexp.Regexp
+	additional *Schema
+	closed     bool
+}
+
+// schemaTypes is the type of a Schema, either a single type or a list of them.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// ParseSchema parses a JSON Schema, compiling its patterns.
+func ParseSchema(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return &s, s.compile()
+}
+
+// LoadSchema reads the JSON Schema in the file at path.
+func LoadSchema(path string) (*Schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := ParseSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("Schema in %s is invalid: %s", path, err)
+	}
+	return s, nil
+}
+
+// compile prepares the patterns and additional properties of s and of its sub-schemas.
+func (s *Schema) compile() error {
+	if s.Pattern != "" {
+		var err error
+		if s.pattern, err = regexp.Compile(s.Pattern); err != nil {
+			return err
+		}
+	}
+	switch trimmed := bytes.TrimSpace(s.AdditionalProperties); {
+	case len(trimmed) == 0, string(trimmed) == "true":
+	case string(trimmed) == "false":
+		s.closed = true
+	default:
+		s.additional = new(Schema)
+		if err := json.Unmarshal(trimmed, s.additional); err != nil {
+			return err
+		}
+		if err := s.additional.compile(); err != nil {
+			return err
+		}
+	}
+	for _, p := range s.Properties {
+		if err := p.compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// Validate checks doc as it would be indexed against s, returning an *elasticsearch.ValidationError
+// for the first value failing it.
+func (s *Schema) Validate(doc bson.M) error {
+	data, err := MarshalJSON(doc)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return err
+	}
+	if invalid := s.validate(v, ""); invalid != nil {
+		invalid.Document = map[string]interface{}(doc)
+		return invalid
+	}
+	return nil
+}
+
+// validate checks the JSON value v at the dotted path against s.
+func (s *Schema) validate(v interface{}, path string) *elasticsearch.ValidationError {
+	invalid := func(format string, args ...interface{}) *elasticsearch.ValidationError {
+		return &elasticsearch.ValidationError{Path: path, Reason: fmt.Sprintf(format, args...)}
+	}
+	typ := jsonType(v)
+	if len(s.Type) > 0 && !s.Type.match(typ) {
+		return invalid("Expected %s, got %s", strings.Join(s.Type, " or "), typ)
+	}
+	if len(s.Enum) > 0 && !inEnum(v, s.Enum) {
+		return invalid("Value is not one of the enum")
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := t[name]; !ok {
+				return invalid("Required field %s is missing", name)
+			}
+		}
+		for key, value := range t {
+			sub, ok := s.Properties[key]
+			switch {
+			case ok:
+			case s.closed:
+				return invalid("Field %s is not allowed", key)
+			case s.additional != nil:
+				sub = s.additional
+			default:
+				continue
+			}
+			if err := sub.validate(value, joinPath(path, key)); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(t) < *s.MinItems {
+			return invalid("Expected at least %d items, got %d", *s.MinItems, len(t))
+		}
+		if s.MaxItems != nil && len(t) > *s.MaxItems {
+			return invalid("Expected at most %d items, got %d", *s.MaxItems, len(t))
+		}
+		if s.Items != nil {
+			for i, elem := range t {
+				if err := s.Items.validate(elem, joinPath(path, strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(t)
+		if s.MinLength != nil && length < *s.MinLength {
+			return invalid("Expected at least %d characters, got %d", *s.MinLength, length)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return invalid("Expected at most %d characters, got %d", *s.MaxLength, length)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(t) {
+			return invalid("Value doesn't match %s", s.Pattern)
+		}
+	case json.Number:
+		n, _ := t.Float64()
+		if s.Minimum != nil && n < *s.Minimum {
+			return invalid("Expected at least %v, got %s", *s.Minimum, t)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return invalid("Expected at most %v, got %s", *s.Maximum, t)
+		}
+	}
+	return nil
+}
+
+// match tells if a value of the JSON type typ is any of t, integers are numbers as well.
+func (t schemaTypes) match(typ string) bool {
+	for _, allowed := range t {
+		if allowed == typ || (allowed == "number" && typ == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type of a decoded JSON value.
+func jsonType(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := t.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// inEnum tells if v equals any of the values of enum, compared by their JSON.
+func inEnum(v interface{}, enum []interface{}) bool {
+	value, _ := json.Marshal(v)
+	for _, allowed := range enum {
+		if b, _ := json.Marshal(allowed); bytes.Equal(b, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinPath returns the dotted path of key in path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// schemaValidator implements ValidateSchema.
+type schemaValidator struct {
+	namespaces NamespaceFilter
+	schema     *Schema
+}
+
+// ValidateSchema returns a Manipulator validating the documents of namespaces against schema, it
+// should come after the manipulators changing documents to validate them as they are indexed.
+// Invalid documents fail with an *elasticsearch.ValidationError, which the marshal policy of the
+// bulk body skips, dead-letters or fails on like documents that can't be marshaled. Partial updates
+// are left alone as they lack the fields they don't set.
+func ValidateSchema(namespaces NamespaceFilter, schema *Schema) Manipulator {
+	return &schemaValidator{namespaces, schema}
+}
+
+func (m *schemaValidator) Manipulate(doc *bson.M, op OplogOperation) error {
+	// Without the namespace there is no telling which schema applies
+	return nil
+}
+
+func (m *schemaValidator) ManipulateOperation(doc *bson.M, op *Operation) error {
+	if !m.namespaces.Match(op.Namespace) || isPartial(op) {
+		return nil
+	}
+	err := m.schema.Validate(*doc)
+	if _, ok := err.(*elasticsearch.ValidationError); !ok {
+		// Documents that can't be marshaled are left to fail when added to the bulk body
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package mongodb
+
+import (
+	"github.com/duego/cryriver/elasticsearch"
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+const userSchema = `{
+	"$schema": "http://json-schema.org/draft-07/schema#",
+	"type": "object",
+	"required": ["name", "age"],
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"status": {"enum": ["active", "banned"]},
+		"tags": {"type": "array", "items": {"type": "string", "pattern": "^[a-z]+$"}},
+		"profile": {"type": "object", "additionalProperties": false, "properties": {"site": {"type": ["string", "null"]}}}
+	}
+}`
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := ParseSchema([]byte(userSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := bson.M{
+		"_id":     bson.ObjectIdHex("50eadae392cd864e50cd0dbc"),
+		"name":    "Johnny",
+		"age":     int64(42),
+		"status":  "active",
+		"tags":    []interface{}{"a", "b"},
+		"profile": bson.M{"site": nil},
+	}
+	if err := schema.Validate(valid); err != nil {
+		t.Error("Expected a valid document, got", err)
+	}
+
+	for path, doc := range map[string]bson.M{
+		"":             {"name": "Johnny"},
+		"age":          {"name": "Johnny", "age": 4.2},
+		"name":         {"name": "", "age": 1},
+		"status":       {"name": "Johnny", "age": 1, "status": "gone"},
+		"tags.1":       {"name": "Johnny", "age": 1, "tags": []interface{}{"a", "B"}},
+		"profile":      {"name": "Johnny", "age": 1, "profile": bson.M{"other": 1}},
+		"profile.site": {"name": "Johnny", "age": 1, "profile": bson.M{"site": 1}},
+	} {
+		err, ok := schema.Validate(doc).(*elasticsearch.ValidationError)
+		if !ok {
+			t.Error("Expected a *ValidationError for", doc, "got", err)
+			continue
+		}
+		if err.Path != path {
+			t.Error("Expected the error at", path, "got", err)
+		}
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	schema, err := ParseSchema([]byte(userSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate := ValidateSchema(NamespaceFilter{Include: []string{"test.users"}}, schema)
+
+	op := &Operation{Namespace: "test.users", Op: Insert, Object: bson.M{"_id": "a", "name": "Johnny"}}
+	_, err = NewEsOperation(map[string]string{"test": "test"}, []Manipulator{validate}, op).Document()
+	if invalid, ok := err.(*elasticsearch.ValidationError); !ok || invalid.Document["name"] != "Johnny" {
+		t.Error("Expected a *ValidationError with the document, got", err)
+	}
+
+	// Partial updates and other namespaces are left alone
+	partial := &Operation{Namespace: "test.users", Op: Update, Object: bson.M{"$set": bson.M{"age": 43}}}
+	if _, err := NewEsOperation(map[string]string{"test": "test"}, []Manipulator{validate}, partial).Document(); err != nil {
+		t.Error("Expected partial updates not to be validated, got", err)
+	}
+	other := &Operation{Namespace: "test.other", Op: Insert, Object: bson.M{"_id": "a"}}
+	if _, err := NewEsOperation(map[string]string{"test": "test"}, []Manipulator{validate}, other).Document(); err != nil {
+		t.Error("Expected other namespaces not to be validated, got", err)
+	}
+}
@@ -3,6 +3,7 @@ package mongodb
 
 import (
 	"errors"
+	"github.com/duego/cryriver/stats"
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	"log"
@@ -25,9 +26,38 @@ func Optime(s *mgo.Session) (*Timestamp, error) {
 	return ts, nil
 }
 
-// Tail sends mongodb operations for the namespace on the specified channel.
+// oplogQuery selects the oplog entries after lastTs of the namespaces in filter, along with
+// transactions and noops when TailNoops is set.
+//
+// The checkpoint is the timestamp of the last operation fully handled, which is never read again:
+// timestamps are unique within the oplog of a replica set, the counter part telling apart entries
+// of the same second, so there is no need to tie-break on the h hash of entries which MongoDB 4.2
+// no longer writes anyway. Skipping it keeps non-idempotent operations, such as counters updated
+// by scripts, from being applied twice at the boundary. Operations after it may still be read again
+// after a restart, as the checkpoint is saved every second and held back by operations not yet
+// accepted by ES: delivery is at-least-once, not exactly-once.
+func oplogQuery(filter NamespaceFilter, lastTs Timestamp) bson.M {
+	// Transactions are found as commands on the admin database
+	selectors := []bson.M{
+		{"ns": bson.RegEx{Pattern: filter.regex()}},
+		{"ns": txnNamespace},
+	}
+	if TailNoops {
+		selectors = append(selectors, bson.M{"op": Noop})
+	}
+	return bson.M{
+		"ts":  bson.M{"$gt": lastTs},
+		"$or": selectors,
+	}
+}
+
+// TailNoops makes Tail send noop entries of the oplog as well, so that the checkpoint keeps moving
+// on an idle replica set and a restart doesn't have to scan back to the last change.
+var TailNoops = true
+
+// Tail sends mongodb operations for the namespaces selected by filter on the specified channel.
 // Interrupts tailing if exit chan closes.
-func Tail(session *mgo.Session, ns string, initial bool, lastTs *Timestamp, opc chan<- *Operation, exit chan bool) error {
+func Tail(session *mgo.Session, filter NamespaceFilter, initial bool, lastTs *Timestamp, opc chan<- *Operation, exit chan bool) error {
 	defer close(opc)
 	defer session.Close()
 
@@ -44,49 +74,13 @@ func Tail(session *mgo.Session, ns string, initial bool, lastTs *Timestamp, opc
 		} else {
 			lastTs = ts
 		}
-		nsParts := strings.Split(ns, ".")
-		if len(nsParts) != 2 {
-			return errors.New("Exected namespace provided as database.collection")
+		namespaces, err := Namespaces(session, filter)
+		if err != nil {
+			return err
 		}
-		col := session.DB(nsParts[0]).C(nsParts[1])
-		iter := col.Find(nil).Iter()
-		initialDone := make(chan bool)
-		go func() {
-			log.Println("Doing initial import, this may take a while...")
-			var count uint64
-			for {
-				var result bson.M
-				if iter.Next(&result) {
-					select {
-					case opc <- &Operation{
-						Namespace: ns,
-						Op:        Insert,
-						Object:    result,
-					}:
-						count++
-					case <-exit:
-						break
-					}
-				} else {
-					break
-				}
-			}
-			log.Println("Initial import object count:", count)
-			close(initialDone)
-		}()
-
-	waitInitialSync:
-		for {
-			select {
-			case <-initialDone:
-				if err := iter.Close(); err != nil {
-					return err
-				}
-				break waitInitialSync
-			case <-exit:
-				log.Println("Initial import was interrupted")
-				err := iter.Close()
-				<-initialDone
+		log.Println("Doing initial import, this may take a while...")
+		for _, ns := range namespaces {
+			if interrupted, err := importCollection(session, ns, opc, exit); err != nil || interrupted {
 				return err
 			}
 		}
@@ -98,22 +92,30 @@ func Tail(session *mgo.Session, ns string, initial bool, lastTs *Timestamp, opc
 
 	log.Println("Resuming oplog from timestamp:", *lastTs)
 	log.Println("It could take a moment for MongoDB to scan through the oplog collection...")
-	query := bson.M{"ns": ns, "ts": bson.M{"$gt": *lastTs}}
-
 	// Start tailing, sorted by forward natural order by default in capped collections.
-	iter := col.Find(query).Tail(-1)
+	iter := col.Find(oplogQuery(filter, *lastTs)).Tail(-1)
 	iterClosed := make(chan bool)
+	var decodeErr error
 	go func() {
+		txns := newTransactions(filter)
+	tail:
 		for {
+			// Read as is first to tell how much was read
+			var raw bson.Raw
+			if !iter.Next(&raw) {
+				break
+			}
 			var result Operation
-			if iter.Next(&result) {
+			if decodeErr = raw.Unmarshal(&result); decodeErr != nil {
+				break
+			}
+			countRead(string(result.Op), len(raw.Data), result.Timestamp)
+			for _, op := range txns.unwrap(&result) {
 				select {
-				case opc <- &result:
+				case opc <- op:
 				case <-exit:
-					break
+					break tail
 				}
-			} else {
-				break
 			}
 		}
 		close(iterClosed)
@@ -127,5 +129,103 @@ func Tail(session *mgo.Session, ns string, initial bool, lastTs *Timestamp, opc
 	err := iter.Close()
 	// Make sure iterator has stoped pumping into opc since it will be closed on defered func
 	<-iterClosed
+	if err == nil {
+		err = decodeErr
+	}
+	if err != nil {
+		stats.MongoCursorErrors.Add(1)
+	}
 	return err
 }
+
+// countRead records an operation of kind read from MongoDB in size bytes in the stats, the time
+// of the latest operation is only moved by those having a timestamp.
+func countRead(kind string, size int, ts Timestamp) {
+	stats.MongoRead.Add(kind, 1)
+	stats.MongoReadBytes.Add(int64(size))
+	if ts != 0 {
+		stats.MongoLastRead.Set(ts.Time().Unix())
+	}
+}
+
+// Namespaces lists the existing namespaces selected by filter. Databases and collections are only
+// listed when there are patterns to match, plain names are returned as they are.
+func Namespaces(session *mgo.Session, filter NamespaceFilter) ([]string, error) {
+	namespaces, patterns := filter.literals()
+	if !patterns {
+		return namespaces, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, ns := range namespaces {
+		seen[ns] = true
+	}
+	dbs, err := session.DatabaseNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, db := range dbs {
+		cols, err := session.DB(db).CollectionNames()
+		if err != nil {
+			return nil, err
+		}
+		for _, col := range cols {
+			if ns := db + "." + col; !seen[ns] && filter.Match(ns) {
+				namespaces = append(namespaces, ns)
+			}
+		}
+	}
+	return namespaces, nil
+}
+
+// importCollection sends all documents of the collection ns as inserts.
+func importCollection(session *mgo.Session, ns string, opc chan<- *Operation, exit chan bool) (interrupted bool, err error) {
+	nsParts := strings.SplitN(ns, ".", 2)
+	if len(nsParts) != 2 {
+		return false, errors.New("Exected namespace provided as database.collection")
+	}
+	col := session.DB(nsParts[0]).C(nsParts[1])
+	iter := col.Find(nil).Iter()
+	initialDone := make(chan bool)
+	var decodeErr error
+	go func() {
+		var count uint64
+	read:
+		for {
+			var raw bson.Raw
+			if !iter.Next(&raw) {
+				break
+			}
+			var result bson.M
+			if decodeErr = raw.Unmarshal(&result); decodeErr != nil {
+				break
+			}
+			countRead("import", len(raw.Data), 0)
+			select {
+			case opc <- &Operation{
+				Namespace: ns,
+				Op:        Insert,
+				Object:    result,
+			}:
+				count++
+			case <-exit:
+				break read
+			}
+		}
+		log.Println("Initial import object count for", ns+":", count)
+		close(initialDone)
+	}()
+
+	select {
+	case <-initialDone:
+		if err := iter.Close(); err != nil {
+			return false, err
+		}
+		return false, decodeErr
+	case <-exit:
+		log.Println("Initial import was interrupted")
+		err := iter.Close()
+		<-initialDone
+		return true, err
+	}
+}
@@ -0,0 +1,40 @@
+package mongodb
+
+import (
+	"expvar"
+	"github.com/duego/cryriver/stats"
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+func TestCountRead(t *testing.T) {
+	read := func(kind string) int64 {
+		if n, ok := stats.MongoRead.Get(kind).(*expvar.Int); ok {
+			return n.Value()
+		}
+		return 0
+	}
+	inserts := read("i")
+	before := stats.MongoReadBytes.Value()
+	var ts Timestamp = 5982836443431567364
+	countRead("i", 120, ts)
+	countRead("import", 80, 0)
+
+	if n := read("i") - inserts; n != 1 {
+		t.Error("Expected the insert to be counted once, got", n)
+	}
+	if n := stats.MongoReadBytes.Value() - before; n != 200 {
+		t.Error("Expected 200 bytes read, got", n)
+	}
+	if last := stats.MongoLastRead.Value(); last != ts.Time().Unix() {
+		t.Error("Expected the time of the operation, not moved by the import, got", last)
+	}
+}
+
+func TestOplogQuerySkipsCheckpoint(t *testing.T) {
+	var ts Timestamp = 5982836443431567364
+	query := oplogQuery(NamespaceFilter{Include: []string{"testing.users"}}, ts)
+	if after, ok := query["ts"].(bson.M)["$gt"]; !ok || after != ts {
+		t.Error("Expected only operations after the checkpoint to be read, got", query["ts"])
+	}
+}
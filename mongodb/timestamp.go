@@ -1,14 +1,21 @@
 package mongodb
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"labix.org/v2/mgo/bson"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// ErrInvalidTimestamp is returned when loading something that isn't a saved timestamp, such as a
+// file left empty or partly written by a crash.
+var ErrInvalidTimestamp = errors.New("Stored timestamp is invalid")
+
 type Timestamp bson.MongoTimestamp
 
 // Time converts a mongo timestamp to Time with UTC selected as timezone.
@@ -46,21 +53,38 @@ func (t Timestamp) Save(w io.Writer) error {
 	return nil
 }
 
-// Load sets the timestamp provided by an io.Reader.
+// SaveFile replaces the file at path with the timestamp. It's written to a temporary file that is
+// renamed into place, so that the file never holds a partly written timestamp even if we crash.
+func (t Timestamp) SaveFile(path string) error {
+	return writeFileAtomic(path, t.Save)
+}
+
+// Load sets the timestamp provided by an io.Reader. Returns ErrInvalidTimestamp unless it reads a
+// positive number.
 func (t *Timestamp) Load(r io.Reader) error {
 	b, err := ioutil.ReadAll(r)
 	if err != nil {
 		return err
 	}
 
-	i, err := strconv.Atoi(string(b))
-	if err != nil {
-		return err
+	i, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil || i <= 0 {
+		return ErrInvalidTimestamp
 	}
 	*t = Timestamp(i)
 	return nil
 }
 
+// LoadFile sets the timestamp saved in the file at path.
+func (t *Timestamp) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return t.Load(f)
+}
+
 // Optime is marked "internal" by 10gen and is also broken as it reports the wrong bson type.
 // Work around this by providing the interface to unmarshal it properly.
 type optime Timestamp
@@ -2,6 +2,9 @@ package mongodb
 
 import (
 	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -46,3 +49,51 @@ func TestLoadTimestamp(t *testing.T) {
 	}
 
 }
+
+func TestLoadTimestampTruncated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cryriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cryriver.db")
+
+	// Left empty or partly written by a crash
+	for _, content := range []string{"", "59842860979731\x00"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		ts := Timestamp(42)
+		if err := ts.LoadFile(path); err != ErrInvalidTimestamp {
+			t.Errorf("Expected %q to be invalid, got %v", content, err)
+		}
+		if ts != 42 {
+			t.Error("Expected timestamp to be left alone, got", int64(ts))
+		}
+	}
+}
+
+func TestSaveTimestampFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cryriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cryriver.db")
+
+	for _, ts := range []Timestamp{5984286097973182465, 5984286097973182466} {
+		if err := ts.SaveFile(path); err != nil {
+			t.Fatal(err)
+		}
+		var loaded Timestamp
+		if err := loaded.LoadFile(path); err != nil {
+			t.Fatal(err)
+		}
+		if loaded != ts {
+			t.Error("Expected", int64(ts), "got", int64(loaded))
+		}
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Error("Expected temporary files to be renamed into place, got", len(files))
+	}
+}
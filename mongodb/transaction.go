@@ -0,0 +1,100 @@
+package mongodb
+
+import (
+	"fmt"
+	"labix.org/v2/mgo/bson"
+)
+
+// txnNamespace is where transactions are found in the oplog, as applyOps commands.
+const txnNamespace = "admin.$cmd"
+
+// transactions unwraps the applyOps oplog entries of multi-document transactions into the
+// operations they contain. Prepared transactions, and large transactions split into several
+// entries, are held back until they are committed.
+//
+// Delivery is at-least-once: all operations of a transaction shares the timestamp of the entry
+// committing it, so all but the last one is marked Partial to keep the checkpoint from moving past
+// the transaction until it has been completely delivered. The same goes for any operation
+// delivered while a transaction is pending. Restarting in the middle of a transaction will deliver
+// it again from the start.
+type transactions struct {
+	filter  NamespaceFilter
+	pending map[string][]*Operation
+}
+
+func newTransactions(filter NamespaceFilter) *transactions {
+	return &transactions{filter: filter, pending: make(map[string][]*Operation)}
+}
+
+// unwrap returns the operations on our namespaces that op results in, which is none for held back
+// or unrelated commands.
+func (t *transactions) unwrap(op *Operation) []*Operation {
+	var ops []*Operation
+	switch {
+	case op.Op == Noop:
+		// Only moves the checkpoint, unless within a transaction not yet committed
+		ops = []*Operation{op}
+	case op.Op != Command:
+		if t.filter.Match(op.Namespace) {
+			ops = []*Operation{op}
+		}
+	case op.Namespace != txnNamespace:
+	case op.Object["applyOps"] != nil:
+		key := op.txnKey()
+		inner := t.applyOps(op)
+		if isTrue(op.Object["prepare"]) || isTrue(op.Object["partialTxn"]) {
+			t.pending[key] = append(t.pending[key], inner...)
+			break
+		}
+		ops = append(t.pending[key], inner...)
+		delete(t.pending, key)
+	case op.Object["commitTransaction"] != nil:
+		key := op.txnKey()
+		ops = t.pending[key]
+		delete(t.pending, key)
+	case op.Object["abortTransaction"] != nil:
+		delete(t.pending, op.txnKey())
+	}
+
+	for i, o := range ops {
+		o.Timestamp = op.Timestamp
+		o.Partial = i < len(ops)-1 || len(t.pending) > 0
+	}
+	return ops
+}
+
+// applyOps returns the operations on our namespaces contained in an applyOps command.
+func (t *transactions) applyOps(op *Operation) []*Operation {
+	entries, _ := op.Object["applyOps"].([]interface{})
+	ops := make([]*Operation, 0, len(entries))
+	for _, entry := range entries {
+		m, ok := entry.(bson.M)
+		if !ok {
+			continue
+		}
+		ns, _ := m["ns"].(string)
+		if !t.filter.Match(ns) {
+			continue
+		}
+		kind, _ := m["op"].(string)
+		inner := &Operation{
+			Timestamp: op.Timestamp,
+			Namespace: ns,
+			Op:        OplogOperation(kind),
+		}
+		inner.Object, _ = m["o"].(bson.M)
+		inner.UpdateObject, _ = m["o2"].(bson.M)
+		ops = append(ops, inner)
+	}
+	return ops
+}
+
+// txnKey identifies the transaction op belongs to.
+func (op *Operation) txnKey() string {
+	return fmt.Sprint(op.Session["id"], "/", op.TxnNumber)
+}
+
+func isTrue(v interface{}) bool {
+	b, ok := v.(bool)
+	return ok && b
+}
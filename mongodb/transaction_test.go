@@ -0,0 +1,98 @@
+package mongodb
+
+import (
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+func txnEntry(ts int64, txnNumber int64, o bson.M) *Operation {
+	return &Operation{
+		Timestamp: Timestamp(ts),
+		Namespace: txnNamespace,
+		Op:        Command,
+		Object:    o,
+		Session:   bson.M{"id": "session"},
+		TxnNumber: txnNumber,
+	}
+}
+
+func innerOps() []interface{} {
+	return []interface{}{
+		bson.M{"op": "i", "ns": "test.users", "o": bson.M{"_id": 1}},
+		bson.M{"op": "i", "ns": "test.other", "o": bson.M{"_id": 2}},
+		bson.M{"op": "u", "ns": "test.users", "o": bson.M{"$set": bson.M{"a": 1}}, "o2": bson.M{"_id": 3}},
+	}
+}
+
+func TestTransactionApplyOps(t *testing.T) {
+	txns := newTransactions(NamespaceFilter{Include: []string{"test.users"}})
+	ops := txns.unwrap(txnEntry(100, 1, bson.M{"applyOps": innerOps()}))
+	if len(ops) != 2 {
+		t.Fatal("Expected the 2 operations on our namespace, got", len(ops))
+	}
+	if ops[0].Op != Insert || ops[1].Op != Update || ops[1].UpdateObject["_id"] != 3 {
+		t.Error("Unexpected operations", ops[0], ops[1])
+	}
+	for _, op := range ops {
+		if op.Timestamp != 100 {
+			t.Error("Expected outer timestamp, got", op.Timestamp)
+		}
+	}
+	if !ops[0].Partial || ops[1].Partial {
+		t.Error("Expected only the last operation to allow checkpointing")
+	}
+}
+
+func TestTransactionPrepared(t *testing.T) {
+	txns := newTransactions(NamespaceFilter{Include: []string{"test.users"}})
+	if ops := txns.unwrap(txnEntry(100, 1, bson.M{"applyOps": innerOps(), "prepare": true})); len(ops) != 0 {
+		t.Fatal("Expected prepared transaction to be held back, got", ops)
+	}
+
+	// Operations in between must not move the checkpoint past the pending transaction
+	between := txns.unwrap(&Operation{Timestamp: 101, Namespace: "test.users", Op: Insert, Object: bson.M{"_id": 4}})
+	if len(between) != 1 || !between[0].Partial {
+		t.Error("Expected operation to be delivered as partial while a transaction is pending")
+	}
+
+	ops := txns.unwrap(txnEntry(102, 1, bson.M{"commitTransaction": 1}))
+	if len(ops) != 2 {
+		t.Fatal("Expected commit to release the prepared operations, got", len(ops))
+	}
+	if ops[1].Timestamp != 102 || ops[1].Partial {
+		t.Error("Expected last operation to carry the commit timestamp", ops[1])
+	}
+}
+
+func TestTransactionAborted(t *testing.T) {
+	txns := newTransactions(NamespaceFilter{Include: []string{"test.users"}})
+	txns.unwrap(txnEntry(100, 1, bson.M{"applyOps": innerOps(), "prepare": true}))
+	if ops := txns.unwrap(txnEntry(101, 1, bson.M{"abortTransaction": 1})); len(ops) != 0 {
+		t.Error("Expected aborted transaction to deliver nothing, got", ops)
+	}
+	if len(txns.pending) != 0 {
+		t.Error("Expected aborted transaction to be forgotten")
+	}
+}
+
+func TestNoopCheckpoints(t *testing.T) {
+	txns := newTransactions(NamespaceFilter{Include: []string{"test.users"}})
+	noop := &Operation{Timestamp: 200, Op: Noop, Object: bson.M{"msg": "periodic noop"}}
+	ops := txns.unwrap(noop)
+	if len(ops) != 1 || ops[0].Partial || ops[0].Timestamp != 200 {
+		t.Fatal("Expected noop to be delivered to move the checkpoint, got", ops)
+	}
+	entries, err := Transform(nil).Apply(NewEsOperation(nil, nil, ops[0]))
+	if err != nil || len(entries) != 0 {
+		t.Error("Expected no entries for a noop, got", entries, err)
+	}
+	if entries, _ := AuditDeletes("audit").Apply(NewEsOperation(nil, nil, ops[0])); len(entries) != 0 {
+		t.Error("Expected no entries for a noop with a transform, got", entries)
+	}
+
+	// Not past a transaction still pending though
+	txns.unwrap(txnEntry(201, 1, bson.M{"applyOps": innerOps(), "prepare": true}))
+	if ops := txns.unwrap(&Operation{Timestamp: 202, Op: Noop}); len(ops) != 1 || !ops[0].Partial {
+		t.Error("Expected noop to be partial while a transaction is pending")
+	}
+}
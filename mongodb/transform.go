@@ -0,0 +1,389 @@
+package mongodb
+
+import (
+	"fmt"
+	"github.com/duego/cryriver/elasticsearch"
+	"labix.org/v2/mgo/bson"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Transform turns one EsOperation into the entries to send to ES for it. Any number of entries may
+// be returned, for example to also record the operation in another index, none drops it.
+type Transform func(op *EsOperation) ([]elasticsearch.Transaction, error)
+
+// Apply returns the entries to send for op, only op itself for a nil Transform. There are never
+// any entries for noops, they only carry a timestamp to checkpoint. Operations of a namespace with
+// a Hook are only transformed by the hook.
+func (t Transform) Apply(op *EsOperation) ([]elasticsearch.Transaction, error) {
+	if op.Op == Noop {
+		return nil, nil
+	}
+	if hook, ok := Hooks[op.Namespace]; ok {
+		return hook.apply(op)
+	}
+	if t == nil {
+		return []elasticsearch.Transaction{op}, nil
+	}
+	return t(op)
+}
+
+// Hook is a Go function taking over the transformation of all operations of a namespace, for what
+// can't be configured such as fields derived from several others or picking the index by the
+// document. It's given the operation as read from MongoDB, without any manipulators applied, and
+// returns the entries to send for it. Entries not telling a time of their own have the time of op.
+type Hook func(op Operation) ([]elasticsearch.BulkEntry, error)
+
+// Hooks holds the Hook of namespaces, such as mydb.users, replacing the EsOperation and the
+// Transform otherwise used for them. They have to be registered before operations are transformed.
+var Hooks = make(map[string]Hook)
+
+// apply returns the entries of h for op as transactions.
+func (h Hook) apply(op *EsOperation) ([]elasticsearch.Transaction, error) {
+	entries, err := h(*op.Operation)
+	if err != nil {
+		return nil, err
+	}
+	transactions := make([]elasticsearch.Transaction, len(entries))
+	for i, entry := range entries {
+		if transaction, ok := entry.(elasticsearch.Transaction); ok {
+			transactions[i] = transaction
+		} else {
+			transactions[i] = &hookEntry{entry, op}
+		}
+	}
+	return transactions, nil
+}
+
+// hookEntry is an entry returned by a Hook, timed by the operation it was returned for. The optional
+// interfaces of the entry, such as StoredScripter, are found through Unwrap.
+type hookEntry struct {
+	elasticsearch.BulkEntry
+	op *EsOperation
+}
+
+func (e *hookEntry) Unwrap() elasticsearch.BulkEntry {
+	return e.BulkEntry
+}
+
+func (e *hookEntry) Time() *time.Time {
+	return e.op.Time()
+}
+
+func (e *hookEntry) Sequence() uint64 {
+	return e.op.Sequence()
+}
+
+// AuditDeletes returns a Transform mirroring deletes into the audit index as new documents, while
+// every operation is still applied as usual.
+func AuditDeletes(index string) Transform {
+	return func(op *EsOperation) ([]elasticsearch.Transaction, error) {
+		entries := []elasticsearch.Transaction{op}
+		if action, err := op.Action(); err != nil || action != "delete" {
+			return entries, err
+		}
+		id, err := op.Id()
+		if err != nil {
+			return nil, err
+		}
+		if id == "" {
+			// Nothing to record, the delete fails on its own without an id
+			return entries, nil
+		}
+		return append(entries, &auditEntry{op, index, id}), nil
+	}
+}
+
+// auditEntry records that the document id was deleted by op.
+type auditEntry struct {
+	op    *EsOperation
+	index string
+	id    string
+}
+
+func (a *auditEntry) Action() (string, error) {
+	return "index", nil
+}
+
+func (a *auditEntry) Index() (string, error) {
+	return a.index, nil
+}
+
+func (a *auditEntry) Type() (string, error) {
+	return a.op.Type()
+}
+
+// Id is unique per delete as the same id may be deleted again after being re-inserted.
+func (a *auditEntry) Id() (string, error) {
+	return fmt.Sprintf("%s-%d", a.id, int64(a.op.Timestamp)), nil
+}
+
+func (a *auditEntry) Document() (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"id":         a.id,
+		"ns":         a.op.Namespace,
+		"op":         "delete",
+		"deleted_at": a.op.Time().Format(time.RFC3339),
+	}, nil
+}
+
+func (a *auditEntry) Time() *time.Time {
+	return a.op.Time()
+}
+
+func (a *auditEntry) Sequence() uint64 {
+	return a.op.Sequence()
+}
+
+// Predicate selects documents by the field at Path, dotted for fields of sub-documents such as
+// profile.status. Documents are selected when the field equals Value, or when it's set to anything
+// but null if Value is nil, like the exists query of ES. Values are compared by their text when
+// Value is a string, such as one given on the command line, for numbers and booleans of the
+// document to match.
+type Predicate struct {
+	Path  string
+	Value interface{}
+}
+
+// Match tells if doc is selected by p.
+func (p Predicate) Match(doc map[string]interface{}) bool {
+	v, ok := lookupPath(doc, p.Path)
+	if !ok || v == nil {
+		return false
+	}
+	if p.Value == nil {
+		return true
+	}
+	if s, ok := p.Value.(string); ok {
+		if _, isString := v.(string); !isString {
+			return fmt.Sprint(v) == s
+		}
+	}
+	return reflect.DeepEqual(v, p.Value)
+}
+
+// lookupPath finds the field at the dotted path in doc, also when set by a partial update as a
+// field named by the whole path.
+func lookupPath(doc map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := doc[path]; ok {
+		return v, true
+	}
+	parts := strings.SplitN(path, ".", 2)
+	v, ok := doc[parts[0]]
+	if !ok || len(parts) == 1 {
+		return v, ok
+	}
+	switch sub := v.(type) {
+	case bson.M:
+		return lookupPath(sub, parts[1])
+	case map[string]interface{}:
+		return lookupPath(sub, parts[1])
+	case bson.D:
+		return lookupPath(sub.Map(), parts[1])
+	}
+	return nil, false
+}
+
+// Where returns Select deleting what doesn't match, with the Predicate of each namespace looked up
+// like the index map also by patterns, such as to only mirror active users. Namespaces without a
+// predicate are all given to t.
+//
+// Unlike a PredicateFunc, a Predicate can judge a partial update setting its field: one setting it
+// to match is applied, without upsert like any partial update, while one setting it to something
+// else or unsetting it removes the document.
+func Where(predicates map[string]Predicate, t Transform) Transform {
+	return selectBy(func(ns string) (selector, bool) {
+		p, ok := predicateOf(predicates, ns)
+		judges := func(sets map[string]interface{}) bool {
+			_, set := lookupPath(sets, p.Path)
+			return set
+		}
+		return selector{p.Match, judges}, ok
+	}, true, t)
+}
+
+// withoutUpsert returns op only updating the document if it's indexed.
+func withoutUpsert(op *EsOperation) *EsOperation {
+	update := *op
+	update.noUpsert = true
+	return &update
+}
+
+// deleteOf returns a delete of the document of op, at the same place in the stream as op, for a
+// document left out of ES rather than deleted from MongoDB.
+func deleteOf(op *EsOperation) *EsOperation {
+	del := NewEsOperation(op.indexMap, op.manipulators, &Operation{
+		Timestamp:   op.Timestamp,
+		Namespace:   op.Namespace,
+		Op:          Delete,
+		Object:      bson.M{"_id": op.idObject()["_id"]},
+		Partial:     op.Partial,
+		ResumeToken: op.ResumeToken,
+	})
+	del.leftOut = true
+	return del
+}
+
+// SoftDelete returns a Transform applying t and flagging documents as deleted instead of deleting
+// them from ES, for searches to still find what was removed from MongoDB. Deletes read from
+// MongoDB are turned into updates of the same document setting field to true, without upsert so
+// that only documents already indexed are flagged. Documents left out by Where or Select are still
+// deleted, they were never removed from MongoDB, and entries of hooks are sent as they are. A
+// document inserted again is indexed anew, without the flag.
+func SoftDelete(field string, t Transform) Transform {
+	return func(op *EsOperation) ([]elasticsearch.Transaction, error) {
+		entries, err := t.Apply(op)
+		if err != nil {
+			return nil, err
+		}
+		for i, entry := range entries {
+			del, ok := entry.(*EsOperation)
+			if !ok || del.Op != Delete || del.leftOut {
+				continue
+			}
+			if action, err := del.Action(); err != nil || action != "delete" {
+				continue
+			}
+			if id, err := del.Id(); err != nil || id == "" {
+				// Left to fail as a delete, there is no document to flag without an id
+				continue
+			}
+			entries[i] = &tombstone{del, field}
+		}
+		return entries, nil
+	}
+}
+
+// tombstone flags the document deleted by op with field.
+type tombstone struct {
+	op    *EsOperation
+	field string
+}
+
+func (s *tombstone) Action() (string, error) {
+	return "update", nil
+}
+
+func (s *tombstone) Index() (string, error) {
+	return s.op.Index()
+}
+
+func (s *tombstone) Type() (string, error) {
+	return s.op.Type()
+}
+
+func (s *tombstone) Id() (string, error) {
+	return s.op.Id()
+}
+
+func (s *tombstone) Document() (map[string]interface{}, error) {
+	return map[string]interface{}{s.field: true}, nil
+}
+
+// Upsert is false for the flag alone not to create a document that wasn't indexed.
+func (s *tombstone) Upsert() bool {
+	return false
+}
+
+func (s *tombstone) Time() *time.Time {
+	return s.op.Time()
+}
+
+func (s *tombstone) Sequence() uint64 {
+	return s.op.Sequence()
+}
+
+// PredicateFunc selects documents by Go code, for conditions a Predicate can't tell such as
+// comparing fields or ranges. It's given the document as read from MongoDB, before any manipulators.
+type PredicateFunc func(doc map[string]interface{}) bool
+
+// Select returns a Transform only indexing the documents of namespaces selected by match, applying
+// t to those. Inserts and updates of documents that aren't selected are skipped, or, with
+// deleteOnMismatch, turned into deletes removing what was indexed before the document changed to
+// be left out, such as for soft-deleted documents or a filtered view kept in sync. Without it,
+// documents indexed before no longer matching stay as they were. Deletes and other namespaces are
+// all given to t.
+//
+// Partial updates only carry the fields they set, there is no telling if the document matches.
+// They are applied as they are but only update a document already indexed, as they would otherwise
+// create a document of the fields they set alone for one that was left out. A document changing to
+// be selected by a partial update is therefore only indexed by its next full write, namespaces that
+// are looked up as full documents are judged as a whole.
+func Select(namespaces NamespaceFilter, match PredicateFunc, deleteOnMismatch bool, t Transform) Transform {
+	return selectBy(func(ns string) (selector, bool) {
+		return selector{match: match}, namespaces.Match(ns)
+	}, deleteOnMismatch, t)
+}
+
+// selector selects the documents of a namespace by match. Partial updates are matched by what they
+// set when judges tells it's enough, they are applied without being judged otherwise.
+type selector struct {
+	match  PredicateFunc
+	judges func(sets map[string]interface{}) bool
+}
+
+// selectBy implements Select, with the selector of each namespace given by selectorOf.
+func selectBy(selectorOf func(ns string) (selector, bool), deleteOnMismatch bool, t Transform) Transform {
+	return func(op *EsOperation) ([]elasticsearch.Transaction, error) {
+		s, ok := selectorOf(op.Namespace)
+		if !ok || (op.Op != Insert && op.Op != Update) {
+			return t.Apply(op)
+		}
+		doc, partial := sourceDocument(op.Operation)
+		if partial && (s.judges == nil || !s.judges(doc)) {
+			return t.Apply(withoutUpsert(op))
+		}
+		if !s.match(doc) {
+			if !deleteOnMismatch {
+				return nil, nil
+			}
+			return t.Apply(deleteOf(op))
+		}
+		if partial {
+			return t.Apply(withoutUpsert(op))
+		}
+		return t.Apply(op)
+	}
+}
+
+// predicateOf finds the predicate of ns, trying patterns in sorted order without an exact match.
+func predicateOf(predicates map[string]Predicate, ns string) (Predicate, bool) {
+	if p, ok := predicates[ns]; ok {
+		return p, true
+	}
+	patterns := make([]string, 0, len(predicates))
+	for pattern := range predicates {
+		if isPattern(pattern) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if matchAny([]string{pattern}, ns) {
+			return predicates[pattern], true
+		}
+	}
+	return Predicate{}, false
+}
+
+// sourceDocument returns the document of an insert or update as read from MongoDB, before any
+// manipulators, and if it's only the fields changed by a partial update. Unset fields are null.
+func sourceDocument(op *Operation) (bson.M, bool) {
+	if !isPartial(op) {
+		return op.Object, false
+	}
+	sets := make(bson.M)
+	if s, ok := op.Object["$set"].(bson.M); ok {
+		for k, v := range s {
+			sets[k] = v
+		}
+	}
+	if unsets, ok := op.Object["$unset"].(bson.M); ok {
+		for k := range unsets {
+			sets[k] = nil
+		}
+	}
+	return expandPaths(sets), true
+}
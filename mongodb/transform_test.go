@@ -0,0 +1,330 @@
+package mongodb
+
+import (
+	"errors"
+	"github.com/duego/cryriver/elasticsearch"
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+func TestTransformNil(t *testing.T) {
+	op := getEsOp(&Operation{Namespace: "testing.users", Op: Insert, Object: bson.M{"_id": bson.NewObjectId()}})
+	var transform Transform
+	entries, err := transform.Apply(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0] != op {
+		t.Error("Expected only the operation itself, got", entries)
+	}
+}
+
+func TestAuditDeletes(t *testing.T) {
+	id := bson.ObjectIdHex("52e7e160f4eb2740dda12844")
+	transform := AuditDeletes("audit")
+
+	insert := getEsOp(&Operation{Namespace: "testing.users", Op: Insert, Object: bson.M{"_id": id}})
+	if entries, err := transform.Apply(insert); err != nil || len(entries) != 1 {
+		t.Fatal("Expected inserts to pass through, got", entries, err)
+	}
+
+	del := getEsOp(&Operation{Timestamp: 5982836443431567364, Namespace: "testing.users", Op: Delete, Object: bson.M{"_id": id}})
+	entries, err := transform.Apply(del)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0] != del {
+		t.Fatal("Expected the delete and an audit entry, got", entries)
+	}
+	audit := entries[1]
+	if action, _ := audit.Action(); action != "index" {
+		t.Error("Expected audit entry to be indexed, got", action)
+	}
+	if index, _ := audit.Index(); index != "audit" {
+		t.Error("Unexpected audit index", index)
+	}
+	if auditId, _ := audit.Id(); auditId != "52e7e160f4eb2740dda12844-5982836443431567364" {
+		t.Error("Unexpected audit id", auditId)
+	}
+	doc, _ := audit.Document()
+	if doc["id"] != id.Hex() || doc["ns"] != "testing.users" {
+		t.Error("Unexpected audit document", doc)
+	}
+	if sequence := audit.(elasticsearch.Sequencer).Sequence(); sequence != del.Sequence() {
+		t.Error("Expected the audit entry to share the sequence of the delete, got", sequence)
+	}
+}
+
+func TestTransformHook(t *testing.T) {
+	Hooks["testing.people"] = func(op Operation) ([]elasticsearch.BulkEntry, error) {
+		name := op.Object["first"].(string) + " " + op.Object["last"].(string)
+		return []elasticsearch.BulkEntry{&hookedEntry{op.Object["_id"].(bson.ObjectId).Hex(), name}}, nil
+	}
+	defer delete(Hooks, "testing.people")
+
+	id := bson.ObjectIdHex("52e7e160f4eb2740dda12844")
+	op := getEsOp(&Operation{Timestamp: 5982836443431567364, Namespace: "testing.people", Op: Insert, Object: bson.M{"_id": id, "first": "Johnny", "last": "Doe"}})
+
+	// The hook replaces the transform as well as the operation
+	entries, err := AuditDeletes("audit").Apply(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatal("Expected the entry of the hook, got", entries)
+	}
+	if doc, _ := entries[0].Document(); doc["name"] != "Johnny Doe" {
+		t.Error("Expected the document of the hook, got", doc)
+	}
+	if ts := entries[0].Time(); ts == nil || !ts.Equal(*op.Time()) {
+		t.Error("Expected the time of the operation, got", ts)
+	}
+
+	// Other namespaces are transformed as usual
+	if entries, err := AuditDeletes("audit").Apply(getEsOp(&Operation{Namespace: "testing.users", Op: Insert, Object: bson.M{"_id": id}})); err != nil || len(entries) != 1 || entries[0] == nil {
+		t.Error("Expected the operation itself, got", entries, err)
+	}
+}
+
+func TestTransformHookError(t *testing.T) {
+	Hooks["testing.people"] = func(op Operation) ([]elasticsearch.BulkEntry, error) {
+		return nil, errors.New("No name")
+	}
+	defer delete(Hooks, "testing.people")
+
+	if entries, err := Transform(nil).Apply(getEsOp(&Operation{Namespace: "testing.people", Op: Insert, Object: bson.M{}})); err == nil || entries != nil {
+		t.Error("Expected the error of the hook, got", entries, err)
+	}
+}
+
+// hookedEntry is a plain BulkEntry without a time.
+type hookedEntry struct {
+	id, name string
+}
+
+func (e *hookedEntry) Action() (string, error) {
+	return "index", nil
+}
+
+func (e *hookedEntry) Index() (string, error) {
+	return "people", nil
+}
+
+func (e *hookedEntry) Type() (string, error) {
+	return "", nil
+}
+
+func (e *hookedEntry) Id() (string, error) {
+	return e.id, nil
+}
+
+func (e *hookedEntry) Document() (map[string]interface{}, error) {
+	return map[string]interface{}{"name": e.name}, nil
+}
+
+// scriptedHookEntry increments a counter of the person by a stored script, at a version of its own.
+type scriptedHookEntry struct {
+	hookedEntry
+}
+
+func (e *scriptedHookEntry) Action() (string, error) {
+	return "update", nil
+}
+
+func (e *scriptedHookEntry) StoredScript() (string, map[string]interface{}, bool, error) {
+	return "increment", map[string]interface{}{"field": "visits"}, true, nil
+}
+
+func (e *scriptedHookEntry) RetryOnConflict() int {
+	return 3
+}
+
+func TestTransformHookOptional(t *testing.T) {
+	Hooks["testing.people"] = func(op Operation) ([]elasticsearch.BulkEntry, error) {
+		return []elasticsearch.BulkEntry{&scriptedHookEntry{hookedEntry{op.Object["_id"].(bson.ObjectId).Hex(), ""}}}, nil
+	}
+	defer delete(Hooks, "testing.people")
+
+	id := bson.ObjectIdHex("52e7e160f4eb2740dda12844")
+	entries, err := Transform(nil).Apply(getEsOp(&Operation{Timestamp: 5982836443431567364, Namespace: "testing.people", Op: Insert, Object: bson.M{"_id": id}}))
+	if err != nil || len(entries) != 1 {
+		t.Fatal("Expected the entry of the hook, got", entries, err)
+	}
+	bulk := elasticsearch.NewBulkBody(elasticsearch.MB, elasticsearch.Typeless())
+	if err := bulk.Add(entries[0]); err != nil {
+		t.Fatal(err)
+	}
+	// The entry is timed by the operation, while still updating by its script
+	valid := `{"update":{"_index":"people","_id":"52e7e160f4eb2740dda12844","retry_on_conflict":3}}
+{"script":{"id":"increment","params":{"field":"visits"}},"upsert":{"name":""}}
+`
+	if bulk.String() != valid {
+		t.Errorf("\n'%s'\nNot equal to:\n'%s'", bulk.String(), valid)
+	}
+}
+
+func TestWhere(t *testing.T) {
+	id := bson.ObjectIdHex("52e7e160f4eb2740dda12844")
+	where := Where(map[string]Predicate{
+		"testing.users": {Path: "status", Value: "active"},
+		"testing.p*":    {Path: "profile.verified"},
+	}, nil)
+
+	for _, c := range []struct {
+		op     *Operation
+		action string
+	}{
+		// Active documents are indexed, inactive deleted
+		{&Operation{Namespace: "testing.users", Op: Insert, Object: bson.M{"_id": id, "status": "active"}}, "index"},
+		{&Operation{Namespace: "testing.users", Op: Insert, Object: bson.M{"_id": id, "status": "inactive"}}, "delete"},
+		{&Operation{Namespace: "testing.users", Op: Update, Object: bson.M{"_id": id, "status": "inactive"}, UpdateObject: bson.M{"_id": id}}, "delete"},
+
+		// Transitions of partial updates
+		{&Operation{Namespace: "testing.users", Op: Update, Object: bson.M{"$set": bson.M{"status": "inactive"}}, UpdateObject: bson.M{"_id": id}}, "delete"},
+		{&Operation{Namespace: "testing.users", Op: Update, Object: bson.M{"$set": bson.M{"status": "active"}}, UpdateObject: bson.M{"_id": id}}, "update"},
+		{&Operation{Namespace: "testing.users", Op: Update, Object: bson.M{"$set": bson.M{"name": "Johnny"}}, UpdateObject: bson.M{"_id": id}}, "update"},
+		{&Operation{Namespace: "testing.users", Op: Update, Object: bson.M{"$unset": bson.M{"status": 1}}, UpdateObject: bson.M{"_id": id}}, "delete"},
+
+		// Existence on nested paths, by patterns of namespaces
+		{&Operation{Namespace: "testing.people", Op: Insert, Object: bson.M{"_id": id, "profile": bson.M{"verified": true}}}, "index"},
+		{&Operation{Namespace: "testing.people", Op: Insert, Object: bson.M{"_id": id, "profile": bson.M{"verified": nil}}}, "delete"},
+		{&Operation{Namespace: "testing.people", Op: Update, Object: bson.M{"$set": bson.M{"profile.verified": false}}, UpdateObject: bson.M{"_id": id}}, "update"},
+		{&Operation{Namespace: "testing.people", Op: Insert, Object: bson.M{"_id": id}}, "delete"},
+
+		// Deletes and other namespaces pass through
+		{&Operation{Namespace: "testing.users", Op: Delete, Object: bson.M{"_id": id}}, "delete"},
+		{&Operation{Namespace: "testing.other", Op: Insert, Object: bson.M{"_id": id}}, "index"},
+	} {
+		entries, err := where.Apply(getEsOp(c.op))
+		if err != nil || len(entries) != 1 {
+			t.Error("Expected a single entry for", c.op, "got", entries, err)
+			continue
+		}
+		action, _ := entries[0].Action()
+		entryId, _ := entries[0].Id()
+		if action != c.action || entryId != id.Hex() {
+			t.Error("Expected", c.action, "of", id.Hex(), "for", c.op, "got", action, entryId)
+		}
+		// Partial updates only change documents left in ES
+		if upserter, ok := entries[0].(elasticsearch.Upserter); ok && isPartial(c.op) && action == "update" && upserter.Upsert() {
+			t.Error("Expected", c.op, "not to create the document")
+		}
+	}
+}
+
+func TestPredicateValues(t *testing.T) {
+	doc := map[string]interface{}{"age": 42, "admin": true, "name": "Johnny"}
+	for _, p := range []Predicate{{"age", "42"}, {"age", 42}, {"admin", "true"}, {"name", "Johnny"}} {
+		if !p.Match(doc) {
+			t.Error("Expected", p, "to match", doc)
+		}
+	}
+	for _, p := range []Predicate{{"age", "41"}, {"age", int64(42)}, {"name", "johnny"}, {"missing", nil}} {
+		if p.Match(doc) {
+			t.Error("Expected", p, "not to match", doc)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	id := bson.ObjectIdHex("52e7e160f4eb2740dda12844")
+	active := func(doc map[string]interface{}) bool {
+		return doc["status"] == "active"
+	}
+	namespaces := NamespaceFilter{Include: []string{"testing.users"}}
+
+	for _, c := range []struct {
+		deleteOnMismatch bool
+		op               *Operation
+		action           string
+	}{
+		{false, &Operation{Namespace: "testing.users", Op: Insert, Object: bson.M{"_id": id, "status": "active"}}, "index"},
+		{true, &Operation{Namespace: "testing.users", Op: Insert, Object: bson.M{"_id": id, "status": "active"}}, "index"},
+
+		// Documents that stopped matching are skipped, or deleted
+		{false, &Operation{Namespace: "testing.users", Op: Insert, Object: bson.M{"_id": id, "status": "deleted"}}, ""},
+		{true, &Operation{Namespace: "testing.users", Op: Insert, Object: bson.M{"_id": id, "status": "deleted"}}, "delete"},
+		{true, &Operation{Namespace: "testing.users", Op: Update, Object: bson.M{"_id": id, "status": "deleted"}, UpdateObject: bson.M{"_id": id}}, "delete"},
+
+		// Partial updates, deletes and other namespaces pass through
+		{true, &Operation{Namespace: "testing.users", Op: Update, Object: bson.M{"$set": bson.M{"name": "Johnny"}}, UpdateObject: bson.M{"_id": id}}, "update"},
+		{false, &Operation{Namespace: "testing.users", Op: Delete, Object: bson.M{"_id": id}}, "delete"},
+		{false, &Operation{Namespace: "testing.other", Op: Insert, Object: bson.M{"_id": id}}, "index"},
+	} {
+		entries, err := Select(namespaces, active, c.deleteOnMismatch, nil).Apply(getEsOp(c.op))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if c.action == "" {
+			if len(entries) != 0 {
+				t.Error("Expected", c.op, "to be skipped, got", entries)
+			}
+			continue
+		}
+		if len(entries) != 1 {
+			t.Error("Expected a single entry for", c.op, "got", entries)
+			continue
+		}
+		action, _ := entries[0].Action()
+		entryId, _ := entries[0].Id()
+		if action != c.action || entryId != id.Hex() {
+			t.Error("Expected", c.action, "of", id.Hex(), "for", c.op, "got", action, entryId)
+		}
+		if upserter, ok := entries[0].(elasticsearch.Upserter); ok && isPartial(c.op) && upserter.Upsert() {
+			t.Error("Expected", c.op, "not to create the document")
+		}
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	id := bson.ObjectIdHex("52e7e160f4eb2740dda12844")
+	transform := SoftDelete("removed", nil)
+
+	insert := getEsOp(&Operation{Namespace: "testing.users", Op: Insert, Object: bson.M{"_id": id}})
+	if entries, err := transform.Apply(insert); err != nil || len(entries) != 1 || entries[0] != insert {
+		t.Fatal("Expected inserts to pass through, got", entries, err)
+	}
+
+	del := getEsOp(&Operation{Timestamp: 5982836443431567364, Namespace: "testing.users", Op: Delete, Object: bson.M{"_id": id}})
+	entries, err := transform.Apply(del)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatal("Expected a single entry for the delete, got", entries)
+	}
+	flagged := entries[0]
+	if action, _ := flagged.Action(); action != "update" {
+		t.Error("Expected the delete to become an update, got", action)
+	}
+	if flaggedId, _ := flagged.Id(); flaggedId != id.Hex() {
+		t.Error("Expected the id of the deleted document, got", flaggedId)
+	}
+	if index, _ := flagged.Index(); index != "testing" {
+		t.Error("Unexpected index", index)
+	}
+	if doc, _ := flagged.Document(); len(doc) != 1 || doc["removed"] != true {
+		t.Error("Expected only the flag to be set, got", doc)
+	}
+	if sequence := flagged.(elasticsearch.Sequencer).Sequence(); sequence != del.Sequence() {
+		t.Error("Expected the update to share the sequence of the delete, got", sequence)
+	}
+	if flagged.(elasticsearch.Upserter).Upsert() {
+		t.Error("Expected the flag not to create documents that weren't indexed")
+	}
+
+	// Documents left out by Where are deleted, not flagged
+	where := SoftDelete("removed", Where(map[string]Predicate{"testing.users": {"status", "active"}}, nil))
+	left := getEsOp(&Operation{Namespace: "testing.users", Op: Insert, Object: bson.M{"_id": id, "status": "banned"}})
+	if entries, err := where.Apply(left); err != nil || len(entries) != 1 {
+		t.Fatal("Expected a single entry, got", entries, err)
+	} else if action, _ := entries[0].Action(); action != "delete" {
+		t.Error("Expected the document left out to be deleted, got", action)
+	}
+	if entries, err := where.Apply(del); err != nil || len(entries) != 1 {
+		t.Fatal("Expected a single entry, got", entries, err)
+	} else if action, _ := entries[0].Action(); action != "update" {
+		t.Error("Expected deletes through Where to be flagged, got", action)
+	}
+}
@@ -1,7 +1,13 @@
 package mongodb
 
 import (
+	"io"
+	"io/ioutil"
 	"labix.org/v2/mgo/bson"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 type BsonTraverser struct {
@@ -33,3 +39,63 @@ func (b BsonTraverser) Next(key string) BsonTraverser {
 func (b BsonTraverser) Value() interface{} {
 	return b.value
 }
+
+// expandPaths turns the dotted keys of $set into nested documents, {"a.b": 1} becomes
+// {"a": {"b": 1}}, so that ES merges them into the existing objects. Keys pointing into arrays,
+// such as "tags.0", can't be expressed as a partial document and are kept as they are.
+func expandPaths(m bson.M) bson.M {
+	expanded := make(bson.M, len(m))
+	for key, value := range m {
+		parts := strings.Split(key, ".")
+		if len(parts) == 1 || hasIndex(parts) {
+			expanded[key] = value
+			continue
+		}
+		doc := expanded
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := doc[part].(bson.M)
+			if !ok {
+				next = make(bson.M)
+				doc[part] = next
+			}
+			doc = next
+		}
+		doc[parts[len(parts)-1]] = value
+	}
+	return expanded
+}
+
+// hasIndex tells if any of the path parts is an array index.
+func hasIndex(parts []string) bool {
+	for _, part := range parts {
+		if _, err := strconv.Atoi(part); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// writeFileAtomic replaces the file at path with what write writes. It's written to a temporary file
+// that is renamed into place, so that the file is never left partly written.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	// Make sure it has hit the disk before it replaces the previous file
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -19,3 +19,19 @@ func TestTraverser(t *testing.T) {
 		t.Error("Expected invalid keys to set nil as value")
 	}
 }
+
+func TestExpandPaths(t *testing.T) {
+	expanded := expandPaths(bson.M{
+		"name":          "Johnny",
+		"address.city":  "Stockholm",
+		"address.geo.x": 1,
+		"tags.0":        "new",
+	})
+	if expanded["name"] != "Johnny" || expanded["tags.0"] != "new" {
+		t.Error("Expected plain keys and array paths to be kept, got", expanded)
+	}
+	address := NewBsonTraverser(expanded).Next("address")
+	if address.Next("city").Value() != "Stockholm" || address.Next("geo").Next("x").Value() != 1 {
+		t.Error("Expected dotted paths to be nested, got", expanded)
+	}
+}
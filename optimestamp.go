@@ -2,6 +2,7 @@ package main
 
 import (
 	"expvar"
+	"github.com/duego/cryriver/elasticsearch"
 	"github.com/duego/cryriver/mongodb"
 	"log"
 	"os"
@@ -11,19 +12,30 @@ import (
 var (
 	lastEsSeen     *mongodb.Timestamp
 	lastEsSeenC    = make(chan *mongodb.Timestamp, 1)
+	lastEsSeenSync = make(chan lastEsSeenFlush)
 	lastEsSeenStat = expvar.NewString("Last optime seen")
 )
 
-func init() {
-	// Restore any previously saved timestamp
+// loadLastEsSeen restores any previously saved timestamp and returns it. A saved timestamp that
+// can't be read is replaced by fallback, zero makes the tailer start over with an initial import.
+func loadLastEsSeen(fallback mongodb.Timestamp) mongodb.Timestamp {
 	lastEsSeen = new(mongodb.Timestamp)
-	if f, err := os.Open(*optimeStore); err != nil {
+	if err := lastEsSeen.LoadFile(*optimeStore); os.IsNotExist(err) {
 		log.Println("Failed to load previous lastEsSeen timestamp:", err)
-	} else {
-		lastEsSeen.Load(f)
-		f.Close()
+	} else if err != nil {
+		log.Println("WARNING: Previous lastEsSeen timestamp in", *optimeStore, "is unusable:", err)
+		log.Println("WARNING: Resuming from", fallback, "instead, operations may be missed or applied again")
+		*lastEsSeen = fallback
 	}
+	resumed := *lastEsSeen
 	go saveLastEsSeen()
+	return resumed
+}
+
+// lastEsSeenFlush asks saveLastEsSeen to save ts right away, closing saved once it's done.
+type lastEsSeenFlush struct {
+	ts    *mongodb.Timestamp
+	saved chan bool
 }
 
 // saveLastEsSeen loops the channel to save our progress on what timestamp we have seen so far.
@@ -36,17 +48,52 @@ func saveLastEsSeen() {
 			if lastEsSeen == nil {
 				continue
 			}
-			if f, err := os.Create(*optimeStore); err != nil {
+			if err := lastEsSeen.SaveFile(*optimeStore); err != nil {
 				log.Println("Error saving oplog timestamp:", err)
 			} else {
-				if err := lastEsSeen.Save(f); err != nil {
-					log.Println("Error saving oplog timestamp:", err)
-				}
-				f.Close()
 				lastEsSeenStat.Set(lastEsSeen.String())
 				lastEsSeen = nil
 			}
 		case lastEsSeen = <-lastEsSeenC:
+		case flush := <-lastEsSeenSync:
+			if err := flush.ts.SaveFile(*optimeStore); err != nil {
+				log.Println("Error saving oplog timestamp:", err)
+			} else {
+				lastEsSeenStat.Set(flush.ts.String())
+				lastEsSeen = nil
+			}
+			close(flush.saved)
+		}
+	}
+}
+
+// checkpointAcks moves the checkpoint to the timestamp acks has been acknowledged up to, checking
+// every interval until done is closed once nothing more will be acknowledged. The last checkpoint
+// is then saved before closing saved, rather than losing what was acknowledged since the last tick.
+func checkpointAcks(acks *elasticsearch.AckTracker, lag *mongodb.LagMeter, interval time.Duration, done, saved chan bool) {
+	defer close(saved)
+	last := acks.Checkpoint()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			checkpoint := acks.Checkpoint()
+			if checkpoint == last {
+				continue
+			}
+			last = checkpoint
+			ts := mongodb.Timestamp(checkpoint)
+			lastEsSeenC <- &ts
+			lag.Applied(ts)
+		case <-done:
+			if checkpoint := acks.Checkpoint(); checkpoint != last {
+				ts := mongodb.Timestamp(checkpoint)
+				flush := lastEsSeenFlush{&ts, make(chan bool)}
+				lastEsSeenSync <- flush
+				<-flush.saved
+			}
+			return
 		}
 	}
 }
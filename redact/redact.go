@@ -0,0 +1,182 @@
+// Package redact masks sensitive document fields before they are surfaced in errors and logs.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Mask replaces the values of redacted fields.
+const Mask = "****"
+
+// Paths lists the fields to redact as dotted paths, such as "email" or "address.street". Fields
+// below a redacted path are redacted as well and arrays are redacted element by element. Operators
+// like $set are skipped over, so "email" also covers {"$set": {"email": ...}}.
+var Paths []string
+
+// Document returns a copy of doc with all sensitive fields masked, doc itself is left untouched.
+func Document(doc map[string]interface{}) map[string]interface{} {
+	if len(Paths) == 0 || doc == nil {
+		return doc
+	}
+	return redactMap(reflect.ValueOf(doc), "")
+}
+
+// JSON masks the sensitive fields of a json document, or each line of a bulk body. Elasticsearch
+// update lines are covered by redacting their doc and upsert objects. Lines that aren't json
+// objects are left as they are.
+func JSON(b []byte) []byte {
+	if len(Paths) == 0 {
+		return b
+	}
+	lines := bytes.Split(b, []byte{'\n'})
+	for i, line := range lines {
+		doc := decode(line)
+		if doc == nil {
+			continue
+		}
+		redacted := Document(doc)
+		for _, wrapper := range []string{"doc", "upsert"} {
+			if inner, ok := doc[wrapper].(map[string]interface{}); ok {
+				redacted[wrapper] = Document(inner)
+			}
+		}
+		if out, err := json.Marshal(redacted); err == nil {
+			lines[i] = out
+		}
+	}
+	return bytes.Join(lines, []byte{'\n'})
+}
+
+// Values returns the values of all sensitive fields in doc as strings.
+func Values(doc map[string]interface{}) []string {
+	var values []string
+	if len(Paths) == 0 || doc == nil {
+		return values
+	}
+	collect(reflect.ValueOf(doc), "", &values)
+	return values
+}
+
+// ValuesJSON returns the values of all sensitive fields found in a json document or a bulk body.
+func ValuesJSON(b []byte) []string {
+	var values []string
+	if len(Paths) == 0 {
+		return values
+	}
+	for _, line := range bytes.Split(b, []byte{'\n'}) {
+		if doc := decode(line); doc != nil {
+			values = append(values, Values(doc)...)
+			for _, wrapper := range []string{"doc", "upsert"} {
+				if inner, ok := doc[wrapper].(map[string]interface{}); ok {
+					values = append(values, Values(inner)...)
+				}
+			}
+		}
+	}
+	return values
+}
+
+// Text masks any of values found in s, such as a field value echoed back in an error message.
+// Values shorter than 3 characters are left alone to keep the rest of the message readable.
+func Text(s string, values []string) string {
+	for _, v := range values {
+		if len(v) >= 3 {
+			s = strings.Replace(s, v, Mask, -1)
+		}
+	}
+	return s
+}
+
+// decode parses a json object keeping numbers as they are, nil if it's something else.
+func decode(b []byte) map[string]interface{} {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil
+	}
+	return doc
+}
+
+// sensitive tells if path is, or is below, any of the redacted paths.
+func sensitive(path string) bool {
+	for _, p := range Paths {
+		if path == p || strings.HasPrefix(path, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// join appends key to path, operators are left out of the path.
+func join(path, key string) string {
+	if strings.HasPrefix(key, "$") {
+		return path
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// indirect unwraps interfaces and pointers.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+func redactMap(m reflect.Value, path string) map[string]interface{} {
+	out := make(map[string]interface{}, m.Len())
+	for _, key := range m.MapKeys() {
+		out[key.String()] = redactValue(m.MapIndex(key), join(path, key.String()))
+	}
+	return out
+}
+
+func redactValue(v reflect.Value, path string) interface{} {
+	v = indirect(v)
+	if !v.IsValid() {
+		return nil
+	}
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		return redactMap(v, path)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8:
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			out[i] = redactValue(v.Index(i), path)
+		}
+		return out
+	case sensitive(path):
+		return Mask
+	}
+	return v.Interface()
+}
+
+func collect(v reflect.Value, path string, values *[]string) {
+	v = indirect(v)
+	if !v.IsValid() {
+		return
+	}
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		for _, key := range v.MapKeys() {
+			collect(v.MapIndex(key), join(path, key.String()), values)
+		}
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8:
+		for i := 0; i < v.Len(); i++ {
+			collect(v.Index(i), path, values)
+		}
+	case sensitive(path):
+		*values = append(*values, fmt.Sprint(v.Interface()))
+	}
+}
@@ -0,0 +1,94 @@
+package redact
+
+import (
+	"strings"
+	"testing"
+)
+
+func withPaths(t *testing.T, paths ...string) {
+	previous := Paths
+	Paths = paths
+	t.Cleanup(func() { Paths = previous })
+}
+
+func TestDocument(t *testing.T) {
+	withPaths(t, "email", "address.street", "phones.number")
+	doc := map[string]interface{}{
+		"alias": "Johnny",
+		"email": "johnny@example.com",
+		"address": map[string]interface{}{
+			"street": "Main St 1",
+			"city":   "Stockholm",
+		},
+		"phones": []interface{}{
+			map[string]interface{}{"kind": "home", "number": "555-1234"},
+			map[string]interface{}{"kind": "work", "number": "555-4321"},
+		},
+	}
+	redacted := Document(doc)
+
+	if redacted["alias"] != "Johnny" {
+		t.Error("Expected alias to be left alone")
+	}
+	if redacted["email"] != Mask {
+		t.Error("Expected email to be masked, got", redacted["email"])
+	}
+	address := redacted["address"].(map[string]interface{})
+	if address["street"] != Mask || address["city"] != "Stockholm" {
+		t.Error("Expected only the street to be masked, got", address)
+	}
+	for _, phone := range redacted["phones"].([]interface{}) {
+		if p := phone.(map[string]interface{}); p["number"] != Mask || p["kind"] == Mask {
+			t.Error("Expected each phone number to be masked, got", p)
+		}
+	}
+	if doc["email"] != "johnny@example.com" {
+		t.Error("Expected original document to be left untouched")
+	}
+}
+
+func TestDocumentOperators(t *testing.T) {
+	withPaths(t, "email", "address")
+	doc := map[string]interface{}{
+		"$set": map[string]interface{}{
+			"email":          "johnny@example.com",
+			"address.street": "Main St 1",
+			"alias":          "Johnny",
+		},
+	}
+	set := Document(doc)["$set"].(map[string]interface{})
+	if set["email"] != Mask || set["address.street"] != Mask || set["alias"] != "Johnny" {
+		t.Error("Unexpected redaction of $set:", set)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	withPaths(t, "email")
+	body := []byte(`{"update":{"_index":"testing","_type":"user","_id":"123"}}
+{"doc":{"alias":"Johnny","email":"johnny@example.com"},"doc_as_upsert":true}
+`)
+	redacted := string(JSON(body))
+	if strings.Contains(redacted, "johnny@example.com") {
+		t.Error("Expected email to be masked:\n", redacted)
+	}
+	if !strings.Contains(redacted, `"_id":"123"`) || !strings.Contains(redacted, `"alias":"Johnny"`) {
+		t.Error("Expected other fields to be left alone:\n", redacted)
+	}
+}
+
+func TestText(t *testing.T) {
+	withPaths(t, "email")
+	values := ValuesJSON([]byte(`{"email":"johnny@example.com"}`))
+	msg := Text("failed to parse [email] with value [johnny@example.com]", values)
+	if msg != "failed to parse [email] with value [****]" {
+		t.Error("Unexpected message", msg)
+	}
+}
+
+func TestNoPaths(t *testing.T) {
+	withPaths(t)
+	doc := map[string]interface{}{"email": "johnny@example.com"}
+	if Document(doc)["email"] != "johnny@example.com" {
+		t.Error("Expected nothing to be redacted without paths")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/duego/cryriver/elasticsearch"
+	"github.com/duego/cryriver/mongodb"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// replay sends the dead letters or the bulk body saved in the file at path to ES again, writing
+// those still failing to failedPath in the same format unless it's empty. Dead letters are indexed
+// by indexes like the operations they once were. Returns the number of entries still failing.
+func replay(client *elasticsearch.Client, path, failedPath string, indexes map[string]string) (int, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var entries []elasticsearch.BulkEntry
+	var letters []mongodb.DeadLetter
+	if isDeadLetters(b) {
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		// Keep integers exact rather than going through float64
+		decoder.UseNumber()
+		for decoder.More() {
+			var letter mongodb.DeadLetter
+			if err := decoder.Decode(&letter); err != nil {
+				return 0, err
+			}
+			op, err := letter.Operation()
+			if err != nil {
+				log.Println("Skipping dead letter of", letter.Namespace, letter.Id+":", err)
+				continue
+			}
+			// The document was already manipulated once before it was kept
+			entries = append(entries, mongodb.NewEsOperation(indexes, []mongodb.Manipulator{}, op))
+			letters = append(letters, letter)
+		}
+	} else {
+		ops, err := elasticsearch.ParseBulkBody(bytes.NewReader(b))
+		if err != nil {
+			return 0, err
+		}
+		for i := range ops {
+			entries = append(entries, &ops[i])
+		}
+	}
+	log.Println("Replaying", len(entries), "entries from", path)
+
+	results, err := elasticsearch.Replay(context.Background(), client, entries)
+	if err != nil {
+		return 0, err
+	}
+	var failedLetters []mongodb.DeadLetter
+	var failedEntries []elasticsearch.BulkEntry
+	for i, result := range results {
+		if result.Err == nil {
+			continue
+		}
+		id, _ := result.Entry.Id()
+		index, _ := result.Entry.Index()
+		log.Println("Still failing", id, "in", index+":", result.Err)
+		failedEntries = append(failedEntries, result.Entry)
+		if letters != nil {
+			letter := letters[i]
+			letter.Reason = result.Err.Error()
+			failedLetters = append(failedLetters, letter)
+		}
+	}
+	failed := len(failedEntries)
+	log.Println(len(results)-failed, "entries indexed,", failed, "still failing")
+	if failed == 0 || failedPath == "" {
+		return failed, nil
+	}
+
+	f, err := os.Create(failedPath)
+	if err != nil {
+		return failed, err
+	}
+	defer f.Close()
+	if letters != nil {
+		encoder := json.NewEncoder(f)
+		for _, letter := range failedLetters {
+			if err := encoder.Encode(letter); err != nil {
+				return failed, err
+			}
+		}
+		return failed, nil
+	}
+	body := elasticsearch.NewBulkBody(elasticsearch.DefaultBulkSize)
+	for _, entry := range failedEntries {
+		err := body.Add(entry)
+		if err == elasticsearch.BulkBodyFull {
+			if _, err := body.WriteTo(f); err != nil {
+				return failed, err
+			}
+			err = body.Add(entry)
+		}
+		if err != nil {
+			return failed, err
+		}
+	}
+	body.Done()
+	_, err = body.WriteTo(f)
+	return failed, err
+}
+
+// isDeadLetters tells a file of dead letters from a bulk body by its first line.
+func isDeadLetters(b []byte) bool {
+	b = bytes.TrimSpace(b)
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+	var line map[string]json.RawMessage
+	if err := json.Unmarshal(b, &line); err != nil {
+		return false
+	}
+	_, ok := line["document"]
+	return ok
+}
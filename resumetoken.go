@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/duego/cryriver/mongodb"
+	"log"
+	"os"
+	"time"
+)
+
+var (
+	lastToken  mongodb.ResumeToken
+	lastTokenC = make(chan mongodb.ResumeToken, 1)
+)
+
+// loadLastToken restores any previously saved change stream resume token. Without one the change
+// stream starts over with an initial import.
+func loadLastToken() {
+	token, err := mongodb.LoadResumeToken(*tokenStore)
+	if os.IsNotExist(err) {
+		log.Println("Failed to load previous resume token:", err)
+	} else if err != nil {
+		log.Println("WARNING: Previous resume token in", *tokenStore, "is unusable:", err)
+		log.Println("WARNING: Starting over with an initial import instead")
+	} else {
+		lastToken = token
+	}
+	go saveLastToken()
+}
+
+// saveLastToken loops the channel to save our progress in the change stream, the same way as
+// saveLastEsSeen does for the oplog.
+func saveLastToken() {
+	var pending mongodb.ResumeToken
+	timer := time.NewTicker(time.Second)
+	for {
+		select {
+		case <-timer.C:
+			if pending == nil {
+				continue
+			}
+			if err := pending.SaveFile(*tokenStore); err != nil {
+				log.Println("Error saving resume token:", err)
+			} else {
+				pending = nil
+			}
+		case pending = <-lastTokenC:
+		}
+	}
+}
@@ -7,4 +7,35 @@ import (
 var (
 	BulkFull = expvar.NewInt("bulk full")
 	BulkTime = expvar.NewInt("bulk time")
+
+	// Unix time of the last bulk request accepted by ES
+	LastBulk = expvar.NewInt("bulk last success")
+
+	// Operations dropped for being replaced by a later one in the same bulk body
+	Coalesced = expvar.NewInt("bulk coalesced")
+
+	// Operations left out for being older than the retention of their index
+	Expired = expvar.NewInt("bulk expired")
+
+	// Documents deleted from the buckets of a retention by deletes not telling which one
+	DeletedByQuery = expvar.NewInt("bulk deleted by query")
+
+	// Bulk requests rejected by a write block of the cluster or of an index, such as after hitting
+	// the flood-stage disk watermark
+	ClusterBlocked = expvar.NewInt("bulk cluster blocked")
+
+	// Bulk requests rejected by a circuit breaker of ES protecting its heap, each retry is counted
+	MemoryPressure = expvar.NewInt("bulk memory pressure")
+
+	// Bytes reserved by bulk bodies being built or sent
+	InFlightBytes = expvar.NewInt("bulk in flight bytes")
+
+	// Entries per second sent while throttled by the indexing rate ceiling, and the milliseconds
+	// bulk requests waited for it
+	ThrottleRate = expvar.NewInt("bulk throttle rate")
+	ThrottledMs  = expvar.NewInt("bulk throttled ms")
+
+	// Operations left out by sampling and held back by the rate limit of load testing
+	SampledOut  = expvar.NewInt("load test sampled out")
+	RateLimited = expvar.NewInt("load test rate limited")
 )
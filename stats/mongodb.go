@@ -8,4 +8,23 @@ var (
 	Unsets   = expvar.NewInt("Total $unset")
 	Sets     = expvar.NewInt("Total $set")
 	Complete = expvar.NewInt("Total complete objects")
+
+	// Operations read from the oplog or change stream by kind as in the oplog, such as i for
+	// inserts, and import for documents read by initial imports
+	MongoRead = expvar.NewMap("mongodb read")
+
+	// Bytes of BSON read from MongoDB
+	MongoReadBytes = expvar.NewInt("mongodb read bytes")
+
+	// Failed oplog and change stream cursors, each one stopping the river
+	MongoCursorErrors = expvar.NewInt("mongodb cursor errors")
+
+	// Unix time of the latest operation read from the oplog or change stream
+	MongoLastRead = expvar.NewInt("mongodb last read")
+
+	// Unix time of the last oplog timestamp applied and of the head of the oplog, and the seconds
+	// between them
+	OplogApplied = expvar.NewInt("oplog applied")
+	OplogHead    = expvar.NewInt("oplog head")
+	OplogLag     = expvar.NewInt("oplog lag seconds")
 )
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/duego/cryriver/elasticsearch"
+	"github.com/duego/cryriver/mongodb"
+	"strings"
+	"time"
+)
+
+// validate checks the configuration given by flags the same way the river would load it on start,
+// along with the connectivity towards MongoDB and ES, returning every problem found rather than
+// stopping at the first one.
+func validate() []error {
+	var errs []error
+	check := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %s", name, err))
+		}
+	}
+
+	_, err := namespaceFilter()
+	check("ns", err)
+	_, err = coalesceFilter()
+	check("coalesce", err)
+	_, err = lookupFilter()
+	check("fulldocument", err)
+	_, err = parseTargets(*esTargets)
+	check("target", err)
+	_, err = parseRenames(*renameFields)
+	check("rename", err)
+	_, err = parseStatic(*staticFields)
+	check("static", err)
+	_, err = parseFlatten(*flattenKeys)
+	check("flatten", err)
+	_, err = parseFlattenDepth(*flattenDepth)
+	check("flattendepth", err)
+	if *flattenDepth != "" && *flattenSep == "" {
+		check("flattensep", errors.New("A separator is required to join the keys of flattened objects"))
+	}
+	_, err = parsePredicates(*wherePreds)
+	check("where", err)
+	_, err = loadSchemas(*schemaFiles)
+	check("schema", err)
+	_, err = loadScripts(*esScripts)
+	check("storescript", err)
+	switch *mongoSource {
+	case "oplog", "changestream":
+	default:
+		check("source", fmt.Errorf("Unknown source: %s", *mongoSource))
+	}
+	switch *onMarshal {
+	case "skip", "fail":
+	case "deadletter":
+		if *deadLetter == "" {
+			check("onmarshal", errors.New("A dead-letter file is required to dead-letter documents that can't be marshaled"))
+		}
+	default:
+		check("onmarshal", fmt.Errorf("Unknown marshal policy: %s", *onMarshal))
+	}
+	_, err = mirrorAcks(*esMirrorAcks)
+	check("mirroracks", err)
+	if *esMirror != "" && *esSpool != "" {
+		check("mirror", errors.New("Bulk requests to mirrors are kept in memory, -spool can't be used with -mirror"))
+	}
+	mappings, err := loadMappings()
+	check("mapping", err)
+	if mapping, ok := mappings[*esIndex]; ok {
+		errs = append(errs, checkDateFields(mapping)...)
+	}
+
+	session, err := mongodb.Dial(dialConfig())
+	if err == nil {
+		err = session.Ping()
+		session.Close()
+	}
+	check("mongo", err)
+
+	options, err := clientOptions()
+	check("es", err)
+	if err == nil {
+		client := elasticsearch.NewClient(*esServer, 1, options...)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if _, err := client.Ping(ctx); err != nil {
+			check("es", fmt.Errorf("Unable to reach ES at %s: %s", *esServer, err))
+		} else if _, err := client.DetectVersion(ctx); err != nil {
+			check("esversion", fmt.Errorf("Unable to detect the version of ES: %s", err))
+		}
+		cancel()
+	}
+	return errs
+}
+
+// checkDateFields returns an error for each field the river sets to a time which mapping maps as
+// something else than a date, as ES would reject every document then. Fields left out of the
+// mapping are fine, ES detects them as dates.
+func checkDateFields(mapping json.RawMessage) []error {
+	fields := map[string]string{"timestamp": *injectTs, "indexedat": *esIndexedAt}
+	if *esRetention > 0 {
+		fields["retentionfield"] = *esRetField
+	}
+	var errs []error
+	for name, field := range fields {
+		if field == "" {
+			continue
+		}
+		if typ := mappedType(mapping, field); typ != "" && typ != "date" && typ != "date_nanos" {
+			errs = append(errs, fmt.Errorf("-%s: Field %s is mapped as %s in %s rather than as a date", name, field, typ, *esMapping))
+		}
+	}
+	return errs
+}
+
+// mappedType returns the type field of the dotted path is mapped as in the mapping of an index,
+// empty if it isn't mapped. Mappings with and without a type are both understood.
+func mappedType(mapping json.RawMessage, field string) string {
+	var index struct {
+		Mappings map[string]json.RawMessage `json:"mappings"`
+	}
+	if json.Unmarshal(mapping, &index) != nil {
+		return ""
+	}
+	properties, ok := index.Mappings["properties"]
+	if !ok {
+		for _, typed := range index.Mappings {
+			var typeMapping struct {
+				Properties json.RawMessage `json:"properties"`
+			}
+			if json.Unmarshal(typed, &typeMapping) == nil && typeMapping.Properties != nil {
+				properties = typeMapping.Properties
+				break
+			}
+		}
+	}
+	var typ string
+	for _, name := range strings.Split(field, ".") {
+		var fields map[string]struct {
+			Type       string          `json:"type"`
+			Properties json.RawMessage `json:"properties"`
+		}
+		if properties == nil || json.Unmarshal(properties, &fields) != nil {
+			return ""
+		}
+		f, ok := fields[name]
+		if !ok {
+			return ""
+		}
+		typ, properties = f.Type, f.Properties
+	}
+	return typ
+}